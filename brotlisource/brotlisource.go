@@ -43,6 +43,10 @@ func (bs *brotliSource) Features() savior.SourceFeatures {
 	}
 }
 
+func (bs *brotliSource) Fingerprint() (*savior.Fingerprint, error) {
+	return savior.SourceFingerprint(bs.source)
+}
+
 func (bs *brotliSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
 	savior.Debugf("brotlisource: set source save consumer!")
 	bs.ssc = ssc
@@ -187,4 +191,14 @@ func (bs *brotliSource) Progress() float64 {
 
 func init() {
 	gob.Register(&BrotliSourceCheckpoint{})
+	savior.RegisterCheckpointType("brotlisource.BrotliSourceCheckpoint", &BrotliSourceCheckpoint{})
+
+	// brotli streams have no magic number, so they're only
+	// detected by trying to decompress them
+	savior.RegisterCompressionFormat(savior.CompressionFormat{
+		Name: "brotli",
+		NewSource: func(source savior.Source) savior.Source {
+			return New(source)
+		},
+	})
 }
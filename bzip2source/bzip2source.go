@@ -45,6 +45,10 @@ func (bs *bzip2Source) Features() savior.SourceFeatures {
 	}
 }
 
+func (bs *bzip2Source) Fingerprint() (*savior.Fingerprint, error) {
+	return savior.SourceFingerprint(bs.source)
+}
+
 func (bs *bzip2Source) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
 	savior.Debugf("bzip2: set source save consumer!")
 	bs.ssc = ssc
@@ -185,4 +189,15 @@ func (bs *bzip2Source) Progress() float64 {
 
 func init() {
 	gob.Register(&Bzip2SourceCheckpoint{})
+	savior.RegisterCheckpointType("bzip2source.Bzip2SourceCheckpoint", &Bzip2SourceCheckpoint{})
+
+	savior.RegisterCompressionFormat(savior.CompressionFormat{
+		Name: "bzip2",
+		Match: func(header []byte) bool {
+			return len(header) >= 4 && string(header[:3]) == "BZh" && header[3] >= '1' && header[3] <= '9'
+		},
+		NewSource: func(source savior.Source) savior.Source {
+			return New(source)
+		},
+	})
 }
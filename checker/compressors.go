@@ -84,3 +84,82 @@ func BrotliCompress(input []byte, level int) ([]byte, error) {
 
 	return compressedBuf.Bytes(), nil
 }
+
+func XzCompress(input []byte) ([]byte, error) {
+	cmd := exec.Command("xz", "--format=xz", "--block-size=1MiB", "-c")
+	outbuf := new(bytes.Buffer)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = outbuf
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return outbuf.Bytes(), nil
+}
+
+// LzmaCompress compresses input as a .lzma stream with a 1MiB dictionary,
+// of unknown size (so it has an end marker)
+func LzmaCompress(input []byte) ([]byte, error) {
+	cmd := exec.Command("xz", "--format=lzma", "--lzma1=preset=6,dict=1MiB", "-c")
+	outbuf := new(bytes.Buffer)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = outbuf
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return outbuf.Bytes(), nil
+}
+
+// LzmaZipCompress compresses input like LzmaCompress, but frames it
+// like zip entries compressed with LZMA (method 14)
+func LzmaZipCompress(input []byte) ([]byte, error) {
+	compressed, err := LzmaCompress(input)
+	if err != nil {
+		return nil, err
+	}
+
+	// LZMA SDK version 9.20, 5 bytes of properties
+	// instead of the uncompressed size
+	res := append([]byte{9, 20, 5, 0}, compressed[:5]...)
+	res = append(res, compressed[13:]...)
+	return res, nil
+}
+
+func ZstdCompress(input []byte) ([]byte, error) {
+	cmd := exec.Command("zstd", "-q", "-c")
+	outbuf := new(bytes.Buffer)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = outbuf
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return outbuf.Bytes(), nil
+}
+
+// Lz4Compress compresses input as an LZ4 frame with small (64KiB) blocks,
+// which are either linked or independent
+func Lz4Compress(input []byte, linkedBlocks bool) ([]byte, error) {
+	args := []string{"-q", "-c", "-B4"}
+	if linkedBlocks {
+		args = append(args, "-BD")
+	}
+	cmd := exec.Command("lz4", args...)
+	outbuf := new(bytes.Buffer)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = outbuf
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return outbuf.Bytes(), nil
+}
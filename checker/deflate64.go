@@ -0,0 +1,177 @@
+package checker
+
+import (
+	"bytes"
+)
+
+// Deflate64Compress compresses input as a Deflate64 stream. Nothing in the
+// standard library (or on most systems) produces Deflate64, so this is a
+// simple encoder meant for tests: it uses fixed huffman codes, with some
+// stored blocks, and finds matches that are long and far enough to need
+// what Deflate64 adds to deflate.
+func Deflate64Compress(input []byte) ([]byte, error) {
+	const (
+		blockSize  = 256 * 1024
+		windowSize = 64 * 1024
+		maxLength  = 65538
+		hashBits   = 16
+	)
+
+	bw := &bitWriter{}
+	head := make([]int, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	hash := func(i int) int {
+		return int((uint32(input[i])|uint32(input[i+1])<<8|uint32(input[i+2])<<16)*2654435761) >> (32 - hashBits) & (1<<hashBits - 1)
+	}
+
+	numBlocks := 0
+	for start := 0; start < len(input) || numBlocks == 0; start += blockSize {
+		end := start + blockSize
+		if end > len(input) {
+			end = len(input)
+		}
+		final := uint32(0)
+		if end == len(input) {
+			final = 1
+		}
+
+		if numBlocks%4 == 3 {
+			// stored block(s), up to 64KiB each
+			for s := start; s < end || s == start; s += 0xffff {
+				e := s + 0xffff
+				if e > end {
+					e = end
+				}
+				last := uint32(0)
+				if e == end {
+					last = final
+				}
+				bw.write(last, 1)
+				bw.write(0, 2)
+				bw.align()
+				bw.write(uint32(e-s), 16)
+				bw.write(uint32(^uint16(e-s)), 16)
+				bw.buf.Write(input[s:e])
+				if e == end {
+					break
+				}
+			}
+			for i := start; i+3 <= end; i++ {
+				head[hash(i)] = i
+			}
+		} else {
+			bw.write(final, 1)
+			bw.write(1, 2)
+
+			for i := start; i < end; {
+				length, dist := 0, 0
+				if i+3 <= end {
+					h := hash(i)
+					if j := head[h]; j >= 0 && i-j <= windowSize {
+						for length < maxLength && i+length < end && input[j+length] == input[i+length] {
+							length++
+						}
+						dist = i - j
+					}
+					head[h] = i
+				}
+
+				if length < 3 {
+					writeFixedLiteral(bw, int(input[i]))
+					i++
+					continue
+				}
+
+				writeFixedLength(bw, length)
+				writeFixedDistance(bw, dist)
+				for k := i + 1; k < i+length && k+3 <= end; k++ {
+					head[hash(k)] = k
+				}
+				i += length
+			}
+			writeFixedLiteral(bw, 256)
+		}
+		numBlocks++
+	}
+	bw.align()
+
+	return bw.buf.Bytes(), nil
+}
+
+var d64LengthBase = []int{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227}
+var d64LengthExtra = []uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5}
+var d64DistBase = []int{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577, 32769, 49153}
+var d64DistExtra = []uint{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13, 14, 14}
+
+func writeFixedLiteral(bw *bitWriter, sym int) {
+	switch {
+	case sym < 144:
+		bw.writeCode(uint32(0x30+sym), 8)
+	case sym < 256:
+		bw.writeCode(uint32(0x190+sym-144), 9)
+	case sym < 280:
+		bw.writeCode(uint32(sym-256), 7)
+	default:
+		bw.writeCode(uint32(0xc0+sym-280), 8)
+	}
+}
+
+func writeFixedLength(bw *bitWriter, length int) {
+	if length > 258 {
+		// Deflate64's length code 285 has 16 extra bits
+		writeFixedLiteral(bw, 285)
+		bw.write(uint32(length-3), 16)
+		return
+	}
+
+	code := len(d64LengthBase) - 1
+	for d64LengthBase[code] > length {
+		code--
+	}
+	writeFixedLiteral(bw, 257+code)
+	bw.write(uint32(length-d64LengthBase[code]), d64LengthExtra[code])
+}
+
+func writeFixedDistance(bw *bitWriter, dist int) {
+	code := len(d64DistBase) - 1
+	for d64DistBase[code] > dist {
+		code--
+	}
+	bw.writeCode(uint32(code), 5)
+	bw.write(uint32(dist-d64DistBase[code]), d64DistExtra[code])
+}
+
+// bitWriter writes bits starting from the least significant one, like deflate
+type bitWriter struct {
+	buf  bytes.Buffer
+	bits uint64
+	nb   uint
+}
+
+func (bw *bitWriter) write(value uint32, n uint) {
+	bw.bits |= uint64(value) << bw.nb
+	bw.nb += n
+	for bw.nb >= 8 {
+		bw.buf.WriteByte(byte(bw.bits))
+		bw.bits >>= 8
+		bw.nb -= 8
+	}
+}
+
+// writeCode writes a huffman code, which starts from its most significant bit
+func (bw *bitWriter) writeCode(code uint32, n uint) {
+	var rev uint32
+	for i := uint(0); i < n; i++ {
+		rev = rev<<1 | code&1
+		code >>= 1
+	}
+	bw.write(rev, n)
+}
+
+func (bw *bitWriter) align() {
+	if bw.nb > 0 {
+		bw.write(0, 8-bw.nb)
+	}
+}
@@ -0,0 +1,89 @@
+package checker
+
+import (
+	"context"
+	"log"
+	"testing"
+
+	"github.com/itchio/headway/state"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// RunExtractorCancelTest extracts an archive, cancelling the context passed to
+// ResumeContext at a few points along the way, and resuming from the final
+// checkpoint emitted after each cancellation, then validates the sink.
+func RunExtractorCancelTest(t *testing.T, makeExtractor MakeExtractorFunc, sink *Sink) {
+	var c *savior.ExtractorCheckpoint
+
+	sink.Reset()
+
+	cancelAt := 0.2
+	numCancels := 0
+	numFinalCheckpoints := 0
+	for {
+		if numCancels > 16 {
+			t.Error("Too many cancellations, something must be wrong")
+			t.FailNow()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var finalCheckpoint *savior.ExtractorCheckpoint
+		// only ever save after cancellation
+		sc := NewTestSaveConsumer(1<<50, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			if ctx.Err() != nil {
+				finalCheckpoint, _ = roundtripEThroughGob(t, checkpoint)
+			}
+			return savior.AfterSaveContinue, nil
+		})
+
+		consumer := &state.Consumer{
+			OnProgress: func(progress float64) {
+				if progress >= cancelAt {
+					cancel()
+				}
+			},
+		}
+
+		ex := makeExtractor()
+		ex.SetSaveConsumer(sc)
+		ex.SetConsumer(consumer)
+
+		cex, ok := ex.(savior.ContextExtractor)
+		if !ok {
+			t.Errorf("%s extractor does not support contexts", ex.Features().Name)
+			t.FailNow()
+		}
+
+		_, err := cex.ResumeContext(ctx, c, sink)
+		cancel()
+		if err != nil {
+			assert.True(t, errors.Is(err, context.Canceled), "error wraps context.Canceled")
+			assert.True(t, errors.Is(err, savior.ErrStop), "error wraps ErrStop")
+			assert.Equal(t, savior.ErrStop, errors.Cause(err))
+			if !errors.Is(err, context.Canceled) {
+				must(t, err)
+			}
+
+			numCancels++
+			if finalCheckpoint != nil {
+				log.Printf("↓ cancelled, final checkpoint @ %.0f%% (entry %d)", finalCheckpoint.Progress*100, finalCheckpoint.EntryIndex)
+				numFinalCheckpoints++
+				c = finalCheckpoint
+			} else {
+				log.Printf("↓ cancelled, no final checkpoint")
+			}
+			cancelAt += 0.25
+			continue
+		}
+
+		break
+	}
+
+	log.Printf(" ⇒ %d cancellations, %d final checkpoints", numCancels, numFinalCheckpoints)
+	assert.True(t, numCancels > 0, "extraction was cancelled at least once")
+	assert.True(t, numFinalCheckpoints > 0, "at least one final checkpoint was emitted")
+	assert.NoError(t, sink.Validate())
+}
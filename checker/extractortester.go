@@ -27,6 +27,7 @@ func RunExtractorText(t *testing.T, makeExtractor MakeExtractorFunc, sink *Sink,
 	sc := NewTestSaveConsumer(1*1024*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
 		if shouldSave() {
 			c2, checkpointSize := roundtripEThroughGob(t, checkpoint)
+			c2 = roundtripEThroughJSON(t, c2)
 			totalCheckpointSize += int64(checkpointSize)
 			c = c2
 			log.Printf("↓ saved @ %.0f%% (%s checkpoint, entry %d)", c.Progress*100, united.FormatBytes(checkpointSize), c.EntryIndex)
@@ -122,3 +123,16 @@ func roundtripEThroughGob(t *testing.T, c *savior.ExtractorCheckpoint) (*savior.
 
 	return c2, int64(buflen)
 }
+
+// roundtripEThroughJSON makes sure checkpoints (and their
+// Data payloads) survive a round-trip through savior.JSONCodec
+func roundtripEThroughJSON(t *testing.T, c *savior.ExtractorCheckpoint) *savior.ExtractorCheckpoint {
+	saveBuf := new(bytes.Buffer)
+	err := savior.JSONCodec.Encode(saveBuf, c)
+	must(t, err)
+
+	c2, err := savior.JSONCodec.Decode(saveBuf)
+	must(t, err)
+
+	return c2
+}
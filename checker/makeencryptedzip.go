@@ -0,0 +1,166 @@
+package checker
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/crc32"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/itchio/savior"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Encryption methods for MakeEncryptedZip
+const (
+	ZipCrypto = 0
+	AES128    = 1
+	AES192    = 2
+	AES256    = 3
+)
+
+// MakeEncryptedZip makes a zip in which every file and symlink is encrypted
+// with the given password, using either ZipCrypto or WinZip AES (AE-1 for
+// AES256, so that the CRC32 is kept, AE-2 otherwise). Every other file is
+// compressed with deflate.
+func MakeEncryptedZip(t *testing.T, sink *Sink, password string, encryption int) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	shouldCompress := true
+	var seed byte
+	for _, item := range sink.Items {
+		seed++
+		fh := &zip.FileHeader{
+			Name: item.Entry.CanonicalPath,
+		}
+
+		var data []byte
+		switch item.Entry.Kind {
+		case savior.EntryKindDir:
+			fh.SetMode(os.ModeDir | 0755)
+			_, err := zw.CreateHeader(fh)
+			must(t, err)
+			continue
+		case savior.EntryKindFile:
+			fh.SetMode(0644)
+			data = item.Data
+		case savior.EntryKindSymlink:
+			fh.SetMode(os.ModeSymlink | 0644)
+			data = []byte(item.Entry.Linkname)
+		}
+
+		fh.Method = zip.Store
+		payload := data
+		if shouldCompress && item.Entry.Kind == savior.EntryKindFile {
+			fh.Method = zip.Deflate
+			compressed, err := FlateCompress(data)
+			must(t, err)
+			payload = compressed
+		}
+		shouldCompress = !shouldCompress
+
+		fh.CRC32 = crc32.ChecksumIEEE(data)
+		fh.UncompressedSize64 = uint64(len(data))
+		fh.Flags |= 0x1
+
+		if encryption == ZipCrypto {
+			payload = zipCryptoEncrypt(payload, password, byte(fh.CRC32>>24), seed)
+		} else {
+			version := uint16(2)
+			if encryption == AES256 {
+				version = 1
+			} else {
+				fh.CRC32 = 0
+			}
+
+			extra := make([]byte, 11)
+			binary.LittleEndian.PutUint16(extra[0:], 0x9901)
+			binary.LittleEndian.PutUint16(extra[2:], 7)
+			binary.LittleEndian.PutUint16(extra[4:], version)
+			copy(extra[6:], "AE")
+			extra[8] = byte(encryption)
+			binary.LittleEndian.PutUint16(extra[9:], fh.Method)
+			fh.Extra = extra
+
+			payload = winzipAESEncrypt(payload, password, encryption, seed)
+			fh.Method = 99
+		}
+		fh.CompressedSize64 = uint64(len(payload))
+
+		w, err := zw.CreateRaw(fh)
+		must(t, err)
+		_, err = w.Write(payload)
+		must(t, err)
+	}
+
+	err := zw.Close()
+	must(t, err)
+
+	log.Printf("Made encrypted zip (method %d)", encryption)
+
+	return buf.Bytes()
+}
+
+func zipCryptoEncrypt(payload []byte, password string, check byte, seed byte) []byte {
+	keys := [3]uint32{0x12345678, 0x23456789, 0x34567890}
+	update := func(b byte) {
+		keys[0] = crc32.IEEETable[byte(keys[0])^b] ^ (keys[0] >> 8)
+		keys[1] = (keys[1]+keys[0]&0xff)*134775813 + 1
+		keys[2] = crc32.IEEETable[byte(keys[2])^byte(keys[1]>>24)] ^ (keys[2] >> 8)
+	}
+	for i := 0; i < len(password); i++ {
+		update(password[i])
+	}
+
+	header := make([]byte, 12)
+	for i := range header {
+		header[i] = seed + byte(i)
+	}
+	header[11] = check
+
+	res := append(header, payload...)
+	for i, b := range res {
+		t := keys[2] | 2
+		res[i] = b ^ byte((t*(t^1))>>8)
+		update(b)
+	}
+	return res
+}
+
+func winzipAESEncrypt(payload []byte, password string, strength int, seed byte) []byte {
+	keySize := 8 + 8*strength
+	salt := make([]byte, keySize/2)
+	for i := range salt {
+		salt[i] = seed + byte(i)
+	}
+
+	keys := pbkdf2.Key([]byte(password), salt, 1000, 2*keySize+2, sha1.New)
+	block, err := aes.NewCipher(keys[:keySize])
+	if err != nil {
+		panic(err)
+	}
+
+	encrypted := make([]byte, len(payload))
+	var counter, keystream [aes.BlockSize]byte
+	for i := range payload {
+		if i%aes.BlockSize == 0 {
+			binary.LittleEndian.PutUint64(counter[:], uint64(i/aes.BlockSize+1))
+			block.Encrypt(keystream[:], counter[:])
+		}
+		encrypted[i] = payload[i] ^ keystream[i%aes.BlockSize]
+	}
+
+	mac := hmac.New(sha1.New, keys[keySize:2*keySize])
+	mac.Write(encrypted)
+
+	res := append(salt, keys[2*keySize:]...)
+	res = append(res, encrypted...)
+	res = append(res, mac.Sum(nil)[:10]...)
+	return res
+}
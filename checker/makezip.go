@@ -2,6 +2,7 @@ package checker
 
 import (
 	"bytes"
+	"io"
 	"log"
 	"os"
 	"testing"
@@ -11,12 +12,27 @@ import (
 	"github.com/itchio/savior"
 )
 
+// CompressFunc compresses a whole buffer at once
+type CompressFunc func(input []byte) ([]byte, error)
+
 func MakeZip(t *testing.T, sink *Sink) []byte {
+	return MakeZipWithMethod(t, sink, zip.Deflate, nil)
+}
+
+// MakeZipWithMethod makes a zip in which every other file is compressed
+// with `method`. If compress is nil, the method must have a compressor
+// registered with the zip package.
+func MakeZipWithMethod(t *testing.T, sink *Sink, method uint16, compress CompressFunc) []byte {
 	buf := new(bytes.Buffer)
 	zw := zip.NewWriter(buf)
+	if compress != nil {
+		zw.RegisterCompressor(method, func(s zip.CompressionSettings, w io.Writer) (io.WriteCloser, error) {
+			return &bufferedCompressor{w: w, compress: compress}, nil
+		})
+	}
 
 	shouldCompress := true
-	numDeflate := 0
+	numCompressed := 0
 	numStore := 0
 
 	for _, item := range sink.Items {
@@ -32,8 +48,8 @@ func MakeZip(t *testing.T, sink *Sink) []byte {
 		case savior.EntryKindFile:
 			fh.SetMode(0644)
 			if shouldCompress {
-				fh.Method = zip.Deflate
-				numDeflate++
+				fh.Method = method
+				numCompressed++
 			} else {
 				fh.Method = zip.Store
 				numStore++
@@ -57,7 +73,26 @@ func MakeZip(t *testing.T, sink *Sink) []byte {
 	err := zw.Close()
 	must(t, err)
 
-	log.Printf("Made zip with %d deflate files, %d store files", numDeflate, numStore)
+	log.Printf("Made zip with %d compressed (method %d) files, %d store files", numCompressed, method, numStore)
 
 	return buf.Bytes()
 }
+
+type bufferedCompressor struct {
+	w        io.Writer
+	compress CompressFunc
+	buf      bytes.Buffer
+}
+
+func (bc *bufferedCompressor) Write(p []byte) (int, error) {
+	return bc.buf.Write(p)
+}
+
+func (bc *bufferedCompressor) Close() error {
+	compressed, err := bc.compress(bc.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = bc.w.Write(compressed)
+	return err
+}
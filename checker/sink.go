@@ -5,6 +5,7 @@ import (
 	"io"
 	"log"
 	"math"
+	"sync"
 
 	"github.com/itchio/savior"
 	"github.com/pkg/errors"
@@ -17,9 +18,11 @@ import (
 type Sink struct {
 	Items     map[string]*Item
 	DoneItems map[string]*DoneItem
+
+	mu sync.Mutex
 }
 
-var _ savior.Sink = (*Sink)(nil)
+var _ savior.ConcurrentSink = (*Sink)(nil)
 
 // Item represents a savior.Entry + bytes pair
 type Item struct {
@@ -43,6 +46,18 @@ func NewSink() *Sink {
 	return cs
 }
 
+// Filter returns a new sink holding only the items accepted by filter,
+// to validate extractions that skip some entries.
+func (cs *Sink) Filter(filter savior.Filter) *Sink {
+	res := NewSink()
+	for name, item := range cs.Items {
+		if filter(item.Entry) {
+			res.Items[name] = item
+		}
+	}
+	return res
+}
+
 func (cs *Sink) Reset() {
 	cs.DoneItems = make(map[string]*DoneItem)
 }
@@ -124,6 +139,12 @@ func (cs *Sink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
 	return ew, nil
 }
 
+// GetConcurrentWriter is like GetWriter, since
+// writers don't need to be closed anyway
+func (cs *Sink) GetConcurrentWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	return cs.GetWriter(entry)
+}
+
 func (cs *Sink) Preallocate(entry *savior.Entry) error {
 	return cs.withItem(entry, savior.EntryKindFile, func(item *Item, di *DoneItem) error {
 		// nothing to do
@@ -141,6 +162,9 @@ func (cs *Sink) Nuke() error {
 type withItemFunc func(item *Item, di *DoneItem) error
 
 func (cs *Sink) withItem(entry *savior.Entry, actualKind savior.EntryKind, cb withItemFunc) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
 	item, ok := cs.Items[entry.CanonicalPath]
 	if !ok {
 		err := fmt.Errorf("%s: no such item", entry.CanonicalPath)
@@ -3,6 +3,7 @@ package checker
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"io"
 	"log"
 	"testing"
@@ -51,6 +52,7 @@ func RunSourceTest(t *testing.T, source savior.Source, reference []byte) {
 			}
 
 			c2, checkpointSize := roundtripThroughGob(t, c)
+			c2 = roundtripThroughJSON(t, c2)
 
 			totalCheckpoints++
 			log.Printf("%s ↓ made %s checkpoint @ %.2f%% (byte %d)", united.FormatBytes(c2.Offset), united.FormatBytes(checkpointSize), source.Progress()*100, c2.Offset)
@@ -105,3 +107,16 @@ func roundtripThroughGob(t *testing.T, c *savior.SourceCheckpoint) (*savior.Sour
 
 	return c2, int64(buflen)
 }
+
+// roundtripThroughJSON makes sure checkpoints (and their
+// Data payloads) survive being encoded as JSON
+func roundtripThroughJSON(t *testing.T, c *savior.SourceCheckpoint) *savior.SourceCheckpoint {
+	buf, err := json.Marshal(c)
+	must(t, err)
+
+	c2 := &savior.SourceCheckpoint{}
+	err = json.Unmarshal(buf, c2)
+	must(t, err)
+
+	return c2
+}
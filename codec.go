@@ -0,0 +1,195 @@
+package savior
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// A CheckpointCodec serializes extractor checkpoints.
+type CheckpointCodec interface {
+	Encode(w io.Writer, checkpoint *ExtractorCheckpoint) error
+	Decode(r io.Reader) (*ExtractorCheckpoint, error)
+}
+
+// GobCodec encodes checkpoints with encoding/gob. It's compact, but only
+// readable from Go. Data payloads must be registered with gob.Register.
+var GobCodec CheckpointCodec = &gobCodec{}
+
+// JSONCodec encodes checkpoints as JSON, which can be stored (and inspected)
+// by non-Go programs. Data payloads must be registered with RegisterCheckpointType,
+// and are encoded as {"type": name, "value": payload}.
+var JSONCodec CheckpointCodec = &jsonCodec{}
+
+type gobCodec struct{}
+
+func (gc *gobCodec) Encode(w io.Writer, checkpoint *ExtractorCheckpoint) error {
+	return errors.WithStack(gob.NewEncoder(w).Encode(checkpoint))
+}
+
+func (gc *gobCodec) Decode(r io.Reader) (*ExtractorCheckpoint, error) {
+	checkpoint := &ExtractorCheckpoint{}
+	err := gob.NewDecoder(r).Decode(checkpoint)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return checkpoint, nil
+}
+
+type jsonCodec struct{}
+
+func (jc *jsonCodec) Encode(w io.Writer, checkpoint *ExtractorCheckpoint) error {
+	return errors.WithStack(json.NewEncoder(w).Encode(checkpoint))
+}
+
+func (jc *jsonCodec) Decode(r io.Reader) (*ExtractorCheckpoint, error) {
+	checkpoint := &ExtractorCheckpoint{}
+	err := json.NewDecoder(r).Decode(checkpoint)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return checkpoint, nil
+}
+
+var checkpointTypes = struct {
+	lock   sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}{
+	byName: make(map[string]reflect.Type),
+	byType: make(map[reflect.Type]string),
+}
+
+// RegisterCheckpointType registers the type of value, which can be found in the
+// Data field of an ExtractorCheckpoint or a SourceCheckpoint, under a name that
+// must stay the same across versions, so that codecs like JSONCodec can decode it.
+//
+// Like gob.Register, it's meant to be called from init functions.
+func RegisterCheckpointType(name string, value any) {
+	checkpointTypes.lock.Lock()
+	defer checkpointTypes.lock.Unlock()
+
+	typ := reflect.TypeOf(value)
+	if other, ok := checkpointTypes.byName[name]; ok && other != typ {
+		panic(fmt.Sprintf("savior: checkpoint type name %q registered twice (for %v and %v)", name, other, typ))
+	}
+	checkpointTypes.byName[name] = typ
+	checkpointTypes.byType[typ] = name
+}
+
+// typedData is how Data payloads are encoded in JSON
+type typedData struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func marshalData(data any) (*typedData, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	checkpointTypes.lock.RLock()
+	name, ok := checkpointTypes.byType[reflect.TypeOf(data)]
+	checkpointTypes.lock.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("savior: checkpoint type %T is not registered", data)
+	}
+
+	value, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &typedData{
+		Type:  name,
+		Value: value,
+	}, nil
+}
+
+func unmarshalData(td *typedData) (any, error) {
+	if td == nil {
+		return nil, nil
+	}
+
+	checkpointTypes.lock.RLock()
+	typ, ok := checkpointTypes.byName[td.Type]
+	checkpointTypes.lock.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("savior: unknown checkpoint type %q", td.Type)
+	}
+
+	// registered types are usually pointers to structs
+	var ptr reflect.Value
+	if typ.Kind() == reflect.Ptr {
+		ptr = reflect.New(typ.Elem())
+	} else {
+		ptr = reflect.New(typ)
+	}
+
+	err := json.Unmarshal(td.Value, ptr.Interface())
+	if err != nil {
+		return nil, errors.Wrapf(err, "decoding checkpoint type %q", td.Type)
+	}
+
+	if typ.Kind() == reflect.Ptr {
+		return ptr.Interface(), nil
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+func (ec *ExtractorCheckpoint) MarshalJSON() ([]byte, error) {
+	type plain ExtractorCheckpoint
+	data, err := marshalData(ec.Data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&struct {
+		*plain
+		Data *typedData
+	}{(*plain)(ec), data})
+}
+
+func (ec *ExtractorCheckpoint) UnmarshalJSON(buf []byte) error {
+	type plain ExtractorCheckpoint
+	aux := &struct {
+		*plain
+		Data *typedData
+	}{plain: (*plain)(ec)}
+	err := json.Unmarshal(buf, aux)
+	if err != nil {
+		return err
+	}
+	ec.Data, err = unmarshalData(aux.Data)
+	return err
+}
+
+func (sc *SourceCheckpoint) MarshalJSON() ([]byte, error) {
+	type plain SourceCheckpoint
+	data, err := marshalData(sc.Data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&struct {
+		*plain
+		Data *typedData
+	}{(*plain)(sc), data})
+}
+
+func (sc *SourceCheckpoint) UnmarshalJSON(buf []byte) error {
+	type plain SourceCheckpoint
+	aux := &struct {
+		*plain
+		Data *typedData
+	}{plain: (*plain)(sc)}
+	err := json.Unmarshal(buf, aux)
+	if err != nil {
+		return err
+	}
+	sc.Data, err = unmarshalData(aux.Data)
+	return err
+}
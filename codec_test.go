@@ -0,0 +1,58 @@
+package savior_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/gzipsource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_JSONCodec(t *testing.T) {
+	c := &savior.ExtractorCheckpoint{
+		EntryIndex: 3,
+		Progress:   0.5,
+		SourceCheckpoint: &savior.SourceCheckpoint{
+			Offset: 1234,
+			Data: &gzipsource.GzipSourceCheckpoint{
+				Offset: 1234,
+				SourceCheckpoint: &savior.SourceCheckpoint{
+					Offset: 567,
+				},
+			},
+		},
+		Data: &tarextractor.TarExtractorState{
+			Result: &savior.ExtractorResult{},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	tmust(t, savior.JSONCodec.Encode(buf, c))
+
+	// payloads are tagged with their registered name, for non-Go readers
+	var raw map[string]any
+	tmust(t, json.Unmarshal(buf.Bytes(), &raw))
+	assert.EqualValues(t, "tarextractor.TarExtractorState", raw["Data"].(map[string]any)["type"])
+
+	c2, err := savior.JSONCodec.Decode(buf)
+	tmust(t, err)
+	assert.EqualValues(t, c.EntryIndex, c2.EntryIndex)
+	assert.IsType(t, &tarextractor.TarExtractorState{}, c2.Data)
+
+	gc, ok := c2.SourceCheckpoint.Data.(*gzipsource.GzipSourceCheckpoint)
+	if assert.True(t, ok) {
+		assert.EqualValues(t, 1234, gc.Offset)
+		assert.EqualValues(t, 567, gc.SourceCheckpoint.Offset)
+		assert.Nil(t, gc.SourceCheckpoint.Data)
+	}
+
+	type unregistered struct{}
+	c.Data = &unregistered{}
+	assert.Error(t, savior.JSONCodec.Encode(new(bytes.Buffer), c))
+
+	_, err = savior.JSONCodec.Decode(bytes.NewReader([]byte(`{"Data":{"type":"nope","value":{}}}`)))
+	assert.Error(t, err)
+}
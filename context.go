@@ -0,0 +1,55 @@
+package savior
+
+import (
+	"context"
+	"fmt"
+)
+
+// A ContextExtractor is an extractor whose extraction can be cancelled
+// with a context. When the context is done, it tries to emit a final
+// checkpoint (through its SaveConsumer) before returning a *CancelledError.
+//
+// Whether it can depends on where it's at. In the middle of a file entry,
+// the copier asks for a save, then keeps copying until one is made, or until
+// it gives up after 4MiB: extractors that save through their source (like
+// tarextractor and zipextractor.NewStream) can only do so when the source
+// emits a checkpoint, and entries that can't be saved in the middle of
+// (like zip entries whose method only has entry resume support) stop
+// right away. Between entries, extractors that can save without
+// their source (like zipextractor.New) make a checkpoint for the next entry,
+// but the others return right away. Either way, a *CancelledError doesn't
+// mean a final checkpoint was made: the last one that was is still valid.
+type ContextExtractor interface {
+	Extractor
+
+	ResumeContext(ctx context.Context, checkpoint *ExtractorCheckpoint, sink Sink) (*ExtractorResult, error)
+}
+
+// A CancelledError is returned when extraction stops because its context is
+// done. It matches both ErrStop and the context's error with errors.Is, and
+// its Cause (as in github.com/pkg/errors) is ErrStop, so code that handles
+// stops after save keeps working.
+type CancelledError struct {
+	// Err is the error of the context, either context.Canceled
+	// or context.DeadlineExceeded
+	Err error
+}
+
+var _ error = (*CancelledError)(nil)
+
+// NewCancelledError returns a *CancelledError for a context that is done
+func NewCancelledError(ctx context.Context) *CancelledError {
+	return &CancelledError{Err: ctx.Err()}
+}
+
+func (ce *CancelledError) Error() string {
+	return fmt.Sprintf("extraction stopped: %v", ce.Err)
+}
+
+func (ce *CancelledError) Unwrap() []error {
+	return []error{ErrStop, ce.Err}
+}
+
+func (ce *CancelledError) Cause() error {
+	return ErrStop
+}
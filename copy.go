@@ -1,6 +1,7 @@
 package savior
 
 import (
+	"context"
 	"io"
 
 	"github.com/pkg/errors"
@@ -21,13 +22,35 @@ type CopyParams struct {
 	Dst   io.Writer
 	Entry *Entry
 
+	// If nil, there's nothing to save, and no checkpoints are made
 	Savable Savable
 
 	EmitProgress EmitProgressFunc
+
+	// If non-nil, copying stops when the context is done. If Savable is set,
+	// a final save is requested first, and the copier keeps going until it's
+	// stopped (usually from the save callback), or gives up after a while.
+	Context context.Context
+
+	// If non-nil, copying fails with a *LimitError when Entry grows
+	// past its declared size, or past the maximum compression ratio.
+	Limits *Limits
+
+	// If non-nil, Entry's sizes aren't known until it's extracted, and
+	// Compressed returns how many bytes of compressed data were read so
+	// far. Limits are then checked with Limits.CheckStreamProgress, with
+	// TotalBytes as the number of bytes extracted before Entry.
+	Compressed func() int64
+	TotalBytes int64
 }
 
 const progressThreshold = 512 * 1024
 
+// how many bytes we're willing to copy after a context is
+// done, while waiting for a final checkpoint to be emitted,
+// see ContextExtractor
+const cancelGraceThreshold = 4 * 1024 * 1024
+
 type Copier struct {
 	// params
 	SaveConsumer SaveConsumer
@@ -53,15 +76,57 @@ func (c *Copier) Do(params *CopyParams) error {
 
 	var progressCounter int64
 
+	ctx := params.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var cancelled bool
+	var graceCounter int64
+
+	var written int64
+	if params.Entry != nil {
+		written = params.Entry.WriteOffset
+	}
+
 	for !c.stop {
+		if cancelled {
+			if graceCounter > cancelGraceThreshold {
+				Debugf("copier: no checkpoint after %d bytes, giving up", graceCounter)
+				return NewCancelledError(ctx)
+			}
+		} else if ctx.Err() != nil {
+			if params.Savable == nil {
+				return NewCancelledError(ctx)
+			}
+			Debugf("copier: context done, asking for a final save")
+			cancelled = true
+			params.Savable.WantSave()
+		}
+
 		n, readErr := params.Src.Read(c.buf)
 
+		if params.Limits != nil && params.Entry != nil {
+			var err error
+			if params.Compressed != nil {
+				err = params.Limits.CheckStreamProgress(params.Entry, params.Compressed(), written+int64(n), params.TotalBytes)
+			} else {
+				err = params.Limits.CheckProgress(params.Entry, written+int64(n))
+			}
+			if err != nil {
+				return err
+			}
+		}
+
 		m, err := params.Dst.Write(c.buf[:n])
 		if err != nil {
 			return errors.WithStack(err)
 		}
 
 		progressCounter += int64(m)
+		written += int64(m)
+		if cancelled {
+			graceCounter += int64(m)
+		}
 		if progressCounter > progressThreshold {
 			progressCounter = 0
 			if params.EmitProgress != nil {
@@ -77,7 +142,7 @@ func (c *Copier) Do(params *CopyParams) error {
 			return errors.WithStack(readErr)
 		}
 
-		if c.SaveConsumer.ShouldSave(int64(n)) {
+		if !cancelled && params.Savable != nil && c.SaveConsumer.ShouldSave(int64(n)) {
 			params.Savable.WantSave()
 		}
 	}
@@ -70,6 +70,10 @@ func (cs *countingSource) WantSave() {
 	cs.source.WantSave()
 }
 
+func (cs *countingSource) Fingerprint() (*savior.Fingerprint, error) {
+	return savior.SourceFingerprint(cs.source)
+}
+
 func (cs *countingSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
 	cs.source.SetSourceSaveConsumer(ssc)
 }
@@ -0,0 +1,188 @@
+package deflate64source
+
+import (
+	"encoding/gob"
+	"fmt"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/internal/deflate64"
+	"github.com/pkg/errors"
+)
+
+type deflate64Source struct {
+	// input
+	source savior.Source
+
+	// internal
+	sr      deflate64.SaverReader
+	offset  int64
+	counter int64
+	bytebuf []byte
+
+	ssc              savior.SourceSaveConsumer
+	sourceCheckpoint *savior.SourceCheckpoint
+}
+
+type Deflate64SourceCheckpoint struct {
+	SourceCheckpoint    *savior.SourceCheckpoint
+	Deflate64Checkpoint *deflate64.Checkpoint
+}
+
+var _ savior.Source = (*deflate64Source)(nil)
+
+func New(source savior.Source) *deflate64Source {
+	return &deflate64Source{
+		source:  source,
+		bytebuf: []byte{0x00},
+	}
+}
+
+func (fs *deflate64Source) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "deflate64",
+		ResumeSupport: savior.ResumeSupportBlock,
+	}
+}
+
+func (fs *deflate64Source) Fingerprint() (*savior.Fingerprint, error) {
+	return savior.SourceFingerprint(fs.source)
+}
+
+func (fs *deflate64Source) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	fs.ssc = ssc
+	fs.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			fs.sourceCheckpoint = checkpoint
+			fs.sr.WantSave()
+			return nil
+		},
+	})
+}
+
+func (fs *deflate64Source) WantSave() {
+	fs.source.WantSave()
+}
+
+func (fs *deflate64Source) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	savior.Debugf(`deflate64source: asked to resume`)
+
+	if checkpoint != nil {
+		if ourCheckpoint, ok := checkpoint.Data.(*Deflate64SourceCheckpoint); ok {
+			sourceOffset, err := fs.source.Resume(ourCheckpoint.SourceCheckpoint)
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+
+			fc := ourCheckpoint.Deflate64Checkpoint
+			if sourceOffset < fc.Roffset {
+				delta := fc.Roffset - sourceOffset
+				savior.Debugf(`deflate64source: discarding %d bytes to align source with decompressor`, delta)
+				err = savior.DiscardByRead(fs.source, delta)
+				if err != nil {
+					return 0, errors.WithStack(err)
+				}
+				sourceOffset += delta
+			}
+
+			if sourceOffset == fc.Roffset {
+				fs.sr, err = fc.Resume(fs.source)
+				if err != nil {
+					savior.Debugf(`deflate64source: could not use deflate64 checkpoint at R=%d / W=%d`, fc.Roffset, fc.Woffset)
+					// well, let's start over
+					_, err = fs.source.Resume(nil)
+					if err != nil {
+						return 0, errors.WithStack(err)
+					}
+				} else {
+					fs.offset = fc.Woffset
+					return fc.Woffset, nil
+				}
+			} else {
+				savior.Debugf(`deflate64source: expected source to resume at %d but got %d`, fc.Roffset, sourceOffset)
+			}
+		}
+	}
+
+	// start from beginning
+	sourceOffset, err := fs.source.Resume(nil)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if sourceOffset != 0 {
+		msg := fmt.Sprintf("deflate64source: expected source to resume at start but got %d", sourceOffset)
+		return 0, errors.New(msg)
+	}
+
+	fs.sr = deflate64.NewSaverReader(fs.source)
+	fs.offset = 0
+	return 0, nil
+}
+
+func (fs *deflate64Source) Read(buf []byte) (int, error) {
+	if fs.sr == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	n, err := fs.sr.Read(buf)
+	fs.offset += int64(n)
+
+	if err == deflate64.ReadyToSaveError {
+		err = nil
+
+		if fs.sourceCheckpoint == nil {
+			savior.Debugf("deflate64source: can't save, sourceCheckpoint is nil!")
+		} else if fs.ssc == nil {
+			savior.Debugf("deflate64source: can't save, ssc is nil!")
+		} else {
+			deflate64Checkpoint, saveErr := fs.sr.Save()
+			if saveErr != nil {
+				return n, saveErr
+			}
+
+			savior.Debugf("deflate64source: saving, deflate64 rOffset = %d, sourceCheckpoint.Offset = %d", deflate64Checkpoint.Roffset, fs.sourceCheckpoint.Offset)
+
+			checkpoint := &savior.SourceCheckpoint{
+				Offset: fs.offset,
+				Data: &Deflate64SourceCheckpoint{
+					Deflate64Checkpoint: deflate64Checkpoint,
+					SourceCheckpoint:    fs.sourceCheckpoint,
+				},
+			}
+			fs.sourceCheckpoint = nil
+
+			err = fs.ssc.Save(checkpoint)
+			savior.Debugf("deflate64source: saved checkpoint at byte %d", fs.offset)
+		}
+	}
+
+	return n, err
+}
+
+func (fs *deflate64Source) ReadByte() (byte, error) {
+	if fs.sr == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	n, err := fs.Read(fs.bytebuf)
+	if n == 0 {
+		/* this happens when Read needs to save, but it swallows the error */
+		/* we're not meant to surface them, but there's no way to handle a */
+		/* short read from ReadByte, so we just read again */
+		n, err = fs.Read(fs.bytebuf)
+	}
+
+	return fs.bytebuf[0], err
+}
+
+func (fs *deflate64Source) Progress() float64 {
+	// We can't tell how large the uncompressed stream is until we finish
+	// decompressing it. The underlying's source progress is a good enough
+	// approximation.
+	return fs.source.Progress()
+}
+
+func init() {
+	gob.Register(&Deflate64SourceCheckpoint{})
+	savior.RegisterCheckpointType("deflate64source.Deflate64SourceCheckpoint", &Deflate64SourceCheckpoint{})
+}
@@ -0,0 +1,114 @@
+package deflate64source_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"hash/crc32"
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/headway/united"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/deflate64source"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func must(t *testing.T, err error) {
+	assert.NoError(t, err)
+	if err != nil {
+		t.FailNow()
+	}
+}
+
+func Test_Uninitialized(t *testing.T) {
+	ss := seeksource.FromBytes(nil)
+	_, err := ss.Resume(nil)
+	assert.NoError(t, err)
+
+	ds := deflate64source.New(ss)
+	_, err = ds.Read([]byte{})
+	assert.Error(t, err)
+	assert.True(t, errors.Cause(err) == savior.ErrUninitializedSource)
+
+	_, err = ds.ReadByte()
+	assert.Error(t, err)
+	assert.True(t, errors.Cause(err) == savior.ErrUninitializedSource)
+}
+
+func Test_Checkpoints(t *testing.T) {
+	reference := semirandom.Bytes(4 * 1024 * 1024 /* 4 MiB of random data */)
+	compressed, err := checker.Deflate64Compress(reference)
+	must(t, err)
+
+	log.Printf("uncompressed size: %s", united.FormatBytes(int64(len(reference))))
+	log.Printf("  compressed size: %s", united.FormatBytes(int64(len(compressed))))
+
+	source := seeksource.FromBytes(compressed)
+	ds := deflate64source.New(source)
+
+	checker.RunSourceTest(t, ds, reference)
+}
+
+func Test_DeflateCompatible(t *testing.T) {
+	reference := semirandom.Bytes(1024 * 1024)
+
+	// without matches, deflate streams are also valid Deflate64 streams,
+	// which covers dynamic huffman blocks
+	for _, level := range []int{flate.NoCompression, flate.HuffmanOnly} {
+		buf := new(bytes.Buffer)
+		w, err := flate.NewWriter(buf, level)
+		must(t, err)
+		_, err = w.Write(reference)
+		must(t, err)
+		must(t, w.Close())
+
+		source := seeksource.FromBytes(buf.Bytes())
+		checker.RunSourceTest(t, deflate64source.New(source), reference)
+	}
+}
+
+// Test_Fixtures decodes the Deflate64 entries of the zips in testdata,
+// which weren't made by checker.Deflate64Compress, and checks them against
+// their CRC32. More can be added as-is, from 7-Zip or Windows for example.
+func Test_Fixtures(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.zip"))
+	must(t, err)
+	assert.NotEmpty(t, paths)
+
+	for _, path := range paths {
+		zr, err := zip.OpenReader(path)
+		must(t, err)
+
+		for _, zf := range zr.File {
+			if zf.Method != 9 {
+				continue
+			}
+
+			t.Run(filepath.Base(path)+"/"+zf.Name, func(t *testing.T) {
+				r, err := zf.OpenRaw()
+				must(t, err)
+				compressed, err := io.ReadAll(r)
+				must(t, err)
+
+				ds := deflate64source.New(seeksource.FromBytes(compressed))
+				_, err = ds.Resume(nil)
+				must(t, err)
+				decompressed, err := io.ReadAll(ds)
+				must(t, err)
+
+				assert.EqualValues(t, zf.UncompressedSize64, len(decompressed))
+				assert.EqualValues(t, zf.CRC32, crc32.ChecksumIEEE(decompressed))
+
+				checker.RunSourceTest(t, deflate64source.New(seeksource.FromBytes(compressed)), decompressed)
+			})
+		}
+		must(t, zr.Close())
+	}
+}
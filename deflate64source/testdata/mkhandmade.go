@@ -0,0 +1,209 @@
+//go:build ignore
+
+// mkhandmade writes handmade.zip, whose only entry is a Deflate64 stream
+// assembled by hand from the format description, independently of
+// checker.Deflate64Compress: dynamic huffman blocks, with 32 distance
+// codes, using length code 285 and distance codes 30 and 31. There's
+// a few of them, so that the source can make checkpoints in between.
+//
+// Usage: go run mkhandmade.go
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"hash/crc32"
+	"log"
+	"math/rand"
+	"os"
+)
+
+type bitWriter struct {
+	buf   bytes.Buffer
+	acc   uint32
+	nbits uint
+}
+
+// write writes the n low bits of value, least significant first
+func (bw *bitWriter) write(value uint32, n uint) {
+	for i := uint(0); i < n; i++ {
+		bw.acc |= ((value >> i) & 1) << bw.nbits
+		bw.nbits++
+		if bw.nbits == 8 {
+			bw.buf.WriteByte(byte(bw.acc))
+			bw.acc = 0
+			bw.nbits = 0
+		}
+	}
+}
+
+// writeCode writes a huffman code, most significant bit first
+func (bw *bitWriter) writeCode(c code) {
+	for i := int(c.length) - 1; i >= 0; i-- {
+		bw.write(c.bits>>uint(i), 1)
+	}
+}
+
+func (bw *bitWriter) flush() []byte {
+	if bw.nbits > 0 {
+		bw.buf.WriteByte(byte(bw.acc))
+	}
+	return bw.buf.Bytes()
+}
+
+type code struct {
+	bits   uint32
+	length uint
+}
+
+// canonical returns the canonical huffman codes for the given code lengths
+func canonical(lengths []uint) []code {
+	var count [16]uint32
+	for _, l := range lengths {
+		if l > 0 {
+			count[l]++
+		}
+	}
+	var next [16]uint32
+	c := uint32(0)
+	for l := 1; l < 16; l++ {
+		c = (c + count[l-1]) << 1
+		next[l] = c
+	}
+	codes := make([]code, len(lengths))
+	for sym, l := range lengths {
+		if l > 0 {
+			codes[sym] = code{bits: next[l], length: l}
+			next[l]++
+		}
+	}
+	return codes
+}
+
+func main() {
+	const (
+		numBlocks   = 6
+		numLiterals = 50000
+		// length code 285 is 3 + 16 extra bits in Deflate64
+		longLength = 65538
+		// distance code 31 is 49153 + 14 extra bits
+		longDistance = 50000
+		shortLength  = 1000
+		// distance code 30 is 32769 + 14 extra bits
+		shortDistance = 40000
+	)
+
+	rng := rand.New(rand.NewSource(0x64))
+	var data []byte
+
+	// literal/length code: a, b, c are 2 bits, d 3 bits,
+	// end of block and 285 are 4 bits
+	litLengths := make([]uint, 286)
+	litLengths['a'], litLengths['b'], litLengths['c'], litLengths['d'] = 2, 2, 2, 3
+	litLengths[256], litLengths[285] = 4, 4
+	litCodes := canonical(litLengths)
+
+	// distance code: only 30 and 31, 1 bit each
+	distLengths := make([]uint, 32)
+	distLengths[30], distLengths[31] = 1, 1
+	distCodes := canonical(distLengths)
+
+	// code length code: 18 (runs of zeros), 1 and 2 are 2 bits, 3 and 4 are 3 bits
+	clLengths := make([]uint, 19)
+	clLengths[18], clLengths[1], clLengths[2] = 2, 2, 2
+	clLengths[3], clLengths[4] = 3, 3
+	clCodes := canonical(clLengths)
+	clOrder := []int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+	bw := &bitWriter{}
+	for block := 0; block < numBlocks; block++ {
+		final := uint32(0)
+		if block == numBlocks-1 {
+			final = 1
+		}
+		// dynamic block
+		bw.write(final, 1)
+		bw.write(2, 2)
+		bw.write(286-257, 5)
+		// 32 distance codes, which deflate doesn't allow
+		bw.write(32-1, 5)
+		// code lengths up to that of 1, in clOrder
+		bw.write(18-4, 4)
+		for _, sym := range clOrder[:18] {
+			bw.write(uint32(clLengths[sym]), 3)
+		}
+
+		zeros := func(n uint32) {
+			bw.writeCode(clCodes[18])
+			bw.write(n-11, 7)
+		}
+		lengths := func(ls ...uint) {
+			for _, l := range ls {
+				bw.writeCode(clCodes[l])
+			}
+		}
+		// literal/length code lengths
+		zeros(97)
+		lengths(2, 2, 2, 3)
+		zeros(138)
+		zeros(17)
+		lengths(4)
+		zeros(28)
+		lengths(4)
+		// distance code lengths
+		zeros(30)
+		lengths(1, 1)
+
+		for i := 0; i < numLiterals; i++ {
+			b := "abcd"[rng.Intn(4)]
+			data = append(data, b)
+			bw.writeCode(litCodes[b])
+		}
+
+		for _, m := range []struct{ length, distance int }{{longLength, longDistance}, {shortLength, shortDistance}} {
+			bw.writeCode(litCodes[285])
+			bw.write(uint32(m.length-3), 16)
+			if m.distance > 49152 {
+				bw.writeCode(distCodes[31])
+				bw.write(uint32(m.distance-49153), 14)
+			} else {
+				bw.writeCode(distCodes[30])
+				bw.write(uint32(m.distance-32769), 14)
+			}
+
+			for i := 0; i < m.length; i++ {
+				data = append(data, data[len(data)-m.distance])
+			}
+		}
+
+		bw.writeCode(litCodes[256])
+	}
+	compressed := bw.flush()
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "handmade.txt",
+		Method:             9,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(len(compressed)),
+		UncompressedSize64: uint64(len(data)),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = w.Write(compressed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = zw.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = os.WriteFile("handmade.zip", buf.Bytes(), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote handmade.zip (%d bytes, decompresses to %d)", buf.Len(), len(data))
+}
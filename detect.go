@@ -0,0 +1,186 @@
+package savior
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// detectHeaderSize is how many bytes are sniffed to detect a format.
+// It's enough to hold a tar header.
+const detectHeaderSize = 512
+
+// A CompressionFormat is a compressed stream format that may wrap an archive,
+// like gzip (for .tar.gz files)
+type CompressionFormat struct {
+	// Short name for the format, like "gzip"
+	Name string
+	// Match returns true if the first bytes of a stream look like this format.
+	// Formats without a magic number (like brotli) leave it nil, and are only
+	// tried after all the others, by attempting to decompress the stream.
+	Match func(header []byte) bool
+	// NewSource returns a Source that decompresses the given source
+	NewSource func(source Source) Source
+}
+
+// An ArchiveFormat is a container for entries, like zip or tar
+type ArchiveFormat struct {
+	// Short name for the format, like "zip"
+	Name string
+	// Match returns true if the first bytes of an archive look like this format
+	Match func(header []byte) bool
+	// Probe returns true if an archive that didn't match anything looks like
+	// this format after all, like a zip appended to an executable. It may read
+	// anywhere in the source. It's optional.
+	Probe func(source SeekSource) bool
+	// NewSeekExtractor returns an extractor for an archive stored as-is
+	// in a SeekSource
+	NewSeekExtractor func(source SeekSource) (Extractor, error)
+	// NewExtractor returns an extractor for an archive read from a
+	// decompressing Source. It's nil for formats that need random access.
+	NewExtractor func(source Source) (Extractor, error)
+}
+
+var formatsLock sync.Mutex
+var compressionFormats []CompressionFormat
+var archiveFormats []ArchiveFormat
+
+// RegisterCompressionFormat makes a compression format available to Detect.
+// Source packages (like gzipsource) register their format when imported.
+func RegisterCompressionFormat(format CompressionFormat) {
+	formatsLock.Lock()
+	defer formatsLock.Unlock()
+	compressionFormats = append(compressionFormats, format)
+}
+
+// RegisterArchiveFormat makes an archive format available to Detect.
+// Extractor packages (like zipextractor) register their format when imported.
+func RegisterArchiveFormat(format ArchiveFormat) {
+	formatsLock.Lock()
+	defer formatsLock.Unlock()
+	archiveFormats = append(archiveFormats, format)
+}
+
+func registeredFormats() ([]CompressionFormat, []ArchiveFormat) {
+	formatsLock.Lock()
+	defer formatsLock.Unlock()
+
+	// formats without magic numbers are tried last
+	var compressions []CompressionFormat
+	for _, cf := range compressionFormats {
+		if cf.Match != nil {
+			compressions = append(compressions, cf)
+		}
+	}
+	for _, cf := range compressionFormats {
+		if cf.Match == nil {
+			compressions = append(compressions, cf)
+		}
+	}
+
+	archives := append([]ArchiveFormat(nil), archiveFormats...)
+	return compressions, archives
+}
+
+// UnsupportedFormatError is returned by Detect when the input doesn't
+// match any registered format.
+type UnsupportedFormatError struct {
+	// Header holds the first bytes of the input
+	Header []byte
+	// Compression is the name of the compression format that was recognized,
+	// if any. It's set when the input was decompressed successfully, but
+	// didn't contain a known archive format.
+	Compression string
+}
+
+func (e *UnsupportedFormatError) Error() string {
+	magic := e.Header
+	if len(magic) > 8 {
+		magic = magic[:8]
+	}
+
+	if e.Compression != "" {
+		return fmt.Sprintf("unsupported archive format inside %s stream (starts with %x)", e.Compression, magic)
+	}
+	return fmt.Sprintf("unsupported archive format (starts with %x)", magic)
+}
+
+// Detect sniffs the first bytes of a SeekSource and returns an extractor
+// for it, decompressing it first if needed. Only formats whose packages were
+// imported are detected - for example, detecting .tar.gz archives requires
+// importing both gzipsource and tarextractor.
+//
+// If no registered format matches, an *UnsupportedFormatError is returned.
+func Detect(source SeekSource) (Extractor, error) {
+	compressions, archives := registeredFormats()
+
+	header, err := readHeader(source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, af := range archives {
+		if af.NewSeekExtractor != nil && af.Match(header) {
+			Debugf("detect: found %s archive", af.Name)
+			return af.NewSeekExtractor(source)
+		}
+	}
+
+	for _, cf := range compressions {
+		if cf.Match != nil && !cf.Match(header) {
+			continue
+		}
+
+		decompressed := cf.NewSource(source)
+		innerHeader, err := readHeader(decompressed)
+		if err != nil {
+			if cf.Match == nil {
+				// that was just a guess, it's fine if it failed
+				continue
+			}
+			return nil, errors.WithStack(err)
+		}
+
+		for _, af := range archives {
+			if af.NewExtractor != nil && af.Match(innerHeader) {
+				Debugf("detect: found %s archive inside %s stream", af.Name, cf.Name)
+				return af.NewExtractor(decompressed)
+			}
+		}
+
+		if cf.Match != nil {
+			return nil, &UnsupportedFormatError{
+				Header:      innerHeader,
+				Compression: cf.Name,
+			}
+		}
+	}
+
+	for _, af := range archives {
+		if af.NewSeekExtractor != nil && af.Probe != nil && af.Probe(source) {
+			Debugf("detect: found %s archive by probing", af.Name)
+			return af.NewSeekExtractor(source)
+		}
+	}
+
+	return nil, &UnsupportedFormatError{
+		Header: header,
+	}
+}
+
+// readHeader resumes a source from the start and reads its first bytes
+func readHeader(source Source) ([]byte, error) {
+	_, err := source.Resume(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, detectHeaderSize)
+	n, err := io.ReadFull(source, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return header[:n], nil
+}
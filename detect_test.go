@@ -0,0 +1,88 @@
+package savior_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/itchio/savior/brotlisource"
+	_ "github.com/itchio/savior/bzip2source"
+	_ "github.com/itchio/savior/gzipsource"
+	_ "github.com/itchio/savior/lz4source"
+	_ "github.com/itchio/savior/tarextractor"
+	_ "github.com/itchio/savior/xzsource"
+	_ "github.com/itchio/savior/zipextractor"
+	_ "github.com/itchio/savior/zstdsource"
+)
+
+func Test_Detect(t *testing.T) {
+	sink := checker.MakeTestSink()
+	tarBytes := checker.MakeTar(t, sink)
+	zipBytes := checker.MakeZip(t, sink)
+
+	compress := func(f func([]byte) ([]byte, error)) []byte {
+		compressed, err := f(tarBytes)
+		tmust(t, err)
+		return compressed
+	}
+
+	cases := []struct {
+		name        string
+		input       []byte
+		archiveName string
+		sourceName  string
+	}{
+		{".zip", zipBytes, "zip", ""},
+		{".exe (self-extracting zip)", append([]byte("MZ\x90\x00"), zipBytes...), "zip", ""},
+		{".tar", tarBytes, "tar", "seek"},
+		{".tar.gz", compress(checker.GzipCompress), "tar", "gzip"},
+		{".tar.bz2", compress(checker.Bzip2Compress), "tar", "bzip2"},
+		{".tar.br", compress(func(in []byte) ([]byte, error) { return checker.BrotliCompress(in, 1) }), "tar", "brotli"},
+		{".tar.xz", compress(checker.XzCompress), "tar", "xz"},
+		{".tar.zst", compress(checker.ZstdCompress), "tar", "zstd"},
+		{".tar.lz4", compress(func(in []byte) ([]byte, error) { return checker.Lz4Compress(in, true) }), "tar", "lz4"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ex, err := savior.Detect(seeksource.FromBytes(c.input))
+			tmust(t, err)
+
+			features := ex.Features()
+			assert.EqualValues(t, c.archiveName, features.Name)
+			if c.sourceName != "" {
+				assert.EqualValues(t, c.sourceName, features.SourceFeatures.Name)
+			}
+
+			sink.Reset()
+			_, err = ex.Resume(nil, sink)
+			tmust(t, err)
+			assert.NoError(t, sink.Validate())
+		})
+	}
+}
+
+func Test_DetectUnsupported(t *testing.T) {
+	var ufe *savior.UnsupportedFormatError
+
+	_, err := savior.Detect(seeksource.FromBytes(semirandom.Bytes(4096)))
+	assert.Error(t, err)
+	assert.True(t, errors.As(err, &ufe))
+	assert.EqualValues(t, "", ufe.Compression)
+
+	gzipBytes, err := checker.GzipCompress(semirandom.Bytes(4096))
+	tmust(t, err)
+	_, err = savior.Detect(seeksource.FromBytes(gzipBytes))
+	assert.Error(t, err)
+	assert.True(t, errors.As(err, &ufe))
+	assert.EqualValues(t, "gzip", ufe.Compression)
+
+	_, err = savior.Detect(seeksource.FromBytes(nil))
+	assert.Error(t, err)
+	assert.True(t, errors.As(err, &ufe))
+}
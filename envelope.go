@@ -0,0 +1,160 @@
+package savior
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CheckpointFormatVersion is the version of the checkpoint format. It's bumped
+// whenever checkpoints saved by an older version of savior can't be resumed from.
+const CheckpointFormatVersion = 1
+
+// FingerprintLength is how many leading bytes of an input are hashed
+// to fingerprint it.
+const FingerprintLength = 64 * 1024
+
+// A CheckpointEnvelope identifies what produced a checkpoint, so that resuming
+// from a checkpoint that was made by another version of savior, another kind of
+// extractor, or for another input, fails early instead of corrupting output.
+//
+// Extractors stamp it onto every checkpoint they emit, and check it on Resume.
+type CheckpointEnvelope struct {
+	// FormatVersion is the CheckpointFormatVersion of the savior that saved the checkpoint
+	FormatVersion int
+	// Chain is the name of the extractor, followed by the name of its source, if any
+	Chain []string
+	// Fingerprint identifies the input, if it could be computed
+	Fingerprint *Fingerprint
+}
+
+// A Fingerprint identifies an input cheaply: by its size, and
+// a hash of its first FingerprintLength bytes.
+type Fingerprint struct {
+	Size int64
+	// SHA-256 of the leading bytes
+	Hash []byte
+}
+
+func (fp *Fingerprint) String() string {
+	if fp == nil {
+		return "<none>"
+	}
+	return fmt.Sprintf("%d bytes, %x", fp.Size, fp.Hash)
+}
+
+// Equal returns true if both fingerprints are for the same input.
+func (fp *Fingerprint) Equal(other *Fingerprint) bool {
+	return fp.Size == other.Size && bytes.Equal(fp.Hash, other.Hash)
+}
+
+// NewFingerprint returns the fingerprint of an input of the given size,
+// whose leading bytes are read from r.
+func NewFingerprint(r io.Reader, size int64) (*Fingerprint, error) {
+	h := sha256.New()
+	_, err := io.CopyN(h, r, min(size, FingerprintLength))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &Fingerprint{
+		Size: size,
+		Hash: h.Sum(nil),
+	}, nil
+}
+
+// A Fingerprinter is a source that can fingerprint its input. Sources
+// that read from another source should implement it by calling
+// SourceFingerprint on it.
+type Fingerprinter interface {
+	Fingerprint() (*Fingerprint, error)
+}
+
+// SourceFingerprint returns the fingerprint of the input of a source,
+// or nil if the source doesn't implement Fingerprinter.
+func SourceFingerprint(source Source) (*Fingerprint, error) {
+	if fs, ok := source.(Fingerprinter); ok {
+		return fs.Fingerprint()
+	}
+	return nil, nil
+}
+
+// Chain returns the names of an extractor and of its source, if any.
+func (ef ExtractorFeatures) Chain() []string {
+	chain := []string{ef.Name}
+	if ef.SourceFeatures != nil {
+		chain = append(chain, ef.SourceFeatures.Name)
+	}
+	return chain
+}
+
+// NewCheckpointEnvelope returns an envelope for checkpoints made by an
+// extractor with the given features, for an input with the given fingerprint
+// (which may be nil).
+func NewCheckpointEnvelope(features ExtractorFeatures, fingerprint *Fingerprint) *CheckpointEnvelope {
+	return &CheckpointEnvelope{
+		FormatVersion: CheckpointFormatVersion,
+		Chain:         features.Chain(),
+		Fingerprint:   fingerprint,
+	}
+}
+
+// A CheckpointMismatchError is returned by Resume when a checkpoint
+// wasn't made by a compatible extractor, or for the same input.
+type CheckpointMismatchError struct {
+	// Field is what didn't match: "format version", "chain" or "fingerprint"
+	Field string
+	// Expected is what the extractor expected
+	Expected string
+	// Actual is what the checkpoint had
+	Actual string
+}
+
+var _ error = (*CheckpointMismatchError)(nil)
+
+func (cme *CheckpointMismatchError) Error() string {
+	return fmt.Sprintf("checkpoint mismatch: expected %s %s, got %s", cme.Field, cme.Expected, cme.Actual)
+}
+
+// CheckCheckpoint returns a *CheckpointMismatchError if checkpoint wasn't made
+// by an extractor like the one described by expected. Fingerprints are only
+// compared if both are known. Checkpoints without an envelope are treated as
+// having format version 0.
+func CheckCheckpoint(checkpoint *ExtractorCheckpoint, expected *CheckpointEnvelope) error {
+	actual := checkpoint.Envelope
+	if actual == nil {
+		actual = &CheckpointEnvelope{}
+	}
+
+	if actual.FormatVersion != expected.FormatVersion {
+		return &CheckpointMismatchError{
+			Field:    "format version",
+			Expected: fmt.Sprintf("%d", expected.FormatVersion),
+			Actual:   fmt.Sprintf("%d", actual.FormatVersion),
+		}
+	}
+
+	expectedChain := strings.Join(expected.Chain, "/")
+	actualChain := strings.Join(actual.Chain, "/")
+	if actualChain != expectedChain {
+		return &CheckpointMismatchError{
+			Field:    "chain",
+			Expected: expectedChain,
+			Actual:   actualChain,
+		}
+	}
+
+	if actual.Fingerprint != nil && expected.Fingerprint != nil && !actual.Fingerprint.Equal(expected.Fingerprint) {
+		return &CheckpointMismatchError{
+			Field:    "fingerprint",
+			Expected: expected.Fingerprint.String(),
+			Actual:   actual.Fingerprint.String(),
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package savior_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/bzip2source"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/gzipsource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckpointEnvelope(t *testing.T) {
+	sink := checker.MakeTestSink()
+	tarBytes := checker.MakeTar(t, sink)
+
+	// grabs the first checkpoint an extractor emits
+	firstCheckpoint := func(ex savior.Extractor) *savior.ExtractorCheckpoint {
+		var res *savior.ExtractorCheckpoint
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(c *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			res = c
+			return savior.AfterSaveStop, nil
+		}))
+		sink.Reset()
+		_, err := ex.Resume(nil, sink)
+		assert.True(t, errors.Is(err, savior.ErrStop))
+		if res == nil {
+			t.Fatalf("%s extractor didn't emit a checkpoint", ex.Features().Name)
+		}
+		return res
+	}
+
+	expectMismatch := func(ex savior.Extractor, c *savior.ExtractorCheckpoint, field string) {
+		sink.Reset()
+		_, err := ex.Resume(c, sink)
+		var cme *savior.CheckpointMismatchError
+		if assert.True(t, errors.As(err, &cme), "expected mismatch error, got %v", err) {
+			assert.EqualValues(t, field, cme.Field)
+		}
+		assert.Empty(t, sink.DoneItems, "nothing was written")
+	}
+
+	gzipBytes, err := checker.GzipCompress(tarBytes)
+	tmust(t, err)
+	bzip2Bytes, err := checker.Bzip2Compress(tarBytes)
+	tmust(t, err)
+
+	c := firstCheckpoint(tarextractor.New(gzipsource.New(seeksource.FromBytes(gzipBytes))))
+	if assert.NotNil(t, c.Envelope) {
+		assert.EqualValues(t, savior.CheckpointFormatVersion, c.Envelope.FormatVersion)
+		assert.EqualValues(t, []string{"tar", "gzip"}, c.Envelope.Chain)
+		assert.EqualValues(t, len(gzipBytes), c.Envelope.Fingerprint.Size)
+	}
+
+	// same input, different source
+	expectMismatch(tarextractor.New(bzip2source.New(seeksource.FromBytes(bzip2Bytes))), c, "chain")
+
+	// same chain, different input
+	otherGzipBytes, err := checker.GzipCompress(semirandom.Bytes(1024))
+	tmust(t, err)
+	expectMismatch(tarextractor.New(gzipsource.New(seeksource.FromBytes(otherGzipBytes))), c, "fingerprint")
+
+	// checkpoints from before envelopes
+	c.Envelope = nil
+	expectMismatch(tarextractor.New(gzipsource.New(seeksource.FromBytes(gzipBytes))), c, "format version")
+
+	zipBytes := checker.MakeZip(t, sink)
+	makeZipExtractor := func(zipBytes []byte) savior.Extractor {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		tmust(t, err)
+		return ex
+	}
+	c = firstCheckpoint(makeZipExtractor(zipBytes))
+
+	otherZipBytes := checker.MakeZip(t, checker.MakeTestSinkAdvanced(3))
+	expectMismatch(makeZipExtractor(otherZipBytes), c, "fingerprint")
+
+	// and the matching extractor still resumes fine
+	sink.Reset()
+	ex := makeZipExtractor(zipBytes)
+	_, err = ex.Resume(c, sink)
+	tmust(t, err)
+}
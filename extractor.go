@@ -3,6 +3,8 @@ package savior
 import (
 	"encoding/gob"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/itchio/headway/state"
 	"github.com/itchio/headway/united"
@@ -14,6 +16,8 @@ type ExtractorCheckpoint struct {
 	Entry            *Entry
 	Progress         float64
 	Data             any
+	// Identifies what made this checkpoint, see CheckCheckpoint
+	Envelope *CheckpointEnvelope
 }
 
 type ExtractorResult struct {
@@ -59,6 +63,10 @@ type ExtractorFeatures struct {
 	Preallocate bool
 	// Is random access supported?
 	RandomAccess bool
+	// Level of resumable decompression support for each compression method
+	// found in the archive, for formats where it varies from entry to entry.
+	// ResumeSupport is the lowest of them.
+	MethodResumeSupport map[string]ResumeSupport
 	// Features for the underlying source
 	SourceFeatures *SourceFeatures
 }
@@ -73,6 +81,15 @@ func (ef ExtractorFeatures) String() string {
 		res += " +randomaccess"
 	}
 
+	if len(ef.MethodResumeSupport) > 0 {
+		var methods []string
+		for method, rs := range ef.MethodResumeSupport {
+			methods = append(methods, fmt.Sprintf("%s=%s", method, rs))
+		}
+		sort.Strings(methods)
+		res += fmt.Sprintf(" [%s]", strings.Join(methods, " "))
+	}
+
 	if ef.SourceFeatures != nil {
 		res += fmt.Sprintf(" (via source %s: resume=%s)", ef.SourceFeatures.Name, ef.SourceFeatures.ResumeSupport)
 	}
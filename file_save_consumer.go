@@ -0,0 +1,201 @@
+package savior
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultSaveMinBytes is used when FileSaveConsumer.MinBytes is zero
+	DefaultSaveMinBytes = 4 * 1024 * 1024
+	// DefaultSaveMinInterval is used when FileSaveConsumer.MinInterval is zero
+	DefaultSaveMinInterval = 1 * time.Second
+)
+
+// FileSaveConsumer is a SaveConsumer that persists checkpoints to a file,
+// with a CheckpointCodec (GobCodec by default). Checkpoints are written atomically (to a temporary file
+// that is synced, then renamed), so the file always holds a complete checkpoint,
+// even after a crash.
+//
+// It only asks for checkpoints once at least MinBytes have been extracted
+// *and* MinInterval has elapsed since the last one, so that fast extractions
+// don't spend their time syncing files to disk.
+//
+// See ResumeFromFile for the simplest way to use it.
+type FileSaveConsumer struct {
+	// Path of the checkpoint file
+	Path string
+	// Minimum amount of bytes to extract between checkpoints,
+	// DefaultSaveMinBytes if zero, disabled if negative.
+	MinBytes int64
+	// Minimum duration between checkpoints,
+	// DefaultSaveMinInterval if zero, disabled if negative.
+	MinInterval time.Duration
+	// Codec used to encode checkpoints, GobCodec if nil
+	Codec CheckpointCodec
+
+	copiedBytes int64
+	lastSave    time.Time
+}
+
+var _ SaveConsumer = (*FileSaveConsumer)(nil)
+
+func (fsc *FileSaveConsumer) ShouldSave(copiedBytes int64) bool {
+	if fsc.lastSave.IsZero() {
+		fsc.lastSave = time.Now()
+	}
+	fsc.copiedBytes += copiedBytes
+
+	minBytes := fsc.MinBytes
+	if minBytes == 0 {
+		minBytes = DefaultSaveMinBytes
+	}
+	if fsc.copiedBytes < minBytes {
+		return false
+	}
+
+	minInterval := fsc.MinInterval
+	if minInterval == 0 {
+		minInterval = DefaultSaveMinInterval
+	}
+	return time.Since(fsc.lastSave) >= minInterval
+}
+
+func (fsc *FileSaveConsumer) Save(checkpoint *ExtractorCheckpoint) (AfterSaveAction, error) {
+	fsc.copiedBytes = 0
+	fsc.lastSave = time.Now()
+
+	if checkpoint == nil {
+		return AfterSaveContinue, nil
+	}
+
+	err := fsc.write(checkpoint)
+	if err != nil {
+		return AfterSaveContinue, errors.WithStack(err)
+	}
+	Debugf("saved checkpoint to %s (entry %d, %.1f%%)", fsc.Path, checkpoint.EntryIndex, checkpoint.Progress*100)
+	return AfterSaveContinue, nil
+}
+
+func (fsc *FileSaveConsumer) write(checkpoint *ExtractorCheckpoint) error {
+	dir := filepath.Dir(fsc.Path)
+	f, err := os.CreateTemp(dir, filepath.Base(fsc.Path)+".tmp*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tmpPath := f.Name()
+
+	err = func() error {
+		defer f.Close()
+
+		err := fsc.codec().Encode(f, checkpoint)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = f.Sync()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return f.Close()
+	}()
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.WithStack(err)
+	}
+
+	err = os.Rename(tmpPath, fsc.Path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.WithStack(err)
+	}
+
+	if !onWindows {
+		// make the rename itself durable. directories can't be
+		// opened for syncing on windows, where renames are durable
+		// enough anyway.
+		if d, err := os.Open(dir); err == nil {
+			d.Sync()
+			d.Close()
+		}
+	}
+
+	return nil
+}
+
+// Load returns the checkpoint stored in the file, or nil
+// if the file doesn't exist.
+func (fsc *FileSaveConsumer) Load() (*ExtractorCheckpoint, error) {
+	f, err := os.Open(fsc.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	checkpoint, err := fsc.codec().Decode(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decoding checkpoint %s", fsc.Path)
+	}
+	return checkpoint, nil
+}
+
+func (fsc *FileSaveConsumer) codec() CheckpointCodec {
+	if fsc.Codec != nil {
+		return fsc.Codec
+	}
+	return GobCodec
+}
+
+// Clear removes the checkpoint file, if it exists.
+func (fsc *FileSaveConsumer) Clear() error {
+	err := os.Remove(fsc.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// ResumeFromFile extracts an archive to sink, saving checkpoints to checkpointPath
+// along the way. If that file already exists (because a previous extraction was
+// interrupted), extraction resumes from it. It's removed when extraction succeeds.
+//
+// If the checkpoint file can't be decoded, or was made for another
+// archive (see CheckCheckpoint), extraction starts over.
+func ResumeFromFile(ex Extractor, sink Sink, checkpointPath string) (*ExtractorResult, error) {
+	fsc := &FileSaveConsumer{
+		Path: checkpointPath,
+	}
+
+	checkpoint, err := fsc.Load()
+	if err != nil {
+		Debugf("ignoring unreadable checkpoint: %+v", err)
+		checkpoint = nil
+	}
+
+	ex.SetSaveConsumer(fsc)
+	res, err := ex.Resume(checkpoint, sink)
+	if err != nil {
+		var cme *CheckpointMismatchError
+		if checkpoint == nil || !errors.As(err, &cme) {
+			return nil, errors.WithStack(err)
+		}
+
+		Debugf("ignoring checkpoint: %v", err)
+		res, err = ex.Resume(nil, sink)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	err = fsc.Clear()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return res, nil
+}
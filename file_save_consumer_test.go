@@ -0,0 +1,102 @@
+package savior_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FileSaveConsumerThrottle(t *testing.T) {
+	fsc := &savior.FileSaveConsumer{
+		Path:        filepath.Join(t.TempDir(), "checkpoint"),
+		MinBytes:    1024,
+		MinInterval: 50 * time.Millisecond,
+	}
+
+	assert.False(t, fsc.ShouldSave(2048), "not enough time has elapsed")
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, fsc.ShouldSave(0))
+
+	_, err := fsc.Save(nil)
+	tmust(t, err)
+	time.Sleep(60 * time.Millisecond)
+	assert.False(t, fsc.ShouldSave(512), "not enough bytes have been copied")
+	assert.True(t, fsc.ShouldSave(512))
+
+	fsc.MinInterval = -1
+	_, err = fsc.Save(nil)
+	tmust(t, err)
+	assert.True(t, fsc.ShouldSave(1024), "interval is disabled")
+}
+
+func Test_ResumeFromFile(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(20)
+	zipBytes := checker.MakeZip(t, sink)
+	checkpointPath := filepath.Join(t.TempDir(), "extract.checkpoint")
+
+	makeExtractor := func() savior.Extractor {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		tmust(t, err)
+		return ex
+	}
+
+	// first, pretend we crash midway
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		fsc := &savior.FileSaveConsumer{
+			Path:        checkpointPath,
+			MinBytes:    256 * 1024,
+			MinInterval: -1,
+		}
+
+		ex := makeExtractor()
+		ex.SetSaveConsumer(fsc)
+		ex.SetConsumer(&state.Consumer{
+			OnProgress: func(progress float64) {
+				if progress > 0.5 {
+					cancel()
+				}
+			},
+		})
+
+		sink.Reset()
+		_, err := ex.(savior.ContextExtractor).ResumeContext(ctx, nil, sink)
+		assert.True(t, errors.Is(err, context.Canceled))
+
+		checkpoint, err := fsc.Load()
+		tmust(t, err)
+		assert.NotNil(t, checkpoint)
+		assert.True(t, checkpoint.Progress > 0.5)
+
+		matches, err := filepath.Glob(checkpointPath + ".tmp*")
+		tmust(t, err)
+		assert.Empty(t, matches, "no temporary files are left behind")
+	}
+
+	// then, pick up where we left off
+	_, err := savior.ResumeFromFile(makeExtractor(), sink, checkpointPath)
+	tmust(t, err)
+	assert.NoError(t, sink.Validate())
+
+	_, err = os.Stat(checkpointPath)
+	assert.True(t, os.IsNotExist(err), "checkpoint is removed after success")
+
+	// garbage checkpoints are ignored
+	tmust(t, os.WriteFile(checkpointPath, []byte("not a checkpoint"), 0644))
+	sink.Reset()
+	_, err = savior.ResumeFromFile(makeExtractor(), sink, checkpointPath)
+	tmust(t, err)
+	assert.NoError(t, sink.Validate())
+}
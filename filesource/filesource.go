@@ -44,3 +44,7 @@ type fileSource struct {
 func (fs *fileSource) Close() error {
 	return fs.f.Close()
 }
+
+func (fs *fileSource) Fingerprint() (*savior.Fingerprint, error) {
+	return savior.SourceFingerprint(fs.SeekSource)
+}
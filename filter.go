@@ -0,0 +1,86 @@
+package savior
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A Filter decides whether an entry should be extracted. It must
+// return the same answer for the same entry every time it's called, so
+// that extraction can be resumed consistently.
+type Filter func(entry *Entry) bool
+
+// Filterable is implemented by extractors that can skip some entries.
+//
+// Filtered entries are never passed to the sink (no Mkdir, Symlink,
+// GetWriter or Preallocate) and are left out of the ExtractorResult.
+// Filters can't be saved in checkpoints, so the same filter must be
+// set again before resuming.
+type Filterable interface {
+	SetFilter(filter Filter)
+}
+
+// GlobFilter returns a filter that accepts entries matching at least one
+// of the include patterns (or every entry, if there are none), and none
+// of the exclude patterns.
+//
+// Patterns use the syntax of path.Match. Like in .gitignore files, patterns
+// without a slash are matched against each component of an entry's path
+// ("*.pdb" matches "bin/game.pdb", "__MACOSX" excludes everything under
+// "__MACOSX/"), and other patterns are matched against the entry's path
+// and each of its parent directories ("data/levels" matches everything
+// under "data/levels/").
+func GlobFilter(include []string, exclude []string) (Filter, error) {
+	for _, patterns := range [][]string{include, exclude} {
+		for _, pattern := range patterns {
+			_, err := path.Match(pattern, "")
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid glob pattern %q", pattern)
+			}
+		}
+	}
+
+	return func(entry *Entry) bool {
+		if len(include) > 0 && !matchesAny(include, entry.CanonicalPath) {
+			return false
+		}
+		return !matchesAny(exclude, entry.CanonicalPath)
+	}, nil
+}
+
+// AllFilters returns a filter that only accepts entries
+// accepted by all the given filters. nil filters are ignored.
+func AllFilters(filters ...Filter) Filter {
+	return func(entry *Entry) bool {
+		for _, f := range filters {
+			if f != nil && !f(entry) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func matchesAny(patterns []string, name string) bool {
+	name = strings.TrimSuffix(name, "/")
+	components := strings.Split(name, "/")
+
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "/") {
+			for i := len(components); i > 0; i-- {
+				if ok, _ := path.Match(pattern, strings.Join(components[:i], "/")); ok {
+					return true
+				}
+			}
+		} else {
+			for _, component := range components {
+				if ok, _ := path.Match(pattern, component); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package savior_test
+
+import (
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GlobFilter(t *testing.T) {
+	entry := func(name string) *savior.Entry {
+		return &savior.Entry{CanonicalPath: name}
+	}
+
+	filter, err := savior.GlobFilter(nil, []string{"__MACOSX", "*.pdb"})
+	tmust(t, err)
+	assert.True(t, filter(entry("game.exe")))
+	assert.True(t, filter(entry("data/level1.dat")))
+	assert.False(t, filter(entry("__MACOSX/")))
+	assert.False(t, filter(entry("__MACOSX/._game.exe")))
+	assert.False(t, filter(entry("game.pdb")))
+	assert.False(t, filter(entry("bin/game.pdb")))
+
+	filter, err = savior.GlobFilter([]string{"data"}, []string{"data/*.tmp"})
+	tmust(t, err)
+	assert.False(t, filter(entry("game.exe")))
+	assert.True(t, filter(entry("data/")))
+	assert.True(t, filter(entry("data/levels/level1.dat")))
+	assert.False(t, filter(entry("data/cache.tmp")))
+	assert.True(t, filter(entry("data/sub/cache.tmp")))
+	assert.False(t, filter(entry("olddata/level1.dat")))
+
+	filter, err = savior.GlobFilter([]string{"data/levels"}, nil)
+	tmust(t, err)
+	assert.True(t, filter(entry("data/levels/level1.dat")))
+	assert.False(t, filter(entry("other/data/levels/level1.dat")))
+
+	_, err = savior.GlobFilter([]string{"[z-a"}, nil)
+	assert.Error(t, err)
+
+	onlyFiles := func(e *savior.Entry) bool {
+		return e.Kind == savior.EntryKindFile
+	}
+	filter = savior.AllFilters(filter, onlyFiles, nil)
+	assert.False(t, filter(&savior.Entry{CanonicalPath: "data/levels/", Kind: savior.EntryKindDir}))
+	assert.True(t, filter(&savior.Entry{CanonicalPath: "data/levels/a.dat", Kind: savior.EntryKindFile}))
+}
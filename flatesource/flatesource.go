@@ -44,6 +44,10 @@ func (fs *flateSource) Features() savior.SourceFeatures {
 	}
 }
 
+func (fs *flateSource) Fingerprint() (*savior.Fingerprint, error) {
+	return savior.SourceFingerprint(fs.source)
+}
+
 func (fs *flateSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
 	fs.ssc = ssc
 	fs.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
@@ -180,4 +184,5 @@ func (fs *flateSource) Progress() float64 {
 
 func init() {
 	gob.Register(&FlateSourceCheckpoint{})
+	savior.RegisterCheckpointType("flatesource.FlateSourceCheckpoint", &FlateSourceCheckpoint{})
 }
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/itchio/headway/state"
 	"github.com/itchio/ox"
@@ -33,10 +34,18 @@ type FolderSink struct {
 	Directory string
 	Consumer  *state.Consumer
 
+	mu     sync.Mutex
 	writer *entryWriter
+	// writers returned by GetConcurrentWriter that aren't closed yet
+	writers map[*entryWriter]struct{}
+	// directories whose times are set on Close, since creating
+	// anything in them until then would change them. Extractors call
+	// Mkdir again for directories made before resuming.
+	dirs []*Entry
 }
 
-var _ Sink = (*FolderSink)(nil)
+var _ ConcurrentSink = (*FolderSink)(nil)
+var _ EntryFinisher = (*FolderSink)(nil)
 
 var ignoredNames = map[string]struct{}{
 	// the path for folder icons on macOS (yes, really).
@@ -82,6 +91,12 @@ func (fs *FolderSink) Mkdir(entry *Entry) error {
 		return err
 	}
 
+	if hasTimes(entry) {
+		fs.mu.Lock()
+		fs.dirs = append(fs.dirs, entry)
+		fs.mu.Unlock()
+	}
+
 	dirstat, err := os.Lstat(dstpath)
 	if err != nil {
 		// main case - dir doesn't exist yet
@@ -154,39 +169,75 @@ func (fs *FolderSink) GetWriter(entry *Entry) (EntryWriter, error) {
 		return &nopEntryWriter{}, nil
 	}
 
-	f, err := fs.createFile(entry)
+	ew, err := fs.openWriter(entry)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
 
-	if f == nil {
-		return nil, nil
+	fs.mu.Lock()
+	lastWriter := fs.writer
+	fs.writer = ew
+	fs.mu.Unlock()
+
+	if lastWriter != nil {
+		err = lastWriter.Close()
+		if err != nil {
+			fs.Consumer.Warnf("folder_sink could not close last writer: %s", err.Error())
+		}
+	}
+
+	return ew, nil
+}
+
+// GetConcurrentWriter returns a writer at entry.WriteOffset, leaving other
+// writers open, see ConcurrentSink. Writers that aren't closed by the
+// caller are closed along with the sink.
+func (fs *FolderSink) GetConcurrentWriter(entry *Entry) (EntryWriter, error) {
+	if shouldIgnorePath(entry.CanonicalPath) {
+		return &nopEntryWriter{}, nil
+	}
+
+	ew, err := fs.openWriter(entry)
+	if err != nil {
+		return nil, err
+	}
+	ew.concurrent = true
+
+	fs.mu.Lock()
+	if fs.writers == nil {
+		fs.writers = make(map[*entryWriter]struct{})
+	}
+	fs.writers[ew] = struct{}{}
+	fs.mu.Unlock()
+
+	return ew, nil
+}
+
+func (fs *FolderSink) openWriter(entry *Entry) (*entryWriter, error) {
+	f, err := fs.createFile(entry)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
 	if entry.WriteOffset > 0 {
 		_, err = f.Seek(entry.WriteOffset, io.SeekStart)
 		if err != nil {
+			f.Close()
 			return nil, errors.WithStack(err)
 		}
 	}
 
 	err = f.Truncate(entry.WriteOffset)
 	if err != nil {
+		f.Close()
 		return nil, errors.WithStack(err)
 	}
 
-	err = fs.Close()
-	if err != nil {
-		fs.Consumer.Warnf("folder_sink could not close last writer: %s", err.Error())
-	}
-
 	ew := &entryWriter{
 		fs:    fs,
 		f:     f,
 		entry: entry,
 	}
-	fs.writer = ew
-
 	return ew, nil
 }
 
@@ -303,6 +354,42 @@ func (fs *FolderSink) Symlink(entry *Entry, linkname string) error {
 	return nil
 }
 
+// FinishEntry closes the writers still open for entry, then sets its
+// times. This is done last, since writing and closing can change the
+// modification time, and only for finished entries, so that a partial
+// file doesn't look like it's the one from the archive.
+func (fs *FolderSink) FinishEntry(entry *Entry) error {
+	if shouldIgnorePath(entry.CanonicalPath) {
+		return nil
+	}
+
+	var open []*entryWriter
+	fs.mu.Lock()
+	if fs.writer != nil && fs.writer.entry.CanonicalPath == entry.CanonicalPath {
+		open = append(open, fs.writer)
+		fs.writer = nil
+	}
+	for ew := range fs.writers {
+		if ew.entry.CanonicalPath == entry.CanonicalPath {
+			open = append(open, ew)
+		}
+	}
+	fs.mu.Unlock()
+
+	for _, ew := range open {
+		err := ew.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	dstpath, err := fs.destPath(entry)
+	if err != nil {
+		return err
+	}
+	return setTimes(dstpath, entry)
+}
+
 func (fs *FolderSink) Nuke() error {
 	err := fs.Close()
 	if err != nil {
@@ -314,12 +401,54 @@ func (fs *FolderSink) Nuke() error {
 }
 
 func (fs *FolderSink) Close() error {
-	if fs.writer != nil {
-		err := fs.writer.Close()
-		fs.writer = nil
-		return err
+	fs.mu.Lock()
+	lastWriter := fs.writer
+	fs.writer = nil
+	writers := fs.writers
+	fs.writers = nil
+	dirs := fs.dirs
+	fs.dirs = nil
+	fs.mu.Unlock()
+
+	var closeErr error
+	if lastWriter != nil {
+		closeErr = lastWriter.Close()
+	}
+	for ew := range writers {
+		err := ew.Close()
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+
+	for _, entry := range dirs {
+		dstpath, err := fs.destPath(entry)
+		if err == nil {
+			err = setTimes(dstpath, entry)
+		}
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+
+	return closeErr
+}
+
+func hasTimes(entry *Entry) bool {
+	return !entry.ModTime.IsZero() || !entry.AccessTime.IsZero()
+}
+
+// setTimes sets the modification and access times of path to
+// those of entry, leaving alone those that are zero
+func setTimes(path string, entry *Entry) error {
+	if !hasTimes(entry) {
+		return nil
 	}
 
+	err := os.Chtimes(path, entry.AccessTime, entry.ModTime)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 	return nil
 }
 
@@ -327,6 +456,8 @@ type entryWriter struct {
 	fs    *FolderSink
 	f     *os.File
 	entry *Entry
+	// set for writers returned by GetConcurrentWriter
+	concurrent bool
 }
 
 var _ EntryWriter = (*entryWriter)(nil)
@@ -347,12 +478,17 @@ func (ew *entryWriter) Close() error {
 		return nil
 	}
 
+	if ew.concurrent {
+		ew.fs.mu.Lock()
+		delete(ew.fs.writers, ew)
+		ew.fs.mu.Unlock()
+	}
+
 	err := ew.f.Close()
 	ew.f = nil
 	if err != nil {
 		return errors.WithStack(err)
 	}
-
 	return nil
 }
 
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/itchio/savior"
 	"github.com/stretchr/testify/assert"
@@ -54,6 +55,97 @@ func Test_FolderSink(t *testing.T) {
 	assert.EqualValues("fee", s)
 }
 
+func Test_FolderSinkConcurrentWriters(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	fs := &savior.FolderSink{
+		Directory: dir,
+	}
+
+	a := &savior.Entry{Kind: savior.EntryKindFile, Mode: 0644, CanonicalPath: "a"}
+	b := &savior.Entry{Kind: savior.EntryKindFile, Mode: 0644, CanonicalPath: "sub/b"}
+
+	wa, err := fs.GetConcurrentWriter(a)
+	tmust(t, err)
+	wb, err := fs.GetConcurrentWriter(b)
+	tmust(t, err)
+
+	// both writers are still open
+	_, err = wa.Write([]byte("foo"))
+	tmust(t, err)
+	_, err = wb.Write([]byte("bar"))
+	tmust(t, err)
+	tmust(t, wa.Close())
+
+	// the one left open gets closed along with the sink
+	tmust(t, fs.Close())
+	_, err = wb.Write([]byte("baz"))
+	assert.Error(err)
+
+	for name, expected := range map[string]string{"a": "foo", "sub/b": "bar"} {
+		bs, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		tmust(t, err)
+		assert.EqualValues(expected, string(bs))
+	}
+}
+
+func Test_FolderSinkTimes(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	fs := &savior.FolderSink{
+		Directory: dir,
+	}
+
+	dirTime := time.Date(2011, time.March, 4, 5, 6, 7, 0, time.UTC)
+	fileTime := time.Date(2012, time.June, 7, 8, 9, 10, 0, time.UTC)
+
+	tmust(t, fs.Mkdir(&savior.Entry{
+		Kind:          savior.EntryKindDir,
+		CanonicalPath: "sub",
+		ModTime:       dirTime,
+	}))
+
+	entry := &savior.Entry{
+		Kind:          savior.EntryKindFile,
+		Mode:          0644,
+		CanonicalPath: "sub/file",
+		ModTime:       fileTime,
+	}
+	w, err := fs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write([]byte("foo"))
+	tmust(t, err)
+	tmust(t, w.Close())
+
+	// closing isn't enough, the file isn't finished
+	stats, err := os.Stat(filepath.Join(dir, "sub", "file"))
+	tmust(t, err)
+	assert.False(fileTime.Equal(stats.ModTime()), "unfinished file has time %s", stats.ModTime())
+
+	// resuming writes to the file again
+	entry.WriteOffset = 1
+	w, err = fs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write([]byte("ee"))
+	tmust(t, err)
+
+	// finishing closes the writer, then sets the time
+	tmust(t, savior.FinishEntry(fs, entry))
+
+	// the directory gets its time when the sink is closed
+	tmust(t, fs.Close())
+
+	stats, err = os.Stat(filepath.Join(dir, "sub", "file"))
+	tmust(t, err)
+	assert.True(fileTime.Equal(stats.ModTime()), "file has time %s", stats.ModTime())
+
+	stats, err = os.Stat(filepath.Join(dir, "sub"))
+	tmust(t, err)
+	assert.True(dirTime.Equal(stats.ModTime()), "dir has time %s", stats.ModTime())
+}
+
 func Test_FolderSinkIgnorePaths(t *testing.T) {
 	assert := assert.New(t)
 
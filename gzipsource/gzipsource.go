@@ -48,6 +48,10 @@ func (gs *gzipSource) Features() savior.SourceFeatures {
 	}
 }
 
+func (gs *gzipSource) Fingerprint() (*savior.Fingerprint, error) {
+	return savior.SourceFingerprint(gs.source)
+}
+
 func (gs *gzipSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
 	gs.ssc = ssc
 	gs.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
@@ -191,4 +195,15 @@ func (gs *gzipSource) Close() error {
 
 func init() {
 	gob.Register(&GzipSourceCheckpoint{})
+	savior.RegisterCheckpointType("gzipsource.GzipSourceCheckpoint", &GzipSourceCheckpoint{})
+
+	savior.RegisterCompressionFormat(savior.CompressionFormat{
+		Name: "gzip",
+		Match: func(header []byte) bool {
+			return len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b
+		},
+		NewSource: func(source savior.Source) savior.Source {
+			return New(source)
+		},
+	})
 }
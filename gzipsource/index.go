@@ -0,0 +1,125 @@
+package gzipsource
+
+import (
+	"io"
+	"sort"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// DefaultIndexSpan is the default distance between access points
+// of an index, in uncompressed bytes.
+const DefaultIndexSpan = 1 * 1024 * 1024
+
+// An Index is a list of access points into a gzip stream, which allow
+// decompressing from (close to) any uncompressed offset, like zlib's zran
+// example does. Each access point holds a 32KiB window, so the span between
+// points is a trade-off between index size and seek cost.
+//
+// Indexes can be serialized with encoding/gob.
+type Index struct {
+	// Span is the minimum distance between access points, in uncompressed bytes
+	Span int64
+	// Size is the total uncompressed size of the stream
+	Size int64
+	// Points are sorted by increasing offset
+	Points []*IndexPoint
+}
+
+// An IndexPoint is a place from which decompression can start again.
+type IndexPoint struct {
+	// Offset is the position in the uncompressed stream
+	Offset int64
+	// Checkpoint is a gzipsource checkpoint for that offset
+	Checkpoint *GzipSourceCheckpoint
+}
+
+// BuildIndex decompresses a whole gzip stream, emitting an access point
+// every `span` bytes or so. If span is zero or negative, DefaultIndexSpan is used.
+func BuildIndex(source savior.Source, span int64) (*Index, error) {
+	if span <= 0 {
+		span = DefaultIndexSpan
+	}
+
+	index := &Index{
+		Span: span,
+	}
+
+	gs := New(source)
+	_, err := gs.Resume(nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var lastPointOffset int64
+	var wantedSave bool
+	gs.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			gc, ok := checkpoint.Data.(*GzipSourceCheckpoint)
+			if !ok {
+				return errors.Errorf("gzipsource: unexpected checkpoint data %T", checkpoint.Data)
+			}
+
+			savior.Debugf("gzipsource: index point at %d", checkpoint.Offset)
+			index.Points = append(index.Points, &IndexPoint{
+				Offset:     checkpoint.Offset,
+				Checkpoint: gc,
+			})
+			lastPointOffset = checkpoint.Offset
+			wantedSave = false
+			return nil
+		},
+	})
+
+	buf := make([]byte, 32*1024)
+	var offset int64
+	for {
+		n, err := gs.Read(buf)
+		offset += int64(n)
+
+		if !wantedSave && offset-lastPointOffset >= span {
+			wantedSave = true
+			gs.WantSave()
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	index.Size = offset
+	return index, nil
+}
+
+// clone returns a copy of an access point's checkpoint that can be
+// resumed from safely: the flate decompressor uses the checkpoint's
+// window as its own, and overwrites it as it goes.
+func (gsc *GzipSourceCheckpoint) clone() *GzipSourceCheckpoint {
+	res := *gsc
+	if gsc.GzipCheckpoint != nil {
+		gc := *gsc.GzipCheckpoint
+		if gc.FlateCheckpoint != nil {
+			fc := *gc.FlateCheckpoint
+			fc.DictDecoderHist = append([]byte(nil), fc.DictDecoderHist...)
+			gc.FlateCheckpoint = &fc
+		}
+		res.GzipCheckpoint = &gc
+	}
+	return &res
+}
+
+// pointFor returns the last access point at or before offset, or
+// nil if decompression should start from the beginning.
+func (idx *Index) pointFor(offset int64) *IndexPoint {
+	i := sort.Search(len(idx.Points), func(i int) bool {
+		return idx.Points[i].Offset > offset
+	})
+	if i == 0 {
+		return nil
+	}
+	return idx.Points[i-1]
+}
@@ -0,0 +1,60 @@
+package gzipsource_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"log"
+	"math/rand"
+	"testing"
+
+	"github.com/itchio/headway/united"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/gzipsource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Index(t *testing.T) {
+	reference := semirandom.Bytes(4 * 1024 * 1024 /* 4 MiB of random data */)
+	compressed, err := checker.GzipCompress(reference)
+	assert.NoError(t, err)
+
+	index, err := gzipsource.BuildIndex(seeksource.FromBytes(compressed), 256*1024)
+	assert.NoError(t, err)
+	assert.EqualValues(t, len(reference), index.Size)
+	assert.True(t, len(index.Points) > 4, "has several access points")
+
+	// indexes must survive a round-trip through gob
+	indexBuf := new(bytes.Buffer)
+	assert.NoError(t, gob.NewEncoder(indexBuf).Encode(index))
+	log.Printf("index has %d points, %s encoded", len(index.Points), united.FormatBytes(int64(indexBuf.Len())))
+
+	index = &gzipsource.Index{}
+	assert.NoError(t, gob.NewDecoder(indexBuf).Decode(index))
+
+	source := seeksource.FromBytes(compressed)
+	ss := gzipsource.NewSeekSource(source, index)
+	assert.EqualValues(t, len(reference), ss.Size())
+
+	checker.RunSourceTest(t, ss, reference)
+
+	rng := rand.New(rand.NewSource(0xfaceface))
+	buf := make([]byte, 4096)
+	for i := 0; i < 32; i++ {
+		start := rng.Int63n(int64(len(reference) - len(buf)))
+		section, err := gzipsource.NewSeekSource(source, index).Section(start, int64(len(buf)))
+		assert.NoError(t, err)
+
+		_, err = section.Resume(nil)
+		assert.NoError(t, err)
+
+		_, err = io.ReadFull(section, buf)
+		assert.NoError(t, err)
+		assert.EqualValues(t, reference[start:start+int64(len(buf))], buf)
+
+		_, err = section.Read(buf)
+		assert.EqualValues(t, io.EOF, err)
+	}
+}
@@ -0,0 +1,207 @@
+package gzipsource
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+type gzipSeekSource struct {
+	// input
+	source savior.Source
+	index  *Index
+
+	// internal
+	gs *gzipSource
+
+	ssc      savior.SourceSaveConsumer
+	wantSave bool
+
+	sectionStart int64
+	offset       int64
+	size         int64
+}
+
+var _ savior.SeekSource = (*gzipSeekSource)(nil)
+
+// NewSeekSource returns a SeekSource over the uncompressed contents of a
+// gzip stream, which uses an index to resume at any offset, by restoring
+// the nearest access point and discarding data until the requested offset.
+//
+// Its checkpoints only hold an offset, so they're tiny - but they're only
+// valid with the same index.
+func NewSeekSource(source savior.Source, index *Index) savior.SeekSource {
+	return &gzipSeekSource{
+		source: source,
+		index:  index,
+		size:   index.Size,
+	}
+}
+
+func (gss *gzipSeekSource) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "gzip-seek",
+		ResumeSupport: savior.ResumeSupportBlock,
+	}
+}
+
+func (gss *gzipSeekSource) Fingerprint() (*savior.Fingerprint, error) {
+	return savior.SourceFingerprint(gss.source)
+}
+
+func (gss *gzipSeekSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	gss.ssc = ssc
+}
+
+func (gss *gzipSeekSource) WantSave() {
+	gss.wantSave = true
+}
+
+func (gss *gzipSeekSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	var offset int64
+	if checkpoint != nil {
+		if checkpoint.Offset < 0 || checkpoint.Offset > gss.size {
+			return 0, errors.Errorf("gzipsource: cannot resume at %d, outside of [0, %d]", checkpoint.Offset, gss.size)
+		}
+		offset = checkpoint.Offset
+	}
+
+	target := gss.sectionStart + offset
+
+	var gzipCheckpoint *savior.SourceCheckpoint
+	if point := gss.index.pointFor(target); point != nil {
+		gzipCheckpoint = &savior.SourceCheckpoint{
+			Offset: point.Offset,
+			Data:   point.Checkpoint.clone(),
+		}
+	}
+
+	gss.gs = New(gss.source)
+	gzipOffset, err := gss.gs.Resume(gzipCheckpoint)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if gzipOffset > target {
+		msg := fmt.Sprintf("gzipsource: access point resumed at %d, past requested offset %d", gzipOffset, target)
+		return 0, errors.New(msg)
+	}
+
+	delta := target - gzipOffset
+	if delta > 0 {
+		savior.Debugf("gzipsource: discarding %d bytes to reach offset %d", delta, target)
+		err = savior.DiscardByRead(gss.gs, delta)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+
+	gss.offset = offset
+	return gss.offset, nil
+}
+
+func (gss *gzipSeekSource) Tell() int64 {
+	return gss.offset
+}
+
+func (gss *gzipSeekSource) Size() int64 {
+	return gss.size
+}
+
+func (gss *gzipSeekSource) Section(start int64, size int64) (savior.SeekSource, error) {
+	if start < 0 {
+		return nil, errors.WithStack(fmt.Errorf("can't make section with negative start"))
+	}
+
+	if size < 0 {
+		return nil, errors.WithStack(fmt.Errorf("can't make section with negative size"))
+	}
+
+	if start+size > gss.size {
+		return nil, errors.WithStack(fmt.Errorf("section too large: start+size (%d) > original size (%d)", start+size, gss.size))
+	}
+
+	sectionSeekSource := &gzipSeekSource{
+		source:       gss.source,
+		index:        gss.index,
+		size:         size,
+		sectionStart: gss.sectionStart + start,
+	}
+	return sectionSeekSource, nil
+}
+
+func (gss *gzipSeekSource) Read(buf []byte) (int, error) {
+	if gss.gs == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	if len(buf) == 0 {
+		return 0, nil
+	}
+
+	err := gss.handleSave()
+	if err != nil {
+		return 0, err
+	}
+
+	remaining := gss.size - gss.offset
+	if remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(buf)) > remaining {
+		buf = buf[:remaining]
+	}
+
+	n, err := gss.gs.Read(buf)
+	gss.offset += int64(n)
+	if err == io.EOF && gss.offset < gss.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (gss *gzipSeekSource) ReadByte() (byte, error) {
+	if gss.gs == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	err := gss.handleSave()
+	if err != nil {
+		return 0, err
+	}
+
+	if gss.offset == gss.size {
+		return 0, io.EOF
+	}
+
+	b, err := gss.gs.ReadByte()
+	if err == nil {
+		gss.offset++
+	}
+	return b, err
+}
+
+func (gss *gzipSeekSource) handleSave() error {
+	if gss.wantSave {
+		gss.wantSave = false
+		if gss.ssc != nil {
+			c := &savior.SourceCheckpoint{
+				Offset: gss.offset,
+			}
+			savior.Debugf("gzipsource: seek source emitting checkpoint at %d", c.Offset)
+			return gss.ssc.Save(c)
+		}
+	}
+	return nil
+}
+
+func (gss *gzipSeekSource) Progress() float64 {
+	// avoid NaNs
+	if gss.size > 0 {
+		return float64(gss.offset) / float64(gss.size)
+	}
+
+	return 0
+}
@@ -0,0 +1,87 @@
+// Package deflate64 implements Deflate64 (also known as "enhanced deflate",
+// zip method 9) decompression, in a way that lets consumers save the state
+// of the decompressor and resume it later.
+//
+// Deflate64 is deflate with a 64KiB window, two more distance codes, and
+// a length code that takes 16 extra bits. Checkpoints are made between
+// blocks, where the only state is the last 64KiB of output and a few bits
+// of input.
+package deflate64
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	// ReadyToSaveError is returned by Read() when a SaverReader is ready to emit a checkpoint
+	ReadyToSaveError = errors.New("ready to save")
+	// NotOnBoundaryError is returned by Save() when a SaverReader wasn't ready to emit a checkpoint
+	NotOnBoundaryError = errors.New("asked to save, but not on boundary")
+	// ErrCorrupt is returned when the compressed data is invalid
+	ErrCorrupt = errors.New("deflate64: corrupt input")
+)
+
+// WindowSize is the maximum distance of a match in Deflate64
+const WindowSize = 64 * 1024
+
+// Reader is what decompressors read from. They consume input one byte
+// at a time, so they never read past the end of the compressed data.
+type Reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// A SaverReader is a decompressor that can be asked to stop on
+// the next boundary, so that its state can be saved.
+type SaverReader interface {
+	io.Reader
+
+	// WantSave signals the decompressor that it should stop
+	// on the next block boundary to allow the consumer to perform a checkpoint
+	WantSave()
+	// Save returns a checkpoint, it must only be called after Read
+	// returned ReadyToSaveError.
+	Save() (*Checkpoint, error)
+}
+
+// A Checkpoint allows resuming decompression from a certain point
+// in the compressed data stream
+type Checkpoint struct {
+	// Roffset is the offset into compressed data
+	Roffset int64
+	// Woffset is the offset into uncompressed data
+	Woffset int64
+
+	// B holds Nb bits of input that were read but not consumed yet
+	B  uint32
+	Nb uint
+
+	// Hist holds the last bytes of uncompressed output, up to WindowSize
+	Hist []byte
+}
+
+// NewSaverReader returns a decompressor for a raw Deflate64 stream
+func NewSaverReader(r Reader) SaverReader {
+	d := &decompressor{r: r}
+	d.win.reset()
+	return d
+}
+
+// Resume starts decompressing again from a given checkpoint
+func (c *Checkpoint) Resume(r Reader) (SaverReader, error) {
+	if len(c.Hist) > WindowSize || int64(len(c.Hist)) > c.Woffset || c.Nb > 32 {
+		return nil, errors.New("deflate64: invalid checkpoint")
+	}
+
+	d := &decompressor{
+		r:       r,
+		roffset: c.Roffset,
+		woffset: c.Woffset,
+		b:       c.B,
+		nb:      c.Nb,
+	}
+	d.win.reset()
+	d.win.restore(c.Hist, c.Woffset)
+	return d, nil
+}
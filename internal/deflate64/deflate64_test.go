@@ -0,0 +1,215 @@
+package deflate64_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/gob"
+	"hash/crc32"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior/internal/deflate64"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func must(t *testing.T, err error) {
+	assert.NoError(t, err)
+	if err != nil {
+		t.FailNow()
+	}
+}
+
+type fixture struct {
+	data  []byte
+	crc32 uint32
+	size  uint64
+}
+
+// readFixtures reads the streams in the zip made by testdata/mkfixtures.go
+func readFixtures(t *testing.T) map[string]fixture {
+	zr, err := zip.OpenReader(filepath.Join("testdata", "fixtures.zip"))
+	must(t, err)
+	defer zr.Close()
+
+	fixtures := make(map[string]fixture)
+	for _, f := range zr.File {
+		r, err := f.OpenRaw()
+		must(t, err)
+		data, err := io.ReadAll(r)
+		must(t, err)
+		fixtures[f.Name] = fixture{data, f.CRC32, f.UncompressedSize64}
+	}
+	return fixtures
+}
+
+// decode decompresses data. If saveEvery isn't zero, it asks for a
+// checkpoint every saveEvery reads that made progress, and resumes from
+// it with a new decompressor, which reads data from the checkpoint's
+// offset. It returns what was decompressed, even on error, and how many
+// checkpoints were made.
+func decode(data []byte, saveEvery int) ([]byte, int, error) {
+	sr := deflate64.NewSaverReader(bytes.NewReader(data))
+	out := new(bytes.Buffer)
+	buf := make([]byte, 4096)
+	numCheckpoints := 0
+	reads := 0
+
+	for {
+		if saveEvery > 0 && reads == saveEvery {
+			reads = 0
+			sr.WantSave()
+		}
+
+		n, err := sr.Read(buf)
+		if n > 0 {
+			reads++
+		}
+		out.Write(buf[:n])
+		switch err {
+		case nil:
+			continue
+		case io.EOF:
+			return out.Bytes(), numCheckpoints, nil
+		case deflate64.ReadyToSaveError:
+			// keep going below
+		default:
+			return out.Bytes(), numCheckpoints, err
+		}
+
+		c, err := sr.Save()
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+		numCheckpoints++
+		if c.Woffset != int64(out.Len()) {
+			msg := "checkpoint is at %d, but %d bytes were decompressed"
+			return out.Bytes(), numCheckpoints, errors.Errorf(msg, c.Woffset, out.Len())
+		}
+
+		// checkpoints are stored, so make sure they survive that
+		encoded := new(bytes.Buffer)
+		err = gob.NewEncoder(encoded).Encode(c)
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+		c = &deflate64.Checkpoint{}
+		err = gob.NewDecoder(encoded).Decode(c)
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+
+		sr, err = c.Resume(bytes.NewReader(data[c.Roffset:]))
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+	}
+}
+
+func Test_Fixtures(t *testing.T) {
+	fixtures := readFixtures(t)
+
+	tests := []struct {
+		name      string
+		numBlocks int
+	}{
+		{"stored", 3},
+		{"fixed", 1},
+		{"dynamic", 4},
+		{"mixed", 8},
+	}
+
+	for _, tt := range tests {
+		for _, saveEvery := range []int{0, 1, 7} {
+			name := tt.name
+			if saveEvery > 0 {
+				name += "/resumed"
+			}
+			t.Run(name, func(t *testing.T) {
+				f := fixtures[tt.name]
+				out, numCheckpoints, err := decode(f.data, saveEvery)
+				must(t, err)
+				assert.EqualValues(t, f.size, len(out))
+				assert.EqualValues(t, f.crc32, crc32.ChecksumIEEE(out))
+				// checkpoints are made between blocks
+				if saveEvery == 1 && tt.numBlocks > 1 {
+					assert.NotZero(t, numCheckpoints)
+				}
+			})
+		}
+	}
+}
+
+func Test_Corrupt(t *testing.T) {
+	fixtures := readFixtures(t)
+
+	truncate := func(name string, size int) []byte {
+		data := fixtures[name].data
+		if size < 0 {
+			size += len(data)
+		}
+		return data[:size]
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"nothing", nil, io.ErrUnexpectedEOF},
+		{"truncated stored header", truncate("stored", 3), io.ErrUnexpectedEOF},
+		{"truncated stored block", truncate("stored", 100), io.ErrUnexpectedEOF},
+		{"truncated fixed block", truncate("fixed", len(fixtures["fixed"].data)/2), io.ErrUnexpectedEOF},
+		{"truncated dynamic tables", truncate("dynamic", 10), io.ErrUnexpectedEOF},
+		{"truncated dynamic block", truncate("dynamic", len(fixtures["dynamic"].data)/2), io.ErrUnexpectedEOF},
+		{"truncated last block", truncate("mixed", -1), io.ErrUnexpectedEOF},
+	}
+	for _, name := range []string{
+		"block type",
+		"stored length",
+		"distance too far",
+		"length code",
+		"too many literal codes",
+		"code length code",
+		"repeat first",
+		"repeat too long",
+		"no end of block",
+		"literal codes",
+	} {
+		f, ok := fixtures["invalid/"+name]
+		assert.True(t, ok, "missing fixture %s", name)
+		tests = append(tests, struct {
+			name string
+			data []byte
+			err  error
+		}{name, f.data, deflate64.ErrCorrupt})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decode(tt.data, 0)
+			assert.True(t, errors.Is(err, tt.err), "expected %v, got %v", tt.err, err)
+		})
+	}
+}
+
+func Test_CorruptData(t *testing.T) {
+	fixtures := readFixtures(t)
+
+	// there's no checksum, so corrupt data may decode to something
+	// else, but it must never make the decoder panic or go on forever
+	for _, name := range []string{"fixed", "dynamic", "mixed"} {
+		t.Run(name, func(t *testing.T) {
+			f := fixtures[name]
+			for offset := 1; offset < len(f.data); offset += 499 {
+				corrupt := append([]byte(nil), f.data...)
+				corrupt[offset] ^= 0x55
+				out, _, err := decode(corrupt, 0)
+				if err == nil && crc32.ChecksumIEEE(out) == f.crc32 {
+					t.Errorf("corruption at %d went unnoticed", offset)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package deflate64
+
+const (
+	maxCodeBits = 15
+	fastBits    = 9
+	fastMask    = 1<<fastBits - 1
+)
+
+// huffman is a canonical huffman decoding table. Codes up to fastBits
+// long are looked up directly, longer ones are decoded bit by bit.
+type huffman struct {
+	// count[l] is the number of codes of length l
+	count [maxCodeBits + 1]uint16
+	// symbols, ordered by code
+	symbols []uint16
+	// fast[bits] is symbol<<4 | length, or 0 if the code is longer than fastBits
+	fast [1 << fastBits]uint32
+}
+
+// init builds the table from the code length of each symbol
+func (h *huffman) init(lengths []uint8) error {
+	h.count = [maxCodeBits + 1]uint16{}
+	h.fast = [1 << fastBits]uint32{}
+
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	h.count[0] = 0
+
+	// check for an over-subscribed set of lengths. incomplete
+	// sets are allowed (a single distance code is common).
+	left := 1
+	for l := 1; l <= maxCodeBits; l++ {
+		left <<= 1
+		left -= int(h.count[l])
+		if left < 0 {
+			return ErrCorrupt
+		}
+	}
+
+	var offs [maxCodeBits + 2]uint16
+	for l := 1; l <= maxCodeBits; l++ {
+		offs[l+1] = offs[l] + h.count[l]
+	}
+
+	h.symbols = make([]uint16, offs[maxCodeBits+1])
+
+	var nextCode [maxCodeBits + 1]int
+	code := 0
+	for l := 1; l <= maxCodeBits; l++ {
+		code = (code + int(h.count[l-1])) << 1
+		nextCode[l] = code
+	}
+
+	for sym, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		h.symbols[offs[l]] = uint16(sym)
+		offs[l]++
+
+		code := nextCode[l]
+		nextCode[l]++
+		if l <= fastBits {
+			rev := reverse(code, uint(l))
+			for i := rev; i < 1<<fastBits; i += 1 << l {
+				h.fast[i] = uint32(sym)<<4 | uint32(l)
+			}
+		}
+	}
+	return nil
+}
+
+func reverse(code int, length uint) int {
+	res := 0
+	for i := uint(0); i < length; i++ {
+		res = res<<1 | code&1
+		code >>= 1
+	}
+	return res
+}
+
+var fixedLit, fixedDist huffman
+
+func init() {
+	var lengths [288]uint8
+	for i := range lengths {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	if err := fixedLit.init(lengths[:]); err != nil {
+		panic(err)
+	}
+
+	var distLengths [32]uint8
+	for i := range distLengths {
+		distLengths[i] = 5
+	}
+	if err := fixedDist.init(distLengths[:]); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,405 @@
+package deflate64
+
+import (
+	"io"
+)
+
+var lengthBase = [29]uint16{
+	3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31,
+	35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227,
+	// deflate has a single length of 258 here, Deflate64 has 16 extra bits
+	3,
+}
+
+var lengthExtra = [29]uint8{
+	0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2,
+	3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5,
+	16,
+}
+
+var distBase = [32]uint32{
+	1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193,
+	257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145,
+	8193, 12289, 16385, 24577,
+	// Deflate64 only
+	32769, 49153,
+}
+
+var distExtra = [32]uint8{
+	0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6,
+	7, 7, 8, 8, 9, 9, 10, 10, 11, 11,
+	12, 12, 13, 13,
+	14, 14,
+}
+
+// order in which code length code lengths are stored
+var codeLengthOrder = [19]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+type stage int
+
+const (
+	stageHeader stage = iota
+	stageStored
+	stageHuffman
+)
+
+type decompressor struct {
+	r Reader
+
+	roffset int64
+	woffset int64
+	b       uint32
+	nb      uint
+
+	win   window
+	stage stage
+	final bool
+
+	// stored blocks
+	storedLeft int
+
+	// huffman blocks
+	lit, dist         *huffman
+	dynLit, dynDist   huffman
+	copyLen, copyDist int
+
+	wantSave bool
+	err      error
+}
+
+var _ SaverReader = (*decompressor)(nil)
+
+func (d *decompressor) Read(p []byte) (int, error) {
+	n := 0
+
+	for n < len(p) {
+		if d.err != nil {
+			return n, d.err
+		}
+
+		if d.copyLen > 0 {
+			m := d.win.copyMatch(p[n:], d.copyDist, d.copyLen)
+			d.copyLen -= m
+			d.woffset += int64(m)
+			n += m
+			continue
+		}
+
+		switch d.stage {
+		case stageHeader:
+			if d.final {
+				d.err = io.EOF
+				continue
+			}
+
+			if d.wantSave {
+				if n > 0 {
+					return n, nil
+				}
+				return 0, ReadyToSaveError
+			}
+
+			d.err = d.readHeader()
+		case stageStored:
+			if d.storedLeft == 0 {
+				d.stage = stageHeader
+				continue
+			}
+
+			m := len(p) - n
+			if m > d.storedLeft {
+				m = d.storedLeft
+			}
+			m, err := io.ReadFull(d.r, p[n:n+m])
+			d.roffset += int64(m)
+			d.win.write(p[n : n+m])
+			d.woffset += int64(m)
+			d.storedLeft -= m
+			n += m
+			if err != nil {
+				d.err = noEOF(err)
+			}
+		case stageHuffman:
+			m, err := d.decodeSymbol(p[n:])
+			n += m
+			d.err = err
+		}
+	}
+
+	return n, nil
+}
+
+func (d *decompressor) WantSave() {
+	d.wantSave = true
+}
+
+func (d *decompressor) Save() (*Checkpoint, error) {
+	d.wantSave = false
+
+	if d.stage != stageHeader || d.copyLen > 0 || d.err != nil {
+		return nil, NotOnBoundaryError
+	}
+
+	return &Checkpoint{
+		Roffset: d.roffset,
+		Woffset: d.woffset,
+		B:       d.b,
+		Nb:      d.nb,
+		Hist:    d.win.history(),
+	}, nil
+}
+
+func (d *decompressor) readHeader() error {
+	header, err := d.bits(3)
+	if err != nil {
+		return err
+	}
+	d.final = header&1 == 1
+
+	switch header >> 1 {
+	case 0:
+		// stored block: skip to the next byte boundary,
+		// then LEN and NLEN
+		d.b >>= d.nb & 7
+		d.nb -= d.nb & 7
+
+		length, err := d.bits(16)
+		if err != nil {
+			return err
+		}
+		nlength, err := d.bits(16)
+		if err != nil {
+			return err
+		}
+		if uint16(length) != ^uint16(nlength) {
+			return ErrCorrupt
+		}
+
+		// we never buffer more than 24 bits, so B is empty by now,
+		// and the block's contents can be read directly
+		d.storedLeft = int(length)
+		d.stage = stageStored
+	case 1:
+		d.lit = &fixedLit
+		d.dist = &fixedDist
+		d.stage = stageHuffman
+	case 2:
+		err := d.readDynamicTables()
+		if err != nil {
+			return err
+		}
+		d.lit = &d.dynLit
+		d.dist = &d.dynDist
+		d.stage = stageHuffman
+	default:
+		return ErrCorrupt
+	}
+	return nil
+}
+
+func (d *decompressor) readDynamicTables() error {
+	nlit, err := d.bits(5)
+	if err != nil {
+		return err
+	}
+	ndist, err := d.bits(5)
+	if err != nil {
+		return err
+	}
+	nclen, err := d.bits(4)
+	if err != nil {
+		return err
+	}
+	numLit := int(nlit) + 257
+	numDist := int(ndist) + 1
+	if numLit > 286 {
+		return ErrCorrupt
+	}
+
+	var clLengths [19]uint8
+	for i := 0; i < int(nclen)+4; i++ {
+		l, err := d.bits(3)
+		if err != nil {
+			return err
+		}
+		clLengths[codeLengthOrder[i]] = uint8(l)
+	}
+
+	var cl huffman
+	err = cl.init(clLengths[:])
+	if err != nil {
+		return err
+	}
+
+	lengths := make([]uint8, numLit+numDist)
+	for i := 0; i < len(lengths); {
+		sym, err := d.decode(&cl)
+		if err != nil {
+			return err
+		}
+
+		if sym < 16 {
+			lengths[i] = uint8(sym)
+			i++
+			continue
+		}
+
+		var rep uint32
+		var value uint8
+		switch sym {
+		case 16:
+			if i == 0 {
+				return ErrCorrupt
+			}
+			value = lengths[i-1]
+			rep, err = d.bits(2)
+			rep += 3
+		case 17:
+			rep, err = d.bits(3)
+			rep += 3
+		default:
+			rep, err = d.bits(7)
+			rep += 11
+		}
+		if err != nil {
+			return err
+		}
+
+		if i+int(rep) > len(lengths) {
+			return ErrCorrupt
+		}
+		for ; rep > 0; rep-- {
+			lengths[i] = value
+			i++
+		}
+	}
+
+	if lengths[256] == 0 {
+		// no end of block code
+		return ErrCorrupt
+	}
+
+	err = d.dynLit.init(lengths[:numLit])
+	if err != nil {
+		return err
+	}
+	return d.dynDist.init(lengths[numLit:])
+}
+
+// decodeSymbol decodes a literal (written to out), an end of block,
+// or a match (copied by Read). It returns the number of bytes written.
+func (d *decompressor) decodeSymbol(out []byte) (int, error) {
+	sym, err := d.decode(d.lit)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case sym < 256:
+		out[0] = byte(sym)
+		d.win.put(byte(sym))
+		d.woffset++
+		return 1, nil
+	case sym == 256:
+		d.stage = stageHeader
+		return 0, nil
+	case sym > 285:
+		return 0, ErrCorrupt
+	}
+
+	extra, err := d.bits(uint(lengthExtra[sym-257]))
+	if err != nil {
+		return 0, err
+	}
+	length := int(lengthBase[sym-257]) + int(extra)
+
+	dsym, err := d.decode(d.dist)
+	if err != nil {
+		return 0, err
+	}
+	if dsym >= 32 {
+		return 0, ErrCorrupt
+	}
+	extra, err = d.bits(uint(distExtra[dsym]))
+	if err != nil {
+		return 0, err
+	}
+	dist := int(distBase[dsym] + extra)
+
+	if !d.win.has(dist) {
+		return 0, ErrCorrupt
+	}
+	d.copyLen = length
+	d.copyDist = dist
+	return 0, nil
+}
+
+// decode reads one symbol from the input
+func (d *decompressor) decode(h *huffman) (uint16, error) {
+	for d.nb < fastBits {
+		if d.moreBits() != nil {
+			// near the end of the input, go bit by bit
+			break
+		}
+	}
+
+	if d.nb >= fastBits {
+		e := h.fast[d.b&fastMask]
+		if l := uint(e & 0xf); l > 0 {
+			d.b >>= l
+			d.nb -= l
+			return uint16(e >> 4), nil
+		}
+	}
+
+	// codes are packed starting from the most significant bit
+	code, first, index := 0, 0, 0
+	for l := 1; l <= maxCodeBits; l++ {
+		bit, err := d.bits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= int(bit)
+		count := int(h.count[l])
+		if code-first < count {
+			return h.symbols[index+code-first], nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+	return 0, ErrCorrupt
+}
+
+func (d *decompressor) moreBits() error {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return noEOF(err)
+	}
+	d.roffset++
+	d.b |= uint32(c) << d.nb
+	d.nb += 8
+	return nil
+}
+
+// bits reads n bits (at most 16) from the input
+func (d *decompressor) bits(n uint) (uint32, error) {
+	for d.nb < n {
+		err := d.moreBits()
+		if err != nil {
+			return 0, err
+		}
+	}
+	v := d.b & (1<<n - 1)
+	d.b >>= n
+	d.nb -= n
+	return v, nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,600 @@
+//go:build ignore
+
+// mkfixtures writes fixtures.zip, whose entries are the Deflate64 streams
+// the decoder is tested with. There's no reference tool that writes
+// Deflate64 in $PATH, so, like deflate64source/testdata/mkhandmade.go,
+// they're assembled by hand from the format description. The zip holds
+// the size and CRC32 of what valid streams decompress to; invalid ones
+// are prefixed with "invalid/", and have neither.
+//
+// Usage: go run mkfixtures.go
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+type bitWriter struct {
+	buf   bytes.Buffer
+	acc   uint32
+	nbits uint
+}
+
+// write writes the n low bits of value, least significant first
+func (bw *bitWriter) write(value uint32, n uint) {
+	for i := uint(0); i < n; i++ {
+		bw.acc |= ((value >> i) & 1) << bw.nbits
+		bw.nbits++
+		if bw.nbits == 8 {
+			bw.buf.WriteByte(byte(bw.acc))
+			bw.acc = 0
+			bw.nbits = 0
+		}
+	}
+}
+
+// writeCode writes a huffman code, most significant bit first
+func (bw *bitWriter) writeCode(c code) {
+	if c.length == 0 {
+		log.Fatal("writing a symbol that has no code")
+	}
+	for i := int(c.length) - 1; i >= 0; i-- {
+		bw.write(c.bits>>uint(i), 1)
+	}
+}
+
+// align skips to the next byte boundary
+func (bw *bitWriter) align() {
+	for bw.nbits != 0 {
+		bw.write(0, 1)
+	}
+}
+
+func (bw *bitWriter) flush() []byte {
+	bw.align()
+	return bw.buf.Bytes()
+}
+
+type code struct {
+	bits   uint32
+	length uint
+}
+
+// canonical returns the canonical huffman codes for the given code lengths
+func canonical(lengths []uint) []code {
+	var count [16]uint32
+	for _, l := range lengths {
+		if l > 0 {
+			count[l]++
+		}
+	}
+	var next [16]uint32
+	c := uint32(0)
+	for l := 1; l < 16; l++ {
+		c = (c + count[l-1]) << 1
+		next[l] = c
+	}
+	codes := make([]code, len(lengths))
+	for sym, l := range lengths {
+		if l > 0 {
+			codes[sym] = code{bits: next[l], length: l}
+			next[l]++
+		}
+	}
+	return codes
+}
+
+// huffmanLengths returns the code lengths of a huffman code for the
+// given symbol frequencies. A lone symbol gets a 1-bit code.
+func huffmanLengths(freqs []int, limit uint) []uint {
+	type node struct {
+		weight  int
+		symbols []int
+	}
+	var nodes []node
+	for sym, f := range freqs {
+		if f > 0 {
+			nodes = append(nodes, node{f, []int{sym}})
+		}
+	}
+
+	lengths := make([]uint, len(freqs))
+	if len(nodes) == 1 {
+		lengths[nodes[0].symbols[0]] = 1
+		return lengths
+	}
+	for len(nodes) > 1 {
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+		a, b := nodes[0], nodes[1]
+		merged := node{a.weight + b.weight, append(append([]int(nil), a.symbols...), b.symbols...)}
+		for _, sym := range merged.symbols {
+			lengths[sym]++
+			if lengths[sym] > limit {
+				log.Fatalf("code length over %d bits", limit)
+			}
+		}
+		nodes = append(nodes[2:], merged)
+	}
+	return lengths
+}
+
+var lengthBase = [29]int{
+	3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31,
+	35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 3,
+}
+
+var lengthExtra = [29]uint{
+	0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2,
+	3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 16,
+}
+
+var distBase = [32]int{
+	1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193,
+	257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145,
+	8193, 12289, 16385, 24577, 32769, 49153,
+}
+
+var distExtra = [32]uint{
+	0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6,
+	7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13, 14, 14,
+}
+
+var clOrder = []int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+// lengthSymbol returns the symbol for a match length: up to 258, it's
+// the same as in deflate, longer matches need code 285
+func lengthSymbol(length int) int {
+	if length > 258 {
+		return 285
+	}
+	for i := 27; i >= 0; i-- {
+		if lengthBase[i] <= length {
+			return 257 + i
+		}
+	}
+	panic("match too short")
+}
+
+func distSymbol(dist int) int {
+	for i := 31; i >= 0; i-- {
+		if distBase[i] <= dist {
+			return i
+		}
+	}
+	panic("distance too short")
+}
+
+// op is a literal if length is zero, a match otherwise
+type op struct {
+	literal          byte
+	length, distance int
+}
+
+// stream is a Deflate64 stream being written, along with what it
+// decompresses to
+type stream struct {
+	bw   bitWriter
+	data []byte
+}
+
+// apply appends what ops decompress to to s.data
+func (s *stream) apply(ops []op) {
+	for _, o := range ops {
+		if o.length == 0 {
+			s.data = append(s.data, o.literal)
+			continue
+		}
+		if o.distance > len(s.data) || o.distance > 64*1024 {
+			log.Fatalf("match at distance %d, with %d bytes of history", o.distance, len(s.data))
+		}
+		for i := 0; i < o.length; i++ {
+			s.data = append(s.data, s.data[len(s.data)-o.distance])
+		}
+	}
+}
+
+func (s *stream) header(final bool, blockType uint32) {
+	if final {
+		s.bw.write(1, 1)
+	} else {
+		s.bw.write(0, 1)
+	}
+	s.bw.write(blockType, 2)
+}
+
+func (s *stream) stored(final bool, data []byte) {
+	s.header(final, 0)
+	s.bw.align()
+	s.bw.write(uint32(len(data)), 16)
+	s.bw.write(^uint32(len(data)), 16)
+	for _, b := range data {
+		s.bw.write(uint32(b), 8)
+	}
+	s.data = append(s.data, data...)
+}
+
+// symbols writes ops then an end of block
+func (s *stream) symbols(ops []op, litCodes, distCodes []code) {
+	for _, o := range ops {
+		if o.length == 0 {
+			s.bw.writeCode(litCodes[o.literal])
+			continue
+		}
+		lsym := lengthSymbol(o.length)
+		s.bw.writeCode(litCodes[lsym])
+		s.bw.write(uint32(o.length-lengthBase[lsym-257]), lengthExtra[lsym-257])
+		dsym := distSymbol(o.distance)
+		s.bw.writeCode(distCodes[dsym])
+		s.bw.write(uint32(o.distance-distBase[dsym]), distExtra[dsym])
+	}
+	s.bw.writeCode(litCodes[256])
+	s.apply(ops)
+}
+
+func fixedLengths() (lit []uint, dist []uint) {
+	lit = make([]uint, 288)
+	for sym := range lit {
+		switch {
+		case sym < 144:
+			lit[sym] = 8
+		case sym < 256:
+			lit[sym] = 9
+		case sym < 280:
+			lit[sym] = 7
+		default:
+			lit[sym] = 8
+		}
+	}
+	dist = make([]uint, 32)
+	for sym := range dist {
+		dist[sym] = 5
+	}
+	return lit, dist
+}
+
+func (s *stream) fixed(final bool, ops []op) {
+	s.header(final, 1)
+	lit, dist := fixedLengths()
+	s.symbols(ops, canonical(lit), canonical(dist))
+}
+
+// clSymbol is a symbol of the code length code, and its extra bits
+type clSymbol struct {
+	sym   int
+	extra uint32
+}
+
+// runLengths encodes code lengths like zlib does: runs of zeros with
+// 17 and 18, repeats of the previous length with 16
+func runLengths(lengths []uint) []clSymbol {
+	var res []clSymbol
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
+		}
+
+		switch {
+		case l == 0 && run >= 11:
+			if run > 138 {
+				run = 138
+			}
+			res = append(res, clSymbol{18, uint32(run - 11)})
+		case l == 0 && run >= 3:
+			res = append(res, clSymbol{17, uint32(run - 3)})
+		case l != 0 && run >= 4:
+			if run > 7 {
+				run = 7
+			}
+			res = append(res, clSymbol{int(l), 0}, clSymbol{16, uint32(run - 4)})
+		default:
+			run = 1
+			res = append(res, clSymbol{int(l), 0})
+		}
+		i += run
+	}
+	return res
+}
+
+var clExtra = map[int]uint{16: 2, 17: 3, 18: 7}
+
+// tables writes the header of a dynamic block, which is given as is, so
+// that invalid ones can be written too
+func (s *stream) tables(numLit, numDist int, clLengths []uint, clSymbols []clSymbol) {
+	numCL := 19
+	for numCL > 4 && clLengths[clOrder[numCL-1]] == 0 {
+		numCL--
+	}
+	s.bw.write(uint32(numLit-257), 5)
+	s.bw.write(uint32(numDist-1), 5)
+	s.bw.write(uint32(numCL-4), 4)
+	for _, sym := range clOrder[:numCL] {
+		s.bw.write(uint32(clLengths[sym]), 3)
+	}
+
+	clCodes := canonical(clLengths)
+	for _, cs := range clSymbols {
+		s.bw.writeCode(clCodes[cs.sym])
+		s.bw.write(cs.extra, clExtra[cs.sym])
+	}
+}
+
+// dynamic writes a dynamic block, whose codes are made for ops
+func (s *stream) dynamic(final bool, ops []op) {
+	litFreqs := make([]int, 286)
+	distFreqs := make([]int, 32)
+	litFreqs[256] = 1
+	for _, o := range ops {
+		if o.length == 0 {
+			litFreqs[o.literal]++
+			continue
+		}
+		litFreqs[lengthSymbol(o.length)]++
+		distFreqs[distSymbol(o.distance)]++
+	}
+	litLengths := huffmanLengths(litFreqs, 15)
+	distLengths := huffmanLengths(distFreqs, 15)
+
+	numLit := len(litLengths)
+	for litLengths[numLit-1] == 0 {
+		numLit--
+	}
+	numDist := len(distLengths)
+	for numDist > 1 && distLengths[numDist-1] == 0 {
+		numDist--
+	}
+
+	all := append(append([]uint(nil), litLengths[:numLit]...), distLengths[:numDist]...)
+	clSymbols := runLengths(all)
+	clFreqs := make([]int, 19)
+	for _, cs := range clSymbols {
+		clFreqs[cs.sym]++
+	}
+	clLengths := huffmanLengths(clFreqs, 7)
+
+	s.header(final, 2)
+	s.tables(numLit, numDist, clLengths, clSymbols)
+	s.symbols(ops, canonical(litLengths), canonical(distLengths))
+}
+
+var words = []string{"savior", "resumes", "extraction", "of", "archives", "from", "checkpoints"}
+
+// text returns ops for n bytes of literal text
+func text(rng *rand.Rand, n int) []op {
+	var ops []op
+	for len(ops) < n {
+		for _, b := range []byte(words[rng.Intn(len(words))] + " ") {
+			ops = append(ops, op{literal: b})
+		}
+	}
+	return ops[:n]
+}
+
+// matches returns n ops, half of which are literals, and half matches of
+// all lengths, at all distances history allows, which grows as they're
+// applied
+func matches(rng *rand.Rand, n int, history int) []op {
+	var ops []op
+	for i := 0; i < n; i++ {
+		if history == 0 || rng.Intn(2) == 0 {
+			ops = append(ops, text(rng, 1)...)
+			history++
+			continue
+		}
+
+		var length int
+		switch n := rng.Intn(100); {
+		case n == 0:
+			length = 259 + rng.Intn(2000)
+		case n < 30:
+			length = 3 + rng.Intn(256)
+		default:
+			length = 3 + rng.Intn(16)
+		}
+		ops = append(ops, op{length: length, distance: 1 + rng.Intn(maxDistance(history))})
+		history += length
+	}
+	return ops
+}
+
+// maxDistance returns how far back a match can go with that much history
+func maxDistance(history int) int {
+	if history > 64*1024 {
+		return 64 * 1024
+	}
+	return history
+}
+
+// size returns how much ops decompress to
+func size(ops []op) int {
+	res := 0
+	for _, o := range ops {
+		if o.length == 0 {
+			res++
+		}
+		res += o.length
+	}
+	return res
+}
+
+// bigMatches returns the matches only Deflate64 has: a length of over
+// 258 with code 285, and distances with codes 30 and 31, then one at
+// the largest distance there is. They start with enough history for
+// those, which is mostly a long match too.
+func bigMatches(rng *rand.Rand) []op {
+	return append(text(rng, 1000), []op{
+		{length: 49000, distance: 1000},
+		{length: 65538, distance: 50000},
+		{length: 1000, distance: 40000},
+		{length: 259, distance: 64 * 1024},
+		{length: 258, distance: 1},
+	}...)
+}
+
+func main() {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	add := func(name string, s *stream, valid bool) {
+		compressed := s.bw.flush()
+		fh := &zip.FileHeader{
+			Name:             name,
+			Method:           9,
+			CompressedSize64: uint64(len(compressed)),
+		}
+		if valid {
+			fh.CRC32 = crc32.ChecksumIEEE(s.data)
+			fh.UncompressedSize64 = uint64(len(s.data))
+		}
+		w, err := zw.CreateRaw(fh)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = w.Write(compressed)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s: %d bytes, decompresses to %d", name, len(compressed), len(s.data))
+	}
+
+	rng := rand.New(rand.NewSource(0x64))
+	random := func(n int) []byte {
+		data := make([]byte, n)
+		rng.Read(data)
+		return data
+	}
+
+	{
+		// the largest stored block there is, an empty one, then the
+		// last one, which starts on a byte boundary already
+		s := &stream{}
+		s.stored(false, random(65535))
+		s.stored(false, nil)
+		s.stored(true, random(1000))
+		add("stored", s, true)
+	}
+
+	{
+		s := &stream{}
+		ops := bigMatches(rng)
+		ops = append(ops, matches(rng, 5000, size(ops))...)
+		s.fixed(true, ops)
+		add("fixed", s, true)
+	}
+
+	{
+		// blocks with lots of codes, one with a single distance code,
+		// and one with none
+		s := &stream{}
+		s.dynamic(false, bigMatches(rng))
+		s.dynamic(false, matches(rng, 5000, len(s.data)))
+		s.dynamic(false, append(text(rng, 1), op{length: 5000, distance: 1}))
+		s.dynamic(true, text(rng, 3000))
+		add("dynamic", s, true)
+	}
+
+	{
+		// all kinds of blocks, whose matches refer to the ones before
+		s := &stream{}
+		s.stored(false, []byte("savior resumes extraction"))
+		s.fixed(false, matches(rng, 3000, len(s.data)))
+		s.dynamic(false, matches(rng, 3000, len(s.data)))
+		s.stored(false, random(3000))
+		s.fixed(false, nil)
+		s.dynamic(false, matches(rng, 3000, len(s.data)))
+		s.fixed(false, []op{{length: 40000, distance: maxDistance(len(s.data))}})
+		s.stored(true, nil)
+		add("mixed", s, true)
+	}
+
+	invalid := func(name string, write func(s *stream)) {
+		s := &stream{}
+		write(s)
+		add("invalid/"+name, s, false)
+	}
+	lit, dist := fixedLengths()
+	litCodes, distCodes := canonical(lit), canonical(dist)
+
+	invalid("block type", func(s *stream) {
+		s.header(true, 3)
+	})
+	invalid("stored length", func(s *stream) {
+		s.header(true, 0)
+		s.bw.align()
+		s.bw.write(10, 16)
+		s.bw.write(10, 16)
+	})
+	invalid("distance too far", func(s *stream) {
+		s.header(true, 1)
+		s.bw.writeCode(litCodes['a'])
+		s.bw.writeCode(litCodes[lengthSymbol(3)])
+		s.bw.writeCode(distCodes[distSymbol(2)])
+	})
+	invalid("length code", func(s *stream) {
+		s.header(true, 1)
+		s.bw.writeCode(litCodes['a'])
+		s.bw.writeCode(litCodes[286])
+		s.bw.write(0, 5)
+		s.bw.writeCode(distCodes[0])
+	})
+	// dynamic blocks that code a single literal, 'a'
+	clLengths := make([]uint, 19)
+	clLengths[0], clLengths[1], clLengths[18] = 2, 2, 1
+	invalid("too many literal codes", func(s *stream) {
+		s.header(true, 2)
+		s.tables(287, 1, clLengths, nil)
+	})
+	invalid("code length code", func(s *stream) {
+		s.header(true, 2)
+		over := make([]uint, 19)
+		for i := range over {
+			over[i] = 1
+		}
+		s.tables(257, 1, over, nil)
+	})
+	invalid("repeat first", func(s *stream) {
+		lengths := make([]uint, 19)
+		lengths[0], lengths[1], lengths[16], lengths[18] = 2, 2, 2, 2
+		s.header(true, 2)
+		s.tables(257, 1, lengths, []clSymbol{{16, 0}})
+	})
+	invalid("repeat too long", func(s *stream) {
+		s.header(true, 2)
+		s.tables(257, 1, clLengths, []clSymbol{{18, 127}, {18, 127}, {18, 127}})
+	})
+	invalid("no end of block", func(s *stream) {
+		s.header(true, 2)
+		s.tables(257, 1, clLengths, []clSymbol{
+			{18, 'a' - 11}, {1, 0}, {18, 127}, {18, 256 - 'a' - 1 - 138 - 11}, {0, 0}, {0, 0},
+		})
+	})
+	invalid("literal codes", func(s *stream) {
+		// 257 8-bit codes
+		lengths := make([]uint, 257+1)
+		for i := range lengths[:257] {
+			lengths[i] = 8
+		}
+		over := make([]uint, 19)
+		over[0], over[8], over[16] = 2, 1, 2
+		s.header(true, 2)
+		s.tables(257, 1, over, runLengths(lengths))
+	})
+
+	err := zw.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = os.WriteFile("fixtures.zip", buf.Bytes(), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("wrote fixtures.zip (%d bytes)\n", buf.Len())
+}
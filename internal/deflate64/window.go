@@ -0,0 +1,74 @@
+package deflate64
+
+const windowMask = WindowSize - 1
+
+// window is a ring buffer of the last WindowSize bytes of output
+type window struct {
+	buf   []byte
+	pos   int
+	total int64
+}
+
+func (w *window) reset() {
+	if w.buf == nil {
+		w.buf = make([]byte, WindowSize)
+	}
+	w.pos = 0
+	w.total = 0
+}
+
+func (w *window) put(b byte) {
+	w.buf[w.pos] = b
+	w.pos = (w.pos + 1) & windowMask
+	w.total++
+}
+
+func (w *window) write(p []byte) {
+	for len(p) > 0 {
+		n := copy(w.buf[w.pos:], p)
+		w.pos = (w.pos + n) & windowMask
+		w.total += int64(n)
+		p = p[n:]
+	}
+}
+
+// has returns true if a match can go dist bytes back
+func (w *window) has(dist int) bool {
+	return int64(dist) <= w.total && dist <= WindowSize
+}
+
+// copyMatch copies up to length bytes from dist bytes back, into
+// out and the window. It returns the number of bytes copied.
+func (w *window) copyMatch(out []byte, dist int, length int) int {
+	n := length
+	if n > len(out) {
+		n = len(out)
+	}
+	for i := 0; i < n; i++ {
+		b := w.buf[(w.pos-dist)&windowMask]
+		out[i] = b
+		w.put(b)
+	}
+	return n
+}
+
+// history returns a copy of the window contents, oldest byte first
+func (w *window) history() []byte {
+	n := WindowSize
+	if w.total < int64(n) {
+		n = int(w.total)
+	}
+
+	res := make([]byte, n)
+	start := (w.pos - n) & windowMask
+	m := copy(res, w.buf[start:])
+	copy(res[m:], w.buf[:w.pos])
+	return res
+}
+
+// restore refills the window from the result of history()
+func (w *window) restore(hist []byte, total int64) {
+	w.pos = 0
+	w.write(hist)
+	w.total = total
+}
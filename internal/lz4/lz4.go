@@ -0,0 +1,560 @@
+// Package lz4 implements decompression of the LZ4 frame format, in a way
+// that lets consumers save the state of the decompressor and resume it later.
+//
+// Checkpoints can be made between frames, and between blocks inside of
+// a frame. When blocks are linked, block checkpoints carry the last 64 KiB
+// of output, which later blocks may refer to.
+package lz4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ReadyToSaveError is returned by Read() when a SaverReader is ready to emit a checkpoint
+	ReadyToSaveError = errors.New("ready to save")
+	// NotOnBoundaryError is returned by Save() when a SaverReader wasn't ready to emit a checkpoint
+	NotOnBoundaryError = errors.New("asked to save, but not on boundary")
+	// ErrCorrupt is returned when the compressed data is invalid
+	ErrCorrupt = errors.New("lz4: corrupt input")
+	// ErrChecksum is returned when a header, block or content checksum doesn't match
+	ErrChecksum = errors.New("lz4: checksum error")
+)
+
+const (
+	frameMagic         = 0x184D2204
+	legacyFrameMagic   = 0x184C2102
+	skippableMagicMask = 0xFFFFFFF0
+	skippableMagic     = 0x184D2A50
+
+	// windowSize is how far back matches can reach
+	windowSize = 64 * 1024
+)
+
+// Reader is what the decompressor reads from.
+type Reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// A SaverReader is a decompressor that can be asked to stop on
+// the next boundary, so that its state can be saved.
+type SaverReader interface {
+	io.Reader
+
+	// WantSave signals the decompressor that it should stop
+	// on the next boundary to allow the consumer to perform a checkpoint
+	WantSave()
+	// Save returns a checkpoint, it must only be called after Read
+	// returned ReadyToSaveError.
+	Save() (*Checkpoint, error)
+}
+
+type stage int
+
+const (
+	stageFrameHeader stage = iota
+	stageBlockHeader
+	stageDone
+)
+
+// A Checkpoint allows resuming decompression from a frame boundary or
+// a block boundary.
+type Checkpoint struct {
+	// Roffset is the offset into compressed data
+	Roffset int64
+	// Woffset is the offset into uncompressed data
+	Woffset int64
+
+	Stage    int
+	SawFrame bool
+
+	// Frame state, only set for block checkpoints
+	IndependentBlocks bool
+	BlockChecksum     bool
+	BlockMaxSize      int
+	ContentSize       int64
+	FrameWoffset      int64
+	Hash              *XXHash32
+
+	// History holds the last 64 KiB of the frame's output,
+	// if blocks are linked.
+	History []byte
+}
+
+type countingReader struct {
+	r Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return b, err
+}
+
+type saverReader struct {
+	cr      *countingReader
+	woffset int64
+	stage   stage
+
+	sawFrame bool
+
+	// frame state
+	independentBlocks bool
+	blockChecksum     bool
+	blockMaxSize      int
+	contentSize       int64
+	frameWoffset      int64
+	hash              *XXHash32
+
+	blockBuf []byte
+
+	// hist holds the frame's output, of which hist[outPos:]
+	// hasn't been returned by Read yet.
+	hist   []byte
+	outPos int
+
+	wantSave bool
+	err      error
+}
+
+var _ SaverReader = (*saverReader)(nil)
+
+// NewSaverReader returns a reader that decompresses an LZ4 stream, made of
+// one or more frames.
+func NewSaverReader(r Reader) SaverReader {
+	return &saverReader{
+		cr: &countingReader{r: r},
+	}
+}
+
+func (sr *saverReader) Read(p []byte) (int, error) {
+	for {
+		if sr.outPos < len(sr.hist) {
+			if len(p) == 0 {
+				return 0, nil
+			}
+			n := copy(p, sr.hist[sr.outPos:])
+			sr.outPos += n
+			sr.woffset += int64(n)
+			return n, nil
+		}
+
+		if sr.err != nil {
+			return 0, sr.err
+		}
+
+		switch sr.stage {
+		case stageFrameHeader:
+			if sr.wantSave {
+				return 0, ReadyToSaveError
+			}
+			sr.err = sr.readFrameHeader()
+		case stageBlockHeader:
+			if sr.wantSave {
+				return 0, ReadyToSaveError
+			}
+			sr.err = sr.readBlock()
+		case stageDone:
+			return 0, io.EOF
+		}
+	}
+}
+
+func (sr *saverReader) readFull(buf []byte) error {
+	_, err := io.ReadFull(sr.cr, buf)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (sr *saverReader) readFrameHeader() error {
+	var magicBuf [4]byte
+	n, err := io.ReadFull(sr.cr, magicBuf[:])
+	if n == 0 && err == io.EOF && sr.sawFrame {
+		sr.stage = stageDone
+		return nil
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	magic := binary.LittleEndian.Uint32(magicBuf[:])
+	if magic&skippableMagicMask == skippableMagic {
+		var sizeBuf [4]byte
+		err = sr.readFull(sizeBuf[:])
+		if err != nil {
+			return err
+		}
+		size := int64(binary.LittleEndian.Uint32(sizeBuf[:]))
+		_, err = io.CopyN(io.Discard, sr.cr, size)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		return nil
+	}
+
+	if magic == legacyFrameMagic {
+		return errors.New("lz4: legacy frame format is not supported")
+	}
+	if magic != frameMagic {
+		return ErrCorrupt
+	}
+
+	var desc [2]byte
+	err = sr.readFull(desc[:])
+	if err != nil {
+		return err
+	}
+
+	flg, bd := desc[0], desc[1]
+	if flg>>6 != 1 {
+		return fmt.Errorf("lz4: unsupported frame version %d", flg>>6)
+	}
+	if flg&0x02 != 0 || bd&0x8F != 0 {
+		// reserved bits
+		return ErrCorrupt
+	}
+
+	independentBlocks := flg&0x20 != 0
+	blockChecksum := flg&0x10 != 0
+	hasContentSize := flg&0x08 != 0
+	contentChecksum := flg&0x04 != 0
+	hasDictID := flg&0x01 != 0
+
+	var blockMaxSize int
+	switch bd >> 4 {
+	case 4:
+		blockMaxSize = 64 * 1024
+	case 5:
+		blockMaxSize = 256 * 1024
+	case 6:
+		blockMaxSize = 1024 * 1024
+	case 7:
+		blockMaxSize = 4 * 1024 * 1024
+	default:
+		return ErrCorrupt
+	}
+
+	rest := 1
+	if hasContentSize {
+		rest += 8
+	}
+	if hasDictID {
+		rest += 4
+	}
+	buf := make([]byte, 2+rest)
+	copy(buf, desc[:])
+	err = sr.readFull(buf[2:])
+	if err != nil {
+		return err
+	}
+
+	headerChecksum := buf[len(buf)-1]
+	if byte(xxh32(buf[:len(buf)-1])>>8) != headerChecksum {
+		return ErrChecksum
+	}
+
+	contentSize := int64(-1)
+	if hasContentSize {
+		v := binary.LittleEndian.Uint64(buf[2:10])
+		if v > 1<<62 {
+			return ErrCorrupt
+		}
+		contentSize = int64(v)
+	}
+	if hasDictID {
+		return errors.New("lz4: frames using dictionaries are not supported")
+	}
+
+	sr.sawFrame = true
+	sr.independentBlocks = independentBlocks
+	sr.blockChecksum = blockChecksum
+	sr.blockMaxSize = blockMaxSize
+	sr.contentSize = contentSize
+	sr.frameWoffset = 0
+	sr.hash = nil
+	if contentChecksum {
+		sr.hash = newXXHash32()
+	}
+	sr.hist = sr.hist[:0]
+	sr.outPos = 0
+	sr.stage = stageBlockHeader
+	return nil
+}
+
+func (sr *saverReader) readBlock() error {
+	var sizeBuf [4]byte
+	err := sr.readFull(sizeBuf[:])
+	if err != nil {
+		return err
+	}
+
+	size := binary.LittleEndian.Uint32(sizeBuf[:])
+	if size == 0 {
+		// end mark
+		return sr.finishFrame()
+	}
+
+	uncompressed := size&0x80000000 != 0
+	size &= 0x7FFFFFFF
+	if int(size) > sr.blockMaxSize {
+		return ErrCorrupt
+	}
+
+	if cap(sr.blockBuf) < int(size) {
+		sr.blockBuf = make([]byte, size, sr.blockMaxSize)
+	}
+	buf := sr.blockBuf[:size]
+	err = sr.readFull(buf)
+	if err != nil {
+		return err
+	}
+
+	if sr.blockChecksum {
+		var checksumBuf [4]byte
+		err = sr.readFull(checksumBuf[:])
+		if err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(checksumBuf[:]) != xxh32(buf) {
+			return ErrChecksum
+		}
+	}
+
+	// linked blocks can refer to the last 64KiB of output,
+	// independent blocks can't refer to anything
+	if sr.independentBlocks {
+		sr.hist = sr.hist[:0]
+	} else if len(sr.hist) > windowSize {
+		keep := sr.hist[len(sr.hist)-windowSize:]
+		sr.hist = sr.hist[:copy(sr.hist, keep)]
+	}
+	start := len(sr.hist)
+	sr.outPos = start
+
+	if uncompressed {
+		sr.hist = append(sr.hist, buf...)
+	} else {
+		sr.hist, err = decodeBlock(buf, sr.hist, sr.blockMaxSize)
+		if err != nil {
+			sr.hist = sr.hist[:start]
+			return err
+		}
+	}
+
+	output := sr.hist[start:]
+	sr.frameWoffset += int64(len(output))
+	if sr.hash != nil {
+		sr.hash.Write(output)
+	}
+
+	if sr.contentSize >= 0 && sr.frameWoffset > sr.contentSize {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// decodeBlock decompresses an LZ4 block, appending its output to hist,
+// which must only hold the history the block is allowed to refer to.
+func decodeBlock(in []byte, hist []byte, maxSize int) ([]byte, error) {
+	start := len(hist)
+	i := 0
+
+	for {
+		if i >= len(in) {
+			return hist, ErrCorrupt
+		}
+		token := in[i]
+		i++
+
+		literalsLength := int(token >> 4)
+		if literalsLength == 15 {
+			for {
+				if i >= len(in) {
+					return hist, ErrCorrupt
+				}
+				b := in[i]
+				i++
+				literalsLength += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+
+		if literalsLength > len(in)-i || len(hist)-start+literalsLength > maxSize {
+			return hist, ErrCorrupt
+		}
+		hist = append(hist, in[i:i+literalsLength]...)
+		i += literalsLength
+
+		if i == len(in) {
+			// the last sequence only has literals
+			return hist, nil
+		}
+
+		if i+2 > len(in) {
+			return hist, ErrCorrupt
+		}
+		offset := int(in[i]) | int(in[i+1])<<8
+		i += 2
+
+		matchLength := int(token & 15)
+		if matchLength == 15 {
+			for {
+				if i >= len(in) {
+					return hist, ErrCorrupt
+				}
+				b := in[i]
+				i++
+				matchLength += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		matchLength += 4
+
+		if offset == 0 || offset > len(hist) || len(hist)-start+matchLength > maxSize {
+			return hist, ErrCorrupt
+		}
+
+		src := len(hist) - offset
+		if matchLength <= offset {
+			hist = append(hist, hist[src:src+matchLength]...)
+		} else {
+			for j := 0; j < matchLength; j++ {
+				hist = append(hist, hist[src+j])
+			}
+		}
+	}
+}
+
+func (sr *saverReader) finishFrame() error {
+	if sr.contentSize >= 0 && sr.frameWoffset != sr.contentSize {
+		return ErrCorrupt
+	}
+
+	if sr.hash != nil {
+		var buf [4]byte
+		err := sr.readFull(buf[:])
+		if err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(buf[:]) != sr.hash.Sum32() {
+			return ErrChecksum
+		}
+	}
+
+	sr.stage = stageFrameHeader
+	return nil
+}
+
+// WantSave signals the decompressor that it should stop
+// on the next frame or block boundary to allow the consumer to perform a checkpoint
+func (sr *saverReader) WantSave() {
+	sr.wantSave = true
+}
+
+func (sr *saverReader) Save() (*Checkpoint, error) {
+	sr.wantSave = false
+
+	if sr.err != nil || sr.outPos < len(sr.hist) {
+		return nil, NotOnBoundaryError
+	}
+
+	c := &Checkpoint{
+		Roffset:  sr.cr.n,
+		Woffset:  sr.woffset,
+		Stage:    int(sr.stage),
+		SawFrame: sr.sawFrame,
+	}
+
+	switch sr.stage {
+	case stageFrameHeader:
+		// all good, nothing else to save
+	case stageBlockHeader:
+		c.IndependentBlocks = sr.independentBlocks
+		c.BlockChecksum = sr.blockChecksum
+		c.BlockMaxSize = sr.blockMaxSize
+		c.ContentSize = sr.contentSize
+		c.FrameWoffset = sr.frameWoffset
+		if sr.hash != nil {
+			hash := *sr.hash
+			c.Hash = &hash
+		}
+
+		if !sr.independentBlocks {
+			history := sr.hist
+			if len(history) > windowSize {
+				history = history[len(history)-windowSize:]
+			}
+			c.History = append([]byte(nil), history...)
+		}
+	default:
+		return nil, NotOnBoundaryError
+	}
+
+	return c, nil
+}
+
+// Resume starts decompressing again from a given checkpoint
+func (c *Checkpoint) Resume(r Reader) (SaverReader, error) {
+	sr := &saverReader{
+		cr:       &countingReader{r: r, n: c.Roffset},
+		woffset:  c.Woffset,
+		stage:    stage(c.Stage),
+		sawFrame: c.SawFrame,
+	}
+
+	switch sr.stage {
+	case stageFrameHeader:
+		// nothing else to restore
+	case stageBlockHeader:
+		switch c.BlockMaxSize {
+		case 64 * 1024, 256 * 1024, 1024 * 1024, 4 * 1024 * 1024:
+			// valid
+		default:
+			return nil, errors.New("lz4: checkpoint has invalid block size")
+		}
+		if len(c.History) > windowSize || int64(len(c.History)) > c.FrameWoffset {
+			return nil, errors.New("lz4: checkpoint has invalid history")
+		}
+
+		sr.independentBlocks = c.IndependentBlocks
+		sr.blockChecksum = c.BlockChecksum
+		sr.blockMaxSize = c.BlockMaxSize
+		sr.contentSize = c.ContentSize
+		sr.frameWoffset = c.FrameWoffset
+		if c.Hash != nil {
+			hash := *c.Hash
+			sr.hash = &hash
+		}
+		sr.hist = append([]byte(nil), c.History...)
+		sr.outPos = len(sr.hist)
+	default:
+		return nil, fmt.Errorf("lz4: cannot resume from stage %d", c.Stage)
+	}
+
+	return sr, nil
+}
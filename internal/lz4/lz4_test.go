@@ -0,0 +1,263 @@
+package lz4_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior/internal/lz4"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func must(t *testing.T, err error) {
+	assert.NoError(t, err)
+	if err != nil {
+		t.FailNow()
+	}
+}
+
+// readFixture reads a file made by internal/testdata/mkfixtures.go
+func readFixture(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	must(t, err)
+	return data
+}
+
+func readInput(t *testing.T) []byte {
+	data, err := os.ReadFile(filepath.Join("..", "testdata", "input.bin"))
+	must(t, err)
+	return data
+}
+
+// random returns the random bytes mkfixtures.go makes
+func random() []byte {
+	random := make([]byte, 8*1024)
+	rand.New(rand.NewSource(0x5a)).Read(random)
+	return random
+}
+
+// withHeader returns a frame whose header, which starts after the
+// magic and ends with its checksum, is replaced with header
+func withHeader(data []byte, oldSize int, header []byte) []byte {
+	frame := append([]byte(nil), data[:4]...)
+	frame = append(frame, header...)
+	frame = append(frame, byte(lz4.XXH32(header)>>8))
+	return append(frame, data[4+oldSize:]...)
+}
+
+// decode decompresses data. If saveEvery isn't zero, it asks for a
+// checkpoint every saveEvery reads that made progress, and resumes from
+// it with a new decompressor, which reads data from the checkpoint's
+// offset. It returns what was decompressed, even on error, and how many
+// checkpoints were made.
+func decode(data []byte, saveEvery int) ([]byte, int, error) {
+	sr := lz4.NewSaverReader(bytes.NewReader(data))
+	out := new(bytes.Buffer)
+	buf := make([]byte, 4096)
+	numCheckpoints := 0
+	reads := 0
+
+	for {
+		if saveEvery > 0 && reads == saveEvery {
+			reads = 0
+			sr.WantSave()
+		}
+
+		n, err := sr.Read(buf)
+		if n > 0 {
+			reads++
+		}
+		out.Write(buf[:n])
+		switch err {
+		case nil:
+			continue
+		case io.EOF:
+			return out.Bytes(), numCheckpoints, nil
+		case lz4.ReadyToSaveError:
+			// keep going below
+		default:
+			return out.Bytes(), numCheckpoints, err
+		}
+
+		c, err := sr.Save()
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+		numCheckpoints++
+		if c.Woffset != int64(out.Len()) {
+			msg := "checkpoint is at %d, but %d bytes were decompressed"
+			return out.Bytes(), numCheckpoints, errors.Errorf(msg, c.Woffset, out.Len())
+		}
+
+		// checkpoints are stored, so make sure they survive that
+		encoded := new(bytes.Buffer)
+		err = gob.NewEncoder(encoded).Encode(c)
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+		c = &lz4.Checkpoint{}
+		err = gob.NewDecoder(encoded).Decode(c)
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+
+		sr, err = c.Resume(bytes.NewReader(data[c.Roffset:]))
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+	}
+}
+
+func Test_Fixtures(t *testing.T) {
+	input := readInput(t)
+
+	tests := []struct {
+		fixture string
+		output  []byte
+	}{
+		{"default.lz4", input},
+		{"linked.lz4", input},
+		{"block-checksum.lz4", input},
+		{"content-size.lz4", input},
+		{"random.lz4", random()},
+		{"empty.lz4", nil},
+		{"multiframe.lz4", append(append([]byte(nil), input...), input...)},
+	}
+
+	for _, tt := range tests {
+		for _, saveEvery := range []int{0, 1, 7} {
+			name := tt.fixture
+			if saveEvery > 0 {
+				name += "/resumed"
+			}
+			t.Run(name, func(t *testing.T) {
+				out, numCheckpoints, err := decode(readFixture(t, tt.fixture), saveEvery)
+				must(t, err)
+				assert.Equal(t, len(tt.output), len(out))
+				assert.True(t, bytes.Equal(tt.output, out), "output differs")
+				if saveEvery == 1 && len(tt.output) > 4096 {
+					assert.NotZero(t, numCheckpoints)
+				}
+			})
+		}
+	}
+}
+
+func Test_Corrupt(t *testing.T) {
+	// frames start with their magic, flags, block descriptor and
+	// header checksum, unless they have a content size
+	const firstBlock = 4 + 3
+
+	with := func(change func(data []byte) []byte) func(data []byte) []byte {
+		return change
+	}
+	xor := func(offset int, mask byte) func(data []byte) []byte {
+		return func(data []byte) []byte {
+			if offset < 0 {
+				offset += len(data)
+			}
+			data[offset] ^= mask
+			return data
+		}
+	}
+	truncate := func(size int) func(data []byte) []byte {
+		return func(data []byte) []byte {
+			if size < 0 {
+				size += len(data)
+			}
+			return data[:size]
+		}
+	}
+
+	tests := []struct {
+		name    string
+		fixture string
+		corrupt func(data []byte) []byte
+		err     error
+	}{
+		{"bad magic", "default.lz4", xor(0, 0x01), lz4.ErrCorrupt},
+		{"reserved flag", "default.lz4", xor(4, 0x02), lz4.ErrCorrupt},
+		{"reserved block descriptor bit", "default.lz4", xor(5, 0x80), lz4.ErrCorrupt},
+		{"bad block max size", "default.lz4", xor(5, 0x70), lz4.ErrCorrupt},
+		{"bad header checksum", "default.lz4", xor(6, 0x01), lz4.ErrChecksum},
+		{"bad content checksum", "default.lz4", xor(-1, 0x01), lz4.ErrChecksum},
+		// the last block's checksum is right before the end mark
+		{"bad block checksum", "block-checksum.lz4", xor(-4-1, 0x01), lz4.ErrChecksum},
+		{"block too large", "linked.lz4", xor(firstBlock+2, 0x01), lz4.ErrCorrupt},
+		{"bad compressed block", "random.lz4", xor(firstBlock+3, 0x80), lz4.ErrCorrupt},
+		{"wrong content size", "content-size.lz4", with(func(data []byte) []byte {
+			header := append([]byte(nil), data[4:4+10]...)
+			header[2]++
+			return withHeader(data, 11, header)
+		}), lz4.ErrCorrupt},
+		{"nothing", "empty.lz4", truncate(0), io.ErrUnexpectedEOF},
+		{"truncated magic", "default.lz4", truncate(2), io.ErrUnexpectedEOF},
+		{"truncated frame header", "default.lz4", truncate(6), io.ErrUnexpectedEOF},
+		{"truncated block size", "default.lz4", truncate(firstBlock + 2), io.ErrUnexpectedEOF},
+		{"truncated block", "default.lz4", truncate(firstBlock + 100), io.ErrUnexpectedEOF},
+		{"truncated block checksum", "block-checksum.lz4", truncate(-4 - 1), io.ErrUnexpectedEOF},
+		{"truncated end mark", "default.lz4", truncate(-4 - 1), io.ErrUnexpectedEOF},
+		{"truncated content checksum", "default.lz4", truncate(-1), io.ErrUnexpectedEOF},
+		{"truncated skippable frame", "multiframe.lz4", truncate(4 + 4 + 2), io.ErrUnexpectedEOF},
+		{"trailing garbage", "default.lz4", with(func(data []byte) []byte {
+			return append(data, []byte("garbage!")...)
+		}), lz4.ErrCorrupt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.corrupt(readFixture(t, tt.fixture))
+			_, _, err := decode(data, 0)
+			assert.True(t, errors.Is(err, tt.err), "expected %v, got %v", tt.err, err)
+		})
+	}
+}
+
+func Test_Unsupported(t *testing.T) {
+	_, _, err := decode(readFixture(t, "legacy.lz4"), 0)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "legacy frame format is not supported")
+	}
+
+	data := readFixture(t, "default.lz4")
+
+	header := []byte{data[4] &^ 0xC0, data[5]}
+	_, _, err = decode(withHeader(data, 3, header), 0)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported frame version 0")
+	}
+
+	header = []byte{data[4] | 0x01, data[5]}
+	header = binary.LittleEndian.AppendUint32(header, 1)
+	_, _, err = decode(withHeader(data, 3, header), 0)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "dictionaries are not supported")
+	}
+}
+
+func Test_CorruptData(t *testing.T) {
+	input := readInput(t)
+
+	// corrupt data may decode to something else without a checksum,
+	// but it must never make the decoder panic or go on forever, and
+	// the checksums must catch it otherwise
+	for _, fixture := range []string{"default.lz4", "linked.lz4", "block-checksum.lz4"} {
+		t.Run(fixture, func(t *testing.T) {
+			data := readFixture(t, fixture)
+			for offset := 4; offset < len(data); offset += 151 {
+				corrupt := append([]byte(nil), data...)
+				corrupt[offset] ^= 0x55
+				out, _, err := decode(corrupt, 0)
+				if err == nil && !bytes.Equal(input, out) {
+					t.Errorf("corruption at %d went unnoticed", offset)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package lz4
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	prime32_1 uint32 = 2654435761
+	prime32_2 uint32 = 2246822519
+	prime32_3 uint32 = 3266489917
+	prime32_4 uint32 = 668265263
+	prime32_5 uint32 = 374761393
+)
+
+// XXHash32 is a streaming XXH32 hasher (with a seed of 0), used for
+// header, block and content checksums. Its fields are exported so it
+// can be saved in checkpoints.
+type XXHash32 struct {
+	V     [4]uint32
+	Total uint64
+	Mem   [16]byte
+	N     int
+}
+
+func newXXHash32() *XXHash32 {
+	p1, p2 := prime32_1, prime32_2
+	h := &XXHash32{}
+	h.V = [4]uint32{p1 + p2, p2, 0, -p1}
+	return h
+}
+
+func xxh32(p []byte) uint32 {
+	h := newXXHash32()
+	h.Write(p)
+	return h.Sum32()
+}
+
+func xxRound(acc, input uint32) uint32 {
+	acc += input * prime32_2
+	acc = bits.RotateLeft32(acc, 13)
+	return acc * prime32_1
+}
+
+func (h *XXHash32) Write(p []byte) {
+	h.Total += uint64(len(p))
+
+	if h.N > 0 {
+		n := copy(h.Mem[h.N:], p)
+		h.N += n
+		p = p[n:]
+		if h.N < 16 {
+			return
+		}
+		h.stripe(h.Mem[:])
+		h.N = 0
+	}
+
+	for len(p) >= 16 {
+		h.stripe(p[:16])
+		p = p[16:]
+	}
+
+	h.N = copy(h.Mem[:], p)
+}
+
+func (h *XXHash32) stripe(b []byte) {
+	h.V[0] = xxRound(h.V[0], binary.LittleEndian.Uint32(b[0:4]))
+	h.V[1] = xxRound(h.V[1], binary.LittleEndian.Uint32(b[4:8]))
+	h.V[2] = xxRound(h.V[2], binary.LittleEndian.Uint32(b[8:12]))
+	h.V[3] = xxRound(h.V[3], binary.LittleEndian.Uint32(b[12:16]))
+}
+
+func (h *XXHash32) Sum32() uint32 {
+	var acc uint32
+	if h.Total >= 16 {
+		v := h.V
+		acc = bits.RotateLeft32(v[0], 1) + bits.RotateLeft32(v[1], 7) +
+			bits.RotateLeft32(v[2], 12) + bits.RotateLeft32(v[3], 18)
+	} else {
+		acc = h.V[2] + prime32_5
+	}
+	acc += uint32(h.Total)
+
+	p := h.Mem[:h.N]
+	for len(p) >= 4 {
+		acc += binary.LittleEndian.Uint32(p) * prime32_3
+		acc = bits.RotateLeft32(acc, 17) * prime32_4
+		p = p[4:]
+	}
+	for _, b := range p {
+		acc += uint32(b) * prime32_5
+		acc = bits.RotateLeft32(acc, 11) * prime32_1
+	}
+
+	acc ^= acc >> 15
+	acc *= prime32_2
+	acc ^= acc >> 13
+	acc *= prime32_3
+	acc ^= acc >> 16
+	return acc
+}
@@ -0,0 +1,373 @@
+package lzma
+
+import "io"
+
+const (
+	numStates          = 12
+	numPosBitsMax      = 4
+	numPosStatesMax    = 1 << numPosBitsMax
+	numLenToPosStates  = 4
+	numAlignBits       = 4
+	startPosModelIndex = 4
+	endPosModelIndex   = 14
+	numFullDistances   = 1 << (endPosModelIndex >> 1)
+	numPosSlotBits     = 6
+	matchMinLen        = 2
+
+	numBitModelTotalBits = 11
+	bitModelTotal        = 1 << numBitModelTotalBits
+	numMoveBits          = 5
+	topValue             = 1 << 24
+
+	eosDistance = 0xFFFFFFFF
+)
+
+// Offsets into the flat probability array, in the same
+// layout as the reference decoder.
+const (
+	lenChoice  = 0
+	lenChoice2 = 1
+	lenLow     = 2
+	lenMid     = lenLow + numPosStatesMax<<3
+	lenHigh    = lenMid + numPosStatesMax<<3
+	lenProbs   = lenHigh + 1<<8
+
+	probIsMatch    = 0
+	probIsRep      = probIsMatch + numStates<<numPosBitsMax
+	probIsRepG0    = probIsRep + numStates
+	probIsRepG1    = probIsRepG0 + numStates
+	probIsRepG2    = probIsRepG1 + numStates
+	probIsRep0Long = probIsRepG2 + numStates
+	probPosSlot    = probIsRep0Long + numStates<<numPosBitsMax
+	probSpecPos    = probPosSlot + numLenToPosStates<<numPosSlotBits
+	probAlign      = probSpecPos + numFullDistances - endPosModelIndex
+	probLenCoder   = probAlign + 1<<numAlignBits
+	probRepLen     = probLenCoder + lenProbs
+	probLiteral    = probRepLen + lenProbs
+)
+
+type rangeDecoder struct {
+	br   io.ByteReader
+	rng  uint32
+	code uint32
+	// n is the number of bytes consumed so far
+	n   int64
+	err error
+}
+
+func (rc *rangeDecoder) readByte() byte {
+	b, err := rc.br.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if rc.err == nil {
+			rc.err = err
+		}
+		return 0
+	}
+	rc.n++
+	return b
+}
+
+func (rc *rangeDecoder) init() {
+	if rc.readByte() != 0 && rc.err == nil {
+		rc.err = ErrCorrupt
+	}
+	rc.code = 0
+	for i := 0; i < 4; i++ {
+		rc.code = rc.code<<8 | uint32(rc.readByte())
+	}
+	rc.rng = 0xFFFFFFFF
+}
+
+func (rc *rangeDecoder) normalize() {
+	if rc.rng < topValue {
+		rc.rng <<= 8
+		rc.code = rc.code<<8 | uint32(rc.readByte())
+	}
+}
+
+func (rc *rangeDecoder) bit(p *uint16) uint32 {
+	rc.normalize()
+	bound := (rc.rng >> numBitModelTotalBits) * uint32(*p)
+	if rc.code < bound {
+		rc.rng = bound
+		*p += (bitModelTotal - *p) >> numMoveBits
+		return 0
+	}
+	rc.rng -= bound
+	rc.code -= bound
+	*p -= *p >> numMoveBits
+	return 1
+}
+
+func (rc *rangeDecoder) direct(numBits uint32) uint32 {
+	var res uint32
+	for ; numBits > 0; numBits-- {
+		rc.normalize()
+		rc.rng >>= 1
+		rc.code -= rc.rng
+		mask := 0 - (rc.code >> 31)
+		rc.code += rc.rng & mask
+		res = res<<1 + mask + 1
+	}
+	return res
+}
+
+// decoder holds the LZMA state shared by the LZMA and LZMA2 readers
+type decoder struct {
+	rc  rangeDecoder
+	win window
+
+	props  Props
+	lpMask uint32
+	pbMask uint32
+	probs  []uint16
+
+	state uint32
+	// reps are zero-based: a distance of 0 refers to the last byte written
+	reps [4]uint32
+
+	// matchLen is the number of bytes of the current match
+	// that haven't been copied out yet
+	matchLen int
+	eos      bool
+}
+
+func (d *decoder) setProps(p Props) {
+	d.props = p
+	d.lpMask = 1<<uint(p.LP) - 1
+	d.pbMask = 1<<uint(p.PB) - 1
+
+	numProbs := probLiteral + 0x300<<uint(p.LC+p.LP)
+	if cap(d.probs) >= numProbs {
+		d.probs = d.probs[:numProbs]
+	} else {
+		d.probs = make([]uint16, numProbs)
+	}
+}
+
+func (d *decoder) resetState() {
+	for i := range d.probs {
+		d.probs[i] = bitModelTotal >> 1
+	}
+	d.state = 0
+	d.reps = [4]uint32{}
+	d.matchLen = 0
+}
+
+func (d *decoder) bittree(off int, numBits uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < numBits; i++ {
+		m = m<<1 | d.rc.bit(&d.probs[off+int(m)])
+	}
+	return m - 1<<numBits
+}
+
+func (d *decoder) reverseBittree(off int, numBits uint32) uint32 {
+	m := uint32(1)
+	var sym uint32
+	for i := uint32(0); i < numBits; i++ {
+		b := d.rc.bit(&d.probs[off+int(m)])
+		m = m<<1 | b
+		sym |= b << i
+	}
+	return sym
+}
+
+func (d *decoder) decodeLen(base int, posState uint32) uint32 {
+	if d.rc.bit(&d.probs[base+lenChoice]) == 0 {
+		return d.bittree(base+lenLow+int(posState<<3), 3)
+	}
+	if d.rc.bit(&d.probs[base+lenChoice2]) == 0 {
+		return 1<<3 + d.bittree(base+lenMid+int(posState<<3), 3)
+	}
+	return 1<<3 + 1<<3 + d.bittree(base+lenHigh, 8)
+}
+
+func (d *decoder) decodeDistance(length uint32) uint32 {
+	lenState := length
+	if lenState > numLenToPosStates-1 {
+		lenState = numLenToPosStates - 1
+	}
+
+	slot := d.bittree(probPosSlot+int(lenState<<numPosSlotBits), numPosSlotBits)
+	if slot < startPosModelIndex {
+		return slot
+	}
+
+	numDirect := slot>>1 - 1
+	dist := (2 | slot&1) << numDirect
+	if slot < endPosModelIndex {
+		return dist + d.reverseBittree(probSpecPos+int(dist)-int(slot)-1, numDirect)
+	}
+
+	dist += d.rc.direct(numDirect-numAlignBits) << numAlignBits
+	return dist + d.reverseBittree(probAlign, numAlignBits)
+}
+
+// decodeSymbol decodes a literal (which is written to out[0] and returns 1),
+// or a match (which sets matchLen and returns 0). limit is the maximum
+// number of bytes the symbol is allowed to produce.
+func (d *decoder) decodeSymbol(out []byte, limit int64) (int, error) {
+	posState := uint32(d.win.total) & d.pbMask
+	state := d.state
+
+	if d.rc.bit(&d.probs[probIsMatch+int(state<<numPosBitsMax+posState)]) == 0 {
+		var prevByte byte
+		if d.win.total > 0 {
+			prevByte = d.win.get(1)
+		}
+		litState := (uint32(d.win.total)&d.lpMask)<<uint(d.props.LC) | uint32(prevByte)>>uint(8-d.props.LC)
+		off := probLiteral + int(0x300*litState)
+
+		sym := uint32(1)
+		if state >= 7 {
+			if !d.win.has(d.reps[0] + 1) {
+				return 0, ErrCorrupt
+			}
+			matchByte := uint32(d.win.get(d.reps[0] + 1))
+			for sym < 0x100 {
+				matchBit := (matchByte >> 7) & 1
+				matchByte <<= 1
+				b := d.rc.bit(&d.probs[off+int((1+matchBit)<<8+sym)])
+				sym = sym<<1 | b
+				if matchBit != b {
+					break
+				}
+			}
+		}
+		for sym < 0x100 {
+			sym = sym<<1 | d.rc.bit(&d.probs[off+int(sym)])
+		}
+
+		switch {
+		case state < 4:
+			d.state = 0
+		case state < 10:
+			d.state = state - 3
+		default:
+			d.state = state - 6
+		}
+
+		if d.rc.err != nil {
+			return 0, d.rc.err
+		}
+
+		b := byte(sym)
+		d.win.put(b)
+		out[0] = b
+		return 1, nil
+	}
+
+	var length uint32
+	if d.rc.bit(&d.probs[probIsRep+int(state)]) == 0 {
+		// simple match
+		length = d.decodeLen(probLenCoder, posState)
+		if state < 7 {
+			d.state = 7
+		} else {
+			d.state = 10
+		}
+
+		dist := d.decodeDistance(length)
+		if d.rc.err != nil {
+			return 0, d.rc.err
+		}
+		if dist == eosDistance {
+			d.eos = true
+			return 0, nil
+		}
+		d.reps[3], d.reps[2], d.reps[1], d.reps[0] = d.reps[2], d.reps[1], d.reps[0], dist
+	} else {
+		// repeated match
+		if d.rc.bit(&d.probs[probIsRepG0+int(state)]) == 0 {
+			if d.rc.bit(&d.probs[probIsRep0Long+int(state<<numPosBitsMax+posState)]) == 0 {
+				// short rep: a single byte at distance rep0
+				if state < 7 {
+					d.state = 9
+				} else {
+					d.state = 11
+				}
+				if d.rc.err != nil {
+					return 0, d.rc.err
+				}
+				if !d.win.has(d.reps[0] + 1) {
+					return 0, ErrCorrupt
+				}
+				b := d.win.get(d.reps[0] + 1)
+				d.win.put(b)
+				out[0] = b
+				return 1, nil
+			}
+		} else {
+			var dist uint32
+			if d.rc.bit(&d.probs[probIsRepG1+int(state)]) == 0 {
+				dist = d.reps[1]
+			} else {
+				if d.rc.bit(&d.probs[probIsRepG2+int(state)]) == 0 {
+					dist = d.reps[2]
+				} else {
+					dist = d.reps[3]
+					d.reps[3] = d.reps[2]
+				}
+				d.reps[2] = d.reps[1]
+			}
+			d.reps[1] = d.reps[0]
+			d.reps[0] = dist
+		}
+
+		length = d.decodeLen(probRepLen, posState)
+		if state < 7 {
+			d.state = 8
+		} else {
+			d.state = 11
+		}
+	}
+
+	if d.rc.err != nil {
+		return 0, d.rc.err
+	}
+
+	length += matchMinLen
+	if !d.win.has(d.reps[0]+1) || int64(length) > limit {
+		return 0, ErrCorrupt
+	}
+	d.matchLen = int(length)
+	return 0, nil
+}
+
+// copyMatch copies as much of the current match as fits into out
+func (d *decoder) copyMatch(out []byte) int {
+	n := len(out)
+	if n > d.matchLen {
+		n = d.matchLen
+	}
+
+	dist := d.reps[0] + 1
+	for i := 0; i < n; i++ {
+		b := d.win.get(dist)
+		d.win.put(b)
+		out[i] = b
+	}
+	d.matchLen -= n
+	return n
+}
+
+func (d *decoder) checkpoint() *Checkpoint {
+	c := &Checkpoint{
+		Props:     d.props,
+		State:     d.state,
+		Reps:      d.reps,
+		Dict:      d.win.history(),
+		DictTotal: d.win.total,
+		Roffset:   d.rc.n,
+	}
+	if d.probs != nil {
+		c.HasProps = true
+		c.Probs = make([]uint16, len(d.probs))
+		copy(c.Probs, d.probs)
+	}
+	return c
+}
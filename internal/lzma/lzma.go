@@ -0,0 +1,161 @@
+// Package lzma implements LZMA and LZMA2 decompression, in a way that
+// lets consumers save the state of the decompressor and resume it later.
+//
+// LZMA streams can be checkpointed between any two symbols, whereas LZMA2
+// streams are checkpointed between chunks, where the range decoder is
+// not in use.
+package lzma
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ReadyToSaveError is returned by Read() when a SaverReader is ready to emit a checkpoint
+	ReadyToSaveError = errors.New("ready to save")
+	// NotOnBoundaryError is returned by Save() when a SaverReader wasn't ready to emit a checkpoint
+	NotOnBoundaryError = errors.New("asked to save, but not on boundary")
+	// ErrCorrupt is returned when the compressed data is invalid
+	ErrCorrupt = errors.New("lzma: corrupt input")
+)
+
+// Props are the literal context bits, literal position bits,
+// position bits, and dictionary size of an LZMA stream.
+type Props struct {
+	LC       int
+	LP       int
+	PB       int
+	DictSize uint32
+}
+
+const minDictSize = 4096
+
+// DecodeProps decodes the classic 5-byte LZMA properties header, as
+// found in .lzma files and in zip entries compressed with LZMA.
+func DecodeProps(b []byte) (Props, error) {
+	if len(b) < 5 {
+		return Props{}, ErrCorrupt
+	}
+
+	var p Props
+	err := p.setPropsByte(b[0])
+	if err != nil {
+		return Props{}, err
+	}
+
+	p.DictSize = uint32(b[1]) | uint32(b[2])<<8 | uint32(b[3])<<16 | uint32(b[4])<<24
+	if p.DictSize < minDictSize {
+		p.DictSize = minDictSize
+	}
+	return p, nil
+}
+
+func (p *Props) setPropsByte(d byte) error {
+	if d >= 9*5*5 {
+		return fmt.Errorf("lzma: invalid properties byte %#x", d)
+	}
+	p.LC = int(d % 9)
+	d /= 9
+	p.LP = int(d % 5)
+	p.PB = int(d / 5)
+	return nil
+}
+
+// Reader is what decompressors read from. They consume input one byte
+// at a time, so they never read past the end of the compressed data.
+type Reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// A SaverReader is a decompressor that can be asked to stop on
+// the next boundary, so that its state can be saved.
+type SaverReader interface {
+	io.Reader
+
+	// WantSave signals the decompressor that it should stop
+	// on the next boundary to allow the consumer to perform a checkpoint
+	WantSave()
+	// Save returns a checkpoint, it must only be called after Read
+	// returned ReadyToSaveError.
+	Save() (*Checkpoint, error)
+}
+
+// A Checkpoint allows resuming decompression from a certain point
+// in the compressed data stream
+type Checkpoint struct {
+	// LZMA2 is true if the checkpoint was made by an LZMA2 reader
+	LZMA2 bool
+
+	// Roffset is the offset into compressed data
+	Roffset int64
+	// Woffset is the offset into uncompressed data
+	Woffset int64
+	// Size is the uncompressed size of an LZMA stream, or -1 if unknown
+	Size int64
+
+	// Decoder state
+	HasProps bool
+	Props    Props
+	State    uint32
+	Reps     [4]uint32
+	Probs    []uint16
+
+	// Dict holds the last bytes of uncompressed output, up to the
+	// dictionary size. DictTotal is how many bytes were written since
+	// the last dictionary reset.
+	Dict      []byte
+	DictTotal int64
+
+	// Range decoder state, LZMA only
+	Range uint32
+	Code  uint32
+
+	// Chunk sequencing state, LZMA2 only
+	NeedDictReset bool
+	NeedProps     bool
+}
+
+// Resume starts decompressing again from a given checkpoint
+func (c *Checkpoint) Resume(r Reader) (SaverReader, error) {
+	d := &decoder{props: c.Props}
+	d.rc.br = r
+	d.rc.n = c.Roffset
+	d.win.reset(int(c.Props.DictSize))
+	d.win.restore(c.Dict, c.DictTotal)
+
+	if c.HasProps {
+		d.setProps(c.Props)
+		if len(c.Probs) != len(d.probs) {
+			return nil, errors.New("lzma: checkpoint has wrong number of probabilities")
+		}
+		copy(d.probs, c.Probs)
+		d.state = c.State
+		d.reps = c.Reps
+	}
+
+	if c.LZMA2 {
+		lr := &lzma2Reader{
+			d:             d,
+			woffset:       c.Woffset,
+			needDictReset: c.NeedDictReset,
+			needProps:     c.NeedProps,
+		}
+		return lr, nil
+	}
+
+	if !c.HasProps {
+		return nil, errors.New("lzma: checkpoint is missing properties")
+	}
+	d.rc.rng = c.Range
+	d.rc.code = c.Code
+
+	lr := &lzmaReader{
+		d:       d,
+		size:    c.Size,
+		woffset: c.Woffset,
+	}
+	return lr, nil
+}
@@ -0,0 +1,283 @@
+package lzma_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior/internal/lzma"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func must(t *testing.T, err error) {
+	assert.NoError(t, err)
+	if err != nil {
+		t.FailNow()
+	}
+}
+
+// readFixture reads a file made by internal/testdata/mkfixtures.go
+func readFixture(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	must(t, err)
+	return data
+}
+
+func readInput(t *testing.T) []byte {
+	data, err := os.ReadFile(filepath.Join("..", "testdata", "input.bin"))
+	must(t, err)
+	return data
+}
+
+type newReaderFunc func(r lzma.Reader) lzma.SaverReader
+
+// alone returns the stream of a .lzma file, and a function that makes an
+// LZMA reader for it, whose size is the one in the header, unless size
+// isn't nil
+func alone(t *testing.T, data []byte, size *int64) ([]byte, newReaderFunc) {
+	props, err := lzma.DecodeProps(data[:5])
+	must(t, err)
+	headerSize := int64(binary.LittleEndian.Uint64(data[5:13]))
+	if size != nil {
+		headerSize = *size
+	}
+	return data[13:], func(r lzma.Reader) lzma.SaverReader {
+		return lzma.NewReader(r, props, headerSize)
+	}
+}
+
+func lzma2(dictSize uint32) newReaderFunc {
+	return func(r lzma.Reader) lzma.SaverReader {
+		return lzma.NewReader2(r, dictSize)
+	}
+}
+
+// decode decompresses data. If saveEvery isn't zero, it asks for a
+// checkpoint every saveEvery reads that made progress, and resumes from
+// it with a new decompressor, which reads data from the checkpoint's
+// offset. It returns what was decompressed, even on error, and how many
+// checkpoints were made.
+func decode(data []byte, newReader newReaderFunc, saveEvery int) ([]byte, int, error) {
+	sr := newReader(bytes.NewReader(data))
+	out := new(bytes.Buffer)
+	buf := make([]byte, 4096)
+	numCheckpoints := 0
+	reads := 0
+
+	for {
+		if saveEvery > 0 && reads == saveEvery {
+			reads = 0
+			sr.WantSave()
+		}
+
+		n, err := sr.Read(buf)
+		if n > 0 {
+			reads++
+		}
+		out.Write(buf[:n])
+		switch err {
+		case nil:
+			continue
+		case io.EOF:
+			return out.Bytes(), numCheckpoints, nil
+		case lzma.ReadyToSaveError:
+			// keep going below
+		default:
+			return out.Bytes(), numCheckpoints, err
+		}
+
+		c, err := sr.Save()
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+		numCheckpoints++
+		if c.Woffset != int64(out.Len()) {
+			msg := "checkpoint is at %d, but %d bytes were decompressed"
+			return out.Bytes(), numCheckpoints, errors.Errorf(msg, c.Woffset, out.Len())
+		}
+
+		// checkpoints are stored, so make sure they survive that
+		encoded := new(bytes.Buffer)
+		err = gob.NewEncoder(encoded).Encode(c)
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+		c = &lzma.Checkpoint{}
+		err = gob.NewDecoder(encoded).Decode(c)
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+
+		sr, err = c.Resume(bytes.NewReader(data[c.Roffset:]))
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+	}
+}
+
+// uncompressedChunks returns an LZMA2 stream made of uncompressed
+// chunks only, the first of which resets the dictionary
+func uncompressedChunks(chunks ...[]byte) []byte {
+	buf := new(bytes.Buffer)
+	for i, chunk := range chunks {
+		control := byte(0x02)
+		if i == 0 {
+			control = 0x01
+		}
+		buf.WriteByte(control)
+		binary.Write(buf, binary.BigEndian, uint16(len(chunk)-1))
+		buf.Write(chunk)
+	}
+	buf.WriteByte(0x00)
+	return buf.Bytes()
+}
+
+func Test_Fixtures(t *testing.T) {
+	input := readInput(t)
+	inputSize := int64(len(input))
+
+	type test struct {
+		name      string
+		data      []byte
+		newReader newReaderFunc
+		output    []byte
+	}
+	var tests []test
+	addAlone := func(name string, fixture string, size *int64, output []byte) {
+		data, newReader := alone(t, readFixture(t, fixture), size)
+		tests = append(tests, test{name, data, newReader, output})
+	}
+
+	// the xz tool doesn't write the size, and always ends with a marker
+	addAlone("default.lzma", "default.lzma", nil, input)
+	// which isn't read if it's given
+	addAlone("default.lzma/known size", "default.lzma", &inputSize, input)
+	addAlone("props.lzma", "props.lzma", nil, input)
+	addAlone("empty.lzma", "empty.lzma", nil, nil)
+
+	tests = append(tests,
+		test{"dict64k.lzma2", readFixture(t, "dict64k.lzma2"), lzma2(64 * 1024), input},
+		test{"uncompressed chunks", uncompressedChunks(input[:4096], input[4096:5000]), lzma2(4096), input[:5000]},
+		test{"empty lzma2", []byte{0x00}, lzma2(4096), nil},
+	)
+
+	for _, tt := range tests {
+		for _, saveEvery := range []int{0, 1, 7} {
+			name := tt.name
+			if saveEvery > 0 {
+				name += "/resumed"
+			}
+			t.Run(name, func(t *testing.T) {
+				out, numCheckpoints, err := decode(tt.data, tt.newReader, saveEvery)
+				must(t, err)
+				assert.Equal(t, len(tt.output), len(out))
+				assert.True(t, bytes.Equal(tt.output, out), "output differs")
+				if saveEvery == 1 && len(tt.output) > 4096 {
+					assert.NotZero(t, numCheckpoints)
+				}
+			})
+		}
+	}
+}
+
+func Test_Props(t *testing.T) {
+	props, err := lzma.DecodeProps([]byte{0x5d, 0x00, 0x00, 0x01, 0x00})
+	must(t, err)
+	assert.EqualValues(t, lzma.Props{LC: 3, LP: 0, PB: 2, DictSize: 64 * 1024}, props)
+
+	// tiny dictionaries are rounded up
+	props, err = lzma.DecodeProps([]byte{0x5d, 0x01, 0x00, 0x00, 0x00})
+	must(t, err)
+	assert.EqualValues(t, 4096, props.DictSize)
+
+	_, err = lzma.DecodeProps([]byte{9 * 5 * 5, 0x00, 0x00, 0x01, 0x00})
+	assert.Error(t, err)
+	_, err = lzma.DecodeProps([]byte{0x5d})
+	assert.True(t, errors.Is(err, lzma.ErrCorrupt))
+
+	dictSize, err := lzma.DictSize(0)
+	must(t, err)
+	assert.EqualValues(t, 4096, dictSize)
+	dictSize, err = lzma.DictSize(19)
+	must(t, err)
+	assert.EqualValues(t, 3*1024*1024, dictSize)
+	dictSize, err = lzma.DictSize(40)
+	must(t, err)
+	assert.EqualValues(t, uint32(0xFFFFFFFF), dictSize)
+	_, err = lzma.DictSize(41)
+	assert.True(t, errors.Is(err, lzma.ErrCorrupt))
+}
+
+func Test_Corrupt(t *testing.T) {
+	input := readInput(t)
+	defaultData, defaultReader := alone(t, readFixture(t, "default.lzma"), nil)
+	tooLarge := int64(len(input) + 1)
+	_, tooLargeReader := alone(t, readFixture(t, "default.lzma"), &tooLarge)
+	dict64k := readFixture(t, "dict64k.lzma2")
+
+	withByte := func(data []byte, offset int, b byte) []byte {
+		data = append([]byte(nil), data...)
+		data[offset] = b
+		return data
+	}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		newReader newReaderFunc
+		err       error
+	}{
+		{"truncated lzma", defaultData[:len(defaultData)/2], defaultReader, io.ErrUnexpectedEOF},
+		{"truncated end marker", defaultData[:len(defaultData)-1], defaultReader, io.ErrUnexpectedEOF},
+		{"end marker before size", defaultData, tooLargeReader, lzma.ErrCorrupt},
+		{"bad first byte", withByte(defaultData, 0, 0x01), defaultReader, lzma.ErrCorrupt},
+		{"truncated lzma2", dict64k[:len(dict64k)/2], lzma2(64 * 1024), io.ErrUnexpectedEOF},
+		{"missing end of lzma2", dict64k[:len(dict64k)-1], lzma2(64 * 1024), io.ErrUnexpectedEOF},
+		{"invalid control", []byte{0x03}, lzma2(4096), lzma.ErrCorrupt},
+		{"no dictionary reset", uncompressedChunks(input[:10], input[10:20])[3+10:], lzma2(4096), lzma.ErrCorrupt},
+		{"no props", withByte(dict64k, 0, 0x80|dict64k[0]&0x1f), lzma2(64 * 1024), lzma.ErrCorrupt},
+		{"lc+lp too large", withByte(dict64k, 5, 4+9*1), lzma2(64 * 1024), lzma.ErrCorrupt},
+		{"wrong packed size", withByte(dict64k, 4, dict64k[4]-1), lzma2(64 * 1024), lzma.ErrCorrupt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decode(tt.data, tt.newReader, 0)
+			assert.True(t, errors.Is(err, tt.err), "expected %v, got %v", tt.err, err)
+		})
+	}
+}
+
+func Test_CorruptData(t *testing.T) {
+	input := readInput(t)
+
+	// there's no checksum, so corrupt data may decode to something
+	// else, but it must never make the decoder panic or go on forever
+	tests := []struct {
+		name      string
+		data      []byte
+		newReader newReaderFunc
+	}{
+		{"lzma", nil, nil},
+		{"lzma2", readFixture(t, "dict64k.lzma2"), lzma2(64 * 1024)},
+	}
+	tests[0].data, tests[0].newReader = alone(t, readFixture(t, "default.lzma"), nil)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for offset := 1; offset < len(tt.data); offset += 499 {
+				corrupt := append([]byte(nil), tt.data...)
+				corrupt[offset] ^= 0x55
+				out, _, err := decode(corrupt, tt.newReader, 0)
+				if err == nil && bytes.Equal(input, out) {
+					t.Errorf("corruption at %d went unnoticed", offset)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package lzma
+
+import (
+	"io"
+	"math"
+)
+
+type lzmaReader struct {
+	d *decoder
+	// size is the expected uncompressed size, or -1 if the
+	// stream is terminated by an end marker
+	size    int64
+	woffset int64
+
+	wantSave bool
+	err      error
+}
+
+var _ SaverReader = (*lzmaReader)(nil)
+
+// NewReader returns a reader that decompresses a raw LZMA stream (without
+// the properties header). If size is negative, the stream must end with
+// an end-of-stream marker.
+func NewReader(r Reader, props Props, size int64) SaverReader {
+	d := &decoder{}
+	d.rc.br = r
+	d.win.reset(int(props.DictSize))
+	d.setProps(props)
+	d.resetState()
+	d.rc.init()
+
+	lr := &lzmaReader{
+		d:    d,
+		size: size,
+	}
+	if d.rc.err != nil {
+		lr.err = d.rc.err
+	}
+	return lr
+}
+
+func (lr *lzmaReader) Read(p []byte) (int, error) {
+	d := lr.d
+	n := 0
+
+	for n < len(p) {
+		if lr.err != nil {
+			return n, lr.err
+		}
+
+		if d.matchLen > 0 {
+			m := d.copyMatch(p[n:])
+			n += m
+			lr.woffset += int64(m)
+			continue
+		}
+
+		limit := int64(math.MaxInt64)
+		if lr.size >= 0 {
+			limit = lr.size - lr.woffset
+			if limit == 0 {
+				lr.err = io.EOF
+				continue
+			}
+		}
+
+		if lr.wantSave {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, ReadyToSaveError
+		}
+
+		m, err := d.decodeSymbol(p[n:], limit)
+		if err != nil {
+			lr.err = err
+			continue
+		}
+		if d.eos {
+			if lr.size >= 0 {
+				// the end marker is only legal if we've reached the expected size,
+				// and we never decode past it.
+				lr.err = ErrCorrupt
+			} else {
+				lr.err = io.EOF
+			}
+			continue
+		}
+		n += m
+		lr.woffset += int64(m)
+	}
+
+	return n, nil
+}
+
+// WantSave signals the decompressor that it should stop
+// on the next symbol boundary to allow the consumer to perform a checkpoint
+func (lr *lzmaReader) WantSave() {
+	lr.wantSave = true
+}
+
+func (lr *lzmaReader) Save() (*Checkpoint, error) {
+	lr.wantSave = false
+
+	d := lr.d
+	if d.matchLen > 0 || lr.err != nil {
+		return nil, NotOnBoundaryError
+	}
+
+	c := d.checkpoint()
+	c.Woffset = lr.woffset
+	c.Size = lr.size
+	c.Range = d.rc.rng
+	c.Code = d.rc.code
+	return c, nil
+}
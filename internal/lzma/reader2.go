@@ -0,0 +1,232 @@
+package lzma
+
+import (
+	"io"
+)
+
+type lzma2Stage int
+
+const (
+	lzma2StageControl lzma2Stage = iota
+	lzma2StageLZMA
+	lzma2StageCopy
+)
+
+type lzma2Reader struct {
+	d       *decoder
+	woffset int64
+
+	stage lzma2Stage
+	// remaining uncompressed bytes in the current chunk
+	unpacked int64
+	// compressed size of the current LZMA chunk, and where it started
+	packed      int64
+	packedStart int64
+
+	needDictReset bool
+	needProps     bool
+
+	wantSave bool
+	err      error
+}
+
+var _ SaverReader = (*lzma2Reader)(nil)
+
+// NewReader2 returns a reader that decompresses a raw LZMA2 stream,
+// as found inside .xz blocks.
+func NewReader2(r Reader, dictSize uint32) SaverReader {
+	d := &decoder{}
+	d.rc.br = r
+	d.props.DictSize = dictSize
+	d.win.reset(int(dictSize))
+
+	return &lzma2Reader{
+		d:             d,
+		needDictReset: true,
+		needProps:     true,
+	}
+}
+
+// DictSize decodes the one-byte dictionary size used in
+// LZMA2 filter properties.
+func DictSize(b byte) (uint32, error) {
+	if b > 40 {
+		return 0, ErrCorrupt
+	}
+	if b == 40 {
+		return 0xFFFFFFFF, nil
+	}
+	return (2 | uint32(b)&1) << (b/2 + 11), nil
+}
+
+func (lr *lzma2Reader) Read(p []byte) (int, error) {
+	d := lr.d
+	n := 0
+
+	for n < len(p) {
+		if lr.err != nil {
+			return n, lr.err
+		}
+
+		switch lr.stage {
+		case lzma2StageControl:
+			if lr.wantSave {
+				if n > 0 {
+					return n, nil
+				}
+				return 0, ReadyToSaveError
+			}
+			lr.err = lr.readControl()
+		case lzma2StageCopy:
+			for n < len(p) && lr.unpacked > 0 {
+				b := d.rc.readByte()
+				if d.rc.err != nil {
+					lr.err = d.rc.err
+					break
+				}
+				d.win.put(b)
+				p[n] = b
+				n++
+				lr.woffset++
+				lr.unpacked--
+			}
+			if lr.unpacked == 0 {
+				lr.stage = lzma2StageControl
+			}
+		case lzma2StageLZMA:
+			if d.matchLen > 0 {
+				m := d.copyMatch(p[n:])
+				n += m
+				lr.woffset += int64(m)
+				continue
+			}
+
+			if lr.unpacked == 0 {
+				d.rc.normalize()
+				if d.rc.err != nil {
+					lr.err = d.rc.err
+					continue
+				}
+				if d.rc.n-lr.packedStart != lr.packed || d.rc.code != 0 {
+					lr.err = ErrCorrupt
+					continue
+				}
+				lr.stage = lzma2StageControl
+				continue
+			}
+
+			m, err := d.decodeSymbol(p[n:], lr.unpacked)
+			if err != nil {
+				lr.err = err
+				continue
+			}
+			if d.eos {
+				// end markers are not allowed in LZMA2
+				lr.err = ErrCorrupt
+				continue
+			}
+			if m == 0 {
+				lr.unpacked -= int64(d.matchLen)
+			} else {
+				lr.unpacked--
+			}
+			n += m
+			lr.woffset += int64(m)
+		}
+	}
+
+	return n, nil
+}
+
+func (lr *lzma2Reader) readControl() error {
+	d := lr.d
+	rc := &d.rc
+
+	control := rc.readByte()
+	if rc.err != nil {
+		return rc.err
+	}
+
+	if control == 0x00 {
+		return io.EOF
+	}
+
+	if control >= 0xE0 || control == 0x01 {
+		lr.needProps = true
+		lr.needDictReset = false
+		d.win.reset(int(d.props.DictSize))
+	} else if lr.needDictReset {
+		return ErrCorrupt
+	}
+
+	if control >= 0x80 {
+		lr.unpacked = int64(control&0x1F)<<16 + int64(rc.readByte())<<8 + int64(rc.readByte()) + 1
+		lr.packed = int64(rc.readByte())<<8 + int64(rc.readByte()) + 1
+		if rc.err != nil {
+			return rc.err
+		}
+
+		if control >= 0xC0 {
+			propsByte := rc.readByte()
+			if rc.err != nil {
+				return rc.err
+			}
+			props := d.props
+			err := props.setPropsByte(propsByte)
+			if err != nil {
+				return err
+			}
+			if props.LC+props.LP > 4 {
+				return ErrCorrupt
+			}
+			d.setProps(props)
+			lr.needProps = false
+			d.resetState()
+		} else if lr.needProps {
+			return ErrCorrupt
+		} else if control >= 0xA0 {
+			d.resetState()
+		}
+
+		lr.packedStart = rc.n
+		rc.init()
+		if rc.err != nil {
+			return rc.err
+		}
+		lr.stage = lzma2StageLZMA
+		return nil
+	}
+
+	if control > 0x02 {
+		return ErrCorrupt
+	}
+
+	lr.unpacked = int64(rc.readByte())<<8 + int64(rc.readByte()) + 1
+	if rc.err != nil {
+		return rc.err
+	}
+	lr.stage = lzma2StageCopy
+	return nil
+}
+
+// WantSave signals the decompressor that it should stop
+// on the next chunk boundary to allow the consumer to perform a checkpoint
+func (lr *lzma2Reader) WantSave() {
+	lr.wantSave = true
+}
+
+func (lr *lzma2Reader) Save() (*Checkpoint, error) {
+	lr.wantSave = false
+
+	if lr.stage != lzma2StageControl || lr.err != nil {
+		return nil, NotOnBoundaryError
+	}
+
+	c := lr.d.checkpoint()
+	c.LZMA2 = true
+	c.Woffset = lr.woffset
+	c.Size = -1
+	c.NeedDictReset = lr.needDictReset
+	c.NeedProps = lr.needProps
+	return c, nil
+}
@@ -0,0 +1,85 @@
+package lzma
+
+// window is the LZ77 sliding dictionary. It grows as data is written
+// until it reaches the dictionary size, after which it wraps around.
+type window struct {
+	buf  []byte
+	size int
+	// pos is the next write index, only meaningful when full
+	pos  int
+	full bool
+	// total is how many bytes were written since the last reset
+	total int64
+}
+
+func (w *window) reset(size int) {
+	if size < minDictSize {
+		size = minDictSize
+	}
+	w.size = size
+	w.buf = w.buf[:0]
+	w.pos = 0
+	w.full = false
+	w.total = 0
+}
+
+func (w *window) put(b byte) {
+	w.total++
+	if !w.full {
+		w.buf = append(w.buf, b)
+		if len(w.buf) == w.size {
+			w.full = true
+			w.pos = 0
+		}
+		return
+	}
+
+	w.buf[w.pos] = b
+	w.pos++
+	if w.pos == w.size {
+		w.pos = 0
+	}
+}
+
+// get returns the byte that was written dist bytes ago (dist >= 1).
+// Callers must check that dist is within range with has()
+func (w *window) get(dist uint32) byte {
+	if !w.full {
+		return w.buf[len(w.buf)-int(dist)]
+	}
+
+	i := w.pos - int(dist)
+	if i < 0 {
+		i += w.size
+	}
+	return w.buf[i]
+}
+
+// has returns true if a byte written dist bytes ago is still available
+func (w *window) has(dist uint32) bool {
+	return int64(dist) <= w.total && int(dist) <= w.size
+}
+
+// history returns a copy of the window contents, oldest byte first
+func (w *window) history() []byte {
+	res := make([]byte, len(w.buf))
+	if !w.full {
+		copy(res, w.buf)
+		return res
+	}
+
+	n := copy(res, w.buf[w.pos:])
+	copy(res[n:], w.buf[:w.pos])
+	return res
+}
+
+// restore refills the window from the result of history()
+func (w *window) restore(hist []byte, total int64) {
+	if len(hist) > w.size {
+		hist = hist[len(hist)-w.size:]
+	}
+	w.buf = append(w.buf[:0], hist...)
+	w.pos = 0
+	w.full = len(w.buf) == w.size
+	w.total = total
+}
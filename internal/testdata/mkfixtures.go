@@ -0,0 +1,163 @@
+//go:build ignore
+
+// mkfixtures writes input.bin, and the fixtures the decoders of the
+// internal packages are tested with, by compressing it with the reference
+// command-line tools, which need to be in $PATH. Their output depends on
+// their version, which is why fixtures are checked in rather than made
+// when testing: they were made with xz 5.6.4, zstd 1.5.6 and lz4 1.9.4.
+//
+// Usage: go run mkfixtures.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// input is a bit of everything: text, which compresses well, random
+// bytes, which don't, and a run of zeroes
+func input() []byte {
+	words := []string{"savior", "resumes", "extraction", "of", "archives", "from", "checkpoints"}
+
+	buf := new(bytes.Buffer)
+	text := func(size int) {
+		for i := 0; buf.Len() < size; i++ {
+			fmt.Fprintf(buf, "%d: %s %s %s\n", i, words[i%len(words)], words[(i/7)%len(words)], words[(i/49)%len(words)])
+		}
+	}
+
+	text(48 * 1024)
+	buf.Write(random())
+	buf.Write(make([]byte, 16*1024))
+	text(96 * 1024)
+	return buf.Bytes()
+}
+
+// random returns 8KiB of random bytes, the same every time
+func random() []byte {
+	random := make([]byte, 8*1024)
+	rand.New(rand.NewSource(0x5a)).Read(random)
+	return random
+}
+
+// skippable returns a skippable frame, as found in zstd and lz4 streams
+func skippable(magic uint32, payload []byte) []byte {
+	frame := make([]byte, 8, 8+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:], magic)
+	binary.LittleEndian.PutUint32(frame[4:], uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+// compress runs a command with data as its standard input,
+// and writes its standard output to path
+func compress(data []byte, path string, name string, args ...string) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		log.Fatalf("%s %v: %v", name, args, err)
+	}
+	write(path, out)
+}
+
+func write(path string, data []byte) {
+	err := os.WriteFile(path, data, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %s (%d bytes)", path, len(data))
+}
+
+func read(path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return data
+}
+
+func main() {
+	data := input()
+	write("input.bin", data)
+
+	xz := func(name string) string {
+		return filepath.Join("..", "xz", "testdata", name)
+	}
+	// several blocks, one of which holds the random bytes,
+	// and is stored in an uncompressed LZMA2 chunk
+	for _, check := range []string{"none", "crc32", "crc64", "sha256"} {
+		compress(data, xz("check-"+check+".xz"), "xz", "-c", "--check="+check, "--block-size=16KiB")
+	}
+	compress(nil, xz("empty.xz"), "xz", "-c")
+	// two streams, with stream padding in between
+	multi := append([]byte(nil), read(xz("check-crc32.xz"))...)
+	multi = append(multi, 0, 0, 0, 0)
+	multi = append(multi, read(xz("check-sha256.xz"))...)
+	write(xz("multistream.xz"), multi)
+
+	lzma := func(name string) string {
+		return filepath.Join("..", "lzma", "testdata", name)
+	}
+	// .lzma files have a 13-byte header: properties, then the
+	// uncompressed size, unknown here, so the stream has an end marker
+	compress(data, lzma("default.lzma"), "xz", "-c", "--format=lzma")
+	compress(data, lzma("props.lzma"), "xz", "-c", "--format=lzma", "--lzma1=preset=6,lc=0,lp=2,pb=0")
+	compress(nil, lzma("empty.lzma"), "xz", "-c", "--format=lzma")
+	// raw LZMA2, with a 64KiB dictionary, which means the text
+	// after the random bytes and zeroes can't refer to that before
+	compress(data, lzma("dict64k.lzma2"), "xz", "-c", "--format=raw", "--lzma2=preset=6,dict=64KiB")
+
+	zstd := func(name string) string {
+		return filepath.Join("..", "zstd", "testdata", name)
+	}
+	// given a file, zstd writes the content size in the frame header,
+	// and the window is the whole content
+	compress(nil, zstd("default.zst"), "zstd", "-c", "-19", "input.bin")
+	// from standard input, it doesn't know it
+	compress(data, zstd("no-check.zst"), "zstd", "-c", "--no-check")
+	// several compressed blocks, some of which reuse the huffman
+	// table of the one before for their literals
+	compress(nil, zstd("blocks.zst"), "zstd", "-c", "--target-compressed-block-size=4096", "input.bin")
+	// the smallest window there is, so most matches are out of reach
+	compress(nil, zstd("window1k.zst"), "zstd", "-c", "--zstd=wlog=10", "input.bin")
+	// RLE blocks
+	compress(make([]byte, 300*1000), zstd("zeroes.zst"), "zstd", "-c")
+	// a raw block
+	compress(random(), zstd("random.zst"), "zstd", "-c")
+	compress(nil, zstd("empty.zst"), "zstd", "-c")
+	// two frames, with skippable frames before and between them
+	multi = skippable(0x184D2A50, []byte("savior!!"))
+	multi = append(multi, read(zstd("default.zst"))...)
+	multi = append(multi, skippable(0x184D2A5F, nil)...)
+	multi = append(multi, read(zstd("no-check.zst"))...)
+	write(zstd("multiframe.zst"), multi)
+
+	lz4 := func(name string) string {
+		return filepath.Join("..", "lz4", "testdata", name)
+	}
+	// independent 4MiB blocks, and a content checksum
+	compress(nil, lz4("default.lz4"), "lz4", "-c", "input.bin")
+	// 64KiB blocks, each of which can refer to the one before
+	compress(nil, lz4("linked.lz4"), "lz4", "-c", "-B4", "-BD", "input.bin")
+	// block checksums instead of a content checksum
+	compress(nil, lz4("block-checksum.lz4"), "lz4", "-c", "-B4", "-BX", "--no-frame-crc", "input.bin")
+	compress(nil, lz4("content-size.lz4"), "lz4", "-c", "--content-size", "input.bin")
+	// an uncompressed block
+	compress(random(), lz4("random.lz4"), "lz4", "-c")
+	compress(nil, lz4("empty.lz4"), "lz4", "-c")
+	// which isn't supported
+	compress(nil, lz4("legacy.lz4"), "lz4", "-c", "-l", "input.bin")
+	// two frames, with skippable frames before and between them
+	multi = skippable(0x184D2A50, []byte("savior!!"))
+	multi = append(multi, read(lz4("default.lz4"))...)
+	multi = append(multi, skippable(0x184D2A5F, nil)...)
+	multi = append(multi, read(lz4("linked.lz4"))...)
+	write(lz4("multiframe.lz4"), multi)
+}
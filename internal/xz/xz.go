@@ -0,0 +1,732 @@
+// Package xz implements decompression of the .xz container format,
+// in a way that lets consumers save the state of the decompressor and
+// resume it later.
+//
+// Only the LZMA2 filter is supported, which is what virtually all .xz
+// files use. Checkpoints can be made between blocks, and between LZMA2
+// chunks inside of a block.
+package xz
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+
+	"github.com/itchio/savior/internal/lzma"
+)
+
+var (
+	// ReadyToSaveError is returned by Read() when a SaverReader is ready to emit a checkpoint
+	ReadyToSaveError = lzma.ReadyToSaveError
+	// NotOnBoundaryError is returned by Save() when a SaverReader wasn't ready to emit a checkpoint
+	NotOnBoundaryError = lzma.NotOnBoundaryError
+	// ErrFormat is returned when the input is not a valid .xz stream
+	ErrFormat = errors.New("xz: invalid format")
+	// ErrChecksum is returned when a block's check doesn't match its contents
+	ErrChecksum = errors.New("xz: checksum error")
+)
+
+var headerMagic = []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+var footerMagic = []byte{'Y', 'Z'}
+
+const (
+	checkNone   = 0x00
+	checkCRC32  = 0x01
+	checkCRC64  = 0x04
+	checkSHA256 = 0x0A
+
+	filterLZMA2 = 0x21
+)
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// checkSize returns the size of a block check for a given check type,
+// including reserved ones, as defined in the .xz file format spec.
+func checkSize(checkType byte) int {
+	if checkType == 0 {
+		return 0
+	}
+	return 4 << ((checkType - 1) / 3)
+}
+
+func newCheck(checkType byte) hash.Hash {
+	switch checkType {
+	case checkCRC32:
+		return crc32.NewIEEE()
+	case checkCRC64:
+		return crc64.New(crc64Table)
+	case checkSHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+type stage int
+
+const (
+	stageStreamHeader stage = iota
+	stageBlockHeader
+	stageBlockData
+	stageIndex
+	stageStreamPadding
+	stageDone
+)
+
+// A SaverReader is a decompressor that can be asked to stop on
+// the next boundary, so that its state can be saved.
+type SaverReader interface {
+	io.Reader
+
+	// WantSave signals the decompressor that it should stop
+	// on the next boundary to allow the consumer to perform a checkpoint
+	WantSave()
+	// Save returns a checkpoint, it must only be called after Read
+	// returned ReadyToSaveError.
+	Save() (*Checkpoint, error)
+}
+
+// A Checkpoint allows resuming decompression from a block boundary or
+// an LZMA2 chunk boundary.
+type Checkpoint struct {
+	// Roffset is the offset into compressed data
+	Roffset int64
+	// Woffset is the offset into uncompressed data
+	Woffset int64
+
+	Stage     int
+	CheckType byte
+
+	// Current block state
+	BlockHeaderSize        int64
+	BlockCompressedSize    int64
+	BlockUncompressedSize  int64
+	BlockCompressedStart   int64
+	BlockUncompressedStart int64
+	CheckState             []byte
+	LZMA2Checkpoint        *lzma.Checkpoint
+
+	// Index state for the current stream, used to validate the index
+	NumRecords         int64
+	UnpaddedSum        int64
+	UncompressedSum    int64
+	RecordsHash        uint32
+	StreamIndexStarted bool
+}
+
+type countingReader struct {
+	r lzma.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return b, err
+}
+
+type saverReader struct {
+	cr      *countingReader
+	woffset int64
+	stage   stage
+
+	checkType byte
+	check     hash.Hash
+
+	blockHeaderSize        int64
+	blockCompressedSize    int64
+	blockUncompressedSize  int64
+	blockCompressedStart   int64
+	blockUncompressedStart int64
+	lr                     lzma.SaverReader
+
+	// running index of the current stream
+	numRecords      int64
+	unpaddedSum     int64
+	uncompressedSum int64
+	recordsHash     uint32
+
+	wantSave bool
+	err      error
+}
+
+var _ SaverReader = (*saverReader)(nil)
+
+// NewSaverReader returns a reader that decompresses an .xz stream, or
+// several concatenated ones.
+func NewSaverReader(r lzma.Reader) SaverReader {
+	return &saverReader{
+		cr: &countingReader{r: r},
+	}
+}
+
+func (sr *saverReader) Read(p []byte) (int, error) {
+	for {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+
+		switch sr.stage {
+		case stageStreamHeader:
+			sr.err = sr.readStreamHeader()
+		case stageBlockHeader:
+			if sr.wantSave {
+				return 0, ReadyToSaveError
+			}
+			sr.err = sr.readBlockHeader()
+		case stageBlockData:
+			if len(p) == 0 {
+				return 0, nil
+			}
+			n, err := sr.lr.Read(p)
+			if n > 0 {
+				sr.woffset += int64(n)
+				if sr.check != nil {
+					sr.check.Write(p[:n])
+				}
+			}
+			if err == lzma.ReadyToSaveError {
+				return n, ReadyToSaveError
+			}
+			if err == io.EOF {
+				err = sr.finishBlock()
+				if err != nil {
+					sr.err = err
+				}
+				if n > 0 {
+					return n, nil
+				}
+				continue
+			}
+			if err != nil {
+				if err == lzma.ErrCorrupt {
+					err = ErrFormat
+				}
+				sr.err = err
+			}
+			if n > 0 {
+				return n, nil
+			}
+		case stageIndex:
+			sr.err = sr.readIndexAndFooter()
+		case stageStreamPadding:
+			sr.err = sr.readStreamPadding()
+		case stageDone:
+			return 0, io.EOF
+		}
+	}
+}
+
+func (sr *saverReader) readFull(buf []byte) error {
+	_, err := io.ReadFull(sr.cr, buf)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (sr *saverReader) readStreamHeader() error {
+	var buf [12]byte
+	err := sr.readFull(buf[:])
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(buf[:6], headerMagic) {
+		return ErrFormat
+	}
+	if crc32.ChecksumIEEE(buf[6:8]) != binary.LittleEndian.Uint32(buf[8:12]) {
+		return ErrFormat
+	}
+	if buf[6] != 0 || buf[7] > 0x0F {
+		return fmt.Errorf("xz: unsupported stream flags %#x %#x", buf[6], buf[7])
+	}
+
+	sr.checkType = buf[7]
+	sr.numRecords = 0
+	sr.unpaddedSum = 0
+	sr.uncompressedSum = 0
+	sr.recordsHash = 0
+	sr.stage = stageBlockHeader
+	return nil
+}
+
+func (sr *saverReader) readBlockHeader() error {
+	start := sr.cr.n
+	sizeByte, err := sr.cr.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	if sizeByte == 0 {
+		// that's the index indicator
+		sr.stage = stageIndex
+		return nil
+	}
+
+	headerSize := (int(sizeByte) + 1) * 4
+	buf := make([]byte, headerSize)
+	buf[0] = sizeByte
+	err = sr.readFull(buf[1:])
+	if err != nil {
+		return err
+	}
+
+	if crc32.ChecksumIEEE(buf[:headerSize-4]) != binary.LittleEndian.Uint32(buf[headerSize-4:]) {
+		return ErrFormat
+	}
+
+	flags := buf[1]
+	if flags&0x3C != 0 {
+		return ErrFormat
+	}
+	numFilters := int(flags&0x03) + 1
+
+	hr := bytes.NewReader(buf[2 : headerSize-4])
+
+	sr.blockCompressedSize = -1
+	if flags&0x40 != 0 {
+		sr.blockCompressedSize, err = readVLI(hr)
+		if err != nil {
+			return err
+		}
+	}
+
+	sr.blockUncompressedSize = -1
+	if flags&0x80 != 0 {
+		sr.blockUncompressedSize, err = readVLI(hr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if numFilters != 1 {
+		return fmt.Errorf("xz: unsupported filter chain (%d filters)", numFilters)
+	}
+
+	filterID, err := readVLI(hr)
+	if err != nil {
+		return err
+	}
+	if filterID != filterLZMA2 {
+		return fmt.Errorf("xz: unsupported filter %#x", filterID)
+	}
+
+	propsSize, err := readVLI(hr)
+	if err != nil {
+		return err
+	}
+	if propsSize != 1 {
+		return ErrFormat
+	}
+
+	propsByte, err := hr.ReadByte()
+	if err != nil {
+		return ErrFormat
+	}
+	dictSize, err := lzma.DictSize(propsByte)
+	if err != nil {
+		return ErrFormat
+	}
+
+	for hr.Len() > 0 {
+		b, _ := hr.ReadByte()
+		if b != 0 {
+			return ErrFormat
+		}
+	}
+
+	sr.blockHeaderSize = sr.cr.n - start
+	sr.blockCompressedStart = sr.cr.n
+	sr.blockUncompressedStart = sr.woffset
+	sr.check = newCheck(sr.checkType)
+	sr.lr = lzma.NewReader2(sr.cr, dictSize)
+	if sr.wantSave {
+		sr.lr.WantSave()
+	}
+	sr.stage = stageBlockData
+	return nil
+}
+
+func (sr *saverReader) finishBlock() error {
+	compressedSize := sr.cr.n - sr.blockCompressedStart
+	uncompressedSize := sr.woffset - sr.blockUncompressedStart
+
+	if sr.blockCompressedSize >= 0 && sr.blockCompressedSize != compressedSize {
+		return ErrFormat
+	}
+	if sr.blockUncompressedSize >= 0 && sr.blockUncompressedSize != uncompressedSize {
+		return ErrFormat
+	}
+
+	// block padding
+	for sr.cr.n%4 != 0 {
+		b, err := sr.cr.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if b != 0 {
+			return ErrFormat
+		}
+	}
+
+	size := checkSize(sr.checkType)
+	if size > 0 {
+		buf := make([]byte, size)
+		err := sr.readFull(buf)
+		if err != nil {
+			return err
+		}
+
+		if sr.check != nil {
+			sum := sr.check.Sum(nil)
+			if sr.checkType != checkSHA256 {
+				// CRC32 and CRC64 are stored little-endian
+				for i, j := 0, len(sum)-1; i < j; i, j = i+1, j-1 {
+					sum[i], sum[j] = sum[j], sum[i]
+				}
+			}
+			if !bytes.Equal(sum, buf) {
+				return ErrChecksum
+			}
+		}
+	}
+
+	unpaddedSize := sr.blockHeaderSize + compressedSize + int64(size)
+	sr.addRecord(unpaddedSize, uncompressedSize)
+
+	sr.lr = nil
+	sr.check = nil
+	sr.stage = stageBlockHeader
+	return nil
+}
+
+func (sr *saverReader) addRecord(unpaddedSize int64, uncompressedSize int64) {
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[:8], uint64(unpaddedSize))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(uncompressedSize))
+	sr.recordsHash = crc32.Update(sr.recordsHash, crc32.IEEETable, buf[:])
+	sr.numRecords++
+	sr.unpaddedSum += unpaddedSize
+	sr.uncompressedSum += uncompressedSize
+}
+
+func (sr *saverReader) readIndexAndFooter() error {
+	// the index indicator (0x00) has already been read
+	indexStart := sr.cr.n - 1
+	crc := crc32.NewIEEE()
+	crc.Write([]byte{0})
+	tr := io.TeeReader(sr.cr, crc)
+	br := &teeByteReader{r: tr}
+
+	numRecords, err := readVLI(br)
+	if err != nil {
+		return err
+	}
+	if numRecords != sr.numRecords {
+		return ErrFormat
+	}
+
+	var recordsHash uint32
+	var unpaddedSum, uncompressedSum int64
+	for i := int64(0); i < numRecords; i++ {
+		unpaddedSize, err := readVLI(br)
+		if err != nil {
+			return err
+		}
+		uncompressedSize, err := readVLI(br)
+		if err != nil {
+			return err
+		}
+
+		var buf [16]byte
+		binary.LittleEndian.PutUint64(buf[:8], uint64(unpaddedSize))
+		binary.LittleEndian.PutUint64(buf[8:], uint64(uncompressedSize))
+		recordsHash = crc32.Update(recordsHash, crc32.IEEETable, buf[:])
+		unpaddedSum += unpaddedSize
+		uncompressedSum += uncompressedSize
+	}
+
+	if recordsHash != sr.recordsHash || unpaddedSum != sr.unpaddedSum || uncompressedSum != sr.uncompressedSum {
+		return ErrFormat
+	}
+
+	for sr.cr.n%4 != 0 {
+		b, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != 0 {
+			return ErrFormat
+		}
+	}
+
+	indexSize := sr.cr.n - indexStart + 4
+	sum := crc.Sum32()
+
+	var footer [16]byte
+	err = sr.readFull(footer[:])
+	if err != nil {
+		return err
+	}
+
+	if binary.LittleEndian.Uint32(footer[:4]) != sum {
+		return ErrFormat
+	}
+
+	f := footer[4:]
+	if !bytes.Equal(f[10:12], footerMagic) {
+		return ErrFormat
+	}
+	if crc32.ChecksumIEEE(f[4:10]) != binary.LittleEndian.Uint32(f[:4]) {
+		return ErrFormat
+	}
+	backwardSize := (int64(binary.LittleEndian.Uint32(f[4:8])) + 1) * 4
+	if backwardSize != indexSize {
+		return ErrFormat
+	}
+	if f[8] != 0 || f[9] != sr.checkType {
+		return ErrFormat
+	}
+
+	sr.stage = stageStreamPadding
+	return nil
+}
+
+func (sr *saverReader) readStreamPadding() error {
+	for {
+		b, err := sr.cr.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				if sr.cr.n%4 != 0 {
+					return ErrFormat
+				}
+				sr.stage = stageDone
+				return nil
+			}
+			return err
+		}
+
+		if b == 0 {
+			continue
+		}
+
+		// looks like another stream, concatenated to the first one
+		if sr.cr.n%4 != 1 {
+			return ErrFormat
+		}
+
+		var buf [12]byte
+		buf[0] = b
+		err = sr.readFull(buf[1:])
+		if err != nil {
+			return err
+		}
+		sr.stage = stageStreamHeader
+		return sr.readStreamHeaderFrom(buf[:])
+	}
+}
+
+func (sr *saverReader) readStreamHeaderFrom(buf []byte) error {
+	// re-use readStreamHeader's validation on an already-read header
+	cr := sr.cr
+	sr.cr = &countingReader{r: &prefixReader{prefix: buf, r: cr}, n: cr.n - int64(len(buf))}
+	err := sr.readStreamHeader()
+	sr.cr = cr
+	return err
+}
+
+// WantSave signals the decompressor that it should stop
+// on the next block or chunk boundary to allow the consumer to perform a checkpoint
+func (sr *saverReader) WantSave() {
+	sr.wantSave = true
+	if sr.lr != nil {
+		sr.lr.WantSave()
+	}
+}
+
+func (sr *saverReader) Save() (*Checkpoint, error) {
+	sr.wantSave = false
+
+	if sr.err != nil {
+		return nil, NotOnBoundaryError
+	}
+
+	c := &Checkpoint{
+		Roffset:   sr.cr.n,
+		Woffset:   sr.woffset,
+		Stage:     int(sr.stage),
+		CheckType: sr.checkType,
+
+		NumRecords:      sr.numRecords,
+		UnpaddedSum:     sr.unpaddedSum,
+		UncompressedSum: sr.uncompressedSum,
+		RecordsHash:     sr.recordsHash,
+	}
+
+	switch sr.stage {
+	case stageBlockHeader:
+		// all good, nothing else to save
+	case stageBlockData:
+		lc, err := sr.lr.Save()
+		if err != nil {
+			return nil, err
+		}
+		c.LZMA2Checkpoint = lc
+
+		c.BlockHeaderSize = sr.blockHeaderSize
+		c.BlockCompressedSize = sr.blockCompressedSize
+		c.BlockUncompressedSize = sr.blockUncompressedSize
+		c.BlockCompressedStart = sr.blockCompressedStart
+		c.BlockUncompressedStart = sr.blockUncompressedStart
+
+		if sr.check != nil {
+			state, err := sr.check.(encoding.BinaryMarshaler).MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+			c.CheckState = state
+		}
+	default:
+		return nil, NotOnBoundaryError
+	}
+
+	return c, nil
+}
+
+// Resume starts decompressing again from a given checkpoint
+func (c *Checkpoint) Resume(r lzma.Reader) (SaverReader, error) {
+	cr := &countingReader{r: r, n: c.Roffset}
+
+	sr := &saverReader{
+		cr:        cr,
+		woffset:   c.Woffset,
+		stage:     stage(c.Stage),
+		checkType: c.CheckType,
+
+		numRecords:      c.NumRecords,
+		unpaddedSum:     c.UnpaddedSum,
+		uncompressedSum: c.UncompressedSum,
+		recordsHash:     c.RecordsHash,
+	}
+
+	switch sr.stage {
+	case stageBlockHeader:
+		// nothing else to restore
+	case stageBlockData:
+		if c.LZMA2Checkpoint == nil {
+			return nil, errors.New("xz: checkpoint is missing LZMA2 state")
+		}
+
+		lr, err := c.LZMA2Checkpoint.Resume(cr)
+		if err != nil {
+			return nil, err
+		}
+		sr.lr = lr
+
+		sr.blockHeaderSize = c.BlockHeaderSize
+		sr.blockCompressedSize = c.BlockCompressedSize
+		sr.blockUncompressedSize = c.BlockUncompressedSize
+		sr.blockCompressedStart = c.BlockCompressedStart
+		sr.blockUncompressedStart = c.BlockUncompressedStart
+
+		sr.check = newCheck(c.CheckType)
+		if sr.check != nil {
+			err := sr.check.(encoding.BinaryUnmarshaler).UnmarshalBinary(c.CheckState)
+			if err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("xz: cannot resume from stage %d", c.Stage)
+	}
+
+	return sr, nil
+}
+
+type byteReader interface {
+	io.ByteReader
+}
+
+// readVLI reads a variable-length integer, as used throughout the .xz format
+func readVLI(br byteReader) (int64, error) {
+	var res uint64
+	for i := 0; i < 9; i++ {
+		b, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+
+		res |= uint64(b&0x7F) << (7 * uint(i))
+		if b&0x80 == 0 {
+			if i > 0 && b == 0 {
+				// not minimally encoded
+				return 0, ErrFormat
+			}
+			if res > 1<<63-1 {
+				return 0, ErrFormat
+			}
+			return int64(res), nil
+		}
+	}
+	return 0, ErrFormat
+}
+
+type teeByteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (tbr *teeByteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(tbr.r, tbr.buf[:])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return tbr.buf[0], err
+}
+
+type prefixReader struct {
+	prefix []byte
+	r      lzma.Reader
+}
+
+func (pr *prefixReader) Read(p []byte) (int, error) {
+	if len(pr.prefix) > 0 {
+		n := copy(p, pr.prefix)
+		pr.prefix = pr.prefix[n:]
+		return n, nil
+	}
+	return pr.r.Read(p)
+}
+
+func (pr *prefixReader) ReadByte() (byte, error) {
+	if len(pr.prefix) > 0 {
+		b := pr.prefix[0]
+		pr.prefix = pr.prefix[1:]
+		return b, nil
+	}
+	return pr.r.ReadByte()
+}
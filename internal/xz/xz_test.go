@@ -0,0 +1,222 @@
+package xz_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior/internal/xz"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func must(t *testing.T, err error) {
+	assert.NoError(t, err)
+	if err != nil {
+		t.FailNow()
+	}
+}
+
+// readFixture reads a file made by internal/testdata/mkfixtures.go
+func readFixture(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	must(t, err)
+	return data
+}
+
+func readInput(t *testing.T) []byte {
+	data, err := os.ReadFile(filepath.Join("..", "testdata", "input.bin"))
+	must(t, err)
+	return data
+}
+
+// decode decompresses data. If saveEvery isn't zero, it asks for a
+// checkpoint every saveEvery reads that made progress, and resumes from
+// it with a new decompressor, which reads data from the checkpoint's
+// offset. It returns what was decompressed, even on error, and how many
+// checkpoints were made.
+func decode(data []byte, saveEvery int) ([]byte, int, error) {
+	sr := xz.NewSaverReader(bytes.NewReader(data))
+	out := new(bytes.Buffer)
+	buf := make([]byte, 4096)
+	numCheckpoints := 0
+	reads := 0
+
+	for {
+		if saveEvery > 0 && reads == saveEvery {
+			reads = 0
+			sr.WantSave()
+		}
+
+		n, err := sr.Read(buf)
+		if n > 0 {
+			reads++
+		}
+		out.Write(buf[:n])
+		switch err {
+		case nil:
+			continue
+		case io.EOF:
+			return out.Bytes(), numCheckpoints, nil
+		case xz.ReadyToSaveError:
+			// keep going below
+		default:
+			return out.Bytes(), numCheckpoints, err
+		}
+
+		c, err := sr.Save()
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+		numCheckpoints++
+		if c.Woffset != int64(out.Len()) {
+			msg := "checkpoint is at %d, but %d bytes were decompressed"
+			return out.Bytes(), numCheckpoints, errors.Errorf(msg, c.Woffset, out.Len())
+		}
+
+		// checkpoints are stored, so make sure they survive that
+		encoded := new(bytes.Buffer)
+		err = gob.NewEncoder(encoded).Encode(c)
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+		c = &xz.Checkpoint{}
+		err = gob.NewDecoder(encoded).Decode(c)
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+
+		sr, err = c.Resume(bytes.NewReader(data[c.Roffset:]))
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+	}
+}
+
+func Test_Fixtures(t *testing.T) {
+	input := readInput(t)
+
+	tests := []struct {
+		fixture string
+		output  []byte
+	}{
+		{"check-none.xz", input},
+		{"check-crc32.xz", input},
+		{"check-crc64.xz", input},
+		{"check-sha256.xz", input},
+		{"empty.xz", nil},
+		{"multistream.xz", append(append([]byte(nil), input...), input...)},
+	}
+
+	for _, tt := range tests {
+		for _, saveEvery := range []int{0, 1, 7} {
+			name := tt.fixture
+			if saveEvery > 0 {
+				name += "/resumed"
+			}
+			t.Run(name, func(t *testing.T) {
+				out, numCheckpoints, err := decode(readFixture(t, tt.fixture), saveEvery)
+				must(t, err)
+				assert.Equal(t, len(tt.output), len(out))
+				assert.True(t, bytes.Equal(tt.output, out), "output differs")
+				if saveEvery > 0 && len(tt.output) > 0 {
+					assert.NotZero(t, numCheckpoints)
+				}
+			})
+		}
+	}
+}
+
+// checkOffset returns where the check of the last block of a single
+// stream is: right before the index, whose size is in the footer
+func checkOffset(data []byte, checkSize int) int {
+	footer := data[len(data)-12:]
+	indexSize := (int(binary.LittleEndian.Uint32(footer[4:])) + 1) * 4
+	return len(data) - 12 - indexSize - checkSize
+}
+
+func Test_Corrupt(t *testing.T) {
+	flip := func(offset func(data []byte) int) func(data []byte) []byte {
+		return func(data []byte) []byte {
+			data[offset(data)] ^= 0x55
+			return data
+		}
+	}
+	at := func(offset int) func(data []byte) int {
+		return func(data []byte) int {
+			if offset < 0 {
+				return len(data) + offset
+			}
+			return offset
+		}
+	}
+	truncate := func(size func(data []byte) int) func(data []byte) []byte {
+		return func(data []byte) []byte {
+			return data[:size(data)]
+		}
+	}
+	appendBytes := func(b ...byte) func(data []byte) []byte {
+		return func(data []byte) []byte {
+			return append(data, b...)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		fixture string
+		corrupt func(data []byte) []byte
+		err     error
+	}{
+		{"bad magic", "check-crc64.xz", flip(at(0)), xz.ErrFormat},
+		{"bad stream header crc", "check-crc64.xz", flip(at(8)), xz.ErrFormat},
+		{"bad block header crc", "check-crc64.xz", flip(at(12 + 4)), xz.ErrFormat},
+		{"bad crc32", "check-crc32.xz", flip(func(data []byte) int { return checkOffset(data, 4) }), xz.ErrChecksum},
+		{"bad crc64", "check-crc64.xz", flip(func(data []byte) int { return checkOffset(data, 8) }), xz.ErrChecksum},
+		{"bad sha256", "check-sha256.xz", flip(func(data []byte) int { return checkOffset(data, 32) }), xz.ErrChecksum},
+		{"bad index crc", "check-crc64.xz", flip(at(-12 - 1)), xz.ErrFormat},
+		{"bad footer magic", "check-crc64.xz", flip(at(-1)), xz.ErrFormat},
+		{"bad footer crc", "check-crc64.xz", flip(at(-12)), xz.ErrFormat},
+		{"truncated stream header", "check-crc64.xz", truncate(at(6)), io.ErrUnexpectedEOF},
+		{"truncated block", "check-crc64.xz", truncate(func(data []byte) int { return len(data) / 2 }), io.ErrUnexpectedEOF},
+		{"truncated footer", "check-crc64.xz", truncate(at(-1)), io.ErrUnexpectedEOF},
+		{"truncated empty stream", "empty.xz", truncate(at(-4)), io.ErrUnexpectedEOF},
+		{"misaligned stream padding", "check-crc64.xz", appendBytes(0, 0), xz.ErrFormat},
+		{"trailing garbage", "check-crc64.xz", appendBytes([]byte("garbage!garbage!")...), xz.ErrFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.corrupt(readFixture(t, tt.fixture))
+			_, _, err := decode(data, 0)
+			assert.True(t, errors.Is(err, tt.err), "expected %v, got %v", tt.err, err)
+		})
+	}
+}
+
+func Test_CorruptData(t *testing.T) {
+	input := readInput(t)
+
+	// without a check, corrupt data either decodes to something else,
+	// or is caught by the LZMA2 decoder, but never goes unnoticed
+	// when there's one
+	for _, fixture := range []string{"check-none.xz", "check-crc32.xz"} {
+		t.Run(fixture, func(t *testing.T) {
+			data := readFixture(t, fixture)
+			for offset := 12 + 16; offset < len(data)/2; offset += 997 {
+				corrupt := append([]byte(nil), data...)
+				corrupt[offset] ^= 0x55
+				out, _, err := decode(corrupt, 0)
+				if err != nil {
+					continue
+				}
+				if fixture != "check-none.xz" || bytes.Equal(input, out) {
+					t.Errorf("corruption at %d went unnoticed", offset)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package zstd
+
+import "math/bits"
+
+// backwardBitReader reads a bitstream from its last byte to its first,
+// as used by Huffman-coded literals and FSE-coded sequences.
+type backwardBitReader struct {
+	in []byte
+	// off is the number of bytes not yet loaded into value
+	off int
+	// the low nbits of value are valid, the most significant
+	// of them are the next to be read
+	value uint64
+	nbits uint
+	// overflow is set when reading past the start of the stream
+	overflow bool
+}
+
+func (br *backwardBitReader) init(in []byte) error {
+	if len(in) == 0 {
+		return ErrCorrupt
+	}
+	last := in[len(in)-1]
+	if last == 0 {
+		return ErrCorrupt
+	}
+
+	br.in = in
+	br.off = len(in)
+	br.value = 0
+	br.nbits = 0
+	br.overflow = false
+	br.fill()
+
+	// skip the padding: leading zeroes and the first 1 bit
+	br.readBits(uint(bits.LeadingZeros8(last)) + 1)
+	return nil
+}
+
+func (br *backwardBitReader) fill() {
+	for br.nbits <= 56 && br.off > 0 {
+		br.off--
+		br.value = br.value<<8 | uint64(br.in[br.off])
+		br.nbits += 8
+	}
+}
+
+func (br *backwardBitReader) peekBits(n uint) uint64 {
+	if br.nbits < n {
+		br.fill()
+	}
+	mask := uint64(1)<<n - 1
+	if br.nbits < n {
+		// past the start of the stream, pad with zeroes
+		return (br.value << (n - br.nbits)) & mask
+	}
+	return (br.value >> (br.nbits - n)) & mask
+}
+
+func (br *backwardBitReader) consume(n uint) {
+	if n > br.nbits {
+		br.overflow = true
+		br.nbits = 0
+		return
+	}
+	br.nbits -= n
+}
+
+func (br *backwardBitReader) readBits(n uint) uint64 {
+	if n == 0 {
+		return 0
+	}
+	v := br.peekBits(n)
+	br.consume(n)
+	return v
+}
+
+// finished returns true if the stream was consumed exactly
+func (br *backwardBitReader) finished() bool {
+	return br.off == 0 && br.nbits == 0 && !br.overflow
+}
@@ -0,0 +1,480 @@
+package zstd
+
+const (
+	maxBlockSize = 128 * 1024
+
+	maxLiteralsLengthCode = 35
+	maxMatchLengthCode    = 52
+	maxOffsetCode         = 31
+
+	maxLiteralsLengthLog = 9
+	maxMatchLengthLog    = 9
+	maxOffsetLog         = 8
+)
+
+// Indices of the sequence tables, in the order they're stored in blocks.
+const (
+	tableLiteralsLength = iota
+	tableOffset
+	tableMatchLength
+)
+
+var predefinedLiteralsLength = &TableDescription{
+	AccuracyLog: 6,
+	Norm: []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	},
+}
+
+var predefinedMatchLength = &TableDescription{
+	AccuracyLog: 6,
+	Norm: []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	},
+}
+
+var predefinedOffset = &TableDescription{
+	AccuracyLog: 5,
+	Norm: []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	},
+}
+
+var predefinedTables = [3]*TableDescription{
+	tableLiteralsLength: predefinedLiteralsLength,
+	tableOffset:         predefinedOffset,
+	tableMatchLength:    predefinedMatchLength,
+}
+
+var maxTableCodes = [3]int{
+	tableLiteralsLength: maxLiteralsLengthCode,
+	tableOffset:         maxOffsetCode,
+	tableMatchLength:    maxMatchLengthCode,
+}
+
+var maxTableLogs = [3]uint{
+	tableLiteralsLength: maxLiteralsLengthLog,
+	tableOffset:         maxOffsetLog,
+	tableMatchLength:    maxMatchLengthLog,
+}
+
+type codeValue struct {
+	baseline uint32
+	nbBits   uint
+}
+
+var literalsLengthCodes = [maxLiteralsLengthCode + 1]codeValue{
+	{0, 0}, {1, 0}, {2, 0}, {3, 0}, {4, 0}, {5, 0}, {6, 0}, {7, 0},
+	{8, 0}, {9, 0}, {10, 0}, {11, 0}, {12, 0}, {13, 0}, {14, 0}, {15, 0},
+	{16, 1}, {18, 1}, {20, 1}, {22, 1}, {24, 2}, {28, 2}, {32, 3}, {40, 3},
+	{48, 4}, {64, 6}, {128, 7}, {256, 8}, {512, 9}, {1024, 10}, {2048, 11}, {4096, 12},
+	{8192, 13}, {16384, 14}, {32768, 15}, {65536, 16},
+}
+
+var matchLengthCodes = [maxMatchLengthCode + 1]codeValue{
+	{3, 0}, {4, 0}, {5, 0}, {6, 0}, {7, 0}, {8, 0}, {9, 0}, {10, 0},
+	{11, 0}, {12, 0}, {13, 0}, {14, 0}, {15, 0}, {16, 0}, {17, 0}, {18, 0},
+	{19, 0}, {20, 0}, {21, 0}, {22, 0}, {23, 0}, {24, 0}, {25, 0}, {26, 0},
+	{27, 0}, {28, 0}, {29, 0}, {30, 0}, {31, 0}, {32, 0}, {33, 0}, {34, 0},
+	{35, 1}, {37, 1}, {39, 1}, {41, 1}, {43, 2}, {47, 2}, {51, 3}, {59, 3},
+	{67, 4}, {83, 4}, {99, 5}, {131, 7}, {259, 8}, {515, 9}, {1027, 10}, {2051, 11},
+	{4099, 12}, {8195, 13}, {16387, 14}, {32771, 15}, {65539, 16},
+}
+
+// blockDecoder holds the state that carries over from one
+// compressed block to the next, within a frame.
+type blockDecoder struct {
+	reps [3]uint32
+
+	huffWeights []byte
+	huff        *huffTable
+
+	descs  [3]*TableDescription
+	tables [3]*fseTable
+
+	literals []byte
+}
+
+func (bd *blockDecoder) reset() {
+	bd.reps = [3]uint32{1, 4, 8}
+	bd.huffWeights = nil
+	bd.huff = nil
+	bd.descs = [3]*TableDescription{}
+	bd.tables = [3]*fseTable{}
+}
+
+// restore rebuilds decoding tables after resuming
+func (bd *blockDecoder) restore(reps [3]uint32, huffWeights []byte, descs [3]*TableDescription) error {
+	bd.reset()
+	bd.reps = reps
+
+	if huffWeights != nil {
+		huff, err := buildHuffmanTable(huffWeights)
+		if err != nil {
+			return err
+		}
+		bd.huffWeights = huffWeights
+		bd.huff = huff
+	}
+
+	for i, desc := range descs {
+		if desc == nil {
+			continue
+		}
+		table, err := desc.build()
+		if err != nil {
+			return err
+		}
+		bd.descs[i] = desc
+		bd.tables[i] = table
+	}
+	return nil
+}
+
+// decodeBlock decompresses a compressed block, appending its
+// output to hist, which must hold the frame's history.
+func (bd *blockDecoder) decodeBlock(in []byte, hist []byte, windowSize int64) ([]byte, error) {
+	literals, n, err := bd.decodeLiterals(in)
+	if err != nil {
+		return hist, err
+	}
+	return bd.executeSequences(in[n:], literals, hist, windowSize)
+}
+
+// decodeLiterals decodes the literals section, as described in
+// section 3.1.1.3.1 of RFC 8878. It returns the number of bytes consumed.
+func (bd *blockDecoder) decodeLiterals(in []byte) ([]byte, int, error) {
+	if len(in) == 0 {
+		return nil, 0, ErrCorrupt
+	}
+
+	blockType := in[0] & 3
+	sizeFormat := (in[0] >> 2) & 3
+
+	switch blockType {
+	case 0, 1:
+		// raw or RLE literals
+		var size, headerSize int
+		switch sizeFormat {
+		case 0, 2:
+			headerSize = 1
+			size = int(in[0] >> 3)
+		case 1:
+			headerSize = 2
+			if len(in) < headerSize {
+				return nil, 0, ErrCorrupt
+			}
+			size = int(in[0]>>4) + int(in[1])<<4
+		case 3:
+			headerSize = 3
+			if len(in) < headerSize {
+				return nil, 0, ErrCorrupt
+			}
+			size = int(in[0]>>4) + int(in[1])<<4 + int(in[2])<<12
+		}
+		if size > maxBlockSize {
+			return nil, 0, ErrCorrupt
+		}
+
+		if blockType == 0 {
+			if headerSize+size > len(in) {
+				return nil, 0, ErrCorrupt
+			}
+			return in[headerSize : headerSize+size], headerSize + size, nil
+		}
+
+		if headerSize+1 > len(in) {
+			return nil, 0, ErrCorrupt
+		}
+		literals := bd.literalsBuffer(size)
+		for i := range literals {
+			literals[i] = in[headerSize]
+		}
+		return literals, headerSize + 1, nil
+	}
+
+	// Huffman-compressed literals
+	var regenerated, compressed, headerSize int
+	streams := 4
+	switch sizeFormat {
+	case 0, 1:
+		if sizeFormat == 0 {
+			streams = 1
+		}
+		headerSize = 3
+		if len(in) < headerSize {
+			return nil, 0, ErrCorrupt
+		}
+		v := uint64(in[0]) | uint64(in[1])<<8 | uint64(in[2])<<16
+		regenerated = int(v>>4) & 0x3FF
+		compressed = int(v>>14) & 0x3FF
+	case 2:
+		headerSize = 4
+		if len(in) < headerSize {
+			return nil, 0, ErrCorrupt
+		}
+		v := uint64(in[0]) | uint64(in[1])<<8 | uint64(in[2])<<16 | uint64(in[3])<<24
+		regenerated = int(v>>4) & 0x3FFF
+		compressed = int(v>>18) & 0x3FFF
+	case 3:
+		headerSize = 5
+		if len(in) < headerSize {
+			return nil, 0, ErrCorrupt
+		}
+		v := uint64(in[0]) | uint64(in[1])<<8 | uint64(in[2])<<16 | uint64(in[3])<<24 | uint64(in[4])<<32
+		regenerated = int(v>>4) & 0x3FFFF
+		compressed = int(v>>22) & 0x3FFFF
+	}
+	if regenerated > maxBlockSize || headerSize+compressed > len(in) {
+		return nil, 0, ErrCorrupt
+	}
+	data := in[headerSize : headerSize+compressed]
+
+	if blockType == 2 {
+		weights, n, err := readHuffmanWeights(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		huff, err := buildHuffmanTable(weights)
+		if err != nil {
+			return nil, 0, err
+		}
+		bd.huffWeights = weights
+		bd.huff = huff
+		data = data[n:]
+	} else if bd.huff == nil {
+		// treeless literals need a table from a previous block
+		return nil, 0, ErrCorrupt
+	}
+
+	literals := bd.literalsBuffer(regenerated)
+	if streams == 1 {
+		err := bd.huff.decodeStream(data, literals)
+		if err != nil {
+			return nil, 0, err
+		}
+	} else {
+		if len(data) < 6 {
+			return nil, 0, ErrCorrupt
+		}
+		var sizes [4]int
+		sizes[0] = int(data[0]) | int(data[1])<<8
+		sizes[1] = int(data[2]) | int(data[3])<<8
+		sizes[2] = int(data[4]) | int(data[5])<<8
+		sizes[3] = len(data) - 6 - sizes[0] - sizes[1] - sizes[2]
+		if sizes[3] < 0 {
+			return nil, 0, ErrCorrupt
+		}
+		data = data[6:]
+
+		segment := (regenerated + 3) / 4
+		out := literals
+		for i, size := range sizes {
+			count := segment
+			if i == 3 {
+				count = len(out)
+			}
+			if count > len(out) {
+				return nil, 0, ErrCorrupt
+			}
+			err := bd.huff.decodeStream(data[:size], out[:count])
+			if err != nil {
+				return nil, 0, err
+			}
+			data = data[size:]
+			out = out[count:]
+		}
+	}
+
+	return literals, headerSize + compressed, nil
+}
+
+func (bd *blockDecoder) literalsBuffer(size int) []byte {
+	if cap(bd.literals) < size {
+		bd.literals = make([]byte, size, maxBlockSize)
+	}
+	return bd.literals[:size]
+}
+
+// executeSequences decodes the sequences section, as described in
+// section 3.1.1.3.2 of RFC 8878, and executes them.
+func (bd *blockDecoder) executeSequences(in []byte, literals []byte, hist []byte, windowSize int64) ([]byte, error) {
+	if len(in) == 0 {
+		return hist, ErrCorrupt
+	}
+
+	var numSeqs, n int
+	switch b0 := int(in[0]); {
+	case b0 < 128:
+		numSeqs, n = b0, 1
+	case b0 < 255:
+		if len(in) < 2 {
+			return hist, ErrCorrupt
+		}
+		numSeqs, n = (b0-128)<<8+int(in[1]), 2
+	default:
+		if len(in) < 3 {
+			return hist, ErrCorrupt
+		}
+		numSeqs, n = int(in[1])+int(in[2])<<8+0x7F00, 3
+	}
+	in = in[n:]
+
+	if numSeqs == 0 {
+		if len(in) != 0 {
+			return hist, ErrCorrupt
+		}
+		return append(hist, literals...), nil
+	}
+
+	if len(in) == 0 {
+		return hist, ErrCorrupt
+	}
+	modes := in[0]
+	if modes&3 != 0 {
+		return hist, ErrCorrupt
+	}
+	in = in[1:]
+
+	for i := 0; i < 3; i++ {
+		mode := (modes >> uint(6-2*i)) & 3
+		switch mode {
+		case 0:
+			// predefined distribution
+			desc := predefinedTables[i]
+			table, err := desc.build()
+			if err != nil {
+				return hist, err
+			}
+			bd.descs[i] = desc
+			bd.tables[i] = table
+		case 1:
+			// a single symbol
+			if len(in) == 0 || int(in[0]) > maxTableCodes[i] {
+				return hist, ErrCorrupt
+			}
+			desc := &TableDescription{RLE: true, Symbol: in[0]}
+			bd.descs[i] = desc
+			bd.tables[i] = rleTable(in[0])
+			in = in[1:]
+		case 2:
+			// FSE-compressed distribution
+			desc, n, err := readNCount(in, maxTableCodes[i], maxTableLogs[i])
+			if err != nil {
+				return hist, err
+			}
+			table, err := desc.build()
+			if err != nil {
+				return hist, err
+			}
+			bd.descs[i] = desc
+			bd.tables[i] = table
+			in = in[n:]
+		case 3:
+			// repeat the table from the previous block
+			if bd.tables[i] == nil {
+				return hist, ErrCorrupt
+			}
+		}
+	}
+
+	var br backwardBitReader
+	err := br.init(in)
+	if err != nil {
+		return hist, err
+	}
+
+	var llState, ofState, mlState fseState
+	llState.init(&br, bd.tables[tableLiteralsLength])
+	ofState.init(&br, bd.tables[tableOffset])
+	mlState.init(&br, bd.tables[tableMatchLength])
+
+	blockStart := len(hist)
+	for s := 0; s < numSeqs; s++ {
+		llCode := int(llState.symbol())
+		ofCode := uint(ofState.symbol())
+		mlCode := int(mlState.symbol())
+		if llCode > maxLiteralsLengthCode || mlCode > maxMatchLengthCode || ofCode > maxOffsetCode {
+			return hist, ErrCorrupt
+		}
+
+		ofValue := uint32(1)<<ofCode + uint32(br.readBits(ofCode))
+		mlv := matchLengthCodes[mlCode]
+		matchLength := int(mlv.baseline) + int(br.readBits(mlv.nbBits))
+		llv := literalsLengthCodes[llCode]
+		literalsLength := int(llv.baseline) + int(br.readBits(llv.nbBits))
+
+		var offset uint32
+		if ofValue > 3 {
+			offset = ofValue - 3
+			bd.reps = [3]uint32{offset, bd.reps[0], bd.reps[1]}
+		} else {
+			idx := int(ofValue) - 1
+			if literalsLength == 0 {
+				idx++
+			}
+			if idx == 0 {
+				offset = bd.reps[0]
+			} else {
+				if idx == 3 {
+					offset = bd.reps[0] - 1
+				} else {
+					offset = bd.reps[idx]
+				}
+				if idx != 1 {
+					bd.reps[2] = bd.reps[1]
+				}
+				bd.reps[1] = bd.reps[0]
+				bd.reps[0] = offset
+			}
+		}
+
+		if s < numSeqs-1 {
+			llState.update(&br)
+			mlState.update(&br)
+			ofState.update(&br)
+		}
+
+		if br.overflow {
+			return hist, ErrCorrupt
+		}
+
+		if literalsLength > len(literals) {
+			return hist, ErrCorrupt
+		}
+		hist = append(hist, literals[:literalsLength]...)
+		literals = literals[literalsLength:]
+
+		if offset == 0 || int64(offset) > windowSize || int(offset) > len(hist) {
+			return hist, ErrCorrupt
+		}
+		if len(hist)-blockStart+matchLength > maxBlockSize {
+			return hist, ErrCorrupt
+		}
+
+		src := len(hist) - int(offset)
+		if matchLength <= int(offset) {
+			hist = append(hist, hist[src:src+matchLength]...)
+		} else {
+			for i := 0; i < matchLength; i++ {
+				hist = append(hist, hist[src+i])
+			}
+		}
+	}
+
+	if !br.finished() {
+		return hist, ErrCorrupt
+	}
+
+	hist = append(hist, literals...)
+	if len(hist)-blockStart > maxBlockSize {
+		return hist, ErrCorrupt
+	}
+	return hist, nil
+}
@@ -0,0 +1,204 @@
+package zstd
+
+import "math/bits"
+
+type fseEntry struct {
+	symbol   uint8
+	nbBits   uint8
+	newState uint16
+}
+
+type fseTable struct {
+	accuracyLog uint
+	entries     []fseEntry
+}
+
+// A TableDescription is enough information to rebuild an FSE table,
+// which lets checkpoints stay small and independent of the table layout.
+type TableDescription struct {
+	// RLE is true if the table always decodes to Symbol
+	RLE    bool
+	Symbol uint8
+
+	AccuracyLog uint
+	Norm        []int16
+}
+
+func (td *TableDescription) build() (*fseTable, error) {
+	if td.RLE {
+		return rleTable(td.Symbol), nil
+	}
+	return buildFSETable(td.Norm, td.AccuracyLog)
+}
+
+func rleTable(symbol uint8) *fseTable {
+	return &fseTable{
+		accuracyLog: 0,
+		entries:     []fseEntry{{symbol: symbol}},
+	}
+}
+
+// readNCount reads a normalized distribution, as described in
+// section 4.1.1 of RFC 8878. It returns the number of bytes consumed.
+func readNCount(in []byte, maxSymbol int, maxLog uint) (*TableDescription, int, error) {
+	bitpos := 0
+	peek := func(n int) int32 {
+		var v int32
+		for i := 0; i < n; i++ {
+			p := bitpos + i
+			if p/8 < len(in) && (in[p/8]>>uint(p%8))&1 == 1 {
+				v |= 1 << uint(i)
+			}
+		}
+		return v
+	}
+
+	if len(in) == 0 {
+		return nil, 0, ErrCorrupt
+	}
+
+	accuracyLog := uint(peek(4)) + 5
+	bitpos += 4
+	if accuracyLog > maxLog {
+		return nil, 0, ErrCorrupt
+	}
+
+	var norm []int16
+	remaining := int32(1)<<accuracyLog + 1
+	threshold := int32(1) << accuracyLog
+	nbBits := int(accuracyLog) + 1
+	previous0 := false
+
+	for remaining > 1 && len(norm) <= maxSymbol {
+		if previous0 {
+			for {
+				repeat := peek(2)
+				bitpos += 2
+				for i := int32(0); i < repeat; i++ {
+					norm = append(norm, 0)
+				}
+				if repeat != 3 {
+					break
+				}
+			}
+			if len(norm) > maxSymbol {
+				return nil, 0, ErrCorrupt
+			}
+		}
+
+		max := (2*threshold - 1) - remaining
+		var count int32
+		if low := peek(nbBits - 1); low < max {
+			count = low
+			bitpos += nbBits - 1
+		} else {
+			count = peek(nbBits)
+			if count >= threshold {
+				count -= max
+			}
+			bitpos += nbBits
+		}
+
+		count--
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		norm = append(norm, int16(count))
+		previous0 = count == 0
+
+		if remaining < 1 {
+			break
+		}
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+
+	consumed := (bitpos + 7) / 8
+	if remaining != 1 || consumed > len(in) {
+		return nil, 0, ErrCorrupt
+	}
+
+	td := &TableDescription{
+		AccuracyLog: accuracyLog,
+		Norm:        norm,
+	}
+	return td, consumed, nil
+}
+
+// buildFSETable builds a decoding table from a normalized distribution,
+// as described in section 4.1.1 of RFC 8878.
+func buildFSETable(norm []int16, accuracyLog uint) (*fseTable, error) {
+	size := 1 << accuracyLog
+	entries := make([]fseEntry, size)
+	next := make([]uint16, len(norm))
+
+	high := size - 1
+	for s, c := range norm {
+		if c == -1 {
+			if high < 0 {
+				return nil, ErrCorrupt
+			}
+			entries[high].symbol = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = uint16(c)
+		}
+	}
+
+	pos := 0
+	step := size>>1 + size>>3 + 3
+	mask := size - 1
+	for s, c := range norm {
+		for i := 0; i < int(c); i++ {
+			entries[pos].symbol = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, ErrCorrupt
+	}
+
+	for u := range entries {
+		s := entries[u].symbol
+		ns := next[s]
+		next[s]++
+		if ns == 0 {
+			return nil, ErrCorrupt
+		}
+		nb := accuracyLog - uint(bits.Len16(ns)-1)
+		entries[u].nbBits = uint8(nb)
+		entries[u].newState = uint16(int(ns)<<nb - size)
+	}
+
+	return &fseTable{
+		accuracyLog: accuracyLog,
+		entries:     entries,
+	}, nil
+}
+
+type fseState struct {
+	table *fseTable
+	state uint16
+}
+
+func (fs *fseState) init(br *backwardBitReader, table *fseTable) {
+	fs.table = table
+	fs.state = uint16(br.readBits(table.accuracyLog))
+}
+
+func (fs *fseState) symbol() uint8 {
+	return fs.table.entries[fs.state].symbol
+}
+
+func (fs *fseState) update(br *backwardBitReader) {
+	e := fs.table.entries[fs.state]
+	fs.state = e.newState + uint16(br.readBits(uint(e.nbBits)))
+}
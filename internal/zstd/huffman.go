@@ -0,0 +1,178 @@
+package zstd
+
+import "math/bits"
+
+const (
+	maxHuffmanBits    = 11
+	maxHuffmanSymbols = 256
+)
+
+type huffEntry struct {
+	symbol uint8
+	nbBits uint8
+}
+
+type huffTable struct {
+	maxBits uint
+	entries []huffEntry
+}
+
+// readHuffmanWeights reads a Huffman tree description, as described in
+// section 4.2.1 of RFC 8878. The last weight, which is implied, is not
+// included. It returns the number of bytes consumed.
+func readHuffmanWeights(in []byte) ([]byte, int, error) {
+	if len(in) == 0 {
+		return nil, 0, ErrCorrupt
+	}
+
+	header := int(in[0])
+	if header >= 128 {
+		// weights are stored directly, 4 bits each
+		num := header - 127
+		size := (num + 1) / 2
+		if 1+size > len(in) {
+			return nil, 0, ErrCorrupt
+		}
+
+		weights := make([]byte, num)
+		for i := range weights {
+			b := in[1+i/2]
+			if i%2 == 0 {
+				weights[i] = b >> 4
+			} else {
+				weights[i] = b & 0xF
+			}
+		}
+		return weights, 1 + size, nil
+	}
+
+	// weights are FSE-compressed
+	size := header
+	if 1+size > len(in) {
+		return nil, 0, ErrCorrupt
+	}
+	in = in[1 : 1+size]
+
+	td, n, err := readNCount(in, maxHuffmanBits+1, 6)
+	if err != nil {
+		return nil, 0, err
+	}
+	table, err := td.build()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var br backwardBitReader
+	err = br.init(in[n:])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var s1, s2 fseState
+	s1.init(&br, table)
+	s2.init(&br, table)
+
+	var weights []byte
+	for {
+		if len(weights) >= maxHuffmanSymbols-1 {
+			return nil, 0, ErrCorrupt
+		}
+		weights = append(weights, s1.symbol())
+		s1.update(&br)
+		if br.overflow {
+			weights = append(weights, s2.symbol())
+			break
+		}
+
+		if len(weights) >= maxHuffmanSymbols-1 {
+			return nil, 0, ErrCorrupt
+		}
+		weights = append(weights, s2.symbol())
+		s2.update(&br)
+		if br.overflow {
+			weights = append(weights, s1.symbol())
+			break
+		}
+	}
+
+	if len(weights) > maxHuffmanSymbols-1 {
+		return nil, 0, ErrCorrupt
+	}
+	return weights, 1 + size, nil
+}
+
+// buildHuffmanTable completes a list of weights with the implied last
+// weight, and builds a decoding table from it.
+func buildHuffmanTable(weights []byte) (*huffTable, error) {
+	var sum uint32
+	for _, w := range weights {
+		if w > maxHuffmanBits {
+			return nil, ErrCorrupt
+		}
+		if w > 0 {
+			sum += 1 << (w - 1)
+		}
+	}
+	if sum == 0 {
+		return nil, ErrCorrupt
+	}
+
+	maxBits := uint(bits.Len32(sum))
+	if maxBits > maxHuffmanBits {
+		return nil, ErrCorrupt
+	}
+	rest := uint32(1)<<maxBits - sum
+	if rest&(rest-1) != 0 {
+		return nil, ErrCorrupt
+	}
+	lastWeight := byte(bits.Len32(rest))
+
+	all := make([]byte, len(weights)+1)
+	copy(all, weights)
+	all[len(weights)] = lastWeight
+
+	entries := make([]huffEntry, 1<<maxBits)
+	pos := 0
+	for w := byte(1); w <= byte(maxBits); w++ {
+		count := 1 << (w - 1)
+		for s, sw := range all {
+			if sw != w {
+				continue
+			}
+			e := huffEntry{
+				symbol: uint8(s),
+				nbBits: uint8(maxBits + 1 - uint(w)),
+			}
+			for i := 0; i < count; i++ {
+				entries[pos] = e
+				pos++
+			}
+		}
+	}
+
+	return &huffTable{
+		maxBits: maxBits,
+		entries: entries,
+	}, nil
+}
+
+// decodeStream decodes exactly len(out) symbols from a single
+// Huffman-coded stream.
+func (ht *huffTable) decodeStream(in []byte, out []byte) error {
+	var br backwardBitReader
+	err := br.init(in)
+	if err != nil {
+		return err
+	}
+
+	for i := range out {
+		e := ht.entries[br.peekBits(ht.maxBits)]
+		out[i] = e.symbol
+		br.consume(uint(e.nbBits))
+	}
+
+	if !br.finished() {
+		return ErrCorrupt
+	}
+	return nil
+}
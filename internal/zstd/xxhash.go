@@ -0,0 +1,110 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	prime64_1 uint64 = 11400714785074694791
+	prime64_2 uint64 = 14029467366897019727
+	prime64_3 uint64 = 1609587929392839161
+	prime64_4 uint64 = 9650029242287828579
+	prime64_5 uint64 = 2870177450012600261
+)
+
+// XXHash64 is a streaming XXH64 hasher (with a seed of 0), used for
+// content checksums. Its fields are exported so it can be saved in
+// checkpoints.
+type XXHash64 struct {
+	V     [4]uint64
+	Total uint64
+	Mem   [32]byte
+	N     int
+}
+
+func newXXHash64() *XXHash64 {
+	p1, p2 := prime64_1, prime64_2
+	h := &XXHash64{}
+	h.V = [4]uint64{p1 + p2, p2, 0, -p1}
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * prime64_2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * prime64_1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	val = xxRound(0, val)
+	acc ^= val
+	return acc*prime64_1 + prime64_4
+}
+
+func (h *XXHash64) Write(p []byte) {
+	h.Total += uint64(len(p))
+
+	if h.N > 0 {
+		n := copy(h.Mem[h.N:], p)
+		h.N += n
+		p = p[n:]
+		if h.N < 32 {
+			return
+		}
+		h.stripe(h.Mem[:])
+		h.N = 0
+	}
+
+	for len(p) >= 32 {
+		h.stripe(p[:32])
+		p = p[32:]
+	}
+
+	h.N = copy(h.Mem[:], p)
+}
+
+func (h *XXHash64) stripe(b []byte) {
+	h.V[0] = xxRound(h.V[0], binary.LittleEndian.Uint64(b[0:8]))
+	h.V[1] = xxRound(h.V[1], binary.LittleEndian.Uint64(b[8:16]))
+	h.V[2] = xxRound(h.V[2], binary.LittleEndian.Uint64(b[16:24]))
+	h.V[3] = xxRound(h.V[3], binary.LittleEndian.Uint64(b[24:32]))
+}
+
+func (h *XXHash64) Sum64() uint64 {
+	var acc uint64
+	if h.Total >= 32 {
+		v := h.V
+		acc = bits.RotateLeft64(v[0], 1) + bits.RotateLeft64(v[1], 7) +
+			bits.RotateLeft64(v[2], 12) + bits.RotateLeft64(v[3], 18)
+		for _, x := range v {
+			acc = xxMergeRound(acc, x)
+		}
+	} else {
+		acc = h.V[2] + prime64_5
+	}
+	acc += h.Total
+
+	p := h.Mem[:h.N]
+	for len(p) >= 8 {
+		acc ^= xxRound(0, binary.LittleEndian.Uint64(p))
+		acc = bits.RotateLeft64(acc, 27)*prime64_1 + prime64_4
+		p = p[8:]
+	}
+	if len(p) >= 4 {
+		acc ^= uint64(binary.LittleEndian.Uint32(p)) * prime64_1
+		acc = bits.RotateLeft64(acc, 23)*prime64_2 + prime64_3
+		p = p[4:]
+	}
+	for _, b := range p {
+		acc ^= uint64(b) * prime64_5
+		acc = bits.RotateLeft64(acc, 11) * prime64_1
+	}
+
+	acc ^= acc >> 33
+	acc *= prime64_2
+	acc ^= acc >> 29
+	acc *= prime64_3
+	acc ^= acc >> 32
+	return acc
+}
@@ -0,0 +1,536 @@
+// Package zstd implements decompression of the Zstandard format (RFC 8878),
+// in a way that lets consumers save the state of the decompressor and
+// resume it later.
+//
+// Checkpoints can be made between frames, and between blocks inside of
+// a frame. Block checkpoints carry the window history needed to decode
+// further blocks, so their size depends on the frame's window size.
+// Frames that need a dictionary are not supported.
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ReadyToSaveError is returned by Read() when a SaverReader is ready to emit a checkpoint
+	ReadyToSaveError = errors.New("ready to save")
+	// NotOnBoundaryError is returned by Save() when a SaverReader wasn't ready to emit a checkpoint
+	NotOnBoundaryError = errors.New("asked to save, but not on boundary")
+	// ErrCorrupt is returned when the compressed data is invalid
+	ErrCorrupt = errors.New("zstd: corrupt input")
+	// ErrChecksum is returned when a frame's content checksum doesn't match
+	ErrChecksum = errors.New("zstd: checksum error")
+)
+
+const (
+	frameMagic         = 0xFD2FB528
+	skippableMagicMask = 0xFFFFFFF0
+	skippableMagic     = 0x184D2A50
+
+	minWindowSize = 1 << 10
+	// MaxWindowSize is the largest window we agree to decode with,
+	// the same default limit as the reference implementation.
+	MaxWindowSize = 1 << 27
+)
+
+// Reader is what the decompressor reads from.
+type Reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// A SaverReader is a decompressor that can be asked to stop on
+// the next boundary, so that its state can be saved.
+type SaverReader interface {
+	io.Reader
+
+	// WantSave signals the decompressor that it should stop
+	// on the next boundary to allow the consumer to perform a checkpoint
+	WantSave()
+	// Save returns a checkpoint, it must only be called after Read
+	// returned ReadyToSaveError.
+	Save() (*Checkpoint, error)
+}
+
+type stage int
+
+const (
+	stageFrameHeader stage = iota
+	stageBlockHeader
+	stageDone
+)
+
+// A Checkpoint allows resuming decompression from a frame boundary or
+// a block boundary.
+type Checkpoint struct {
+	// Roffset is the offset into compressed data
+	Roffset int64
+	// Woffset is the offset into uncompressed data
+	Woffset int64
+
+	Stage     int
+	SawFrame  bool
+	LastBlock bool
+
+	// Frame state, only set for block checkpoints
+	WindowSize   int64
+	ContentSize  int64
+	FrameWoffset int64
+	Hash         *XXHash64
+
+	// History holds the last bytes of the frame's output,
+	// up to the window size.
+	History []byte
+
+	// Block decoder state
+	Reps        [3]uint32
+	HuffWeights []byte
+	// Tables are zero until the frame sets them (gob can't encode nil
+	// pointers in arrays)
+	Tables [3]TableDescription
+}
+
+type countingReader struct {
+	r Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return b, err
+}
+
+type saverReader struct {
+	cr      *countingReader
+	woffset int64
+	stage   stage
+
+	sawFrame bool
+
+	// frame state
+	windowSize   int64
+	contentSize  int64
+	frameWoffset int64
+	hash         *XXHash64
+
+	bd       blockDecoder
+	blockBuf []byte
+
+	// hist holds the frame's output, of which hist[outPos:]
+	// hasn't been returned by Read yet.
+	hist   []byte
+	outPos int
+
+	wantSave bool
+	err      error
+}
+
+var _ SaverReader = (*saverReader)(nil)
+
+// NewSaverReader returns a reader that decompresses a zstd stream, made of
+// one or more frames.
+func NewSaverReader(r Reader) SaverReader {
+	return &saverReader{
+		cr: &countingReader{r: r},
+	}
+}
+
+func (sr *saverReader) Read(p []byte) (int, error) {
+	for {
+		if sr.outPos < len(sr.hist) {
+			if len(p) == 0 {
+				return 0, nil
+			}
+			n := copy(p, sr.hist[sr.outPos:])
+			sr.outPos += n
+			sr.woffset += int64(n)
+			return n, nil
+		}
+
+		if sr.err != nil {
+			return 0, sr.err
+		}
+
+		switch sr.stage {
+		case stageFrameHeader:
+			if sr.wantSave {
+				return 0, ReadyToSaveError
+			}
+			sr.err = sr.readFrameHeader()
+		case stageBlockHeader:
+			if sr.wantSave {
+				return 0, ReadyToSaveError
+			}
+			sr.err = sr.readBlock()
+		case stageDone:
+			return 0, io.EOF
+		}
+	}
+}
+
+func (sr *saverReader) readFull(buf []byte) error {
+	_, err := io.ReadFull(sr.cr, buf)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (sr *saverReader) readFrameHeader() error {
+	var magicBuf [4]byte
+	n, err := io.ReadFull(sr.cr, magicBuf[:])
+	if n == 0 && err == io.EOF && sr.sawFrame {
+		sr.stage = stageDone
+		return nil
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	magic := binary.LittleEndian.Uint32(magicBuf[:])
+	if magic&skippableMagicMask == skippableMagic {
+		var sizeBuf [4]byte
+		err = sr.readFull(sizeBuf[:])
+		if err != nil {
+			return err
+		}
+		size := int64(binary.LittleEndian.Uint32(sizeBuf[:]))
+		_, err = io.CopyN(io.Discard, sr.cr, size)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		return nil
+	}
+
+	if magic != frameMagic {
+		return ErrCorrupt
+	}
+
+	desc, err := sr.cr.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	fcsFlag := desc >> 6
+	singleSegment := desc&(1<<5) != 0
+	if desc&(1<<3) != 0 {
+		// reserved bit
+		return ErrCorrupt
+	}
+	hasChecksum := desc&(1<<2) != 0
+	dictIDFlag := desc & 3
+
+	headerSize := 0
+	if !singleSegment {
+		headerSize++
+	}
+	dictIDSizes := [4]int{0, 1, 2, 4}
+	headerSize += dictIDSizes[dictIDFlag]
+	fcsSizes := [4]int{0, 2, 4, 8}
+	fcsSize := fcsSizes[fcsFlag]
+	if fcsFlag == 0 && singleSegment {
+		fcsSize = 1
+	}
+	headerSize += fcsSize
+
+	buf := make([]byte, headerSize)
+	err = sr.readFull(buf)
+	if err != nil {
+		return err
+	}
+
+	var windowSize int64
+	if !singleSegment {
+		wd := buf[0]
+		buf = buf[1:]
+		windowLog := uint(10 + wd>>3)
+		windowBase := int64(1) << windowLog
+		windowSize = windowBase + (windowBase/8)*int64(wd&7)
+	}
+
+	var dictID uint32
+	for i := 0; i < dictIDSizes[dictIDFlag]; i++ {
+		dictID |= uint32(buf[i]) << (8 * uint(i))
+	}
+	buf = buf[dictIDSizes[dictIDFlag]:]
+	if dictID != 0 {
+		return fmt.Errorf("zstd: frames using dictionaries are not supported (dictionary %d)", dictID)
+	}
+
+	contentSize := int64(-1)
+	switch fcsSize {
+	case 1:
+		contentSize = int64(buf[0])
+	case 2:
+		contentSize = int64(binary.LittleEndian.Uint16(buf)) + 256
+	case 4:
+		contentSize = int64(binary.LittleEndian.Uint32(buf))
+	case 8:
+		v := binary.LittleEndian.Uint64(buf)
+		if v > 1<<62 {
+			return ErrCorrupt
+		}
+		contentSize = int64(v)
+	}
+
+	if singleSegment {
+		windowSize = contentSize
+	}
+	if windowSize > MaxWindowSize {
+		return fmt.Errorf("zstd: window size %d exceeds limit of %d", windowSize, MaxWindowSize)
+	}
+	if windowSize < minWindowSize {
+		windowSize = minWindowSize
+	}
+
+	sr.sawFrame = true
+	sr.windowSize = windowSize
+	sr.contentSize = contentSize
+	sr.frameWoffset = 0
+	sr.hash = nil
+	if hasChecksum {
+		sr.hash = newXXHash64()
+	}
+	sr.bd.reset()
+	sr.hist = sr.hist[:0]
+	sr.outPos = 0
+	sr.stage = stageBlockHeader
+	return nil
+}
+
+func (sr *saverReader) maxBlockSize() int {
+	if sr.windowSize < maxBlockSize {
+		return int(sr.windowSize)
+	}
+	return maxBlockSize
+}
+
+func (sr *saverReader) readBlock() error {
+	var header [3]byte
+	err := sr.readFull(header[:])
+	if err != nil {
+		return err
+	}
+
+	h := uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16
+	last := h&1 != 0
+	blockType := (h >> 1) & 3
+	blockSize := int(h >> 3)
+
+	maxSize := sr.maxBlockSize()
+	if blockSize > maxSize {
+		return ErrCorrupt
+	}
+
+	// only keep as much history as the window needs, but don't slide it
+	// too often either.
+	if int64(len(sr.hist)) > 2*sr.windowSize+maxBlockSize {
+		keep := sr.hist[int64(len(sr.hist))-sr.windowSize:]
+		sr.hist = sr.hist[:copy(sr.hist, keep)]
+	}
+	sr.outPos = len(sr.hist)
+
+	switch blockType {
+	case 0:
+		// raw block
+		start := len(sr.hist)
+		sr.hist = append(sr.hist, make([]byte, blockSize)...)
+		err = sr.readFull(sr.hist[start:])
+		if err != nil {
+			sr.hist = sr.hist[:start]
+			return err
+		}
+	case 1:
+		// RLE block
+		b, err := sr.cr.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		for i := 0; i < blockSize; i++ {
+			sr.hist = append(sr.hist, b)
+		}
+	case 2:
+		// compressed block
+		if cap(sr.blockBuf) < blockSize {
+			sr.blockBuf = make([]byte, blockSize, maxBlockSize)
+		}
+		buf := sr.blockBuf[:blockSize]
+		err = sr.readFull(buf)
+		if err != nil {
+			return err
+		}
+
+		start := len(sr.hist)
+		sr.hist, err = sr.bd.decodeBlock(buf, sr.hist, sr.windowSize)
+		if err != nil {
+			sr.hist = sr.hist[:start]
+			return err
+		}
+		if len(sr.hist)-start > maxSize {
+			sr.hist = sr.hist[:start]
+			return ErrCorrupt
+		}
+	default:
+		return ErrCorrupt
+	}
+
+	output := sr.hist[sr.outPos:]
+	sr.frameWoffset += int64(len(output))
+	if sr.hash != nil {
+		sr.hash.Write(output)
+	}
+
+	if sr.contentSize >= 0 && sr.frameWoffset > sr.contentSize {
+		return ErrCorrupt
+	}
+
+	if last {
+		return sr.finishFrame()
+	}
+	return nil
+}
+
+func (sr *saverReader) finishFrame() error {
+	if sr.contentSize >= 0 && sr.frameWoffset != sr.contentSize {
+		return ErrCorrupt
+	}
+
+	if sr.hash != nil {
+		var buf [4]byte
+		err := sr.readFull(buf[:])
+		if err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(buf[:]) != uint32(sr.hash.Sum64()) {
+			return ErrChecksum
+		}
+	}
+
+	sr.stage = stageFrameHeader
+	return nil
+}
+
+// WantSave signals the decompressor that it should stop
+// on the next frame or block boundary to allow the consumer to perform a checkpoint
+func (sr *saverReader) WantSave() {
+	sr.wantSave = true
+}
+
+func (sr *saverReader) Save() (*Checkpoint, error) {
+	sr.wantSave = false
+
+	if sr.err != nil || sr.outPos < len(sr.hist) {
+		return nil, NotOnBoundaryError
+	}
+
+	c := &Checkpoint{
+		Roffset:  sr.cr.n,
+		Woffset:  sr.woffset,
+		Stage:    int(sr.stage),
+		SawFrame: sr.sawFrame,
+	}
+
+	switch sr.stage {
+	case stageFrameHeader:
+		// all good, nothing else to save
+	case stageBlockHeader:
+		c.WindowSize = sr.windowSize
+		c.ContentSize = sr.contentSize
+		c.FrameWoffset = sr.frameWoffset
+		if sr.hash != nil {
+			hash := *sr.hash
+			c.Hash = &hash
+		}
+
+		history := sr.hist
+		if int64(len(history)) > sr.windowSize {
+			history = history[int64(len(history))-sr.windowSize:]
+		}
+		c.History = append([]byte(nil), history...)
+
+		c.Reps = sr.bd.reps
+		c.HuffWeights = sr.bd.huffWeights
+		for i, desc := range sr.bd.descs {
+			if desc != nil {
+				c.Tables[i] = *desc
+			}
+		}
+	default:
+		return nil, NotOnBoundaryError
+	}
+
+	return c, nil
+}
+
+// Resume starts decompressing again from a given checkpoint
+func (c *Checkpoint) Resume(r Reader) (SaverReader, error) {
+	sr := &saverReader{
+		cr:       &countingReader{r: r, n: c.Roffset},
+		woffset:  c.Woffset,
+		stage:    stage(c.Stage),
+		sawFrame: c.SawFrame,
+	}
+
+	switch sr.stage {
+	case stageFrameHeader:
+		// nothing else to restore
+	case stageBlockHeader:
+		if c.WindowSize < minWindowSize || c.WindowSize > MaxWindowSize {
+			return nil, errors.New("zstd: checkpoint has invalid window size")
+		}
+		if int64(len(c.History)) > c.WindowSize || int64(len(c.History)) > c.FrameWoffset {
+			return nil, errors.New("zstd: checkpoint has invalid history")
+		}
+
+		sr.windowSize = c.WindowSize
+		sr.contentSize = c.ContentSize
+		sr.frameWoffset = c.FrameWoffset
+		if c.Hash != nil {
+			hash := *c.Hash
+			sr.hash = &hash
+		}
+		sr.hist = append([]byte(nil), c.History...)
+		sr.outPos = len(sr.hist)
+
+		var descs [3]*TableDescription
+		for i := range c.Tables {
+			if desc := c.Tables[i]; desc.RLE || desc.Norm != nil {
+				descs[i] = &desc
+			}
+		}
+
+		err := sr.bd.restore(c.Reps, c.HuffWeights, descs)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("zstd: cannot resume from stage %d", c.Stage)
+	}
+
+	return sr, nil
+}
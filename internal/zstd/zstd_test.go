@@ -0,0 +1,251 @@
+package zstd_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior/internal/zstd"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func must(t *testing.T, err error) {
+	assert.NoError(t, err)
+	if err != nil {
+		t.FailNow()
+	}
+}
+
+// readFixture reads a file made by internal/testdata/mkfixtures.go
+func readFixture(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	must(t, err)
+	return data
+}
+
+func readInput(t *testing.T) []byte {
+	data, err := os.ReadFile(filepath.Join("..", "testdata", "input.bin"))
+	must(t, err)
+	return data
+}
+
+// random returns the random bytes mkfixtures.go makes
+func random() []byte {
+	random := make([]byte, 8*1024)
+	rand.New(rand.NewSource(0x5a)).Read(random)
+	return random
+}
+
+// decode decompresses data. If saveEvery isn't zero, it asks for a
+// checkpoint every saveEvery reads that made progress, and resumes from
+// it with a new decompressor, which reads data from the checkpoint's
+// offset. It returns what was decompressed, even on error, and how many
+// checkpoints were made.
+func decode(data []byte, saveEvery int) ([]byte, int, error) {
+	sr := zstd.NewSaverReader(bytes.NewReader(data))
+	out := new(bytes.Buffer)
+	buf := make([]byte, 4096)
+	numCheckpoints := 0
+	reads := 0
+
+	for {
+		if saveEvery > 0 && reads == saveEvery {
+			reads = 0
+			sr.WantSave()
+		}
+
+		n, err := sr.Read(buf)
+		if n > 0 {
+			reads++
+		}
+		out.Write(buf[:n])
+		switch err {
+		case nil:
+			continue
+		case io.EOF:
+			return out.Bytes(), numCheckpoints, nil
+		case zstd.ReadyToSaveError:
+			// keep going below
+		default:
+			return out.Bytes(), numCheckpoints, err
+		}
+
+		c, err := sr.Save()
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+		numCheckpoints++
+		if c.Woffset != int64(out.Len()) {
+			msg := "checkpoint is at %d, but %d bytes were decompressed"
+			return out.Bytes(), numCheckpoints, errors.Errorf(msg, c.Woffset, out.Len())
+		}
+
+		// checkpoints are stored, so make sure they survive that
+		encoded := new(bytes.Buffer)
+		err = gob.NewEncoder(encoded).Encode(c)
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+		c = &zstd.Checkpoint{}
+		err = gob.NewDecoder(encoded).Decode(c)
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+
+		sr, err = c.Resume(bytes.NewReader(data[c.Roffset:]))
+		if err != nil {
+			return out.Bytes(), numCheckpoints, err
+		}
+	}
+}
+
+func Test_Fixtures(t *testing.T) {
+	input := readInput(t)
+
+	tests := []struct {
+		fixture string
+		output  []byte
+	}{
+		{"default.zst", input},
+		{"no-check.zst", input},
+		{"blocks.zst", input},
+		{"window1k.zst", input},
+		{"zeroes.zst", make([]byte, 300*1000)},
+		{"random.zst", random()},
+		{"empty.zst", nil},
+		{"multiframe.zst", append(append([]byte(nil), input...), input...)},
+	}
+
+	for _, tt := range tests {
+		for _, saveEvery := range []int{0, 1, 7} {
+			name := tt.fixture
+			if saveEvery > 0 {
+				name += "/resumed"
+			}
+			t.Run(name, func(t *testing.T) {
+				out, numCheckpoints, err := decode(readFixture(t, tt.fixture), saveEvery)
+				must(t, err)
+				assert.Equal(t, len(tt.output), len(out))
+				assert.True(t, bytes.Equal(tt.output, out), "output differs")
+				if saveEvery == 1 && len(tt.output) > 4096 {
+					assert.NotZero(t, numCheckpoints)
+				}
+			})
+		}
+	}
+}
+
+func Test_Corrupt(t *testing.T) {
+	// default.zst has a 4-byte content size, its first block is right after
+	const defaultBlock = 4 + 1 + 4
+	// no-check.zst has a window descriptor instead
+	const noCheckBlock = 4 + 1 + 1
+
+	with := func(change func(data []byte) []byte) func(data []byte) []byte {
+		return change
+	}
+	xor := func(offset int, mask byte) func(data []byte) []byte {
+		return func(data []byte) []byte {
+			if offset < 0 {
+				offset += len(data)
+			}
+			data[offset] ^= mask
+			return data
+		}
+	}
+	truncate := func(size int) func(data []byte) []byte {
+		return func(data []byte) []byte {
+			if size < 0 {
+				size += len(data)
+			}
+			return data[:size]
+		}
+	}
+
+	tests := []struct {
+		name    string
+		fixture string
+		corrupt func(data []byte) []byte
+		err     error
+	}{
+		{"bad magic", "default.zst", xor(0, 0x01), zstd.ErrCorrupt},
+		{"reserved bit", "default.zst", xor(4, 1<<3), zstd.ErrCorrupt},
+		{"bad checksum", "default.zst", xor(-1, 0x01), zstd.ErrChecksum},
+		{"wrong content size", "default.zst", xor(5, 0x01), zstd.ErrCorrupt},
+		{"reserved block type", "no-check.zst", xor(noCheckBlock, 3<<1), zstd.ErrCorrupt},
+		{"block too large", "default.zst", xor(defaultBlock+2, 0x80), zstd.ErrCorrupt},
+		{"bad compressed block", "default.zst", with(func(data []byte) []byte {
+			// garbage in place of the literals section header
+			copy(data[defaultBlock+3:], []byte{0xff, 0xff, 0xff, 0xff})
+			return data
+		}), zstd.ErrCorrupt},
+		{"nothing", "empty.zst", truncate(0), io.ErrUnexpectedEOF},
+		{"truncated magic", "default.zst", truncate(2), io.ErrUnexpectedEOF},
+		{"truncated frame header", "default.zst", truncate(6), io.ErrUnexpectedEOF},
+		{"truncated block header", "default.zst", truncate(defaultBlock + 1), io.ErrUnexpectedEOF},
+		{"truncated block", "default.zst", truncate(defaultBlock + 100), io.ErrUnexpectedEOF},
+		{"truncated raw block", "random.zst", truncate(-100), io.ErrUnexpectedEOF},
+		{"truncated rle block", "zeroes.zst", truncate(noCheckBlock + 3), io.ErrUnexpectedEOF},
+		{"truncated checksum", "default.zst", truncate(-1), io.ErrUnexpectedEOF},
+		{"truncated skippable frame", "multiframe.zst", truncate(4 + 4 + 2), io.ErrUnexpectedEOF},
+		{"trailing garbage", "default.zst", with(func(data []byte) []byte {
+			return append(data, []byte("garbage!")...)
+		}), zstd.ErrCorrupt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.corrupt(readFixture(t, tt.fixture))
+			_, _, err := decode(data, 0)
+			assert.True(t, errors.Is(err, tt.err), "expected %v, got %v", tt.err, err)
+		})
+	}
+}
+
+func Test_Unsupported(t *testing.T) {
+	data := readFixture(t, "no-check.zst")
+
+	// a dictionary ID of one byte, set to 1
+	withDict := append([]byte(nil), data[:4]...)
+	withDict = append(withDict, data[4]|1, data[5], 1)
+	withDict = append(withDict, data[6:]...)
+	_, _, err := decode(withDict, 0)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "dictionaries are not supported")
+	}
+
+	// a 2GiB window
+	hugeWindow := append([]byte(nil), data...)
+	hugeWindow[5] = (31 - 10) << 3
+	_, _, err = decode(hugeWindow, 0)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "exceeds limit")
+	}
+}
+
+func Test_CorruptData(t *testing.T) {
+	input := readInput(t)
+
+	// corrupt data may decode to something else without a checksum,
+	// but it must never make the decoder panic or go on forever, and
+	// the checksum must catch it otherwise
+	for _, fixture := range []string{"default.zst", "no-check.zst", "window1k.zst"} {
+		t.Run(fixture, func(t *testing.T) {
+			data := readFixture(t, fixture)
+			hasChecksum := data[4]&(1<<2) != 0
+			for offset := 4; offset < len(data); offset += 151 {
+				corrupt := append([]byte(nil), data...)
+				corrupt[offset] ^= 0x55
+				out, _, err := decode(corrupt, 0)
+				if err == nil && hasChecksum && !bytes.Equal(input, out) {
+					t.Errorf("corruption at %d went unnoticed", offset)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package savior
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Default values for Limits
+const (
+	DefaultMaxEntries    = 1000 * 1000
+	DefaultMaxPathDepth  = 128
+	DefaultMaxPathLength = 4096
+)
+
+// Values used by StrictLimits. They're not defaults, since legitimate
+// archives can exceed them: deflate can't go much over 1032:1, but
+// bzip2, xz and zstd can.
+const (
+	StrictMaxTotalBytes = 128 * 1024 * 1024 * 1024
+	StrictMaxRatio      = 2000
+)
+
+// compression ratios aren't checked for entries smaller
+// than this, since small files can compress extremely well
+// without being a threat.
+const ratioMinSize = 1024 * 1024
+
+// Names of limits, as found in LimitError
+const (
+	LimitTotalBytes   = "total bytes"
+	LimitEntries      = "entries"
+	LimitRatio        = "compression ratio"
+	LimitPathDepth    = "path depth"
+	LimitPathLength   = "path length"
+	LimitDeclaredSize = "declared size"
+)
+
+// Limits protect against archives that would exhaust resources when
+// extracted, like zip bombs. Limits are disabled when negative. When zero,
+// MaxTotalBytes and MaxRatio are disabled too, and the others use their
+// default value, see StrictLimits for tighter ones.
+//
+// Entries are checked as soon as they're known (up front for zip, header
+// by header for tar), and while they're being copied: an entry that inflates
+// beyond its declared size is always an error. Entries whose sizes aren't
+// known until they're extracted are checked against what was read and
+// extracted so far instead, see CheckStreamProgress.
+type Limits struct {
+	// Maximum number of bytes extracted, in total. None by default.
+	MaxTotalBytes int64
+	// Maximum number of entries in the archive
+	MaxEntries int64
+	// Maximum ratio between the uncompressed and compressed size of an
+	// entry, when the latter is known. None by default.
+	MaxRatio float64
+	// Maximum number of components in an entry's path
+	MaxPathDepth int64
+	// Maximum length of an entry's path, in bytes
+	MaxPathLength int64
+}
+
+// A Limitable extractor can enforce Limits. Those that implement
+// it use the zero value of Limits unless told otherwise.
+type Limitable interface {
+	SetLimits(limits *Limits)
+}
+
+// StrictLimits returns limits that also cap the total extracted size and
+// the compression ratio, for archives that aren't trusted. Archives that
+// compress extremely well, or are very large, may need higher values.
+func StrictLimits() *Limits {
+	return &Limits{
+		MaxTotalBytes: StrictMaxTotalBytes,
+		MaxRatio:      StrictMaxRatio,
+	}
+}
+
+// NoLimits returns limits that are all disabled.
+func NoLimits() *Limits {
+	return &Limits{
+		MaxTotalBytes: -1,
+		MaxEntries:    -1,
+		MaxRatio:      -1,
+		MaxPathDepth:  -1,
+		MaxPathLength: -1,
+	}
+}
+
+// A LimitError is returned when extraction is
+// aborted because an archive exceeds a limit.
+type LimitError struct {
+	// Limit is the name of the limit that was exceeded, like LimitTotalBytes
+	Limit string
+	// Path of the offending entry, if any
+	Path string
+	// Value is what exceeded the limit
+	Value float64
+	// Max is the limit that was exceeded
+	Max float64
+}
+
+var _ error = (*LimitError)(nil)
+
+func (le *LimitError) Error() string {
+	msg := fmt.Sprintf("%s limit exceeded (%s > %s)", le.Limit, formatLimit(le.Value), formatLimit(le.Max))
+	if le.Path != "" {
+		msg += fmt.Sprintf(" for %s", le.Path)
+	}
+	return msg
+}
+
+func formatLimit(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// pickLimit returns 0 for disabled limits
+func pickLimit(value int64, def int64) int64 {
+	if value == 0 {
+		return def
+	}
+	if value < 0 {
+		return 0
+	}
+	return value
+}
+
+// maxRatio returns 0 when the ratio isn't limited
+func (l *Limits) maxRatio() float64 {
+	if l.MaxRatio < 0 {
+		return 0
+	}
+	return l.MaxRatio
+}
+
+// CheckEntry checks the path of an entry and its declared sizes.
+func (l *Limits) CheckEntry(entry *Entry) error {
+	name := strings.TrimSuffix(entry.CanonicalPath, "/")
+
+	if max := pickLimit(l.MaxPathLength, DefaultMaxPathLength); max > 0 && int64(len(name)) > max {
+		return &LimitError{Limit: LimitPathLength, Path: entry.CanonicalPath, Value: float64(len(name)), Max: float64(max)}
+	}
+
+	if max := pickLimit(l.MaxPathDepth, DefaultMaxPathDepth); max > 0 {
+		depth := int64(strings.Count(name, "/") + 1)
+		if depth > max {
+			return &LimitError{Limit: LimitPathDepth, Path: entry.CanonicalPath, Value: float64(depth), Max: float64(max)}
+		}
+	}
+
+	if max := pickLimit(l.MaxTotalBytes, 0); max > 0 && entry.UncompressedSize > max {
+		return &LimitError{Limit: LimitTotalBytes, Path: entry.CanonicalPath, Value: float64(entry.UncompressedSize), Max: float64(max)}
+	}
+
+	return l.checkRatio(entry, entry.CompressedSize, entry.UncompressedSize)
+}
+
+// CheckTotals checks the number of entries of an archive, and the total
+// number of bytes they hold.
+func (l *Limits) CheckTotals(numEntries int64, totalBytes int64) error {
+	if max := pickLimit(l.MaxEntries, DefaultMaxEntries); max > 0 && numEntries > max {
+		return &LimitError{Limit: LimitEntries, Value: float64(numEntries), Max: float64(max)}
+	}
+
+	if max := pickLimit(l.MaxTotalBytes, 0); max > 0 && totalBytes > max {
+		return &LimitError{Limit: LimitTotalBytes, Value: float64(totalBytes), Max: float64(max)}
+	}
+
+	return nil
+}
+
+// CheckProgress checks that an entry, of which `written` bytes have been
+// extracted so far, doesn't exceed its declared size or the maximum ratio.
+func (l *Limits) CheckProgress(entry *Entry, written int64) error {
+	if written > entry.UncompressedSize {
+		return &LimitError{Limit: LimitDeclaredSize, Path: entry.CanonicalPath, Value: float64(written), Max: float64(entry.UncompressedSize)}
+	}
+
+	return l.checkRatio(entry, entry.CompressedSize, written)
+}
+
+// CheckStreamProgress checks an entry whose sizes aren't known until it's
+// extracted, like a streamed zip entry with a data descriptor, of which
+// `written` bytes have been extracted so far from `compressed` bytes, after
+// `totalBytes` bytes for the entries before it.
+func (l *Limits) CheckStreamProgress(entry *Entry, compressed int64, written int64, totalBytes int64) error {
+	if max := pickLimit(l.MaxTotalBytes, 0); max > 0 && totalBytes+written > max {
+		return &LimitError{Limit: LimitTotalBytes, Path: entry.CanonicalPath, Value: float64(totalBytes + written), Max: float64(max)}
+	}
+
+	return l.checkRatio(entry, compressed, written)
+}
+
+func (l *Limits) checkRatio(entry *Entry, compressed int64, size int64) error {
+	max := l.maxRatio()
+	if max == 0 || compressed <= 0 || size < ratioMinSize {
+		return nil
+	}
+
+	ratio := float64(size) / float64(compressed)
+	if ratio > max {
+		return &LimitError{Limit: LimitRatio, Path: entry.CanonicalPath, Value: ratio, Max: max}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package savior_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Limits(t *testing.T) {
+	limit := func(err error) string {
+		var le *savior.LimitError
+		if errors.As(err, &le) {
+			return le.Limit
+		}
+		return ""
+	}
+
+	defaults := &savior.Limits{}
+	assert.NoError(t, defaults.CheckEntry(&savior.Entry{CanonicalPath: "a/b/c/"}))
+
+	deep := &savior.Entry{CanonicalPath: "a/b/c/d/"}
+	limits := &savior.Limits{MaxPathDepth: 3}
+	assert.EqualValues(t, savior.LimitPathDepth, limit(limits.CheckEntry(deep)))
+	assert.NoError(t, savior.NoLimits().CheckEntry(deep))
+
+	assert.EqualValues(t, savior.LimitEntries, limit(defaults.CheckTotals(savior.DefaultMaxEntries+1, 0)))
+	assert.NoError(t, savior.NoLimits().CheckTotals(savior.DefaultMaxEntries+1, 0))
+
+	// the total size and ratio are only limited when asked for
+	strict := savior.StrictLimits()
+	assert.NoError(t, defaults.CheckTotals(1, savior.StrictMaxTotalBytes+1))
+	assert.EqualValues(t, savior.LimitTotalBytes, limit(strict.CheckTotals(1, savior.StrictMaxTotalBytes+1)))
+
+	entry := &savior.Entry{
+		CanonicalPath:    "zeroes",
+		CompressedSize:   1024,
+		UncompressedSize: 64 * 1024 * 1024,
+	}
+	// 64MiB from 1KiB is way over the strict ratio, but
+	// small entries are never checked
+	assert.NoError(t, defaults.CheckEntry(entry))
+	assert.EqualValues(t, savior.LimitRatio, limit(strict.CheckEntry(entry)))
+	assert.NoError(t, strict.CheckProgress(entry, 1024))
+	assert.EqualValues(t, savior.LimitRatio, limit(strict.CheckProgress(entry, 4*1024*1024)))
+
+	// going past the declared size is always an error
+	assert.EqualValues(t, savior.LimitDeclaredSize, limit(savior.NoLimits().CheckProgress(entry, entry.UncompressedSize+1)))
+
+	// entries of unknown size are checked against what was read so far
+	unknown := &savior.Entry{CanonicalPath: "descriptor"}
+	assert.NoError(t, defaults.CheckStreamProgress(unknown, 1024, 64*1024*1024, 0))
+	assert.NoError(t, strict.CheckStreamProgress(unknown, 1024*1024, 64*1024*1024, 0))
+	assert.EqualValues(t, savior.LimitRatio, limit(strict.CheckStreamProgress(unknown, 1024, 4*1024*1024, 0)))
+	assert.EqualValues(t, savior.LimitTotalBytes, limit(strict.CheckStreamProgress(unknown, 1024*1024, 1024, savior.StrictMaxTotalBytes)))
+}
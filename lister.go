@@ -0,0 +1,48 @@
+package savior
+
+import "github.com/pkg/errors"
+
+// ErrListingNotSupported is returned by List when an extractor
+// can't enumerate its entries without extracting them.
+var ErrListingNotSupported = errors.New("extractor does not support listing entries")
+
+// ErrEntryNotFound is returned when looking up an entry
+// by name, and the archive doesn't have it.
+var ErrEntryNotFound = errors.New("entry not found in archive")
+
+// ListEntryFunc is called for each entry of an archive, in order.
+// Returning an error stops the listing, and is returned by List.
+type ListEntryFunc func(entry *Entry) error
+
+// A Lister is an extractor that can enumerate the entries of an archive
+// without writing anything, so their total size can be known before
+// committing disk space, for example.
+//
+// Listers honor the extractor's Filter, if any, and don't emit checkpoints.
+// Symlink entries may not have their Linkname set, if that requires
+// decompressing them.
+type Lister interface {
+	List(onEntry ListEntryFunc) error
+}
+
+// List returns all the entries of an archive, as an ExtractorResult, so that
+// Size() and Stats() can be used on them. It returns ErrListingNotSupported
+// if the extractor isn't a Lister.
+func List(ex Extractor) (*ExtractorResult, error) {
+	lister, ok := ex.(Lister)
+	if !ok {
+		return nil, errors.WithStack(ErrListingNotSupported)
+	}
+
+	res := &ExtractorResult{
+		Entries: []*Entry{},
+	}
+	err := lister.List(func(entry *Entry) error {
+		res.Entries = append(res.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return res, nil
+}
@@ -0,0 +1,199 @@
+package lz4source
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/internal/lz4"
+	"github.com/pkg/errors"
+)
+
+type lz4Source struct {
+	// input
+	source savior.Source
+
+	// internal
+	sr      lz4.SaverReader
+	offset  int64
+	bytebuf []byte
+
+	ssc              savior.SourceSaveConsumer
+	sourceCheckpoint *savior.SourceCheckpoint
+}
+
+type Lz4SourceCheckpoint struct {
+	Offset           int64
+	SourceCheckpoint *savior.SourceCheckpoint
+	Lz4Checkpoint    *lz4.Checkpoint
+}
+
+var _ savior.Source = (*lz4Source)(nil)
+
+func New(source savior.Source) *lz4Source {
+	return &lz4Source{
+		source:  source,
+		bytebuf: []byte{0x00},
+	}
+}
+
+func (ls *lz4Source) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "lz4",
+		ResumeSupport: savior.ResumeSupportBlock,
+	}
+}
+
+func (ls *lz4Source) Fingerprint() (*savior.Fingerprint, error) {
+	return savior.SourceFingerprint(ls.source)
+}
+
+func (ls *lz4Source) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	ls.ssc = ssc
+	ls.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			ls.sourceCheckpoint = checkpoint
+			ls.sr.WantSave()
+			return nil
+		},
+	})
+}
+
+func (ls *lz4Source) WantSave() {
+	ls.source.WantSave()
+}
+
+func (ls *lz4Source) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	if checkpoint != nil {
+		if ourCheckpoint, ok := checkpoint.Data.(*Lz4SourceCheckpoint); ok {
+			sourceOffset, err := ls.source.Resume(ourCheckpoint.SourceCheckpoint)
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+
+			gc := ourCheckpoint.Lz4Checkpoint
+			if sourceOffset < gc.Roffset {
+				delta := gc.Roffset - sourceOffset
+				savior.Debugf(`lz4source: discarding %d bytes to align source with decompressor`, delta)
+				err = savior.DiscardByRead(ls.source, delta)
+				if err != nil {
+					return 0, errors.WithStack(err)
+				}
+				sourceOffset += delta
+			}
+
+			if sourceOffset == gc.Roffset {
+				ls.sr, err = gc.Resume(ls.source)
+				if err != nil {
+					savior.Debugf(`lz4source: could not use lz4 checkpoint at R=%d`, gc.Roffset)
+					// well, let's start over
+					_, err = ls.source.Resume(nil)
+					if err != nil {
+						return 0, errors.WithStack(err)
+					}
+				} else {
+					ls.offset = ourCheckpoint.Offset
+					return ls.offset, nil
+				}
+			} else {
+				savior.Debugf(`lz4source: expected source to resume at %d but got %d`, gc.Roffset, sourceOffset)
+			}
+		}
+	}
+
+	// start from beginning
+	sourceOffset, err := ls.source.Resume(nil)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if sourceOffset != 0 {
+		msg := fmt.Sprintf("lz4source: expected source to resume at start but got %d", sourceOffset)
+		return 0, errors.New(msg)
+	}
+
+	ls.sr = lz4.NewSaverReader(ls.source)
+
+	ls.offset = 0
+	return 0, nil
+}
+
+func (ls *lz4Source) Read(buf []byte) (int, error) {
+	if ls.sr == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	n, err := ls.sr.Read(buf)
+	ls.offset += int64(n)
+
+	if err == lz4.ReadyToSaveError {
+		err = nil
+
+		if ls.sourceCheckpoint == nil {
+			savior.Debugf("lz4source: can't save, sourceCheckpoint is nil!")
+		} else if ls.ssc == nil {
+			savior.Debugf("lz4source: can't save, ssc is nil!")
+		} else {
+			lz4Checkpoint, saveErr := ls.sr.Save()
+			if saveErr != nil {
+				return n, saveErr
+			}
+
+			savior.Debugf("lz4source: saving, lz4 rOffset = %d, sourceCheckpoint.Offset = %d", lz4Checkpoint.Roffset, ls.sourceCheckpoint.Offset)
+
+			checkpoint := &savior.SourceCheckpoint{
+				Offset: ls.offset,
+				Data: &Lz4SourceCheckpoint{
+					Offset:           ls.offset,
+					Lz4Checkpoint:    lz4Checkpoint,
+					SourceCheckpoint: ls.sourceCheckpoint,
+				},
+			}
+			ls.sourceCheckpoint = nil
+
+			err = ls.ssc.Save(checkpoint)
+			savior.Debugf("lz4source: saved checkpoint at byte %d", ls.offset)
+		}
+	}
+
+	return n, err
+}
+
+func (ls *lz4Source) ReadByte() (byte, error) {
+	if ls.sr == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	n, err := ls.Read(ls.bytebuf)
+	if n == 0 {
+		/* this happens when Read needs to save, but it swallows the error */
+		/* we're not meant to surface them, but there's no way to handle a */
+		/* short read from ReadByte, so we just read again */
+		n, err = ls.Read(ls.bytebuf)
+	}
+
+	return ls.bytebuf[0], err
+}
+
+func (ls *lz4Source) Progress() float64 {
+	// We can't tell how large the uncompressed stream is until we finish
+	// decompressing it. The underlying's source progress is a good enough
+	// approximation.
+	return ls.source.Progress()
+}
+
+func init() {
+	gob.Register(&Lz4SourceCheckpoint{})
+	savior.RegisterCheckpointType("lz4source.Lz4SourceCheckpoint", &Lz4SourceCheckpoint{})
+
+	savior.RegisterCompressionFormat(savior.CompressionFormat{
+		Name: "lz4",
+		Match: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte{0x04, 0x22, 0x4D, 0x18})
+		},
+		NewSource: func(source savior.Source) savior.Source {
+			return New(source)
+		},
+	})
+}
@@ -0,0 +1,50 @@
+package lz4source_test
+
+import (
+	"log"
+	"testing"
+
+	"github.com/itchio/headway/united"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/lz4source"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Uninitialized(t *testing.T) {
+	{
+		ss := seeksource.FromBytes(nil)
+		_, err := ss.Resume(nil)
+		assert.NoError(t, err)
+
+		ls := lz4source.New(ss)
+		_, err = ls.Read([]byte{})
+		assert.Error(t, err)
+		assert.True(t, errors.Cause(err) == savior.ErrUninitializedSource)
+
+		_, err = ls.ReadByte()
+		assert.Error(t, err)
+		assert.True(t, errors.Cause(err) == savior.ErrUninitializedSource)
+	}
+}
+
+func Test_Checkpoints(t *testing.T) {
+	reference := semirandom.Bytes(4 * 1024 * 1024 /* 4 MiB of random data */)
+
+	for _, linkedBlocks := range []bool{false, true} {
+		compressed, err := checker.Lz4Compress(reference, linkedBlocks)
+		assert.NoError(t, err)
+
+		log.Printf("linked blocks: %v", linkedBlocks)
+		log.Printf("uncompressed size: %s", united.FormatBytes(int64(len(reference))))
+		log.Printf("  compressed size: %s", united.FormatBytes(int64(len(compressed))))
+
+		source := seeksource.FromBytes(compressed)
+		ls := lz4source.New(source)
+
+		checker.RunSourceTest(t, ls, reference)
+	}
+}
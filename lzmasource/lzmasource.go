@@ -0,0 +1,283 @@
+package lzmasource
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/internal/lzma"
+	"github.com/pkg/errors"
+)
+
+// Format is how an LZMA stream is framed
+type Format int
+
+const (
+	// FormatAlone is the format of .lzma files: 5 bytes of properties,
+	// followed by the uncompressed size on 8 bytes (all ones if unknown)
+	FormatAlone Format = iota
+	// FormatZip is how zip entries compressed with LZMA (method 14) are
+	// stored: the LZMA SDK version and the length of the properties on
+	// 2 bytes each, followed by the properties.
+	FormatZip
+)
+
+type lzmaSource struct {
+	// input
+	source savior.Source
+	format Format
+	size   int64
+
+	// internal
+	sr         lzma.SaverReader
+	headerSize int64
+	offset     int64
+	bytebuf    []byte
+
+	ssc              savior.SourceSaveConsumer
+	sourceCheckpoint *savior.SourceCheckpoint
+}
+
+type LzmaSourceCheckpoint struct {
+	Offset           int64
+	SourceCheckpoint *savior.SourceCheckpoint
+	// HeaderSize is the number of bytes before the LZMA stream,
+	// which LzmaCheckpoint.Roffset doesn't count.
+	HeaderSize     int64
+	LzmaCheckpoint *lzma.Checkpoint
+}
+
+var _ savior.Source = (*lzmaSource)(nil)
+
+// New returns a source that decompresses a .lzma stream
+func New(source savior.Source) *lzmaSource {
+	return &lzmaSource{
+		source:  source,
+		format:  FormatAlone,
+		size:    -1,
+		bytebuf: []byte{0x00},
+	}
+}
+
+// NewZip returns a source that decompresses the contents of an LZMA-compressed zip
+// entry. If size is negative, the stream must be terminated by an end marker.
+func NewZip(source savior.Source, size int64) *lzmaSource {
+	return &lzmaSource{
+		source:  source,
+		format:  FormatZip,
+		size:    size,
+		bytebuf: []byte{0x00},
+	}
+}
+
+func (ls *lzmaSource) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "lzma",
+		ResumeSupport: savior.ResumeSupportBlock,
+	}
+}
+
+func (ls *lzmaSource) Fingerprint() (*savior.Fingerprint, error) {
+	return savior.SourceFingerprint(ls.source)
+}
+
+func (ls *lzmaSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	ls.ssc = ssc
+	ls.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			ls.sourceCheckpoint = checkpoint
+			if ls.sr != nil {
+				ls.sr.WantSave()
+			}
+			return nil
+		},
+	})
+}
+
+func (ls *lzmaSource) WantSave() {
+	ls.source.WantSave()
+}
+
+func (ls *lzmaSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	if checkpoint != nil {
+		if ourCheckpoint, ok := checkpoint.Data.(*LzmaSourceCheckpoint); ok {
+			sourceOffset, err := ls.source.Resume(ourCheckpoint.SourceCheckpoint)
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+
+			lc := ourCheckpoint.LzmaCheckpoint
+			roffset := ourCheckpoint.HeaderSize + lc.Roffset
+			if sourceOffset < roffset {
+				delta := roffset - sourceOffset
+				savior.Debugf(`lzmasource: discarding %d bytes to align source with decompressor`, delta)
+				err = savior.DiscardByRead(ls.source, delta)
+				if err != nil {
+					return 0, errors.WithStack(err)
+				}
+				sourceOffset += delta
+			}
+
+			if sourceOffset == roffset {
+				ls.sr, err = lc.Resume(ls.source)
+				if err != nil {
+					savior.Debugf(`lzmasource: could not use lzma checkpoint at R=%d`, roffset)
+					// well, let's start over
+					_, err = ls.source.Resume(nil)
+					if err != nil {
+						return 0, errors.WithStack(err)
+					}
+				} else {
+					ls.headerSize = ourCheckpoint.HeaderSize
+					ls.offset = ourCheckpoint.Offset
+					return ls.offset, nil
+				}
+			} else {
+				savior.Debugf(`lzmasource: expected source to resume at %d but got %d`, roffset, sourceOffset)
+			}
+		}
+	}
+
+	// start from beginning
+	sourceOffset, err := ls.source.Resume(nil)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if sourceOffset != 0 {
+		msg := fmt.Sprintf("lzmasource: expected source to resume at start but got %d", sourceOffset)
+		return 0, errors.New(msg)
+	}
+
+	// don't let a pending save reach the previous decompressor
+	ls.sr = nil
+	props, size, err := ls.readHeader()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	ls.sr = lzma.NewReader(ls.source, props, size)
+
+	ls.offset = 0
+	return 0, nil
+}
+
+func (ls *lzmaSource) readHeader() (lzma.Props, int64, error) {
+	switch ls.format {
+	case FormatAlone:
+		header := make([]byte, 13)
+		_, err := io.ReadFull(ls.source, header)
+		if err != nil {
+			return lzma.Props{}, 0, errors.WithStack(err)
+		}
+		ls.headerSize = int64(len(header))
+
+		props, err := lzma.DecodeProps(header[:5])
+		if err != nil {
+			return lzma.Props{}, 0, errors.WithStack(err)
+		}
+
+		size := binary.LittleEndian.Uint64(header[5:])
+		if size == math.MaxUint64 || size > math.MaxInt64 {
+			return props, -1, nil
+		}
+		return props, int64(size), nil
+	case FormatZip:
+		header := make([]byte, 4)
+		_, err := io.ReadFull(ls.source, header)
+		if err != nil {
+			return lzma.Props{}, 0, errors.WithStack(err)
+		}
+
+		propsSize := binary.LittleEndian.Uint16(header[2:])
+		if propsSize < 5 {
+			return lzma.Props{}, 0, errors.Errorf("lzmasource: invalid properties size %d", propsSize)
+		}
+
+		propsBytes := make([]byte, propsSize)
+		_, err = io.ReadFull(ls.source, propsBytes)
+		if err != nil {
+			return lzma.Props{}, 0, errors.WithStack(err)
+		}
+		ls.headerSize = int64(len(header)) + int64(propsSize)
+
+		props, err := lzma.DecodeProps(propsBytes)
+		if err != nil {
+			return lzma.Props{}, 0, errors.WithStack(err)
+		}
+		return props, ls.size, nil
+	default:
+		return lzma.Props{}, 0, errors.Errorf("lzmasource: unknown format %d", ls.format)
+	}
+}
+
+func (ls *lzmaSource) Read(buf []byte) (int, error) {
+	if ls.sr == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	n, err := ls.sr.Read(buf)
+	ls.offset += int64(n)
+
+	if err == lzma.ReadyToSaveError {
+		err = nil
+
+		if ls.sourceCheckpoint == nil {
+			savior.Debugf("lzmasource: can't save, sourceCheckpoint is nil!")
+		} else if ls.ssc == nil {
+			savior.Debugf("lzmasource: can't save, ssc is nil!")
+		} else {
+			lzmaCheckpoint, saveErr := ls.sr.Save()
+			if saveErr != nil {
+				return n, saveErr
+			}
+
+			savior.Debugf("lzmasource: saving, lzma rOffset = %d, sourceCheckpoint.Offset = %d", lzmaCheckpoint.Roffset, ls.sourceCheckpoint.Offset)
+
+			checkpoint := &savior.SourceCheckpoint{
+				Offset: ls.offset,
+				Data: &LzmaSourceCheckpoint{
+					Offset:           ls.offset,
+					SourceCheckpoint: ls.sourceCheckpoint,
+					HeaderSize:       ls.headerSize,
+					LzmaCheckpoint:   lzmaCheckpoint,
+				},
+			}
+			ls.sourceCheckpoint = nil
+
+			err = ls.ssc.Save(checkpoint)
+			savior.Debugf("lzmasource: saved checkpoint at byte %d", ls.offset)
+		}
+	}
+
+	return n, err
+}
+
+func (ls *lzmaSource) ReadByte() (byte, error) {
+	if ls.sr == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	n, err := ls.Read(ls.bytebuf)
+	if n == 0 {
+		/* this happens when Read needs to save, but it swallows the error */
+		/* we're not meant to surface them, but there's no way to handle a */
+		/* short read from ReadByte, so we just read again */
+		n, err = ls.Read(ls.bytebuf)
+	}
+
+	return ls.bytebuf[0], err
+}
+
+func (ls *lzmaSource) Progress() float64 {
+	// The underlying's source progress is a good enough approximation.
+	return ls.source.Progress()
+}
+
+func init() {
+	gob.Register(&LzmaSourceCheckpoint{})
+	savior.RegisterCheckpointType("lzmasource.LzmaSourceCheckpoint", &LzmaSourceCheckpoint{})
+}
@@ -0,0 +1,59 @@
+package lzmasource_test
+
+import (
+	"log"
+	"testing"
+
+	"github.com/itchio/headway/united"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/lzmasource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Uninitialized(t *testing.T) {
+	{
+		ss := seeksource.FromBytes(nil)
+		_, err := ss.Resume(nil)
+		assert.NoError(t, err)
+
+		ls := lzmasource.New(ss)
+		_, err = ls.Read([]byte{})
+		assert.Error(t, err)
+		assert.True(t, errors.Cause(err) == savior.ErrUninitializedSource)
+
+		_, err = ls.ReadByte()
+		assert.Error(t, err)
+		assert.True(t, errors.Cause(err) == savior.ErrUninitializedSource)
+	}
+}
+
+func Test_Checkpoints(t *testing.T) {
+	reference := semirandom.Bytes(4 * 1024 * 1024 /* 4 MiB of random data */)
+	compressed, err := checker.LzmaCompress(reference)
+	assert.NoError(t, err)
+
+	log.Printf("uncompressed size: %s", united.FormatBytes(int64(len(reference))))
+	log.Printf("  compressed size: %s", united.FormatBytes(int64(len(compressed))))
+
+	source := seeksource.FromBytes(compressed)
+	ls := lzmasource.New(source)
+
+	checker.RunSourceTest(t, ls, reference)
+}
+
+func Test_Zip(t *testing.T) {
+	reference := semirandom.Bytes(4 * 1024 * 1024 /* 4 MiB of random data */)
+	zipped, err := checker.LzmaZipCompress(reference)
+	assert.NoError(t, err)
+
+	for _, size := range []int64{-1, int64(len(reference))} {
+		source := seeksource.FromBytes(zipped)
+		ls := lzmasource.NewZip(source, size)
+
+		checker.RunSourceTest(t, ls, reference)
+	}
+}
@@ -14,7 +14,7 @@ type NopSink struct {
 	writer *nopEntryWriter
 }
 
-var _ Sink = (*NopSink)(nil)
+var _ ConcurrentSink = (*NopSink)(nil)
 
 func (ns *NopSink) destPath(entry *Entry) string {
 	return filepath.Join(ns.Directory, filepath.FromSlash(entry.CanonicalPath))
@@ -28,6 +28,10 @@ func (ns *NopSink) GetWriter(entry *Entry) (EntryWriter, error) {
 	return NewNopEntryWriter(), nil
 }
 
+func (ns *NopSink) GetConcurrentWriter(entry *Entry) (EntryWriter, error) {
+	return NewNopEntryWriter(), nil
+}
+
 func (ns *NopSink) Preallocate(entry *Entry) error {
 	return nil
 }
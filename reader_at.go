@@ -0,0 +1,51 @@
+package savior
+
+import (
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+type seekSourceReaderAt struct {
+	source  SeekSource
+	resumed bool
+	lock    sync.Mutex
+}
+
+var _ io.ReaderAt = (*seekSourceReaderAt)(nil)
+
+// NewReaderAt returns an io.ReaderAt that reads from a SeekSource, by
+// resuming it at the requested offset. It's safe for concurrent use, but
+// the SeekSource shouldn't be used directly while the io.ReaderAt is in use.
+func NewReaderAt(source SeekSource) io.ReaderAt {
+	return &seekSourceReaderAt{
+		source: source,
+	}
+}
+
+func (ra *seekSourceReaderAt) ReadAt(buf []byte, offset int64) (int, error) {
+	ra.lock.Lock()
+	defer ra.lock.Unlock()
+
+	if offset < 0 {
+		return 0, errors.New("ReadAt: negative offset")
+	}
+	if offset >= ra.source.Size() {
+		return 0, io.EOF
+	}
+
+	if !ra.resumed || ra.source.Tell() != offset {
+		_, err := ra.source.Resume(&SourceCheckpoint{Offset: offset})
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		ra.resumed = true
+	}
+
+	n, err := io.ReadFull(ra.source, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -22,6 +22,8 @@ type seekSource struct {
 	sectionStart int64
 	offset       int64
 	size         int64
+
+	fingerprint *savior.Fingerprint
 }
 
 var _ savior.SeekSource = (*seekSource)(nil)
@@ -59,6 +61,36 @@ func (ss *seekSource) Features() savior.SourceFeatures {
 	}
 }
 
+// Fingerprint hashes the first bytes of the source, see savior.Fingerprinter.
+// It's computed once, and can be called at any time.
+func (ss *seekSource) Fingerprint() (*savior.Fingerprint, error) {
+	if ss.fingerprint != nil {
+		return ss.fingerprint, nil
+	}
+
+	_, err := ss.rs.Seek(ss.sectionStart, io.SeekStart)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	fingerprint, err := savior.NewFingerprint(ss.rs, ss.size)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if ss.br != nil {
+		// we were being read from, go back to where we were
+		_, err = ss.rs.Seek(ss.sectionStart+ss.offset, io.SeekStart)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		ss.br.Reset(ss.rs)
+	}
+
+	ss.fingerprint = fingerprint
+	return fingerprint, nil
+}
+
 func (ss *seekSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
 	savior.Debugf("seeksource: set source save consumer!")
 	ss.ssc = ssc
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/itchio/headway/united"
+	"github.com/pkg/errors"
 )
 
 type EntryKind int
@@ -59,6 +61,14 @@ type Entry struct {
 	// Linkname describes the target of a symlink if the entry is a symlink
 	// and the format we're extracting has symlinks in metadata rather than its contents
 	Linkname string
+
+	// ModTime is the last modification time, it may be zero if the
+	// extractor doesn't have the information
+	ModTime time.Time
+
+	// AccessTime is the last access time, it's zero unless the format
+	// we're extracting records it
+	AccessTime time.Time
 }
 
 func (entry *Entry) String() string {
@@ -89,7 +99,8 @@ type EntryWriter interface {
 // Think of it as a very thin slice of the `os` package that can be
 // implemented completely independently of the filesystem.
 type Sink interface {
-	// Mkdir creates a directory (and parents if needed)
+	// Mkdir creates a directory (and parents if needed). It may be
+	// called again for the same entry when resuming, see RemakeDirs.
 	Mkdir(entry *Entry) error
 
 	// Symlink creates a symlink
@@ -108,3 +119,54 @@ type Sink interface {
 	// Close this sink, including all pending writers
 	Close() error
 }
+
+// A ConcurrentSink is a Sink that can be used from several goroutines at
+// once, by extractors that extract several entries at the same time.
+type ConcurrentSink interface {
+	Sink
+
+	// GetConcurrentWriter returns a writer at entry.WriteOffset, like
+	// GetWriter, except previously returned writers are left open.
+	// The caller is responsible for closing it.
+	GetConcurrentWriter(entry *Entry) (EntryWriter, error)
+}
+
+// An EntryFinisher is a Sink that wants to know when a file entry has
+// been extracted in full and verified, for example to set its times
+// last. It's not told about entries that were stopped, cancelled, or
+// failed along the way.
+type EntryFinisher interface {
+	// FinishEntry is called once a file entry is done, after
+	// its writer was last used.
+	FinishEntry(entry *Entry) error
+}
+
+// FinishEntry calls sink.FinishEntry if the sink is an EntryFinisher,
+// see EntryFinisher. Extractors call it for each verified file entry.
+func FinishEntry(sink Sink, entry *Entry) error {
+	if ef, ok := sink.(EntryFinisher); ok {
+		err := ef.FinishEntry(entry)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// RemakeDirs calls sink.Mkdir for the directories among entries, which
+// were extracted before a checkpoint was made. Extractors call it when
+// resuming, since sinks may only keep some of what they're told about
+// directories in memory, like FolderSink does with their times.
+func RemakeDirs(sink Sink, entries []*Entry) error {
+	for _, entry := range entries {
+		if entry.Kind != EntryKindDir {
+			continue
+		}
+
+		err := sink.Mkdir(entry)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
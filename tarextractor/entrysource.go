@@ -0,0 +1,258 @@
+package tarextractor
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+
+	"github.com/itchio/arkive/tar"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// entrySource reads the contents of a single file entry of a tar archive.
+// Those are stored as-is after the entry's header, so once it's been read,
+// entrySource reads straight from the underlying source.
+type entrySource struct {
+	source savior.Source
+	index  *Index
+	entry  *IndexEntry
+
+	// ready is false until the entry's header has been read
+	ready   bool
+	offset  int64
+	ssc     savior.SourceSaveConsumer
+	bytebuf []byte
+}
+
+// EntrySourceCheckpoint is stored in checkpoints of sources
+// returned by EntrySource
+type EntrySourceCheckpoint struct {
+	SourceCheckpoint *savior.SourceCheckpoint
+}
+
+var _ savior.Source = (*entrySource)(nil)
+
+// EntrySource returns a resumable source for the contents of a file entry,
+// found with an index or ScanEntry. When resumed from the start, it gets
+// to the entry like ExtractEntries does. If index is nil, source is read
+// from the start of the archive.
+//
+// source must be equivalent to the one the entry was found in, and can't
+// be used for anything else while the entry source is in use.
+func EntrySource(source savior.Source, index *Index, entry *IndexEntry) (savior.Source, error) {
+	if entry.Kind != savior.EntryKindFile {
+		msg := fmt.Sprintf("tarextractor: %s is not a file", entry)
+		return nil, errors.New(msg)
+	}
+
+	es := &entrySource{
+		source:  source,
+		index:   index,
+		entry:   entry,
+		bytebuf: []byte{0x00},
+	}
+	es.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			return nil
+		},
+	})
+	return es, nil
+}
+
+// ExtractEntryTo writes the contents of a file entry, found with an index
+// or ScanEntry, to w, and returns how many bytes were written. See EntrySource.
+func ExtractEntryTo(source savior.Source, index *Index, entry *IndexEntry, w io.Writer) (int64, error) {
+	es, err := EntrySource(source, index, entry)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = es.Resume(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(w, es)
+	if err != nil {
+		return n, errors.WithStack(err)
+	}
+	return n, nil
+}
+
+// ScanEntry reads an archive from the start until it finds the entry with the
+// given name, for when there's no index. It returns an error wrapping
+// savior.ErrEntryNotFound if there's no such entry.
+func ScanEntry(source savior.Source, name string) (*IndexEntry, error) {
+	_, err := source.Resume(nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sr, err := tar.NewSaverReader(source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for {
+		before, err := sr.Save()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		headerOffset := before.Roffset + before.RegNb + before.Pad
+
+		hdr, err := sr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.Wrapf(savior.ErrEntryNotFound, "%s", name)
+			}
+			return nil, errors.WithStack(err)
+		}
+
+		entry := entryFromHeader(hdr)
+		if entry == nil || entry.CanonicalPath != name {
+			continue
+		}
+
+		after, err := sr.Save()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return &IndexEntry{
+			Name:         entry.CanonicalPath,
+			Kind:         entry.Kind,
+			HeaderOffset: headerOffset,
+			DataOffset:   after.Roffset,
+			Size:         hdr.Size,
+			Checkpoint:   -1,
+		}, nil
+	}
+}
+
+func (es *entrySource) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "tar-entry",
+		ResumeSupport: es.source.Features().ResumeSupport,
+	}
+}
+
+func (es *entrySource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	es.ssc = ssc
+	es.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(sourceCheckpoint *savior.SourceCheckpoint) error {
+			if !es.ready {
+				// we're still looking for the entry
+				return nil
+			}
+
+			return es.ssc.Save(&savior.SourceCheckpoint{
+				Offset: es.offset,
+				Data: &EntrySourceCheckpoint{
+					SourceCheckpoint: sourceCheckpoint,
+				},
+			})
+		},
+	})
+}
+
+func (es *entrySource) WantSave() {
+	es.source.WantSave()
+}
+
+func (es *entrySource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	es.ready = false
+
+	if checkpoint != nil {
+		ourCheckpoint, ok := checkpoint.Data.(*EntrySourceCheckpoint)
+		if !ok {
+			return 0, errors.Errorf("tarextractor: invalid entry source checkpoint (%T)", checkpoint.Data)
+		}
+
+		offset, err := es.source.Resume(ourCheckpoint.SourceCheckpoint)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+
+		target := es.entry.DataOffset + checkpoint.Offset
+		if offset > target {
+			msg := fmt.Sprintf("tarextractor: source resumed at %d, past entry checkpoint at %d", offset, target)
+			return 0, errors.New(msg)
+		}
+		if offset < target {
+			delta := target - offset
+			savior.Debugf("tarextractor: discarding %d bytes to align source and entry checkpoint", delta)
+			err = savior.DiscardByRead(es.source, delta)
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+		}
+
+		es.ready = true
+		es.offset = checkpoint.Offset
+		return es.offset, nil
+	}
+
+	sr, _, err := seekEntry(es.source, es.index, es.entry, -1)
+	if err != nil {
+		return 0, err
+	}
+
+	tarCheckpoint, err := sr.Save()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if tarCheckpoint.Roffset != es.entry.DataOffset {
+		msg := fmt.Sprintf("tarextractor: expected data of %s, found it at %d", es.entry, tarCheckpoint.Roffset)
+		return 0, errors.New(msg)
+	}
+
+	es.ready = true
+	es.offset = 0
+	return 0, nil
+}
+
+func (es *entrySource) Read(buf []byte) (int, error) {
+	if !es.ready {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	remaining := es.entry.Size - es.offset
+	if remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(buf)) > remaining {
+		buf = buf[:remaining]
+	}
+
+	n, err := es.source.Read(buf)
+	es.offset += int64(n)
+	if err == io.EOF && es.offset < es.entry.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (es *entrySource) ReadByte() (byte, error) {
+	for {
+		n, err := es.Read(es.bytebuf)
+		if n > 0 {
+			return es.bytebuf[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (es *entrySource) Progress() float64 {
+	if es.entry.Size == 0 {
+		return 1
+	}
+	return float64(es.offset) / float64(es.entry.Size)
+}
+
+func init() {
+	gob.Register(&EntrySourceCheckpoint{})
+	savior.RegisterCheckpointType("tarextractor.EntrySourceCheckpoint", &EntrySourceCheckpoint{})
+}
@@ -0,0 +1,324 @@
+package tarextractor
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/itchio/arkive/tar"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// DefaultIndexSpan is the default minimum distance between two source
+// checkpoints of an index, in bytes of tar stream.
+const DefaultIndexSpan = 4 * 1024 * 1024
+
+// An Index lists the entries of a tar archive, along with where they start
+// in the tar stream, so that they can be extracted later without walking
+// the whole archive - by resuming the source at a nearby checkpoint.
+//
+// Indexes can be serialized with encoding/gob. They're only valid for
+// the exact archive (and source chain) they were built from.
+type Index struct {
+	// Size is the total size of the tar stream
+	Size int64
+	// Entries are sorted by increasing header offset
+	Entries []*IndexEntry
+	// Checkpoints are source checkpoints, sorted by increasing offset
+	Checkpoints []*savior.SourceCheckpoint
+}
+
+// An IndexEntry describes a single entry of a tar archive.
+type IndexEntry struct {
+	// Name is the path of the entry in the archive
+	Name string
+	// Kind is either a file, a directory or a symlink
+	Kind savior.EntryKind
+	// HeaderOffset is where the entry's first header starts (including
+	// any PAX or GNU long name headers)
+	HeaderOffset int64
+	// DataOffset is where the entry's contents start
+	DataOffset int64
+	// Size is the uncompressed size of the entry's contents
+	Size int64
+	// Checkpoint is the position in Index.Checkpoints of the
+	// last source checkpoint before HeaderOffset, or -1 if
+	// the source needs to be resumed from the start
+	Checkpoint int
+}
+
+func (ie *IndexEntry) String() string {
+	return fmt.Sprintf("%s (%s, header @ %d, data @ %d)", ie.Name, ie.Kind, ie.HeaderOffset, ie.DataOffset)
+}
+
+// BuildIndex walks a whole tar archive, without extracting anything, and returns
+// an index of its entries. The source is asked to emit a checkpoint every `span`
+// bytes or so - if span is zero or negative, DefaultIndexSpan is used.
+//
+// If the source doesn't support resuming, the index only contains offsets, and
+// extracting entries will require reading the archive from the start.
+func BuildIndex(source savior.Source, span int64) (*Index, error) {
+	if span <= 0 {
+		span = DefaultIndexSpan
+	}
+
+	index := &Index{}
+
+	_, err := source.Resume(nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sr, err := tar.NewSaverReader(source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var lastCheckpointOffset int64
+	var wantedSave bool
+	source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			savior.Debugf("tarextractor: index checkpoint at %d", checkpoint.Offset)
+			index.Checkpoints = append(index.Checkpoints, checkpoint)
+			lastCheckpointOffset = checkpoint.Offset
+			wantedSave = false
+			return nil
+		},
+	})
+
+	for {
+		before, err := sr.Save()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		// whatever is left of the previous entry gets skipped by Next
+		headerOffset := before.Roffset + before.RegNb + before.Pad
+
+		if !wantedSave && headerOffset-lastCheckpointOffset >= span {
+			wantedSave = true
+			source.WantSave()
+		}
+
+		hdr, err := sr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.WithStack(err)
+		}
+
+		entry := entryFromHeader(hdr)
+		if entry == nil {
+			continue
+		}
+
+		after, err := sr.Save()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		index.Entries = append(index.Entries, &IndexEntry{
+			Name:         entry.CanonicalPath,
+			Kind:         entry.Kind,
+			HeaderOffset: headerOffset,
+			DataOffset:   after.Roffset,
+			Size:         hdr.Size,
+		})
+	}
+
+	end, err := sr.Save()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	index.Size = end.Roffset
+
+	// some sources emit checkpoints a little after they were asked to,
+	// so only assign them to entries once we know all of them.
+	for _, ie := range index.Entries {
+		ie.Checkpoint = sort.Search(len(index.Checkpoints), func(i int) bool {
+			return index.Checkpoints[i].Offset > ie.HeaderOffset
+		}) - 1
+	}
+
+	return index, nil
+}
+
+// Lookup returns the entry with the given name, or nil if
+// there is no such entry in the index.
+func (idx *Index) Lookup(name string) *IndexEntry {
+	for _, ie := range idx.Entries {
+		if ie.Name == name {
+			return ie
+		}
+	}
+	return nil
+}
+
+// ExtractEntry extracts a single entry of an indexed archive to sink.
+// See ExtractEntries.
+func ExtractEntry(source savior.Source, index *Index, entry *IndexEntry, sink savior.Sink) (*savior.ExtractorResult, error) {
+	return ExtractEntries(source, index, []*IndexEntry{entry}, sink)
+}
+
+// ExtractEntries extracts some entries of an indexed archive to sink, in
+// archive order. Between entries, the source is either read forward, or resumed
+// from the nearest checkpoint of the index, whichever skips more data.
+//
+// source must be equivalent to the one the index was built from (same
+// archive, same compression). Extraction isn't checkpointed: if it
+// gets interrupted, it needs to be started again.
+func ExtractEntries(source savior.Source, index *Index, entries []*IndexEntry, sink savior.Sink) (*savior.ExtractorResult, error) {
+	sorted := make([]*IndexEntry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].HeaderOffset < sorted[j].HeaderOffset
+	})
+
+	res := &savior.ExtractorResult{
+		Entries: []*savior.Entry{},
+	}
+
+	copier := savior.NewCopier(savior.NopSaveConsumer())
+
+	// offset is our position in the tar stream, -1 until
+	// the source has been resumed at least once
+	var offset int64 = -1
+
+	for _, ie := range sorted {
+		sr, entry, err := seekEntry(source, index, ie, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		err = extractEntry(copier, sr, entry, sink)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if entry.Kind == savior.EntryKindFile {
+			res.Entries = append(res.Entries, entry)
+		}
+
+		after, err := sr.Save()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		offset = after.Roffset
+	}
+
+	return res, nil
+}
+
+// seekEntry moves source to the header of an indexed entry, reads it, and returns
+// a tar reader at the start of the entry's contents. offset is where source is at
+// in the tar stream, or -1 if it hasn't been resumed yet. Without an index, the
+// source is resumed from the start.
+func seekEntry(source savior.Source, index *Index, ie *IndexEntry, offset int64) (tar.SaverReader, *savior.Entry, error) {
+	var checkpoint *savior.SourceCheckpoint
+	if index != nil && ie.Checkpoint >= 0 && ie.Checkpoint < len(index.Checkpoints) {
+		checkpoint = index.Checkpoints[ie.Checkpoint]
+	}
+
+	var checkpointOffset int64
+	if checkpoint != nil {
+		checkpointOffset = checkpoint.Offset
+	}
+
+	if offset < 0 || offset > ie.HeaderOffset || checkpointOffset > offset {
+		if checkpoint != nil {
+			// sources may use parts of their checkpoint as internal
+			// state, so make sure we don't spoil the index's copy.
+			var err error
+			checkpoint, err = cloneSourceCheckpoint(checkpoint)
+			if err != nil {
+				return nil, nil, errors.WithStack(err)
+			}
+			savior.Debugf("tarextractor: resuming source from %d for %s", checkpoint.Offset, ie.Name)
+		}
+
+		var err error
+		offset, err = source.Resume(checkpoint)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+
+		if offset > ie.HeaderOffset {
+			msg := fmt.Sprintf("tarextractor: source resumed at %d, past header of %s", offset, ie)
+			return nil, nil, errors.New(msg)
+		}
+	}
+
+	if delta := ie.HeaderOffset - offset; delta > 0 {
+		savior.Debugf("tarextractor: discarding %d bytes to reach %s", delta, ie.Name)
+		err := savior.DiscardByRead(source, delta)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+	}
+
+	tarCheckpoint := &tar.Checkpoint{
+		Roffset: ie.HeaderOffset,
+	}
+	sr, err := tarCheckpoint.Resume(source)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	hdr, err := sr.Next()
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "tarextractor: reading header of %s", ie)
+	}
+
+	entry := entryFromHeader(hdr)
+	if entry == nil || entry.CanonicalPath != ie.Name || entry.Kind != ie.Kind {
+		msg := fmt.Sprintf("tarextractor: index doesn't match archive: expected %s, found %s", ie, hdr.Name)
+		return nil, nil, errors.New(msg)
+	}
+
+	return sr, entry, nil
+}
+
+func extractEntry(copier *savior.Copier, sr tar.SaverReader, entry *savior.Entry, sink savior.Sink) error {
+	switch entry.Kind {
+	case savior.EntryKindDir:
+		savior.Debugf(`tar: extracting dir %s`, entry.CanonicalPath)
+		return sink.Mkdir(entry)
+	case savior.EntryKindSymlink:
+		savior.Debugf(`tar: extracting symlink %s`, entry.CanonicalPath)
+		return sink.Symlink(entry, entry.Linkname)
+	case savior.EntryKindFile:
+		savior.Debugf(`tar: extracting file %s`, entry.CanonicalPath)
+		w, err := sink.GetWriter(entry)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer w.Close()
+
+		err = copier.Do(&savior.CopyParams{
+			Dst:   w,
+			Src:   sr,
+			Entry: entry,
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+func cloneSourceCheckpoint(checkpoint *savior.SourceCheckpoint) (*savior.SourceCheckpoint, error) {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(checkpoint)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	res := &savior.SourceCheckpoint{}
+	err = gob.NewDecoder(buf).Decode(res)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return res, nil
+}
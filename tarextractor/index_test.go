@@ -0,0 +1,151 @@
+package tarextractor_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log"
+	"testing"
+
+	"github.com/itchio/headway/united"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/gzipsource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTarIndex(t *testing.T) {
+	sink := checker.MakeTestSink()
+	tarBytes := checker.MakeTar(t, sink)
+
+	gzipBytes, err := checker.GzipCompress(tarBytes)
+	must(t, err)
+
+	testTarIndex(t, ".tar", func() savior.Source {
+		return seeksource.FromBytes(tarBytes)
+	}, sink)
+	testTarIndex(t, ".tar.gz", func() savior.Source {
+		return gzipsource.New(seeksource.FromBytes(gzipBytes))
+	}, sink)
+}
+
+func testTarIndex(t *testing.T, ext string, makeSource func() savior.Source, sink *checker.Sink) {
+	index, err := tarextractor.BuildIndex(makeSource(), 256*1024)
+	must(t, err)
+	assert.EqualValues(t, len(sink.Items), len(index.Entries))
+
+	// indexes must survive a round-trip through gob
+	indexBuf := new(bytes.Buffer)
+	must(t, gob.NewEncoder(indexBuf).Encode(index))
+	log.Printf("%s index: %d entries, %d checkpoints, %s encoded", ext,
+		len(index.Entries), len(index.Checkpoints), united.FormatBytes(int64(indexBuf.Len())))
+
+	index = &tarextractor.Index{}
+	must(t, gob.NewDecoder(indexBuf).Decode(index))
+
+	for name, item := range sink.Items {
+		ie := index.Lookup(name)
+		if !assert.NotNil(t, ie, "entry %s is indexed", name) {
+			continue
+		}
+		assert.EqualValues(t, item.Entry.Kind, ie.Kind)
+		assert.True(t, ie.DataOffset > ie.HeaderOffset)
+		if item.Entry.Kind == savior.EntryKindFile {
+			assert.EqualValues(t, len(item.Data), ie.Size)
+		}
+	}
+
+	source := makeSource()
+
+	// extract entries one by one, backwards, to exercise resuming
+	for i := len(index.Entries) - 1; i >= 0; i-- {
+		ie := index.Entries[i]
+		sink.Reset()
+		_, err := tarextractor.ExtractEntry(source, index, ie, sink)
+		must(t, err)
+		assertOnlyExtracted(t, sink, ie)
+	}
+
+	// extract every other entry in a single pass
+	var subset []*tarextractor.IndexEntry
+	for i := len(index.Entries) - 1; i >= 0; i -= 2 {
+		subset = append(subset, index.Entries[i])
+	}
+	sink.Reset()
+	_, err = tarextractor.ExtractEntries(source, index, subset, sink)
+	must(t, err)
+	assertOnlyExtracted(t, sink, subset...)
+
+	_, err = tarextractor.ExtractEntries(source, index, nil, sink)
+	must(t, err)
+}
+
+func assertOnlyExtracted(t *testing.T, sink *checker.Sink, entries ...*tarextractor.IndexEntry) {
+	assert.EqualValues(t, len(entries), len(sink.DoneItems))
+
+	for _, ie := range entries {
+		di, ok := sink.DoneItems[ie.Name]
+		if !assert.True(t, ok, "%s was extracted", ie.Name) {
+			continue
+		}
+		if ie.Kind == savior.EntryKindFile && ie.Size > 0 {
+			assert.EqualValues(t, 0, di.MinWrite, "%s was written from the start", ie.Name)
+			assert.EqualValues(t, ie.Size, di.MaxWrite, "%s was written to the end", ie.Name)
+		}
+	}
+}
+
+func TestTarEntry(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(20)
+	tarBytes := checker.MakeTar(t, sink)
+
+	gzipBytes, err := checker.GzipCompress(tarBytes)
+	must(t, err)
+
+	testTarEntry(t, func() savior.Source {
+		return seeksource.FromBytes(tarBytes)
+	}, sink)
+	testTarEntry(t, func() savior.Source {
+		return gzipsource.New(seeksource.FromBytes(gzipBytes))
+	}, sink)
+}
+
+func testTarEntry(t *testing.T, makeSource func() savior.Source, sink *checker.Sink) {
+	index, err := tarextractor.BuildIndex(makeSource(), 256*1024)
+	must(t, err)
+
+	var largest *checker.Item
+	for name, item := range sink.Items {
+		if item.Entry.Kind != savior.EntryKindFile {
+			continue
+		}
+		if largest == nil || len(item.Data) > len(largest.Data) {
+			largest = item
+		}
+
+		buf := new(bytes.Buffer)
+		n, err := tarextractor.ExtractEntryTo(makeSource(), index, index.Lookup(name), buf)
+		must(t, err)
+		assert.EqualValues(t, len(item.Data), n)
+		assert.True(t, bytes.Equal(item.Data, buf.Bytes()), "%s should be intact", name)
+	}
+
+	name := largest.Entry.CanonicalPath
+	es, err := tarextractor.EntrySource(makeSource(), index, index.Lookup(name))
+	must(t, err)
+	checker.RunSourceTest(t, es, largest.Data)
+
+	// without an index
+	ie, err := tarextractor.ScanEntry(makeSource(), name)
+	must(t, err)
+	assert.EqualValues(t, len(largest.Data), ie.Size)
+
+	es, err = tarextractor.EntrySource(makeSource(), nil, ie)
+	must(t, err)
+	checker.RunSourceTest(t, es, largest.Data)
+
+	_, err = tarextractor.ScanEntry(makeSource(), "nope.txt")
+	assert.True(t, errors.Is(err, savior.ErrEntryNotFound), "expected ErrEntryNotFound, got %v", err)
+}
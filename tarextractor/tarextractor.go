@@ -1,9 +1,12 @@
 package tarextractor
 
 import (
+	"context"
 	"encoding/gob"
 	"io"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/itchio/arkive/tar"
 	"github.com/itchio/headway/state"
@@ -17,20 +20,35 @@ type tarExtractor struct {
 
 	saveConsumer savior.SaveConsumer
 	consumer     *state.Consumer
+
+	filter savior.Filter
+	limits *savior.Limits
 }
 
 type TarExtractorState struct {
 	Result        *savior.ExtractorResult
 	TarCheckpoint *tar.Checkpoint
+	// Dirs holds the directories made so far, which aren't part of
+	// Result. Sinks are told about them again when resuming.
+	Dirs []*savior.Entry
 }
 
 var _ savior.Extractor = (*tarExtractor)(nil)
+var _ savior.ContextExtractor = (*tarExtractor)(nil)
+var _ savior.Filterable = (*tarExtractor)(nil)
+var _ savior.Lister = (*tarExtractor)(nil)
+var _ savior.Limitable = (*tarExtractor)(nil)
+
+// when listing from a SeekSource, entries larger than this
+// are skipped by seeking rather than by reading through them
+const listSeekThreshold = 64 * 1024
 
 func New(source savior.Source) savior.Extractor {
 	return &tarExtractor{
 		source:       source,
 		saveConsumer: savior.NopSaveConsumer(),
 		consumer:     savior.NopConsumer(),
+		limits:       &savior.Limits{},
 	}
 }
 
@@ -42,11 +60,47 @@ func (te *tarExtractor) SetConsumer(consumer *state.Consumer) {
 	te.consumer = consumer
 }
 
+func (te *tarExtractor) SetFilter(filter savior.Filter) {
+	te.filter = filter
+}
+
+// SetLimits sets the limits enforced during extraction, see savior.Limitable.
+// Since tar has no central directory, entries are checked as their headers
+// are read.
+func (te *tarExtractor) SetLimits(limits *savior.Limits) {
+	if limits == nil {
+		limits = &savior.Limits{}
+	}
+	te.limits = limits
+}
+
 func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savior.Sink) (*savior.ExtractorResult, error) {
+	return te.ResumeContext(context.Background(), checkpoint, sink)
+}
+
+// ResumeContext is like Resume, but stops when ctx is done, see savior.ContextExtractor.
+// A final checkpoint can only be emitted in the middle of a file entry, since tar
+// checkpoints need one from the source.
+func (te *tarExtractor) ResumeContext(ctx context.Context, checkpoint *savior.ExtractorCheckpoint, sink savior.Sink) (*savior.ExtractorResult, error) {
+	if ctx.Err() != nil {
+		return nil, savior.NewCancelledError(ctx)
+	}
+
 	var sr tar.SaverReader
 	var state *TarExtractorState
 
+	fingerprint, err := savior.SourceFingerprint(te.source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	envelope := savior.NewCheckpointEnvelope(te.Features(), fingerprint)
+
 	if checkpoint != nil {
+		err := savior.CheckCheckpoint(checkpoint, envelope)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
 		if stateCheckpoint, ok := checkpoint.Data.(*TarExtractorState); ok {
 			if stateCheckpoint.Result != nil && stateCheckpoint.TarCheckpoint != nil {
 				te.consumer.Infof("↻ Resuming @ %.1f%%", checkpoint.Progress*100)
@@ -75,6 +129,11 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 					return nil, errors.WithStack(err)
 				}
 
+				err = savior.RemakeDirs(sink, stateCheckpoint.Dirs)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+
 				state = stateCheckpoint
 			}
 		}
@@ -103,6 +162,13 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 			return nil, errors.WithStack(err)
 		}
 	}
+	checkpoint.Envelope = envelope
+
+	// declared size of entries extracted so far, for limits
+	totalBytes := state.Result.Size()
+	if checkpoint.Entry != nil {
+		totalBytes += checkpoint.Entry.UncompressedSize
+	}
 
 	var stopError error
 
@@ -138,7 +204,10 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 			if err != nil {
 				return errors.WithStack(err)
 			}
-			if action == savior.AfterSaveStop {
+			if ctx.Err() != nil {
+				copier.Stop()
+				stopError = savior.NewCancelledError(ctx)
+			} else if action == savior.AfterSaveStop {
 				copier.Stop()
 				stopError = savior.ErrStop
 			}
@@ -148,6 +217,11 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 
 	entryIndex := checkpoint.EntryIndex
 	for stopError == nil {
+		if ctx.Err() != nil {
+			stopError = savior.NewCancelledError(ctx)
+			break
+		}
+
 		err := func() error {
 			entry = nil
 
@@ -165,24 +239,28 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 					return errors.WithStack(err)
 				}
 
-				entry := &savior.Entry{
-					CanonicalPath:    hdr.Name,
-					UncompressedSize: hdr.Size,
-					Mode:             os.FileMode(hdr.Mode),
+				entry := entryFromHeader(hdr)
+				if entry == nil {
+					// let's just ignore that one..
+					return nil
 				}
 
-				switch hdr.Typeflag {
-				case tar.TypeDir:
-					entry.Kind = savior.EntryKindDir
-				case tar.TypeSymlink:
-					entry.Kind = savior.EntryKindSymlink
-					entry.Linkname = hdr.Linkname
-				case tar.TypeReg:
-					entry.Kind = savior.EntryKindFile
-				default:
-					// let's just ignore that one..
+				if te.filter != nil && !te.filter(entry) {
+					// its contents will be skipped by the next call to Next()
+					savior.Debugf(`tar: skipping filtered entry %s`, entry.CanonicalPath)
 					return nil
 				}
+
+				err = te.limits.CheckEntry(entry)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				// entryIndex counts all headers read so far, even filtered ones
+				totalBytes += entry.UncompressedSize
+				err = te.limits.CheckTotals(entryIndex, totalBytes)
+				if err != nil {
+					return errors.WithStack(err)
+				}
 				checkpoint.Entry = entry
 			}
 			entry = checkpoint.Entry
@@ -196,6 +274,7 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 				if err != nil {
 					return errors.WithStack(err)
 				}
+				state.Dirs = append(state.Dirs, entry)
 			case savior.EntryKindSymlink:
 				savior.Debugf(`tar: extracting symlink %s`, entry.CanonicalPath)
 				err := sink.Symlink(entry, entry.Linkname)
@@ -220,10 +299,19 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 					EmitProgress: func() {
 						te.consumer.Progress(te.source.Progress())
 					},
+
+					Context: ctx,
+					Limits:  te.limits,
 				})
 				if err != nil {
 					return errors.WithStack(err)
 				}
+				if stopError == nil {
+					err = savior.FinishEntry(sink, entry)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+				}
 
 				state.Result.Entries = append(state.Result.Entries, entry)
 				te.consumer.Progress(te.source.Progress())
@@ -251,6 +339,77 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 	return state.Result, nil
 }
 
+// List walks the archive's headers and calls onEntry for each entry
+// that isn't filtered out, see savior.Lister. If the source is a SeekSource,
+// large entries are skipped without reading their contents.
+func (te *tarExtractor) List(onEntry savior.ListEntryFunc) error {
+	_, err := te.source.Resume(nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	sr, err := tar.NewSaverReader(te.source)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	seekSource, canSeek := te.source.(savior.SeekSource)
+
+	for {
+		hdr, err := sr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+
+		entry := entryFromHeader(hdr)
+		if entry != nil && (te.filter == nil || te.filter(entry)) {
+			err = onEntry(entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !canSeek {
+			// Next() will read through the contents
+			continue
+		}
+
+		c, err := sr.Save()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if c.RegNb+c.Pad < listSeekThreshold {
+			continue
+		}
+
+		nextHeaderOffset := c.Roffset + c.RegNb + c.Pad
+		savior.Debugf("tar: listing, seeking to next header at %d", nextHeaderOffset)
+		offset, err := seekSource.Resume(&savior.SourceCheckpoint{
+			Offset: nextHeaderOffset,
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if offset < nextHeaderOffset {
+			err = savior.DiscardByRead(seekSource, nextHeaderOffset-offset)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		nextCheckpoint := &tar.Checkpoint{
+			Roffset: nextHeaderOffset,
+		}
+		sr, err = nextCheckpoint.Resume(seekSource)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
+
 func (te *tarExtractor) Features() savior.ExtractorFeatures {
 	sf := te.source.Features()
 
@@ -273,7 +432,76 @@ func (te *tarExtractor) Features() savior.ExtractorFeatures {
 	}
 }
 
+// entryFromHeader returns a savior.Entry for a tar header, or nil if
+// it's an entry type we don't extract (hard links, devices, fifos, etc.)
+func entryFromHeader(hdr *tar.Header) *savior.Entry {
+	entry := &savior.Entry{
+		CanonicalPath:    hdr.Name,
+		UncompressedSize: hdr.Size,
+		Mode:             os.FileMode(hdr.Mode),
+		ModTime:          hdr.ModTime,
+		AccessTime:       hdr.AccessTime,
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		entry.Kind = savior.EntryKindDir
+	case tar.TypeSymlink:
+		entry.Kind = savior.EntryKindSymlink
+		entry.Linkname = hdr.Linkname
+	case tar.TypeReg:
+		entry.Kind = savior.EntryKindFile
+	default:
+		return nil
+	}
+	return entry
+}
+
+// isTarHeader returns true if header starts with a valid tar header,
+// either with a ustar/gnu magic, or with a correct checksum (for v7 archives)
+func isTarHeader(header []byte) bool {
+	if len(header) < 512 {
+		return false
+	}
+
+	magic := string(header[257:262])
+	if magic == "ustar" {
+		return true
+	}
+
+	field := strings.Trim(string(header[148:156]), " \x00")
+	if field == "" {
+		return false
+	}
+	expected, err := strconv.ParseInt(field, 8, 64)
+	if err != nil {
+		return false
+	}
+
+	// the checksum is computed as if the checksum field was all spaces
+	var sum int64
+	for i, b := range header[:512] {
+		if i >= 148 && i < 156 {
+			b = ' '
+		}
+		sum += int64(b)
+	}
+	return sum == expected
+}
+
 func init() {
 	gob.Register(&TarExtractorState{})
+	savior.RegisterCheckpointType("tarextractor.TarExtractorState", &TarExtractorState{})
 	gob.Register(&tar.Checkpoint{})
+
+	savior.RegisterArchiveFormat(savior.ArchiveFormat{
+		Name:  "tar",
+		Match: isTarHeader,
+		NewSeekExtractor: func(source savior.SeekSource) (savior.Extractor, error) {
+			return New(source), nil
+		},
+		NewExtractor: func(source savior.Source) (savior.Extractor, error) {
+			return New(source), nil
+		},
+	})
 }
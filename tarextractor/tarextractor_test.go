@@ -1,18 +1,29 @@
 package tarextractor_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"encoding/gob"
+	"errors"
 	"log"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/itchio/headway/united"
 	"github.com/itchio/savior/bzip2source"
 	"github.com/itchio/savior/checker"
 	"github.com/itchio/savior/gzipsource"
+	"github.com/itchio/savior/lz4source"
+	"github.com/itchio/savior/xzsource"
+	"github.com/itchio/savior/zstdsource"
 
 	"github.com/itchio/savior"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/semirandom"
 	"github.com/itchio/savior/tarextractor"
 )
 
@@ -42,6 +53,143 @@ func TestTar(t *testing.T) {
 	must(t, err)
 	bzip2Source := bzip2source.New(seeksource.FromBytes(bzip2Bytes))
 	testTarVariants(t, ".tar.bz2", int64(len(bzip2Bytes)), bzip2Source, sink)
+
+	log.Printf("Compressing with xz...")
+	xzBytes, err := checker.XzCompress(tarBytes)
+	must(t, err)
+	xzSource := xzsource.New(seeksource.FromBytes(xzBytes))
+	testTarVariants(t, ".tar.xz", int64(len(xzBytes)), xzSource, sink)
+
+	log.Printf("Compressing with zstd...")
+	zstdBytes, err := checker.ZstdCompress(tarBytes)
+	must(t, err)
+	zstdSource := zstdsource.New(seeksource.FromBytes(zstdBytes))
+	testTarVariants(t, ".tar.zst", int64(len(zstdBytes)), zstdSource, sink)
+
+	log.Printf("Compressing with lz4...")
+	lz4Bytes, err := checker.Lz4Compress(tarBytes, true)
+	must(t, err)
+	lz4Source := lz4source.New(seeksource.FromBytes(lz4Bytes))
+	testTarVariants(t, ".tar.lz4", int64(len(lz4Bytes)), lz4Source, sink)
+}
+
+func TestTarFilter(t *testing.T) {
+	sink := checker.MakeTestSink()
+	tarBytes := checker.MakeTar(t, sink)
+
+	gzipBytes, err := checker.GzipCompress(tarBytes)
+	must(t, err)
+
+	filter, err := savior.GlobFilter([]string{"file-*"}, []string{"file-1"})
+	must(t, err)
+
+	// the filtered sink errors out on entries it doesn't know about,
+	// so this also checks that filtered entries never reach the sink.
+	filteredSink := sink.Filter(filter)
+	assert.True(t, len(filteredSink.Items) < len(sink.Items))
+
+	makeExtractor := func() savior.Extractor {
+		ex := tarextractor.New(gzipsource.New(seeksource.FromBytes(gzipBytes)))
+		ex.(savior.Filterable).SetFilter(filter)
+		return ex
+	}
+
+	log.Printf("Testing filtered .tar.gz, no resumes")
+	checker.RunExtractorText(t, makeExtractor, filteredSink, func() bool {
+		return false
+	})
+
+	log.Printf("Testing filtered .tar.gz, every resume")
+	checker.RunExtractorText(t, makeExtractor, filteredSink, func() bool {
+		return true
+	})
+}
+
+func TestTarList(t *testing.T) {
+	sink := checker.MakeTestSink()
+	tarBytes := checker.MakeTar(t, sink)
+
+	gzipBytes, err := checker.GzipCompress(tarBytes)
+	must(t, err)
+
+	sources := map[string]savior.Source{
+		".tar":    seeksource.FromBytes(tarBytes),
+		".tar.gz": gzipsource.New(seeksource.FromBytes(gzipBytes)),
+	}
+
+	for ext, source := range sources {
+		log.Printf("Listing %s", ext)
+		sink.Reset()
+		res, err := savior.List(tarextractor.New(source))
+		must(t, err)
+		assert.EqualValues(t, len(sink.Items), len(res.Entries))
+
+		var expectedSize int64
+		for _, item := range sink.Items {
+			expectedSize += int64(len(item.Data))
+		}
+		assert.EqualValues(t, expectedSize, res.Size())
+
+		for _, entry := range res.Entries {
+			item, ok := sink.Items[entry.CanonicalPath]
+			if assert.True(t, ok, "listed unknown entry %s", entry.CanonicalPath) {
+				assert.EqualValues(t, item.Entry.Kind, entry.Kind)
+			}
+		}
+		assert.EqualValues(t, 0, len(sink.DoneItems), "nothing was extracted")
+	}
+
+	filter, err := savior.GlobFilter([]string{"file-*"}, nil)
+	must(t, err)
+	ex := tarextractor.New(seeksource.FromBytes(tarBytes))
+	ex.(savior.Filterable).SetFilter(filter)
+	res, err := savior.List(ex)
+	must(t, err)
+	assert.EqualValues(t, len(sink.Filter(filter).Items), len(res.Entries))
+}
+
+func TestTarCancel(t *testing.T) {
+	sink := checker.MakeTestSink()
+	tarBytes := checker.MakeTar(t, sink)
+
+	gzipBytes, err := checker.GzipCompress(tarBytes)
+	must(t, err)
+
+	log.Printf("Cancelling .tar extraction")
+	checker.RunExtractorCancelTest(t, func() savior.Extractor {
+		return tarextractor.New(seeksource.FromBytes(tarBytes))
+	}, sink)
+
+	log.Printf("Cancelling .tar.gz extraction")
+	checker.RunExtractorCancelTest(t, func() savior.Extractor {
+		return tarextractor.New(gzipsource.New(seeksource.FromBytes(gzipBytes)))
+	}, sink)
+}
+
+func TestTarLimits(t *testing.T) {
+	sink := checker.MakeTestSink()
+	tarBytes := checker.MakeTar(t, sink)
+
+	extract := func(limits *savior.Limits) error {
+		ex := tarextractor.New(seeksource.FromBytes(tarBytes))
+		ex.(savior.Limitable).SetLimits(limits)
+		sink.Reset()
+		_, err := ex.Resume(nil, sink)
+		return err
+	}
+
+	expectLimit := func(err error, limit string) {
+		var le *savior.LimitError
+		if assert.True(t, errors.As(err, &le), "expected limit error, got %v", err) {
+			assert.EqualValues(t, limit, le.Limit)
+		}
+	}
+
+	assert.NoError(t, extract(nil))
+	expectLimit(extract(&savior.Limits{MaxEntries: 3}), savior.LimitEntries)
+	expectLimit(extract(&savior.Limits{MaxTotalBytes: 1024 * 1024}), savior.LimitTotalBytes)
+	expectLimit(extract(&savior.Limits{MaxPathLength: 4}), savior.LimitPathLength)
+	assert.NoError(t, extract(savior.NoLimits()))
 }
 
 func testTarVariants(t *testing.T, ext string, size int64, source savior.Source, sink *checker.Sink) {
@@ -66,3 +214,117 @@ func testTarVariants(t *testing.T, ext string, size int64, source savior.Source,
 		return i%2 == 0
 	})
 }
+
+func TestTarTimes(t *testing.T) {
+	modified := time.Date(2015, time.October, 21, 16, 29, 0, 0, time.UTC)
+	accessed := time.Date(2016, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	must(t, tw.WriteHeader(&tar.Header{
+		Name:     "dir/",
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+		ModTime:  modified,
+	}))
+	// atime needs PAX records
+	must(t, tw.WriteHeader(&tar.Header{
+		Name:       "dir/file.txt",
+		Typeflag:   tar.TypeReg,
+		Mode:       0644,
+		Size:       9,
+		ModTime:    modified,
+		AccessTime: accessed,
+		Format:     tar.FormatPAX,
+	}))
+	_, err := tw.Write([]byte("some text"))
+	must(t, err)
+	must(t, tw.Close())
+	tarBytes := buf.Bytes()
+
+	res, err := savior.List(tarextractor.New(seeksource.FromBytes(tarBytes)))
+	must(t, err)
+	if assert.Len(t, res.Entries, 2) {
+		for _, entry := range res.Entries {
+			assert.True(t, modified.Equal(entry.ModTime), "%s has mtime %s", entry.CanonicalPath, entry.ModTime)
+		}
+		assert.True(t, accessed.Equal(res.Entries[1].AccessTime), "file has atime %s", res.Entries[1].AccessTime)
+	}
+
+	dir := t.TempDir()
+	sink := &savior.FolderSink{Directory: dir, Consumer: savior.NopConsumer()}
+	_, err = tarextractor.New(seeksource.FromBytes(tarBytes)).Resume(nil, sink)
+	must(t, err)
+	must(t, sink.Close())
+
+	for _, name := range []string{"dir", "dir/file.txt"} {
+		stats, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		must(t, err)
+		assert.True(t, modified.Equal(stats.ModTime()), "%s has mtime %s", name, stats.ModTime())
+	}
+}
+
+func TestTarTimesResume(t *testing.T) {
+	modified := time.Date(2015, time.October, 21, 16, 29, 0, 0, time.UTC)
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	must(t, tw.WriteHeader(&tar.Header{
+		Name:     "dir/",
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+		ModTime:  modified,
+	}))
+	bigData := semirandom.Bytes(4 * 1024 * 1024)
+	must(t, tw.WriteHeader(&tar.Header{
+		Name:     "dir/big.bin",
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(bigData)),
+		ModTime:  modified,
+	}))
+	_, err := tw.Write(bigData)
+	must(t, err)
+	// created after resuming in the middle of dir/big.bin
+	must(t, tw.WriteHeader(&tar.Header{
+		Name:     "dir/after.txt",
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     9,
+		ModTime:  modified,
+	}))
+	_, err = tw.Write([]byte("some text"))
+	must(t, err)
+	must(t, tw.Close())
+	tarBytes := buf.Bytes()
+
+	dir := t.TempDir()
+	sink := &savior.FolderSink{Directory: dir, Consumer: savior.NopConsumer()}
+
+	var saved *bytes.Buffer
+	ex := tarextractor.New(seeksource.FromBytes(tarBytes))
+	ex.SetSaveConsumer(checker.NewTestSaveConsumer(1024*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+		saved = new(bytes.Buffer)
+		return savior.AfterSaveStop, gob.NewEncoder(saved).Encode(checkpoint)
+	}))
+	_, err = ex.Resume(nil, sink)
+	assert.Equal(t, savior.ErrStop, err)
+	must(t, sink.Close())
+	if !assert.NotNil(t, saved) {
+		return
+	}
+
+	checkpoint := &savior.ExtractorCheckpoint{}
+	must(t, gob.NewDecoder(saved).Decode(checkpoint))
+
+	sink = &savior.FolderSink{Directory: dir, Consumer: savior.NopConsumer()}
+	_, err = tarextractor.New(seeksource.FromBytes(tarBytes)).Resume(checkpoint, sink)
+	must(t, err)
+	must(t, sink.Close())
+
+	for _, name := range []string{"dir", "dir/big.bin", "dir/after.txt"} {
+		stats, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		must(t, err)
+		assert.True(t, modified.Equal(stats.ModTime()), "%s has mtime %s", name, stats.ModTime())
+	}
+}
@@ -0,0 +1,199 @@
+package xzsource
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/internal/xz"
+	"github.com/pkg/errors"
+)
+
+type xzSource struct {
+	// input
+	source savior.Source
+
+	// internal
+	sr      xz.SaverReader
+	offset  int64
+	bytebuf []byte
+
+	ssc              savior.SourceSaveConsumer
+	sourceCheckpoint *savior.SourceCheckpoint
+}
+
+type XzSourceCheckpoint struct {
+	Offset           int64
+	SourceCheckpoint *savior.SourceCheckpoint
+	XzCheckpoint     *xz.Checkpoint
+}
+
+var _ savior.Source = (*xzSource)(nil)
+
+func New(source savior.Source) *xzSource {
+	return &xzSource{
+		source:  source,
+		bytebuf: []byte{0x00},
+	}
+}
+
+func (xs *xzSource) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "xz",
+		ResumeSupport: savior.ResumeSupportBlock,
+	}
+}
+
+func (xs *xzSource) Fingerprint() (*savior.Fingerprint, error) {
+	return savior.SourceFingerprint(xs.source)
+}
+
+func (xs *xzSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	xs.ssc = ssc
+	xs.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			xs.sourceCheckpoint = checkpoint
+			xs.sr.WantSave()
+			return nil
+		},
+	})
+}
+
+func (xs *xzSource) WantSave() {
+	xs.source.WantSave()
+}
+
+func (xs *xzSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	if checkpoint != nil {
+		if ourCheckpoint, ok := checkpoint.Data.(*XzSourceCheckpoint); ok {
+			sourceOffset, err := xs.source.Resume(ourCheckpoint.SourceCheckpoint)
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+
+			gc := ourCheckpoint.XzCheckpoint
+			if sourceOffset < gc.Roffset {
+				delta := gc.Roffset - sourceOffset
+				savior.Debugf(`xzsource: discarding %d bytes to align source with decompressor`, delta)
+				err = savior.DiscardByRead(xs.source, delta)
+				if err != nil {
+					return 0, errors.WithStack(err)
+				}
+				sourceOffset += delta
+			}
+
+			if sourceOffset == gc.Roffset {
+				xs.sr, err = gc.Resume(xs.source)
+				if err != nil {
+					savior.Debugf(`xzsource: could not use xz checkpoint at R=%d`, gc.Roffset)
+					// well, let's start over
+					_, err = xs.source.Resume(nil)
+					if err != nil {
+						return 0, errors.WithStack(err)
+					}
+				} else {
+					xs.offset = ourCheckpoint.Offset
+					return xs.offset, nil
+				}
+			} else {
+				savior.Debugf(`xzsource: expected source to resume at %d but got %d`, gc.Roffset, sourceOffset)
+			}
+		}
+	}
+
+	// start from beginning
+	sourceOffset, err := xs.source.Resume(nil)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if sourceOffset != 0 {
+		msg := fmt.Sprintf("xzsource: expected source to resume at start but got %d", sourceOffset)
+		return 0, errors.New(msg)
+	}
+
+	xs.sr = xz.NewSaverReader(xs.source)
+
+	xs.offset = 0
+	return 0, nil
+}
+
+func (xs *xzSource) Read(buf []byte) (int, error) {
+	if xs.sr == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	n, err := xs.sr.Read(buf)
+	xs.offset += int64(n)
+
+	if err == xz.ReadyToSaveError {
+		err = nil
+
+		if xs.sourceCheckpoint == nil {
+			savior.Debugf("xzsource: can't save, sourceCheckpoint is nil!")
+		} else if xs.ssc == nil {
+			savior.Debugf("xzsource: can't save, ssc is nil!")
+		} else {
+			xzCheckpoint, saveErr := xs.sr.Save()
+			if saveErr != nil {
+				return n, saveErr
+			}
+
+			savior.Debugf("xzsource: saving, xz rOffset = %d, sourceCheckpoint.Offset = %d", xzCheckpoint.Roffset, xs.sourceCheckpoint.Offset)
+
+			checkpoint := &savior.SourceCheckpoint{
+				Offset: xs.offset,
+				Data: &XzSourceCheckpoint{
+					Offset:           xs.offset,
+					XzCheckpoint:     xzCheckpoint,
+					SourceCheckpoint: xs.sourceCheckpoint,
+				},
+			}
+			xs.sourceCheckpoint = nil
+
+			err = xs.ssc.Save(checkpoint)
+			savior.Debugf("xzsource: saved checkpoint at byte %d", xs.offset)
+		}
+	}
+
+	return n, err
+}
+
+func (xs *xzSource) ReadByte() (byte, error) {
+	if xs.sr == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	n, err := xs.Read(xs.bytebuf)
+	if n == 0 {
+		/* this happens when Read needs to save, but it swallows the error */
+		/* we're not meant to surface them, but there's no way to handle a */
+		/* short read from ReadByte, so we just read again */
+		n, err = xs.Read(xs.bytebuf)
+	}
+
+	return xs.bytebuf[0], err
+}
+
+func (xs *xzSource) Progress() float64 {
+	// We can't tell how large the uncompressed stream is until we finish
+	// decompressing it. The underlying's source progress is a good enough
+	// approximation.
+	return xs.source.Progress()
+}
+
+func init() {
+	gob.Register(&XzSourceCheckpoint{})
+	savior.RegisterCheckpointType("xzsource.XzSourceCheckpoint", &XzSourceCheckpoint{})
+
+	savior.RegisterCompressionFormat(savior.CompressionFormat{
+		Name: "xz",
+		Match: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte{0xFD, '7', 'z', 'X', 'Z', 0x00})
+		},
+		NewSource: func(source savior.Source) savior.Source {
+			return New(source)
+		},
+	})
+}
@@ -0,0 +1,69 @@
+package zipextractor
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+)
+
+// ErrChecksum is wrapped by ChecksumError
+var ErrChecksum = zip.ErrChecksum
+
+// A ChecksumError is returned when the contents of an entry don't
+// match the CRC32 found in the zip's central directory.
+type ChecksumError struct {
+	// Path of the corrupted entry
+	Path string
+	// Expected is the CRC32 from the central directory
+	Expected uint32
+	// Actual is the CRC32 of what was extracted
+	Actual uint32
+}
+
+var _ error = (*ChecksumError)(nil)
+
+func (ce *ChecksumError) Error() string {
+	return fmt.Sprintf("zip: checksum mismatch for %s (expected %08x, got %08x)", ce.Path, ce.Expected, ce.Actual)
+}
+
+func (ce *ChecksumError) Unwrap() error {
+	return ErrChecksum
+}
+
+// crcWriter computes the CRC32 of everything written through it,
+// so that it always matches what the sink has, even across resumes
+type crcWriter struct {
+	w   io.Writer
+	crc uint32
+
+	// set when resuming from a checkpoint that didn't have a CRC32
+	unknown bool
+}
+
+var _ io.Writer = (*crcWriter)(nil)
+
+func (cw *crcWriter) Write(buf []byte) (int, error) {
+	n, err := cw.w.Write(buf)
+	cw.crc = crc32.Update(cw.crc, crc32.IEEETable, buf[:n])
+	return n, err
+}
+
+func (cw *crcWriter) check(zf *zip.File, entry *savior.Entry) error {
+	if cw.unknown {
+		savior.Debugf(`%s: resumed without a checksum, can't verify`, entry.CanonicalPath)
+		return nil
+	}
+
+	// like archive/zip, we only check CRCs that seem to be set
+	if zf.CRC32 != 0 && cw.crc != zf.CRC32 {
+		return &ChecksumError{
+			Path:     entry.CanonicalPath,
+			Expected: zf.CRC32,
+			Actual:   cw.crc,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package zipextractor
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/pkg/errors"
+)
+
+const (
+	directoryEndSignature      = 0x06054b50
+	directory64LocSignature    = 0x07064b50
+	directory64EndSignature    = 0x06064b50
+	directoryHeaderSignature   = 0x02014b50
+	directoryEndLen            = 22
+	directory64LocLen          = 20
+	directory64EndLen          = 56
+	directoryHeaderLen         = 46
+	maxDirectoryEndSearchRange = 65 * 1024
+)
+
+// directoryLocation tells where the central directory of an archive is
+type directoryLocation struct {
+	offset int64
+	size   int64
+
+	// archiveStart is where the archive starts. It's non-zero for archives
+	// appended to something else (like the stub of a self-extracting executable,
+	// or another archive), whose offsets are relative to their own start.
+	// Tools that fix up offsets after prepending a stub (like `zip -A`)
+	// make archives that start at zero.
+	archiveStart int64
+}
+
+// locateDirectory finds the central directory by walking back from the
+// end of the file, which works even if something was prepended to the archive
+func locateDirectory(r io.ReaderAt, size int64) (*directoryLocation, error) {
+	searchLen := int64(maxDirectoryEndSearchRange)
+	if searchLen > size {
+		searchLen = size
+	}
+	buf := make([]byte, searchLen)
+	_, err := r.ReadAt(buf, size-searchLen)
+	if err != nil && err != io.EOF {
+		return nil, errors.WithStack(err)
+	}
+
+	endOffset := int64(-1)
+	for i := len(buf) - directoryEndLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(buf[i:]) == directoryEndSignature {
+			commentLen := int(binary.LittleEndian.Uint16(buf[i+20:]))
+			if i+directoryEndLen+commentLen <= len(buf) {
+				endOffset = size - searchLen + int64(i)
+				buf = buf[i:]
+				break
+			}
+		}
+	}
+	if endOffset < 0 {
+		return nil, errors.WithStack(zip.ErrFormat)
+	}
+
+	directorySize := int64(binary.LittleEndian.Uint32(buf[12:]))
+	directoryOffset := int64(binary.LittleEndian.Uint32(buf[16:]))
+	directoryEnd := endOffset
+
+	if endOffset >= directory64LocLen {
+		loc := make([]byte, directory64LocLen)
+		_, err := r.ReadAt(loc, endOffset-directory64LocLen)
+		if err == nil && binary.LittleEndian.Uint32(loc) == directory64LocSignature {
+			// the zip64 directory end is usually right before its locator,
+			// but the offset recorded in the locator doesn't account for
+			// anything that was prepended to the archive
+			candidates := []int64{
+				int64(binary.LittleEndian.Uint64(loc[8:])),
+				endOffset - directory64LocLen - directory64EndLen,
+			}
+			end64 := make([]byte, directory64EndLen)
+			for _, candidate := range candidates {
+				if candidate < 0 || candidate >= endOffset {
+					continue
+				}
+				_, err := r.ReadAt(end64, candidate)
+				if err == nil && binary.LittleEndian.Uint32(end64) == directory64EndSignature {
+					directorySize = int64(binary.LittleEndian.Uint64(end64[40:]))
+					directoryOffset = int64(binary.LittleEndian.Uint64(end64[48:]))
+					directoryEnd = candidate
+					break
+				}
+			}
+		}
+	}
+
+	if directorySize < 0 || directorySize > directoryEnd {
+		return nil, errors.WithStack(zip.ErrFormat)
+	}
+
+	dl := &directoryLocation{
+		offset: directoryEnd - directorySize,
+		size:   directorySize,
+	}
+
+	if directorySize > 0 {
+		sig := make([]byte, 4)
+		_, err := r.ReadAt(sig, dl.offset)
+		if err != nil || binary.LittleEndian.Uint32(sig) != directoryHeaderSignature {
+			return nil, errors.WithStack(zip.ErrFormat)
+		}
+	}
+
+	if start := dl.offset - directoryOffset; start > 0 {
+		dl.archiveStart = start
+	}
+	return dl, nil
+}
+
+// readRawNames reads entry names as they're stored in the central directory,
+// since the zip package transcodes those it doesn't think are UTF-8.
+func readRawNames(r io.ReaderAt, size int64) ([][]byte, error) {
+	dl, err := locateDirectory(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	directory := make([]byte, dl.size)
+	_, err = r.ReadAt(directory, dl.offset)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var names [][]byte
+	for len(directory) >= directoryHeaderLen {
+		if binary.LittleEndian.Uint32(directory) != directoryHeaderSignature {
+			break
+		}
+		nameLen := int(binary.LittleEndian.Uint16(directory[28:]))
+		extraLen := int(binary.LittleEndian.Uint16(directory[30:]))
+		commentLen := int(binary.LittleEndian.Uint16(directory[32:]))
+		recordLen := directoryHeaderLen + nameLen + extraLen + commentLen
+		if recordLen > len(directory) {
+			return nil, errors.WithStack(zip.ErrFormat)
+		}
+		names = append(names, directory[directoryHeaderLen:directoryHeaderLen+nameLen])
+		directory = directory[recordLen:]
+	}
+	return names, nil
+}
@@ -0,0 +1,514 @@
+package zipextractor
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/seeksource"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// MethodAES is the method of entries encrypted with WinZip AES, their
+// actual compression method is found in an extra field.
+const MethodAES uint16 = 99
+
+var (
+	// ErrWrongPassword is returned when the password doesn't match an encrypted entry
+	ErrWrongPassword = errors.New("zip: wrong password")
+	// ErrAuthentication is returned when a WinZip AES entry doesn't
+	// match its authentication code, ie. it was corrupted or tampered with
+	ErrAuthentication = errors.New("zip: authentication failed")
+)
+
+const (
+	flagEncrypted       = 0x1
+	flagDataDescriptor  = 0x8
+	flagStrongEncrypted = 0x40
+
+	aesExtraID      = 0x9901
+	aesIterations   = 1000
+	aesVerifierSize = 2
+	aesAuthCodeSize = 10
+
+	zipCryptoHeaderSize = 12
+)
+
+// PasswordFunc returns the password for an encrypted entry
+type PasswordFunc func(entry *savior.Entry) (string, error)
+
+// encryption describes the encrypted payload of an entry
+type encryption struct {
+	// actual compression method
+	method uint16
+	// bounds of the encrypted (compressed) data, without headers or trailers
+	offset int64
+	size   int64
+	cipher entryCipher
+}
+
+// entryCipher decrypts the payload of an entry, and may save its state
+// so that decryption can be resumed in the middle of an entry.
+type entryCipher interface {
+	// reset returns to the state at the start of the payload
+	reset()
+	// decrypt decrypts buf in place, buf immediately follows what was last decrypted
+	decrypt(buf []byte)
+	// savable returns false if the state must never end up in a checkpoint,
+	// in which case the entry is decrypted from the start when resuming
+	savable() bool
+	// save stores the state into c
+	save(c *DecryptSourceCheckpoint) error
+	// restore restores the state saved in c, for the given payload offset
+	restore(c *DecryptSourceCheckpoint, offset int64) error
+	// verify is called with the rest of the (encrypted) payload when the entry is done
+	verify(rest io.Reader) error
+}
+
+func (ze *ZipExtractor) password(entry *savior.Entry) (string, error) {
+	if ze.passwordFunc != nil {
+		password, err := ze.passwordFunc(entry)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		return password, nil
+	}
+	return ze.defaultPassword, nil
+}
+
+// entryMethod returns the actual compression method of an entry
+func entryMethod(zf *zip.File) uint16 {
+	if zf.Method == MethodAES {
+		if extra, ok := findAESExtra(zf.Extra); ok {
+			return extra.method
+		}
+	}
+	return zf.Method
+}
+
+type aesExtra struct {
+	version  uint16
+	strength byte
+	method   uint16
+}
+
+func findAESExtra(extra []byte) (aesExtra, bool) {
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		if tag == aesExtraID && size >= 7 && extra[2] == 'A' && extra[3] == 'E' {
+			return aesExtra{
+				version:  binary.LittleEndian.Uint16(extra[0:]),
+				strength: extra[4],
+				method:   binary.LittleEndian.Uint16(extra[5:]),
+			}, true
+		}
+		extra = extra[size:]
+	}
+	return aesExtra{}, false
+}
+
+func (ze *ZipExtractor) openEncryption(zf *zip.File, entry *savior.Entry) (*encryption, error) {
+	if zf.Flags&flagStrongEncrypted != 0 {
+		return nil, errors.Wrapf(zip.ErrEncrypted, "%s uses PKWARE strong encryption", entry.CanonicalPath)
+	}
+
+	password, err := ze.password(entry)
+	if err != nil {
+		return nil, err
+	}
+	if password == "" {
+		return nil, errors.Wrapf(zip.ErrEncrypted, "no password for %s", entry.CanonicalPath)
+	}
+
+	dataOff, err := zf.DataOffset()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	size := int64(zf.CompressedSize64)
+
+	if zf.Method == MethodAES {
+		extra, ok := findAESExtra(zf.Extra)
+		if !ok {
+			return nil, errors.Errorf("zip: %s is missing its AES extra field", entry.CanonicalPath)
+		}
+
+		var keySize int
+		switch extra.strength {
+		case 1:
+			keySize = 16
+		case 2:
+			keySize = 24
+		case 3:
+			keySize = 32
+		default:
+			return nil, errors.Errorf("zip: %s has unknown AES strength %d", entry.CanonicalPath, extra.strength)
+		}
+		saltSize := keySize / 2
+
+		overhead := int64(saltSize + aesVerifierSize + aesAuthCodeSize)
+		if size < overhead {
+			return nil, errors.WithStack(zip.ErrFormat)
+		}
+
+		header := make([]byte, saltSize+aesVerifierSize)
+		_, err = ze.reader.ReadAt(header, dataOff)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		authCode := make([]byte, aesAuthCodeSize)
+		_, err = ze.reader.ReadAt(authCode, dataOff+size-aesAuthCodeSize)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		keys := pbkdf2.Key([]byte(password), header[:saltSize], aesIterations, 2*keySize+aesVerifierSize, sha1.New)
+		if subtle.ConstantTimeCompare(keys[2*keySize:], header[saltSize:]) != 1 {
+			return nil, errors.Wrapf(ErrWrongPassword, "%s", entry.CanonicalPath)
+		}
+
+		block, err := aes.NewCipher(keys[:keySize])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		c := &winzipAES{
+			block:    block,
+			authKey:  keys[keySize : 2*keySize],
+			authCode: authCode,
+		}
+		c.reset()
+
+		return &encryption{
+			method: extra.method,
+			offset: dataOff + int64(len(header)),
+			size:   size - overhead,
+			cipher: c,
+		}, nil
+	}
+
+	if size < zipCryptoHeaderSize {
+		return nil, errors.WithStack(zip.ErrFormat)
+	}
+
+	header := make([]byte, zipCryptoHeaderSize)
+	_, err = ze.reader.ReadAt(header, dataOff)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	c := &zipCrypto{}
+	c.init(password)
+	c.decrypt(header)
+
+	// the last byte of the header is used to check the password
+	check := byte(zf.CRC32 >> 24)
+	if zf.Flags&flagDataDescriptor != 0 {
+		check = byte(zf.ModifiedTime >> 8)
+	}
+	if header[zipCryptoHeaderSize-1] != check {
+		return nil, errors.Wrapf(ErrWrongPassword, "%s", entry.CanonicalPath)
+	}
+	c.initial = c.keys
+
+	return &encryption{
+		method: zf.Method,
+		offset: dataOff + zipCryptoHeaderSize,
+		size:   size - zipCryptoHeaderSize,
+		cipher: c,
+	}, nil
+}
+
+// zipCrypto is the traditional PKWARE encryption
+type zipCrypto struct {
+	keys    [3]uint32
+	initial [3]uint32
+}
+
+var _ entryCipher = (*zipCrypto)(nil)
+
+func (zc *zipCrypto) init(password string) {
+	zc.keys = [3]uint32{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		zc.update(password[i])
+	}
+}
+
+func crc32Byte(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ (crc >> 8)
+}
+
+func (zc *zipCrypto) update(b byte) {
+	zc.keys[0] = crc32Byte(zc.keys[0], b)
+	zc.keys[1] = (zc.keys[1]+zc.keys[0]&0xff)*134775813 + 1
+	zc.keys[2] = crc32Byte(zc.keys[2], byte(zc.keys[1]>>24))
+}
+
+func (zc *zipCrypto) reset() {
+	zc.keys = zc.initial
+}
+
+func (zc *zipCrypto) decrypt(buf []byte) {
+	for i, b := range buf {
+		t := zc.keys[2] | 2
+		b ^= byte((t * (t ^ 1)) >> 8)
+		zc.update(b)
+		buf[i] = b
+	}
+}
+
+// savable returns false: with some known plaintext, the keys can be run back
+// to the ones derived from the password, which decrypt every other entry
+// encrypted with it, so saving them would amount to saving the password.
+func (zc *zipCrypto) savable() bool {
+	return false
+}
+
+func (zc *zipCrypto) save(c *DecryptSourceCheckpoint) error {
+	return errors.New("zip: ZipCrypto state is never saved")
+}
+
+func (zc *zipCrypto) restore(c *DecryptSourceCheckpoint, offset int64) error {
+	return errors.New("zip: ZipCrypto state is never saved")
+}
+
+func (zc *zipCrypto) verify(rest io.Reader) error {
+	// the CRC32 of the contents is all we have
+	return nil
+}
+
+// winzipAES is AES in CTR mode, authenticated with HMAC-SHA1
+type winzipAES struct {
+	block    cipher.Block
+	authKey  []byte
+	authCode []byte
+
+	offset    int64
+	keystream [aes.BlockSize]byte
+	// mac is the inner hash of HMAC-SHA1, which can be saved
+	mac hash.Hash
+}
+
+var _ entryCipher = (*winzipAES)(nil)
+
+func (wa *winzipAES) reset() {
+	wa.offset = 0
+	wa.mac = sha1.New()
+	wa.mac.Write(wa.pad(0x36))
+}
+
+func (wa *winzipAES) pad(b byte) []byte {
+	pad := make([]byte, sha1.BlockSize)
+	copy(pad, wa.authKey)
+	for i := range pad {
+		pad[i] ^= b
+	}
+	return pad
+}
+
+func (wa *winzipAES) decrypt(buf []byte) {
+	wa.mac.Write(buf)
+
+	for i := range buf {
+		pos := wa.offset % aes.BlockSize
+		if pos == 0 || i == 0 {
+			// the counter is little-endian and starts at 1
+			var counter [aes.BlockSize]byte
+			binary.LittleEndian.PutUint64(counter[:], uint64(wa.offset/aes.BlockSize+1))
+			wa.block.Encrypt(wa.keystream[:], counter[:])
+		}
+		buf[i] ^= wa.keystream[pos]
+		wa.offset++
+	}
+}
+
+func (wa *winzipAES) savable() bool {
+	return true
+}
+
+func (wa *winzipAES) save(c *DecryptSourceCheckpoint) error {
+	state, err := wa.mac.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	c.MAC = state
+	return nil
+}
+
+func (wa *winzipAES) restore(c *DecryptSourceCheckpoint, offset int64) error {
+	mac := sha1.New()
+	err := mac.(encoding.BinaryUnmarshaler).UnmarshalBinary(c.MAC)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	wa.mac = mac
+	wa.offset = offset
+	return nil
+}
+
+func (wa *winzipAES) verify(rest io.Reader) error {
+	_, err := io.Copy(wa.mac, rest)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	outer := sha1.New()
+	outer.Write(wa.pad(0x5c))
+	outer.Write(wa.mac.Sum(nil))
+	if !bytes.Equal(outer.Sum(nil)[:aesAuthCodeSize], wa.authCode) {
+		return ErrAuthentication
+	}
+	return nil
+}
+
+type decryptSource struct {
+	// input
+	reader io.ReaderAt
+	enc    *encryption
+
+	// internal
+	source  savior.Source
+	offset  int64
+	bytebuf []byte
+
+	ssc savior.SourceSaveConsumer
+}
+
+// DecryptSourceCheckpoint is the state of the decryption of a WinZip AES
+// entry. It never contains the password or AES keys. ZipCrypto entries have
+// no such checkpoints, and are decrypted from the start when resuming.
+type DecryptSourceCheckpoint struct {
+	SourceCheckpoint *savior.SourceCheckpoint
+	// MAC is the state of the WinZip AES authentication code
+	MAC []byte
+}
+
+var _ savior.Source = (*decryptSource)(nil)
+
+func newDecryptSource(reader io.ReaderAt, enc *encryption) *decryptSource {
+	return &decryptSource{
+		reader:  reader,
+		enc:     enc,
+		source:  seeksource.NewWithSize(io.NewSectionReader(reader, enc.offset, enc.size), enc.size),
+		bytebuf: []byte{0x00},
+	}
+}
+
+func (ds *decryptSource) Features() savior.SourceFeatures {
+	rs := savior.ResumeSupportBlock
+	if !ds.enc.cipher.savable() {
+		rs = savior.ResumeSupportNone
+	}
+	return savior.SourceFeatures{
+		Name:          "zip-decrypt",
+		ResumeSupport: rs,
+	}
+}
+
+func (ds *decryptSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	ds.ssc = ssc
+	ds.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			if !ds.enc.cipher.savable() {
+				// the entry will be decrypted from the start
+				return nil
+			}
+
+			// the underlying source saves before reading,
+			// so everything it returned has been decrypted
+			ourCheckpoint := &DecryptSourceCheckpoint{
+				SourceCheckpoint: checkpoint,
+			}
+			err := ds.enc.cipher.save(ourCheckpoint)
+			if err != nil {
+				return err
+			}
+
+			return ds.ssc.Save(&savior.SourceCheckpoint{
+				Offset: checkpoint.Offset,
+				Data:   ourCheckpoint,
+			})
+		},
+	})
+}
+
+func (ds *decryptSource) WantSave() {
+	ds.source.WantSave()
+}
+
+func (ds *decryptSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	if checkpoint != nil {
+		if ourCheckpoint, ok := checkpoint.Data.(*DecryptSourceCheckpoint); ok {
+			offset, err := ds.source.Resume(ourCheckpoint.SourceCheckpoint)
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+
+			if ourCheckpoint.SourceCheckpoint != nil && offset == ourCheckpoint.SourceCheckpoint.Offset {
+				err = ds.enc.cipher.restore(ourCheckpoint, offset)
+				if err == nil {
+					ds.offset = offset
+					return offset, nil
+				}
+				savior.Debugf(`zip-decrypt: could not restore cipher: %+v`, err)
+			} else {
+				savior.Debugf(`zip-decrypt: source resumed at %d, can't use checkpoint`, offset)
+			}
+		}
+	}
+
+	// start from beginning
+	_, err := ds.source.Resume(nil)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	ds.enc.cipher.reset()
+	ds.offset = 0
+	return 0, nil
+}
+
+func (ds *decryptSource) Read(buf []byte) (int, error) {
+	n, err := ds.source.Read(buf)
+	ds.enc.cipher.decrypt(buf[:n])
+	ds.offset += int64(n)
+	return n, err
+}
+
+func (ds *decryptSource) ReadByte() (byte, error) {
+	n, err := ds.Read(ds.bytebuf)
+	if n == 0 && err == nil {
+		n, err = ds.Read(ds.bytebuf)
+	}
+	return ds.bytebuf[0], err
+}
+
+func (ds *decryptSource) Progress() float64 {
+	return ds.source.Progress()
+}
+
+// verify checks the rest of the payload once the entry is decompressed,
+// since decompressors don't necessarily read until the end.
+func (ds *decryptSource) verify() error {
+	rest := io.NewSectionReader(ds.reader, ds.enc.offset+ds.offset, ds.enc.size-ds.offset)
+	return ds.enc.cipher.verify(rest)
+}
+
+func init() {
+	gob.Register(&DecryptSourceCheckpoint{})
+	savior.RegisterCheckpointType("zipextractor.DecryptSourceCheckpoint", &DecryptSourceCheckpoint{})
+}
@@ -0,0 +1,288 @@
+package zipextractor
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// fileSource reads the contents of a single file entry, and verifies
+// them once it's all been read
+type fileSource struct {
+	source savior.Source
+	ds     *decryptSource
+	zf     *zip.File
+	entry  *savior.Entry
+
+	offset int64
+	cw     *crcWriter
+	// doneErr is set once the end has been read: it's io.EOF,
+	// or why the contents couldn't be verified
+	doneErr error
+	ssc     savior.SourceSaveConsumer
+	bytebuf []byte
+}
+
+// FileSourceCheckpoint is stored in checkpoints of sources
+// returned by ZipExtractor.EntrySource
+type FileSourceCheckpoint struct {
+	SourceCheckpoint *savior.SourceCheckpoint
+	// CRC32 of the entry's contents, up to the checkpoint's offset
+	CRC32 uint32
+}
+
+var _ savior.Source = (*fileSource)(nil)
+
+// lookupFile returns the file entry with the given name
+func (ze *ZipExtractor) lookupFile(name string) (*zip.File, *savior.Entry, error) {
+	for _, zf := range ze.zr.File {
+		if filepath.ToSlash(zf.Name) != name {
+			continue
+		}
+
+		entry := zipFileEntry(zf)
+		if entry.Kind != savior.EntryKindFile {
+			msg := fmt.Sprintf("zipextractor: %s is not a file", entry)
+			return nil, nil, errors.New(msg)
+		}
+
+		err := ze.limits.CheckEntry(entry)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		return zf, entry, nil
+	}
+	return nil, nil, errors.Wrapf(savior.ErrEntryNotFound, "%s", name)
+}
+
+// EntrySource returns a resumable source for the contents of the file entry
+// with the given name (as in Entry.CanonicalPath), and the entry itself. Its
+// checkpoints hold the CRC32 of what was read so far, and reading its end fails
+// with a *ChecksumError if the contents don't match. It returns an error
+// wrapping savior.ErrEntryNotFound if there's no such entry, and
+// zip.ErrAlgorithm for entries that need a registered decompressor.
+func (ze *ZipExtractor) EntrySource(name string) (savior.Source, *savior.Entry, error) {
+	zf, entry, err := ze.lookupFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var enc *encryption
+	if zf.Flags&flagEncrypted != 0 {
+		enc, err = ze.openEncryption(zf, entry)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	src, ds, err := ze.entrySource(zf, enc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if src == nil {
+		return nil, nil, errors.Wrapf(zip.ErrAlgorithm, "%s uses %s, which has no resumable source", name, methodName(zf.Method))
+	}
+
+	fs := &fileSource{
+		source:  src,
+		ds:      ds,
+		zf:      zf,
+		entry:   entry,
+		cw:      &crcWriter{w: io.Discard},
+		bytebuf: []byte{0x00},
+	}
+	fs.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			return nil
+		},
+	})
+	return fs, entry, nil
+}
+
+// ExtractEntryTo writes the contents of the file entry with the given name
+// to w, verifying them like Resume does, and returns the entry.
+func (ze *ZipExtractor) ExtractEntryTo(name string, w io.Writer) (*savior.Entry, error) {
+	zf, entry, err := ze.lookupFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	copier := savior.NewCopier(savior.NopSaveConsumer())
+
+	if _, ok := zipMethods[entryMethod(zf)]; !ok && zf.Flags&flagEncrypted == 0 {
+		// no resumable source, but the zip package may have a decompressor
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		defer rc.Close()
+
+		cw := &crcWriter{w: w}
+		err = copier.Do(&savior.CopyParams{
+			Src:    rc,
+			Dst:    cw,
+			Entry:  entry,
+			Limits: ze.limits,
+		})
+		if err != nil {
+			if errors.Cause(err) == zip.ErrChecksum {
+				return nil, &ChecksumError{Path: entry.CanonicalPath, Expected: zf.CRC32, Actual: cw.crc}
+			}
+			return nil, errors.WithStack(err)
+		}
+
+		err = cw.check(zf, entry)
+		if err != nil {
+			return nil, err
+		}
+		return entry, nil
+	}
+
+	src, _, err := ze.EntrySource(name)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = src.Resume(nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = copier.Do(&savior.CopyParams{
+		Src:    src,
+		Dst:    w,
+		Entry:  entry,
+		Limits: ze.limits,
+	})
+	if err != nil {
+		if ce, ok := errors.Cause(err).(*ChecksumError); ok {
+			return nil, ce
+		}
+		return nil, errors.WithStack(err)
+	}
+	return entry, nil
+}
+
+func (fs *fileSource) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "zip-file",
+		ResumeSupport: fs.source.Features().ResumeSupport,
+	}
+}
+
+func (fs *fileSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	fs.ssc = ssc
+	fs.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(sourceCheckpoint *savior.SourceCheckpoint) error {
+			return fs.ssc.Save(&savior.SourceCheckpoint{
+				Offset: fs.offset,
+				Data: &FileSourceCheckpoint{
+					SourceCheckpoint: sourceCheckpoint,
+					CRC32:            fs.cw.crc,
+				},
+			})
+		},
+	})
+}
+
+func (fs *fileSource) WantSave() {
+	fs.source.WantSave()
+}
+
+func (fs *fileSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	if checkpoint == nil {
+		_, err := fs.source.Resume(nil)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		fs.offset = 0
+		fs.cw.crc = 0
+		fs.doneErr = nil
+		return 0, nil
+	}
+
+	ourCheckpoint, ok := checkpoint.Data.(*FileSourceCheckpoint)
+	if !ok {
+		return 0, errors.Errorf("zipextractor: invalid file source checkpoint (%T)", checkpoint.Data)
+	}
+
+	offset, err := fs.source.Resume(ourCheckpoint.SourceCheckpoint)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if offset > checkpoint.Offset {
+		msg := fmt.Sprintf("zipextractor: source resumed at %d, past file checkpoint at %d", offset, checkpoint.Offset)
+		return 0, errors.New(msg)
+	}
+	if offset < checkpoint.Offset {
+		delta := checkpoint.Offset - offset
+		savior.Debugf(`%s: discarding %d bytes to align source and checkpoint`, fs.entry.CanonicalPath, delta)
+		err := savior.DiscardByRead(fs.source, delta)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+
+	fs.offset = checkpoint.Offset
+	fs.cw.crc = ourCheckpoint.CRC32
+	fs.doneErr = nil
+	return fs.offset, nil
+}
+
+func (fs *fileSource) Read(buf []byte) (int, error) {
+	if fs.doneErr != nil {
+		return 0, fs.doneErr
+	}
+
+	n, err := fs.source.Read(buf)
+	fs.cw.Write(buf[:n])
+	fs.offset += int64(n)
+
+	if err == io.EOF {
+		// verifying only works once
+		fs.doneErr = fs.verify()
+		return n, fs.doneErr
+	}
+	return n, err
+}
+
+func (fs *fileSource) verify() error {
+	if fs.ds != nil {
+		err := fs.ds.verify()
+		if err != nil {
+			return errors.Wrapf(err, "%s", fs.entry.CanonicalPath)
+		}
+	}
+
+	err := fs.cw.check(fs.zf, fs.entry)
+	if err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (fs *fileSource) ReadByte() (byte, error) {
+	for {
+		n, err := fs.Read(fs.bytebuf)
+		if n > 0 {
+			return fs.bytebuf[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (fs *fileSource) Progress() float64 {
+	return fs.source.Progress()
+}
+
+func init() {
+	gob.Register(&FileSourceCheckpoint{})
+	savior.RegisterCheckpointType("zipextractor.FileSourceCheckpoint", &FileSourceCheckpoint{})
+}
@@ -0,0 +1,110 @@
+package zipextractor
+
+import (
+	"fmt"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/bzip2source"
+	"github.com/itchio/savior/deflate64source"
+	"github.com/itchio/savior/flatesource"
+	"github.com/itchio/savior/lzmasource"
+	"github.com/itchio/savior/xzsource"
+	"github.com/itchio/savior/zstdsource"
+	"github.com/pkg/errors"
+)
+
+// Compression methods supported on top of those of the zip package
+const (
+	MethodDeflate64 uint16 = 9
+	MethodBzip2     uint16 = 12
+	MethodZstd      uint16 = 93
+	MethodXz        uint16 = 95
+)
+
+type zipMethod struct {
+	name string
+	// newSource returns a source that decompresses raw entry data
+	newSource func(raw savior.Source, zf *zip.File) savior.Source
+}
+
+// zipMethods are the compression methods we have resumable sources for,
+// anything else has to go through a decompressor registered with the zip package.
+var zipMethods = map[uint16]zipMethod{
+	zip.Store: {
+		name: "store",
+		newSource: func(raw savior.Source, zf *zip.File) savior.Source {
+			return raw
+		},
+	},
+	zip.Deflate: {
+		name: "deflate",
+		newSource: func(raw savior.Source, zf *zip.File) savior.Source {
+			return flatesource.New(raw)
+		},
+	},
+	MethodDeflate64: {
+		name: "deflate64",
+		newSource: func(raw savior.Source, zf *zip.File) savior.Source {
+			return deflate64source.New(raw)
+		},
+	},
+	zip.LZMA: {
+		name: "lzma",
+		newSource: func(raw savior.Source, zf *zip.File) savior.Source {
+			// the size is known even when there's an end marker
+			return lzmasource.NewZip(raw, int64(zf.UncompressedSize64))
+		},
+	},
+	MethodBzip2: {
+		name: "bzip2",
+		newSource: func(raw savior.Source, zf *zip.File) savior.Source {
+			return bzip2source.New(raw)
+		},
+	},
+	MethodZstd: {
+		name: "zstd",
+		newSource: func(raw savior.Source, zf *zip.File) savior.Source {
+			return zstdsource.New(raw)
+		},
+	},
+	MethodXz: {
+		name: "xz",
+		newSource: func(raw savior.Source, zf *zip.File) savior.Source {
+			return xzsource.New(raw)
+		},
+	},
+}
+
+func methodName(method uint16) string {
+	if m, ok := zipMethods[method]; ok {
+		return m.name
+	}
+	return fmt.Sprintf("method %d", method)
+}
+
+// methodResumeSupport returns how entries compressed like zf can be resumed:
+// block by block if we have a resumable source for their method, from the
+// start if the zip package has a decompressor for it, and not at all otherwise.
+func methodResumeSupport(zf *zip.File) savior.ResumeSupport {
+	if _, ok := zipMethods[entryMethod(zf)]; ok {
+		return savior.ResumeSupportBlock
+	}
+	if zf.Flags&flagEncrypted != 0 {
+		// only our sources can decompress what we decrypt
+		return savior.ResumeSupportNone
+	}
+
+	// the zip package doesn't expose its registry, but looks it up when
+	// opening an entry, before reading any of its data
+	rc, err := zf.Open()
+	if err != nil {
+		if errors.Is(err, zip.ErrAlgorithm) {
+			return savior.ResumeSupportNone
+		}
+		// extracting it will fail anyway
+		return savior.ResumeSupportEntry
+	}
+	rc.Close()
+	return savior.ResumeSupportEntry
+}
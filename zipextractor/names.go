@@ -0,0 +1,301 @@
+package zipextractor
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// NameEncodingAuto makes the extractor guess the encoding of entry names
+// that aren't valid UTF-8, see Params.NameEncoding
+const NameEncodingAuto = "auto"
+
+const nameEncodingSampleSize = 4096
+
+// nameCandidate is an encoding NameEncodingAuto can pick
+type nameCandidate struct {
+	// IANA name, which is what gets recorded in checkpoints
+	name     string
+	encoding encoding.Encoding
+	// weight tells how plausible a decoded (non-ASCII) rune is in a
+	// file name, given the rune before it: positive if it looks right
+	// (one per byte, so that double-byte encodings aren't at a disadvantage),
+	// zero if it could go either way, negative if it looks like mojibake
+	weight func(c *nameCandidate, r rune, prev rune) int
+}
+
+// nameCandidates are tried in order, the first one wins ties. CP437 comes
+// first since it's what the zip specification says names are in.
+var nameCandidates = []*nameCandidate{
+	{name: "IBM437", encoding: charmap.CodePage437, weight: alphabetWeight(unicode.Latin)},
+	{name: "Shift_JIS", encoding: japanese.ShiftJIS, weight: japaneseWeight},
+	// Hangul and GB2312 hanzi use the same byte ranges, but chinese names
+	// tend to use more of them, see chineseWeight
+	{name: "EUC-KR", encoding: korean.EUCKR, weight: koreanWeight},
+	{name: "GBK", encoding: simplifiedchinese.GBK, weight: chineseWeight(0xb0, 0xf7)},
+	{name: "Big5", encoding: traditionalchinese.Big5, weight: chineseWeight(0xa4, 0xc6)},
+	{name: "IBM866", encoding: charmap.CodePage866, weight: alphabetWeight(unicode.Cyrillic)},
+	{name: "windows-1251", encoding: charmap.Windows1251, weight: alphabetWeight(unicode.Cyrillic)},
+}
+
+// alphabetWeight favors letters of a given script, unless they change
+// case in the middle of a word, which single-byte decodings of
+// multi-byte names do a lot.
+func alphabetWeight(script *unicode.RangeTable) func(c *nameCandidate, r rune, prev rune) int {
+	return func(c *nameCandidate, r rune, prev rune) int {
+		switch {
+		case unicode.Is(script, r):
+			if unicode.IsUpper(r) && unicode.IsLower(prev) {
+				return -1
+			}
+			return 1
+		case unicode.Is(unicode.Common, r):
+			return 0
+		}
+		return -1
+	}
+}
+
+func japaneseWeight(c *nameCandidate, r rune, prev rune) int {
+	switch {
+	case r >= 0xff61 && r <= 0xff9f:
+		// half-width katakana, single bytes that CP437 names are full of
+		return 0
+	case unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+		return 2
+	case unicode.Is(unicode.Han, r):
+		// only count the first level of JIS X 0208 kanji
+		if b := encodeRune(c, r); len(b) == 2 && b[0] >= 0x88 && b[0] <= 0x98 {
+			return 2
+		}
+		return 0
+	case unicode.Is(unicode.Common, r):
+		return 0
+	}
+	return -1
+}
+
+func koreanWeight(c *nameCandidate, r rune, prev rune) int {
+	switch {
+	case unicode.Is(unicode.Hangul, r):
+		// only count KS X 1001 syllables, not those the UHC extension adds
+		if b := encodeRune(c, r); len(b) == 2 && b[0] >= 0xb0 && b[0] <= 0xc8 && b[1] >= 0xa1 {
+			return 2
+		}
+		return 0
+	case unicode.Is(unicode.Han, r), unicode.Is(unicode.Common, r):
+		return 0
+	}
+	return -1
+}
+
+// chineseWeight only counts hanzi whose lead byte is in a range of
+// frequently used characters (the first levels of GB2312 and Big5)
+func chineseWeight(minLead, maxLead byte) func(c *nameCandidate, r rune, prev rune) int {
+	return func(c *nameCandidate, r rune, prev rune) int {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			if b := encodeRune(c, r); len(b) == 2 && b[0] >= minLead && b[0] <= maxLead && b[1] >= 0xa1 {
+				return 2
+			}
+			return 0
+		case unicode.Is(unicode.Common, r):
+			return 0
+		}
+		return -1
+	}
+}
+
+func encodeRune(c *nameCandidate, r rune) []byte {
+	b, err := c.encoding.NewEncoder().Bytes([]byte(string(r)))
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// score tells how plausible names are in a candidate's encoding.
+// It returns false if any of them can't be decoded cleanly.
+func (c *nameCandidate) score(names [][]byte) (int, bool) {
+	decoder := c.encoding.NewDecoder()
+
+	score := 0
+	for _, name := range names {
+		decoded, err := decoder.Bytes(name)
+		if err != nil {
+			return 0, false
+		}
+
+		var prev rune
+		for _, r := range string(decoded) {
+			if r == utf8.RuneError || unicode.IsControl(r) || unicode.Is(unicode.Co, r) {
+				return 0, false
+			}
+			if r >= utf8.RuneSelf {
+				score += c.weight(c, r, prev)
+			}
+			prev = r
+		}
+	}
+	return score, true
+}
+
+// detectNameEncoding guesses the encoding of names that aren't valid UTF-8.
+// It returns "" if there's nothing to decode.
+func detectNameEncoding(rawNames [][]byte) string {
+	var sample [][]byte
+	sampleSize := 0
+	for _, name := range rawNames {
+		if utf8.Valid(name) {
+			continue
+		}
+		sample = append(sample, name)
+		sampleSize += len(name)
+		if sampleSize > nameEncodingSampleSize {
+			break
+		}
+	}
+
+	if len(sample) == 0 {
+		return ""
+	}
+
+	best, bestScore := nameCandidates[0], 0
+	for _, c := range nameCandidates {
+		score, ok := c.score(sample)
+		if ok && score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+	savior.Debugf("zip: names look like %s (score %d)", best.name, bestScore)
+	return best.name
+}
+
+// lookupNameEncoding returns the encoding of a recorded name encoding decision
+func lookupNameEncoding(name string) (encoding.Encoding, error) {
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil || enc == nil {
+		return nil, errors.Errorf("zipextractor: unsupported name encoding %q", name)
+	}
+	return enc, nil
+}
+
+// resolveNameEncoding turns Params.NameEncoding into a decision: an IANA
+// encoding name, or "" to keep names as the zip package decoded them.
+func resolveNameEncoding(param string, rawNames func() ([][]byte, error)) (string, error) {
+	switch param {
+	case "":
+		return "", nil
+	case NameEncodingAuto:
+		names, err := rawNames()
+		if err != nil {
+			return "", err
+		}
+		return detectNameEncoding(names), nil
+	}
+
+	enc, err := lookupNameEncoding(param)
+	if err != nil {
+		return "", err
+	}
+	name, err := ianaindex.IANA.Name(enc)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return name, nil
+}
+
+// useNameEncoding renames entries according to a name encoding decision.
+// Names that are valid UTF-8 are kept as-is, since lots of tools don't set
+// the UTF-8 flag.
+func (ze *ZipExtractor) useNameEncoding(name string) error {
+	files := ze.zr.File
+
+	if ze.zipNames == nil {
+		ze.zipNames = make([]string, len(files))
+		for i, f := range files {
+			ze.zipNames[i] = f.Name
+		}
+	}
+
+	names := ze.zipNames
+	if name != "" {
+		rawNames, err := ze.getRawNames()
+		if err != nil {
+			return err
+		}
+
+		enc, err := lookupNameEncoding(name)
+		if err != nil {
+			return err
+		}
+		decoder := enc.NewDecoder()
+
+		names = make([]string, len(files))
+		for i, raw := range rawNames {
+			if utf8.Valid(raw) {
+				names[i] = string(raw)
+				continue
+			}
+			decoded, err := decoder.Bytes(raw)
+			if err != nil {
+				return errors.Wrapf(err, "decoding entry name as %s", name)
+			}
+			names[i] = string(decoded)
+		}
+	}
+
+	for i, f := range files {
+		f.Name = names[i]
+	}
+	ze.nameEncoding = name
+
+	// names changed, so they need checking again
+	err := checkNames(ze.zr)
+	if err == zip.ErrInsecurePath && ze.normalizeBackslashes {
+		err = normalizeBackslashNames(ze.zr)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (ze *ZipExtractor) getRawNames() ([][]byte, error) {
+	if ze.rawNames == nil {
+		rawNames, err := readRawNames(ze.reader, ze.readerSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(rawNames) != len(ze.zr.File) {
+			return nil, errors.Errorf("zipextractor: found %d names in central directory, expected %d", len(rawNames), len(ze.zr.File))
+		}
+		ze.rawNames = rawNames
+	}
+	return ze.rawNames, nil
+}
+
+// checkNames does the same checks as zip.NewReader
+func checkNames(zr *zip.Reader) error {
+	for _, f := range zr.File {
+		if f.Name == "" {
+			continue
+		}
+		if !filepath.IsLocal(f.Name) || strings.Contains(f.Name, `\`) {
+			return zip.ErrInsecurePath
+		}
+	}
+	return nil
+}
@@ -0,0 +1,546 @@
+package zipextractor
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// EntryCheckpoint is where the extraction of an entry is at,
+// see ZipExtractorState.InFlight
+type EntryCheckpoint struct {
+	EntryIndex       int64
+	Entry            *savior.Entry
+	SourceCheckpoint *savior.SourceCheckpoint
+	// CRC32 of the entry's contents, up to Entry.WriteOffset
+	CRC32 uint32
+}
+
+// errAborted is returned by the writers of workers once another
+// worker has failed, or extraction has stopped
+var errAborted = errors.New("zipextractor: extraction aborted")
+
+func isParallelCheckpoint(checkpoint *savior.ExtractorCheckpoint) bool {
+	state, ok := checkpoint.Data.(*ZipExtractorState)
+	return ok && (len(state.Completed) > 0 || len(state.InFlight) > 0)
+}
+
+// parallelExtraction extracts several entries at once. When it's time to
+// save, all workers that are copying get asked to save. Each records the
+// position of its entry and keeps going, and the checkpoint is emitted
+// once they all have (or are done with their entry). By then, the positions
+// recorded first are a bit behind, but resuming an entry from further back
+// than needed only means decompressing some of it again.
+type parallelExtraction struct {
+	ze         *ZipExtractor
+	ctx        context.Context
+	sink       savior.Sink
+	getWriter  func(entry *savior.Entry) (savior.EntryWriter, error)
+	envelope   *savior.CheckpointEnvelope
+	totalBytes int64
+
+	// set when workers should stop writing
+	aborted atomic.Bool
+
+	mu    sync.Mutex
+	queue []*EntryCheckpoint
+	// entryIndex is the first entry that isn't done
+	entryIndex int64
+	// completed holds entries past entryIndex that are done
+	completed map[int64]bool
+	// inFlight holds the last checkpoint of entries being extracted,
+	// or nil for those that haven't saved one yet
+	inFlight  map[int64]*EntryCheckpoint
+	written   map[int64]int64
+	doneBytes int64
+	// copying holds entries whose workers are copying, and can save
+	copying map[int64]bool
+	// extracting holds the folded paths of entries being extracted,
+	// see next. workers wait on cond for them to be done.
+	extracting map[string]bool
+	cond       *sync.Cond
+	// saving is set while waiting for workers to save, pending
+	// holds the entries that haven't yet
+	saving    bool
+	pending   map[int64]bool
+	stopError error
+	err       error
+}
+
+func (ze *ZipExtractor) resumeParallel(ctx context.Context, checkpoint *savior.ExtractorCheckpoint, sink savior.Sink, totalBytes int64) (*savior.ExtractorResult, error) {
+	zr := ze.zr
+	numEntries := int64(len(zr.File))
+
+	numWorkers := ze.Workers()
+	getWriter := sink.GetWriter
+	if cs, ok := sink.(savior.ConcurrentSink); ok {
+		getWriter = cs.GetConcurrentWriter
+	} else if numWorkers > 1 {
+		ze.consumer.Debugf("Sink isn't a ConcurrentSink, extracting one entry at a time")
+		numWorkers = 1
+	}
+
+	pe := &parallelExtraction{
+		ze:         ze,
+		ctx:        ctx,
+		sink:       sink,
+		getWriter:  getWriter,
+		envelope:   checkpoint.Envelope,
+		totalBytes: totalBytes,
+
+		entryIndex: checkpoint.EntryIndex,
+		completed:  make(map[int64]bool),
+		inFlight:   make(map[int64]*EntryCheckpoint),
+		written:    make(map[int64]int64),
+		copying:    make(map[int64]bool),
+		extracting: make(map[string]bool),
+	}
+	pe.cond = sync.NewCond(&pe.mu)
+
+	var inFlight []*EntryCheckpoint
+	state, _ := checkpoint.Data.(*ZipExtractorState)
+	if state != nil {
+		for _, entryIndex := range state.Completed {
+			pe.completed[entryIndex] = true
+		}
+		inFlight = append(inFlight, state.InFlight...)
+	}
+	if checkpoint.Entry != nil {
+		// made while extracting one entry at a time
+		ec := &EntryCheckpoint{
+			EntryIndex:       checkpoint.EntryIndex,
+			Entry:            checkpoint.Entry,
+			SourceCheckpoint: checkpoint.SourceCheckpoint,
+		}
+		if state != nil {
+			ec.CRC32 = state.CRC32
+		}
+		inFlight = append(inFlight, ec)
+	}
+	for _, ec := range inFlight {
+		if ec == nil || ec.Entry == nil || ec.EntryIndex < checkpoint.EntryIndex || ec.EntryIndex >= numEntries {
+			return nil, errors.New("zipextractor: invalid in-flight entry in checkpoint")
+		}
+		if pe.completed[ec.EntryIndex] {
+			msg := fmt.Sprintf("zipextractor: entry %d is both completed and in flight in checkpoint", ec.EntryIndex)
+			return nil, errors.New(msg)
+		}
+		pe.inFlight[ec.EntryIndex] = ec
+		pe.written[ec.EntryIndex] = ec.Entry.WriteOffset
+	}
+	sort.Slice(inFlight, func(i, j int) bool {
+		return inFlight[i].EntryIndex < inFlight[j].EntryIndex
+	})
+
+	// in-flight entries go first, so they can be done with. entries
+	// that have the same path are all extracted, in order, like when
+	// extracting one at a time, see next.
+	pe.queue = inFlight
+	for i, zf := range zr.File {
+		entryIndex := int64(i)
+		if !ze.includes(zf) {
+			continue
+		}
+
+		if entryIndex < pe.entryIndex || pe.completed[entryIndex] {
+			pe.doneBytes += int64(zf.UncompressedSize64)
+			continue
+		}
+		if _, ok := pe.inFlight[entryIndex]; ok {
+			continue
+		}
+		pe.queue = append(pe.queue, &EntryCheckpoint{EntryIndex: entryIndex})
+	}
+	pe.advance()
+
+	ze.consumer.Debugf("Extracting with %d workers, %d entries to go", numWorkers, len(pe.queue))
+
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pe.work()
+		}()
+	}
+	wg.Wait()
+
+	if pe.err != nil {
+		return nil, pe.err
+	}
+	if pe.stopError != nil {
+		return nil, pe.stopError
+	}
+
+	if pe.entryIndex < numEntries {
+		// workers stopped between entries, that's an easy checkpoint to make
+		_, err := ze.saveConsumer.Save(pe.checkpoint())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return nil, savior.NewCancelledError(ctx)
+	}
+
+	return ze.result(), nil
+}
+
+// copySourceCheckpoint returns a deep copy of a source checkpoint. Sources can
+// keep using the buffers of checkpoints they emit or resume from, which is
+// fine when they're saved right away, but checkpoints of in-flight entries
+// are kept around until their entry is done.
+func copySourceCheckpoint(checkpoint *savior.SourceCheckpoint) (*savior.SourceCheckpoint, error) {
+	if checkpoint == nil {
+		return nil, nil
+	}
+
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(checkpoint)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	res := &savior.SourceCheckpoint{}
+	err = gob.NewDecoder(buf).Decode(res)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return res, nil
+}
+
+// foldedPathKey is the same for entries that are extracted to the
+// same file on case-insensitive filesystems
+func foldedPathKey(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "/"))
+}
+
+func (pe *parallelExtraction) work() {
+	wsc := &workerSaveConsumer{pe: pe}
+	copier := savior.NewCopier(wsc)
+
+	for {
+		ec := pe.next()
+		if ec == nil {
+			return
+		}
+
+		wsc.entryIndex = ec.EntryIndex
+		err := pe.extract(copier, ec)
+		pe.finish(ec, err)
+	}
+}
+
+// next returns the next entry to extract, or nil if there's none,
+// or if extraction is stopping. Entries that have the same path, or
+// whose paths only differ by case, since they may be the same file, are
+// extracted one after the other, in order: it waits for the previous
+// one if needed.
+func (pe *parallelExtraction) next() *EntryCheckpoint {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	var ec *EntryCheckpoint
+	for ec == nil {
+		if pe.stopping() || pe.ctx.Err() != nil || len(pe.queue) == 0 {
+			return nil
+		}
+
+		for i, candidate := range pe.queue {
+			key := foldedPathKey(pe.ze.zr.File[candidate.EntryIndex].Name)
+			if pe.extracting[key] {
+				continue
+			}
+
+			ec = candidate
+			pe.queue = append(pe.queue[:i:i], pe.queue[i+1:]...)
+			pe.extracting[key] = true
+			break
+		}
+		if ec == nil {
+			// woken up by finish
+			pe.cond.Wait()
+		}
+	}
+
+	if _, ok := pe.inFlight[ec.EntryIndex]; !ok {
+		pe.inFlight[ec.EntryIndex] = nil
+		pe.written[ec.EntryIndex] = 0
+	}
+	return ec
+}
+
+// stopping must be called with mu held
+func (pe *parallelExtraction) stopping() bool {
+	return pe.err != nil || pe.stopError != nil
+}
+
+func (pe *parallelExtraction) extract(copier *savior.Copier, ec *EntryCheckpoint) (retErr error) {
+	zf := pe.ze.zr.File[ec.EntryIndex]
+
+	// the worker's entry changes as it's written, checkpoints get copies
+	var entry *savior.Entry
+	if ec.Entry != nil {
+		entryCopy := *ec.Entry
+		entry = &entryCopy
+	} else {
+		entry = zipFileEntry(zf)
+	}
+	sourceCheckpoint, err := copySourceCheckpoint(ec.SourceCheckpoint)
+	if err != nil {
+		return err
+	}
+	ec = &EntryCheckpoint{
+		EntryIndex:       ec.EntryIndex,
+		Entry:            entry,
+		SourceCheckpoint: sourceCheckpoint,
+		CRC32:            ec.CRC32,
+	}
+
+	var writer savior.EntryWriter
+	defer func() {
+		if writer != nil {
+			err := writer.Close()
+			if err != nil && retErr == nil {
+				retErr = errors.WithStack(err)
+			}
+		}
+	}()
+
+	return pe.ze.extractEntry(pe.ctx, copier, zf, ec, true, pe.sink, &entryHooks{
+		getWriter: func(entry *savior.Entry) (savior.EntryWriter, error) {
+			w, err := pe.getWriter(entry)
+			if err != nil {
+				return nil, err
+			}
+			writer = w
+			return &workerWriter{pe: pe, w: w}, nil
+		},
+		save: func(sourceCheckpoint *savior.SourceCheckpoint, crc uint32) (bool, error) {
+			return pe.save(ec, sourceCheckpoint, crc)
+		},
+		progress: func() {
+			pe.progress(ec)
+		},
+	})
+}
+
+func (pe *parallelExtraction) save(ec *EntryCheckpoint, sourceCheckpoint *savior.SourceCheckpoint, crc uint32) (bool, error) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	if pe.stopping() {
+		return true, nil
+	}
+
+	sourceCheckpoint, err := copySourceCheckpoint(sourceCheckpoint)
+	if err != nil {
+		return false, err
+	}
+
+	entry := *ec.Entry
+	pe.inFlight[ec.EntryIndex] = &EntryCheckpoint{
+		EntryIndex:       ec.EntryIndex,
+		Entry:            &entry,
+		SourceCheckpoint: sourceCheckpoint,
+		CRC32:            crc,
+	}
+	pe.written[ec.EntryIndex] = entry.WriteOffset
+
+	if !pe.saving && pe.ctx.Err() != nil {
+		// copiers ask for a final save when the context is done
+		pe.startSaving()
+	}
+	delete(pe.pending, ec.EntryIndex)
+
+	err = pe.maybeEmit()
+	if err != nil {
+		return false, err
+	}
+	return pe.stopping(), nil
+}
+
+func (pe *parallelExtraction) progress(ec *EntryCheckpoint) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	pe.written[ec.EntryIndex] = ec.Entry.WriteOffset
+	pe.ze.consumer.Progress(pe.computeProgress())
+}
+
+func (pe *parallelExtraction) finish(ec *EntryCheckpoint, err error) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	delete(pe.copying, ec.EntryIndex)
+	delete(pe.pending, ec.EntryIndex)
+	delete(pe.extracting, foldedPathKey(pe.ze.zr.File[ec.EntryIndex].Name))
+	pe.cond.Broadcast()
+
+	if err != nil {
+		cause := errors.Cause(err)
+		if cause != errAborted && cause != savior.ErrStop {
+			pe.fail(err)
+		}
+		// otherwise it was interrupted, and stays in flight
+	} else if !pe.stopping() {
+		delete(pe.inFlight, ec.EntryIndex)
+		delete(pe.written, ec.EntryIndex)
+		pe.completed[ec.EntryIndex] = true
+		pe.doneBytes += int64(pe.ze.zr.File[ec.EntryIndex].UncompressedSize64)
+		pe.advance()
+	}
+
+	err = pe.maybeEmit()
+	if err != nil {
+		pe.fail(err)
+	}
+}
+
+// fail must be called with mu held
+func (pe *parallelExtraction) fail(err error) {
+	if pe.err == nil {
+		pe.err = err
+		pe.aborted.Store(true)
+	}
+}
+
+// startSaving must be called with mu held
+func (pe *parallelExtraction) startSaving() {
+	pe.saving = true
+	pe.pending = make(map[int64]bool)
+	for entryIndex := range pe.copying {
+		pe.pending[entryIndex] = true
+	}
+}
+
+// maybeEmit saves a checkpoint if all workers that were asked to save
+// have, must be called with mu held
+func (pe *parallelExtraction) maybeEmit() error {
+	if !pe.saving || len(pe.pending) > 0 || pe.stopping() {
+		return nil
+	}
+	pe.saving = false
+
+	action, err := pe.ze.saveConsumer.Save(pe.checkpoint())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if pe.ctx.Err() != nil {
+		pe.stopError = savior.NewCancelledError(pe.ctx)
+	} else if action == savior.AfterSaveStop {
+		pe.stopError = savior.ErrStop
+	}
+	if pe.stopError != nil {
+		pe.aborted.Store(true)
+	}
+	return nil
+}
+
+// advance moves entryIndex past entries that are done,
+// must be called with mu held
+func (pe *parallelExtraction) advance() {
+	files := pe.ze.zr.File
+	for pe.entryIndex < int64(len(files)) {
+		if pe.ze.includes(files[pe.entryIndex]) && !pe.completed[pe.entryIndex] {
+			break
+		}
+		delete(pe.completed, pe.entryIndex)
+		pe.entryIndex++
+	}
+}
+
+// checkpoint must be called with mu held
+func (pe *parallelExtraction) checkpoint() *savior.ExtractorCheckpoint {
+	state := &ZipExtractorState{
+		NameEncoding: pe.ze.nameEncoding,
+	}
+	for entryIndex := range pe.completed {
+		state.Completed = append(state.Completed, entryIndex)
+	}
+	sort.Slice(state.Completed, func(i, j int) bool {
+		return state.Completed[i] < state.Completed[j]
+	})
+	for _, ec := range pe.inFlight {
+		if ec != nil {
+			state.InFlight = append(state.InFlight, ec)
+		}
+	}
+	sort.Slice(state.InFlight, func(i, j int) bool {
+		return state.InFlight[i].EntryIndex < state.InFlight[j].EntryIndex
+	})
+
+	return &savior.ExtractorCheckpoint{
+		EntryIndex: pe.entryIndex,
+		Progress:   pe.computeProgress(),
+		Envelope:   pe.envelope,
+		Data:       state,
+	}
+}
+
+// computeProgress must be called with mu held
+func (pe *parallelExtraction) computeProgress() float64 {
+	actualDoneBytes := pe.doneBytes
+	for _, written := range pe.written {
+		actualDoneBytes += written
+	}
+	return float64(actualDoneBytes) / float64(pe.totalBytes)
+}
+
+// workerSaveConsumer lets workers share the extractor's save consumer.
+// Once it's time to save, it asks all workers to, until they have.
+type workerSaveConsumer struct {
+	pe         *parallelExtraction
+	entryIndex int64
+}
+
+var _ savior.SaveConsumer = (*workerSaveConsumer)(nil)
+
+func (wsc *workerSaveConsumer) ShouldSave(copiedBytes int64) bool {
+	pe := wsc.pe
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	pe.copying[wsc.entryIndex] = true
+	if !pe.saving && pe.ze.saveConsumer.ShouldSave(copiedBytes) {
+		pe.startSaving()
+	}
+	return pe.saving
+}
+
+func (wsc *workerSaveConsumer) Save(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+	wsc.pe.mu.Lock()
+	defer wsc.pe.mu.Unlock()
+
+	return wsc.pe.ze.saveConsumer.Save(checkpoint)
+}
+
+// workerWriter fails writes once extraction is aborted,
+// so that all workers stop early
+type workerWriter struct {
+	pe *parallelExtraction
+	w  savior.EntryWriter
+}
+
+var _ savior.EntryWriter = (*workerWriter)(nil)
+
+func (ww *workerWriter) Write(buf []byte) (int, error) {
+	if ww.pe.aborted.Load() {
+		return 0, errAborted
+	}
+	return ww.w.Write(buf)
+}
+
+func (ww *workerWriter) Close() error {
+	return ww.w.Close()
+}
+
+func (ww *workerWriter) Sync() error {
+	return ww.w.Sync()
+}
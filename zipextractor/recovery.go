@@ -0,0 +1,507 @@
+package zipextractor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/pkg/errors"
+)
+
+const (
+	fileHeaderSignature     = 0x04034b50
+	dataDescriptorSignature = 0x08074b50
+	fileHeaderLen           = 30
+	zip64ExtraID            = 0x0001
+	uint32max               = 0xffffffff
+	uint16max               = 0xffff
+	msdosDir                = 0x10
+	signatureSearchChunk    = 64 * 1024
+)
+
+// RecoveryReport tells what was found by scanning local file headers,
+// when the central directory of an archive couldn't be read, see Params.Recover
+type RecoveryReport struct {
+	// Recovered lists the entries whose data is all there, which can be extracted
+	Recovered []string
+	// Unreadable lists the entries that were found, but can't be extracted
+	Unreadable []UnreadableEntry
+	// SkippedBytes counts damaged bytes that were skipped while looking
+	// for local file headers
+	SkippedBytes int64
+}
+
+// An UnreadableEntry is an entry that was found while recovering an archive,
+// but couldn't be recovered
+type UnreadableEntry struct {
+	// Name of the entry, empty if its header was cut short
+	Name string
+	// Offset of its local file header
+	Offset int64
+	// Reason it's unreadable, like "truncated"
+	Reason string
+}
+
+var errTruncated = errors.New("truncated")
+var errUndelimited = errors.New("end of data not found")
+
+// localEntry is what a local file header (and data descriptor) says about an entry
+type localEntry struct {
+	offset           int64
+	version          uint16
+	flags            uint16
+	method           uint16
+	modifiedTime     uint16
+	modifiedDate     uint16
+	crc32            uint32
+	compressedSize   uint64
+	uncompressedSize uint64
+	name             []byte
+	// extra fields, except for zip64 ones
+	extra []byte
+}
+
+// recoverArchive scans local file headers to find entries, and returns a
+// reader over the intact part of the archive, followed by a new central
+// directory for the entries that were found.
+func recoverArchive(r io.ReaderAt, size int64) (io.ReaderAt, int64, *RecoveryReport, error) {
+	report := &RecoveryReport{}
+
+	offset, ok, err := findSignature(r, 0, size, fileHeaderSignature)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if !ok {
+		return nil, 0, nil, errors.WithStack(zip.ErrFormat)
+	}
+
+	var entries []*localEntry
+	dataEnd := offset
+
+scan:
+	for offset < size {
+		var sig [4]byte
+		_, err := r.ReadAt(sig[:], offset)
+		if err != nil {
+			break
+		}
+
+		switch binary.LittleEndian.Uint32(sig[:]) {
+		case fileHeaderSignature:
+			// that's what we're looking for
+		case directoryHeaderSignature, directory64EndSignature, directoryEndSignature:
+			// entries stop where the (damaged) central directory starts
+			break scan
+		default:
+			next, ok, err := findSignature(r, offset+1, size, fileHeaderSignature)
+			if err != nil {
+				return nil, 0, nil, err
+			}
+			if !ok {
+				report.SkippedBytes += size - offset
+				break scan
+			}
+			report.SkippedBytes += next - offset
+			offset = next
+			continue
+		}
+
+		e, next, err := readLocalEntry(r, offset, size)
+		if err != nil {
+			unreadable := UnreadableEntry{
+				Offset: offset,
+				Reason: errors.Cause(err).Error(),
+			}
+			if e != nil {
+				unreadable.Name = string(e.name)
+			}
+			report.Unreadable = append(report.Unreadable, unreadable)
+
+			if errors.Cause(err) == errTruncated {
+				// there's nothing after
+				break
+			}
+
+			// look for the next entry, it could be intact
+			next, ok, err = findSignature(r, offset+1, size, fileHeaderSignature)
+			if err != nil {
+				return nil, 0, nil, err
+			}
+			if !ok {
+				break
+			}
+			offset = next
+			continue
+		}
+
+		entries = append(entries, e)
+		dataEnd = next
+		offset = next
+	}
+
+	directory := buildDirectory(entries, dataEnd)
+	rr := &recoveredReaderAt{
+		r:         r,
+		dataEnd:   dataEnd,
+		directory: directory,
+	}
+	return rr, dataEnd + int64(len(directory)), report, nil
+}
+
+// readLocalEntry reads a local file header, finds where its data ends, and
+// returns the offset right after it (and after its data descriptor, if any)
+func readLocalEntry(r io.ReaderAt, offset int64, size int64) (*localEntry, int64, error) {
+	var header [fileHeaderLen]byte
+	_, err := r.ReadAt(header[:], offset)
+	if err != nil {
+		return nil, 0, errors.WithStack(errTruncated)
+	}
+
+	e, nameLen, extraLen := parseLocalHeader(header[:], offset)
+
+	variable := make([]byte, nameLen+extraLen)
+	_, err = r.ReadAt(variable, offset+fileHeaderLen)
+	if err != nil {
+		return nil, 0, errors.WithStack(errTruncated)
+	}
+	e.name = variable[:nameLen]
+	isZip64 := e.parseExtra(variable[nameLen:])
+
+	dataStart := offset + fileHeaderLen + nameLen + extraLen
+	if e.flags&flagDataDescriptor == 0 {
+		dataEnd := dataStart + int64(e.compressedSize)
+		if dataEnd > size {
+			return e, 0, errors.WithStack(errTruncated)
+		}
+		return e, dataEnd, nil
+	}
+
+	if e.compressedSize == 0 {
+		// sizes (and CRC) are only in the data descriptor, which
+		// means finding where the data ends first
+		if e.method == zip.Deflate && e.flags&flagEncrypted == 0 {
+			err = e.inflateToEnd(r, dataStart, size)
+		} else {
+			err = e.searchDataDescriptor(r, dataStart, size, isZip64)
+		}
+		if err != nil {
+			return e, 0, err
+		}
+	}
+
+	dataEnd := dataStart + int64(e.compressedSize)
+	next, err := e.readDataDescriptor(r, dataEnd, size, isZip64)
+	if err != nil {
+		return e, 0, err
+	}
+	return e, next, nil
+}
+
+// parseLocalHeader parses the fixed-size part of a local file header, found
+// at offset, and returns the lengths of the name and extra fields after it
+func parseLocalHeader(header []byte, offset int64) (*localEntry, int64, int64) {
+	b := header[4:]
+	e := &localEntry{
+		offset:           offset,
+		version:          binary.LittleEndian.Uint16(b[0:]),
+		flags:            binary.LittleEndian.Uint16(b[2:]),
+		method:           binary.LittleEndian.Uint16(b[4:]),
+		modifiedTime:     binary.LittleEndian.Uint16(b[6:]),
+		modifiedDate:     binary.LittleEndian.Uint16(b[8:]),
+		crc32:            binary.LittleEndian.Uint32(b[10:]),
+		compressedSize:   uint64(binary.LittleEndian.Uint32(b[14:])),
+		uncompressedSize: uint64(binary.LittleEndian.Uint32(b[18:])),
+	}
+	nameLen := int64(binary.LittleEndian.Uint16(b[22:]))
+	extraLen := int64(binary.LittleEndian.Uint16(b[24:]))
+	return e, nameLen, extraLen
+}
+
+// parseExtra keeps the extra fields of a local file header, except for zip64
+// ones, which have both sizes. It returns true if there was a zip64 field.
+func (e *localEntry) parseExtra(extra []byte) bool {
+	isZip64 := false
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		fieldLen := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+fieldLen > len(extra) {
+			break
+		}
+		field := extra[4 : 4+fieldLen]
+		if id == zip64ExtraID {
+			isZip64 = true
+			if e.uncompressedSize == uint32max && len(field) >= 8 {
+				e.uncompressedSize = binary.LittleEndian.Uint64(field)
+				field = field[8:]
+			}
+			if e.compressedSize == uint32max && len(field) >= 8 {
+				e.compressedSize = binary.LittleEndian.Uint64(field)
+			}
+		} else {
+			e.extra = append(e.extra, extra[:4+fieldLen]...)
+		}
+		extra = extra[4+fieldLen:]
+	}
+	return isZip64
+}
+
+// inflateToEnd finds the end of a deflate stream by decompressing it
+func (e *localEntry) inflateToEnd(r io.ReaderAt, dataStart int64, size int64) error {
+	cr := &countingByteReader{r: bufio.NewReader(io.NewSectionReader(r, dataStart, size-dataStart))}
+	fr := flate.NewReader(cr)
+	defer fr.Close()
+
+	_, err := io.Copy(io.Discard, fr)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return errors.WithStack(errTruncated)
+		}
+		return errors.WithStack(errUndelimited)
+	}
+	e.compressedSize = uint64(cr.n)
+	return nil
+}
+
+// searchDataDescriptor looks for a data descriptor whose compressed size
+// matches its distance from the start of the data
+func (e *localEntry) searchDataDescriptor(r io.ReaderAt, dataStart int64, size int64, isZip64 bool) error {
+	offset := dataStart
+	for {
+		candidate, ok, err := findSignature(r, offset, size, dataDescriptorSignature)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.WithStack(errTruncated)
+		}
+
+		var desc [24]byte
+		n, _ := r.ReadAt(desc[:], candidate)
+		distance := uint64(candidate - dataStart)
+		if n >= 16 && uint64(binary.LittleEndian.Uint32(desc[8:])) == distance {
+			e.compressedSize = distance
+			return nil
+		}
+		if isZip64 && n >= 24 && binary.LittleEndian.Uint64(desc[8:]) == distance {
+			e.compressedSize = distance
+			return nil
+		}
+		offset = candidate + 1
+	}
+}
+
+// readDataDescriptor reads the data descriptor right after an entry's data,
+// which may or may not have a signature
+func (e *localEntry) readDataDescriptor(r io.ReaderAt, offset int64, size int64, isZip64 bool) (int64, error) {
+	descLen := int64(12)
+	if isZip64 {
+		descLen = 20
+	}
+
+	var sig [4]byte
+	_, err := r.ReadAt(sig[:], offset)
+	if err != nil {
+		return 0, errors.WithStack(errTruncated)
+	}
+	if binary.LittleEndian.Uint32(sig[:]) == dataDescriptorSignature {
+		offset += 4
+	}
+
+	desc := make([]byte, descLen)
+	_, err = r.ReadAt(desc, offset)
+	if err != nil {
+		return 0, errors.WithStack(errTruncated)
+	}
+
+	e.crc32 = binary.LittleEndian.Uint32(desc)
+	if isZip64 {
+		e.uncompressedSize = binary.LittleEndian.Uint64(desc[12:])
+	} else {
+		e.uncompressedSize = uint64(binary.LittleEndian.Uint32(desc[8:]))
+	}
+	return offset + descLen, nil
+}
+
+type countingByteReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (cr *countingByteReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingByteReader) ReadByte() (byte, error) {
+	c, err := cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return c, err
+}
+
+// buildDirectory makes a central directory (and its end) for recovered
+// entries, to be found at directoryOffset. Recovered entries don't have
+// attributes, since those only are in the central directory.
+func buildDirectory(entries []*localEntry, directoryOffset int64) []byte {
+	buf := new(bytes.Buffer)
+	le := binary.LittleEndian
+
+	for _, e := range entries {
+		var zip64 []byte
+		compressedSize := uint32(e.compressedSize)
+		if e.compressedSize >= uint32max {
+			compressedSize = uint32max
+		}
+		uncompressedSize := uint32(e.uncompressedSize)
+		if e.uncompressedSize >= uint32max {
+			uncompressedSize = uint32max
+		}
+		offset := uint32(e.offset)
+		if e.offset >= uint32max {
+			offset = uint32max
+		}
+		// zip64 fields only hold what didn't fit, in this order
+		if uncompressedSize == uint32max {
+			zip64 = le.AppendUint64(zip64, e.uncompressedSize)
+		}
+		if compressedSize == uint32max {
+			zip64 = le.AppendUint64(zip64, e.compressedSize)
+		}
+		if offset == uint32max {
+			zip64 = le.AppendUint64(zip64, uint64(e.offset))
+		}
+
+		extra := e.extra
+		if len(zip64) > 0 {
+			extra = le.AppendUint16(nil, zip64ExtraID)
+			extra = le.AppendUint16(extra, uint16(len(zip64)))
+			extra = append(append(extra, zip64...), e.extra...)
+		}
+
+		var externalAttrs uint32
+		if len(e.name) > 0 && e.name[len(e.name)-1] == '/' {
+			externalAttrs = msdosDir
+		}
+
+		var h [directoryHeaderLen]byte
+		le.PutUint32(h[0:], directoryHeaderSignature)
+		le.PutUint16(h[4:], e.version&0xff) // made by MS-DOS
+		le.PutUint16(h[6:], e.version)
+		le.PutUint16(h[8:], e.flags)
+		le.PutUint16(h[10:], e.method)
+		le.PutUint16(h[12:], e.modifiedTime)
+		le.PutUint16(h[14:], e.modifiedDate)
+		le.PutUint32(h[16:], e.crc32)
+		le.PutUint32(h[20:], compressedSize)
+		le.PutUint32(h[24:], uncompressedSize)
+		le.PutUint16(h[28:], uint16(len(e.name)))
+		le.PutUint16(h[30:], uint16(len(extra)))
+		le.PutUint32(h[38:], externalAttrs)
+		le.PutUint32(h[42:], offset)
+		buf.Write(h[:])
+		buf.Write(e.name)
+		buf.Write(extra)
+	}
+
+	directorySize := int64(buf.Len())
+	records := uint64(len(entries))
+	if records >= uint16max || directorySize >= uint32max || directoryOffset >= uint32max {
+		end64Offset := directoryOffset + directorySize
+
+		var end64 [directory64EndLen]byte
+		le.PutUint32(end64[0:], directory64EndSignature)
+		le.PutUint64(end64[4:], directory64EndLen-12)
+		le.PutUint16(end64[12:], 45)
+		le.PutUint16(end64[14:], 45)
+		le.PutUint64(end64[24:], records)
+		le.PutUint64(end64[32:], records)
+		le.PutUint64(end64[40:], uint64(directorySize))
+		le.PutUint64(end64[48:], uint64(directoryOffset))
+		buf.Write(end64[:])
+
+		var loc [directory64LocLen]byte
+		le.PutUint32(loc[0:], directory64LocSignature)
+		le.PutUint64(loc[8:], uint64(end64Offset))
+		le.PutUint32(loc[16:], 1)
+		buf.Write(loc[:])
+
+		records = uint16max
+		directorySize = uint32max
+		directoryOffset = uint32max
+	}
+
+	var end [directoryEndLen]byte
+	le.PutUint32(end[0:], directoryEndSignature)
+	le.PutUint16(end[8:], uint16(records))
+	le.PutUint16(end[10:], uint16(records))
+	le.PutUint32(end[12:], uint32(directorySize))
+	le.PutUint32(end[16:], uint32(directoryOffset))
+	buf.Write(end[:])
+
+	return buf.Bytes()
+}
+
+// recoveredReaderAt reads the intact part of an archive,
+// followed by its rebuilt central directory
+type recoveredReaderAt struct {
+	r         io.ReaderAt
+	dataEnd   int64
+	directory []byte
+}
+
+func (rr *recoveredReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	if off < rr.dataEnd {
+		m := int64(len(p))
+		if m > rr.dataEnd-off {
+			m = rr.dataEnd - off
+		}
+		read, err := rr.r.ReadAt(p[:m], off)
+		n += read
+		if err != nil {
+			return n, err
+		}
+		off += int64(read)
+	}
+
+	if n < len(p) {
+		dirOff := off - rr.dataEnd
+		if dirOff >= int64(len(rr.directory)) {
+			return n, io.EOF
+		}
+		read := copy(p[n:], rr.directory[dirOff:])
+		n += read
+		if n < len(p) {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+// findSignature looks for a 4-byte signature, starting at offset
+func findSignature(r io.ReaderAt, offset int64, size int64, signature uint32) (int64, bool, error) {
+	var sig [4]byte
+	binary.LittleEndian.PutUint32(sig[:], signature)
+
+	buf := make([]byte, signatureSearchChunk)
+	for offset < size {
+		n, err := r.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return 0, false, errors.WithStack(err)
+		}
+		if n < len(sig) {
+			break
+		}
+		if i := bytes.Index(buf[:n], sig[:]); i >= 0 {
+			return offset + int64(i), true, nil
+		}
+		// signatures may straddle chunks
+		offset += int64(n - len(sig) + 1)
+	}
+	return 0, false, nil
+}
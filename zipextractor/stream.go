@@ -0,0 +1,620 @@
+package zipextractor
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/headway/state"
+	"github.com/itchio/headway/united"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding/charmap"
+)
+
+const flagUTF8 = 0x800
+
+type streamExtractor struct {
+	source savior.Source
+
+	saveConsumer savior.SaveConsumer
+	consumer     *state.Consumer
+
+	filter savior.Filter
+	limits *savior.Limits
+
+	normalizeBackslashes bool
+}
+
+// StreamParams are the parameters of extractors made by NewStreamWithParams
+type StreamParams struct {
+	// Interpret backslashes in entry names as path separators, like
+	// Params.NormalizeBackslashes. Otherwise, such names are rejected
+	// as insecure.
+	NormalizeBackslashes bool
+}
+
+// ZipStreamState is stored in checkpoints of streaming extractors
+type ZipStreamState struct {
+	Result *savior.ExtractorResult
+	// Header of the entry being extracted
+	Header *LocalFileHeader
+	// CRC32 of the entry's contents, up to Entry.WriteOffset
+	CRC32 uint32
+}
+
+// A LocalFileHeader is what's known about an entry before reading its data
+type LocalFileHeader struct {
+	zip.FileHeader
+	// DataOffset is where the entry's data starts in the stream
+	DataOffset int64
+	// Zip64 is set if the entry's data descriptor has 64-bit sizes
+	Zip64 bool
+}
+
+var _ savior.Extractor = (*streamExtractor)(nil)
+var _ savior.ContextExtractor = (*streamExtractor)(nil)
+var _ savior.Filterable = (*streamExtractor)(nil)
+var _ savior.Lister = (*streamExtractor)(nil)
+var _ savior.Limitable = (*streamExtractor)(nil)
+
+// NewStream returns an extractor that reads a zip archive front to back from
+// source, so that extraction can start before the whole archive is downloaded.
+//
+// It relies on local file headers and data descriptors, since the central
+// directory comes last: entries are regular files, or directories if their
+// name ends with a slash, as modes (and symlinks) are only found in the central
+// directory. The end of entries of unknown size is found by decompressing them
+// for deflate, and by looking for their data descriptor otherwise. Encrypted
+// entries can't be extracted.
+//
+// Names that aren't valid UTF-8 are decoded as CP437, like the zip package
+// does: their encoding can't be guessed without seeing all of them first, so
+// there's no equivalent to Params.NameEncoding.
+//
+// Like tar, it can only save in the middle of file entries, when the source
+// emits a checkpoint.
+func NewStream(source savior.Source) savior.Extractor {
+	return NewStreamWithParams(source, StreamParams{})
+}
+
+// NewStreamWithParams is like NewStream, with parameters. Resuming requires
+// the same parameters as when the checkpoint was made.
+func NewStreamWithParams(source savior.Source, params StreamParams) savior.Extractor {
+	return &streamExtractor{
+		source:       source,
+		saveConsumer: savior.NopSaveConsumer(),
+		consumer:     savior.NopConsumer(),
+		limits:       &savior.Limits{},
+
+		normalizeBackslashes: params.NormalizeBackslashes,
+	}
+}
+
+func (se *streamExtractor) SetSaveConsumer(saveConsumer savior.SaveConsumer) {
+	se.saveConsumer = saveConsumer
+}
+
+func (se *streamExtractor) SetConsumer(consumer *state.Consumer) {
+	se.consumer = consumer
+}
+
+func (se *streamExtractor) SetFilter(filter savior.Filter) {
+	se.filter = filter
+}
+
+// SetLimits sets the limits enforced during extraction, see savior.Limitable.
+// Entries are checked as their local headers are read. Those whose size is
+// only in their data descriptor are checked once they've been extracted.
+func (se *streamExtractor) SetLimits(limits *savior.Limits) {
+	if limits == nil {
+		limits = &savior.Limits{}
+	}
+	se.limits = limits
+}
+
+func (se *streamExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savior.Sink) (*savior.ExtractorResult, error) {
+	return se.ResumeContext(context.Background(), checkpoint, sink)
+}
+
+// ResumeContext is like Resume, but stops when ctx is done, see savior.ContextExtractor.
+// A final checkpoint can only be emitted in the middle of a file entry.
+func (se *streamExtractor) ResumeContext(ctx context.Context, checkpoint *savior.ExtractorCheckpoint, sink savior.Sink) (*savior.ExtractorResult, error) {
+	if ctx.Err() != nil {
+		return nil, savior.NewCancelledError(ctx)
+	}
+
+	var state *ZipStreamState
+	s := newStream(se.source)
+
+	fingerprint, err := savior.SourceFingerprint(se.source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	envelope := savior.NewCheckpointEnvelope(se.Features(), fingerprint)
+
+	if checkpoint != nil {
+		err := savior.CheckCheckpoint(checkpoint, envelope)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if stateCheckpoint, ok := checkpoint.Data.(*ZipStreamState); ok {
+			if stateCheckpoint.Result != nil && stateCheckpoint.Header != nil && checkpoint.Entry != nil && checkpoint.SourceCheckpoint != nil {
+				se.consumer.Infof("↻ Resuming @ %.1f%%", checkpoint.Progress*100)
+				err := savior.RemakeDirs(sink, stateCheckpoint.Result.Entries)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+
+				// the entry's source resumes the stream
+				state = stateCheckpoint
+			}
+		}
+	}
+
+	if state == nil {
+		se.consumer.Infof("→ Starting fresh extraction")
+
+		state = &ZipStreamState{
+			Result: &savior.ExtractorResult{
+				Entries: []*savior.Entry{},
+			},
+		}
+
+		_, err := s.resume(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		checkpoint = &savior.ExtractorCheckpoint{
+			EntryIndex: 0,
+		}
+	}
+	checkpoint.Envelope = envelope
+
+	// declared size of entries extracted so far, for limits
+	totalBytes := state.Result.Size()
+	if checkpoint.Entry != nil {
+		totalBytes += checkpoint.Entry.UncompressedSize
+	}
+
+	// allocate a copy buffer once
+	copier := savior.NewCopier(se.saveConsumer)
+
+	entryIndex := checkpoint.EntryIndex
+	if checkpoint.Entry != nil {
+		// its header was read before the checkpoint
+		entryIndex++
+	}
+	for {
+		if ctx.Err() != nil {
+			return nil, savior.NewCancelledError(ctx)
+		}
+
+		if checkpoint.Entry == nil {
+			checkpoint.EntryIndex = entryIndex
+			s.stopSaving()
+
+			h, err := readLocalFileHeader(s, se.normalizeBackslashes)
+			if err != nil {
+				return nil, err
+			}
+			if h == nil {
+				// reached the central directory, we done!
+				break
+			}
+			entryIndex++
+
+			entry := h.entry()
+			if entry == nil || (se.filter != nil && !se.filter(entry)) {
+				savior.Debugf(`zip: skipping entry %q`, h.Name)
+				err := skipData(s, h)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			err = se.limits.CheckEntry(entry)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			// entryIndex counts all headers read so far, even filtered ones
+			totalBytes += entry.UncompressedSize
+			err = se.limits.CheckTotals(entryIndex, totalBytes)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			checkpoint.Entry = entry
+			state.Header = h
+			state.CRC32 = 0
+		}
+		entry := checkpoint.Entry
+		h := state.Header
+
+		declaredSize := entry.UncompressedSize
+		err := se.extractEntry(ctx, s, copier, checkpoint, state, sink, totalBytes-declaredSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if declaredSize != entry.UncompressedSize {
+			// the size was in the data descriptor
+			totalBytes += entry.UncompressedSize - declaredSize
+			err = se.limits.CheckTotals(entryIndex, totalBytes)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+
+		state.Result.Entries = append(state.Result.Entries, entry)
+		se.consumer.Progress(se.source.Progress())
+		savior.Debugf(`zip: done with %s, next header at %d`, h.Name, s.offset)
+
+		checkpoint.Entry = nil
+		checkpoint.SourceCheckpoint = nil
+		checkpoint.Data = nil
+		state.Header = nil
+	}
+
+	return state.Result, nil
+}
+
+// extractEntry extracts checkpoint.Entry to sink, and reads through its
+// data descriptor, if any. totalBytes were extracted before it, for limits.
+func (se *streamExtractor) extractEntry(ctx context.Context, s *stream, copier *savior.Copier, checkpoint *savior.ExtractorCheckpoint, state *ZipStreamState, sink savior.Sink, totalBytes int64) error {
+	entry := checkpoint.Entry
+	h := state.Header
+
+	se.consumer.Debugf("→ %s", entry)
+
+	if entry.Kind == savior.EntryKindDir {
+		err := sink.Mkdir(entry)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return skipData(s, h)
+	}
+
+	if h.Flags&(flagEncrypted|flagStrongEncrypted) != 0 {
+		return errors.Wrapf(ErrEncrypted, "%s: encrypted entries can't be extracted from a stream", entry.CanonicalPath)
+	}
+	method, ok := zipMethods[h.Method]
+	if !ok {
+		return errors.Wrapf(zip.ErrAlgorithm, "%s: %s", entry.CanonicalPath, methodName(h.Method))
+	}
+
+	zf := h.file()
+	ds := newDataSource(s, h)
+	src := method.newSource(ds, zf)
+
+	offset, err := src.Resume(checkpoint.SourceCheckpoint)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if offset < entry.WriteOffset {
+		delta := entry.WriteOffset - offset
+		savior.Debugf(`%s: discarding %d bytes to align source and writer`, entry.CanonicalPath, delta)
+		err := savior.DiscardByRead(src, delta)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	savior.Debugf(`%s: zip stream resuming from %s`, entry.CanonicalPath, united.FormatBytes(entry.WriteOffset))
+
+	writer, err := sink.GetWriter(entry)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer writer.Close()
+
+	cw := &crcWriter{w: writer}
+	if entry.WriteOffset > 0 {
+		cw.crc = state.CRC32
+	}
+
+	var stopError error
+	src.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(sourceCheckpoint *savior.SourceCheckpoint) error {
+			savior.Debugf("zip: making checkpoint at entry %d, %s in", checkpoint.EntryIndex, united.FormatBytes(entry.WriteOffset))
+
+			checkpoint.SourceCheckpoint = sourceCheckpoint
+			state.CRC32 = cw.crc
+			checkpoint.Data = state
+			checkpoint.Progress = se.source.Progress()
+
+			err := writer.Sync()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			action, err := se.saveConsumer.Save(checkpoint)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if ctx.Err() != nil {
+				copier.Stop()
+				stopError = savior.NewCancelledError(ctx)
+			} else if action == savior.AfterSaveStop {
+				copier.Stop()
+				stopError = savior.ErrStop
+			}
+			return nil
+		},
+	})
+
+	params := &savior.CopyParams{
+		Src:   src,
+		Dst:   cw,
+		Entry: entry,
+
+		Savable: src,
+
+		EmitProgress: func() {
+			se.consumer.Progress(se.source.Progress())
+		},
+
+		Context: ctx,
+		Limits:  se.limits,
+	}
+	if !h.sizeKnown() {
+		// the data descriptor has them, check what was read so far
+		params.Compressed = func() int64 {
+			return ds.offset
+		}
+		params.TotalBytes = totalBytes
+	}
+
+	err = copier.Do(params)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if stopError != nil {
+		return stopError
+	}
+
+	err = ds.finish()
+	if err != nil {
+		return err
+	}
+	err = readDataDescriptor(s, h)
+	if err != nil {
+		return err
+	}
+	zf.CRC32 = h.CRC32
+	entry.CompressedSize = int64(h.CompressedSize64)
+	entry.UncompressedSize = int64(h.UncompressedSize64)
+
+	err = cw.check(zf, entry)
+	if err != nil {
+		return err
+	}
+	return savior.FinishEntry(sink, entry)
+}
+
+// List walks the local file headers of the archive and calls onEntry for each
+// entry that isn't filtered out, see savior.Lister. Sizes can be in data
+// descriptors, so it reads through the whole archive.
+func (se *streamExtractor) List(onEntry savior.ListEntryFunc) error {
+	s := newStream(se.source)
+	_, err := s.resume(nil)
+	if err != nil {
+		return err
+	}
+	s.stopSaving()
+
+	for {
+		h, err := readLocalFileHeader(s, se.normalizeBackslashes)
+		if err != nil {
+			return err
+		}
+		if h == nil {
+			return nil
+		}
+
+		err = skipData(s, h)
+		if err != nil {
+			return err
+		}
+
+		entry := h.entry()
+		if entry != nil && (se.filter == nil || se.filter(entry)) {
+			err = onEntry(entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (se *streamExtractor) Features() savior.ExtractorFeatures {
+	sf := se.source.Features()
+
+	// like tar, resume support depends on the underlying source
+	var resumeSupport savior.ResumeSupport
+	switch sf.ResumeSupport {
+	case savior.ResumeSupportBlock:
+		resumeSupport = savior.ResumeSupportBlock
+	default:
+		resumeSupport = savior.ResumeSupportNone
+	}
+
+	return savior.ExtractorFeatures{
+		Name:           "zip-stream",
+		ResumeSupport:  resumeSupport,
+		Preallocate:    false,
+		RandomAccess:   false,
+		SourceFeatures: &sf,
+	}
+}
+
+// readLocalFileHeader reads the next local file header, or returns
+// nil when reaching the central directory (or the end of an empty archive)
+func readLocalFileHeader(s *stream, normalizeBackslashes bool) (*LocalFileHeader, error) {
+	offset := s.offset
+
+	var header [fileHeaderLen]byte
+	_, err := io.ReadFull(s, header[:4])
+	if err != nil {
+		return nil, errors.Wrapf(unexpectedEOF(err), "reading local file header at %d", offset)
+	}
+
+	switch binary.LittleEndian.Uint32(header[:]) {
+	case fileHeaderSignature:
+		// good
+	case directoryHeaderSignature, directory64EndSignature, directoryEndSignature:
+		return nil, nil
+	default:
+		return nil, errors.Wrapf(zip.ErrFormat, "no local file header at %d", offset)
+	}
+
+	_, err = io.ReadFull(s, header[4:])
+	if err != nil {
+		return nil, errors.Wrapf(unexpectedEOF(err), "reading local file header at %d", offset)
+	}
+	e, nameLen, extraLen := parseLocalHeader(header[:], offset)
+
+	variable := make([]byte, nameLen+extraLen)
+	_, err = io.ReadFull(s, variable)
+	if err != nil {
+		return nil, errors.Wrapf(unexpectedEOF(err), "reading local file header at %d", offset)
+	}
+	e.name = variable[:nameLen]
+	isZip64 := e.parseExtra(variable[nameLen:])
+
+	name := string(e.name)
+	if e.flags&flagUTF8 == 0 && !utf8.Valid(e.name) {
+		// that's what the zip package falls back to
+		decoded, err := charmap.CodePage437.NewDecoder().Bytes(e.name)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		name = string(decoded)
+	}
+	if name != "" {
+		if normalizeBackslashes {
+			normalized, err := normalizeBackslashName(name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%q", name)
+			}
+			name = normalized
+		} else if !filepath.IsLocal(name) || strings.Contains(name, `\`) {
+			return nil, errors.Wrapf(zip.ErrInsecurePath, "%q", name)
+		}
+	}
+
+	return &LocalFileHeader{
+		FileHeader: zip.FileHeader{
+			Name:               name,
+			ReaderVersion:      e.version,
+			Flags:              e.flags,
+			Method:             e.method,
+			ModifiedTime:       e.modifiedTime,
+			ModifiedDate:       e.modifiedDate,
+			Modified:           modifiedTime(e.modifiedDate, e.modifiedTime, e.extra),
+			CRC32:              e.crc32,
+			CompressedSize64:   e.compressedSize,
+			UncompressedSize64: e.uncompressedSize,
+			Extra:              e.extra,
+		},
+		DataOffset: s.offset,
+		Zip64:      isZip64,
+	}, nil
+}
+
+// readDataDescriptor reads the data descriptor after an entry's data, if it
+// has one, which may or may not start with a signature. It updates h with it.
+func readDataDescriptor(s *stream, h *LocalFileHeader) error {
+	if h.Flags&flagDataDescriptor == 0 {
+		return nil
+	}
+
+	descLen := 12
+	if h.Zip64 {
+		descLen = 20
+	}
+
+	desc := make([]byte, 4+descLen)
+	_, err := io.ReadFull(s, desc[:4])
+	if err == nil {
+		if binary.LittleEndian.Uint32(desc) == dataDescriptorSignature {
+			_, err = io.ReadFull(s, desc[4:])
+			desc = desc[4:]
+		} else {
+			_, err = io.ReadFull(s, desc[4:descLen])
+			desc = desc[:descLen]
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(unexpectedEOF(err), "reading data descriptor of %s", h.Name)
+	}
+
+	h.CRC32 = binary.LittleEndian.Uint32(desc)
+	if h.Zip64 {
+		h.CompressedSize64 = binary.LittleEndian.Uint64(desc[4:])
+		h.UncompressedSize64 = binary.LittleEndian.Uint64(desc[12:])
+	} else {
+		h.CompressedSize64 = uint64(binary.LittleEndian.Uint32(desc[4:]))
+		h.UncompressedSize64 = uint64(binary.LittleEndian.Uint32(desc[8:]))
+	}
+	return nil
+}
+
+// skipData reads through the data of an entry that isn't extracted,
+// and its data descriptor
+func skipData(s *stream, h *LocalFileHeader) error {
+	ds := newDataSource(s, h)
+	src := ds.delimited()
+
+	_, err := src.Resume(nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = io.Copy(io.Discard, src)
+	if err != nil {
+		return errors.Wrapf(err, "skipping data of %s", h.Name)
+	}
+
+	err = ds.finish()
+	if err != nil {
+		return err
+	}
+	return readDataDescriptor(s, h)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// sizeKnown returns false if the entry's sizes are only in its data descriptor
+func (h *LocalFileHeader) sizeKnown() bool {
+	return h.Flags&flagDataDescriptor == 0 || h.CompressedSize64 != 0
+}
+
+func (h *LocalFileHeader) file() *zip.File {
+	return &zip.File{FileHeader: h.FileHeader}
+}
+
+// entry returns a savior.Entry for the header, or nil if it has no name
+func (h *LocalFileHeader) entry() *savior.Entry {
+	if h.Name == "" {
+		return nil
+	}
+	return zipFileEntry(h.file())
+}
+
+func init() {
+	gob.Register(&ZipStreamState{})
+	savior.RegisterCheckpointType("zipextractor.ZipStreamState", &ZipStreamState{})
+}
@@ -0,0 +1,341 @@
+package zipextractor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/flatesource"
+	"github.com/pkg/errors"
+)
+
+// stream reads an archive front to back from a source, and keeps
+// track of where it's at
+type stream struct {
+	source savior.Source
+	offset int64
+
+	// unread bytes were read past the end of an entry's data, while
+	// looking for its data descriptor. They're read again before
+	// anything else, so the source is only read from when it's empty,
+	// which means its checkpoints are always for offset.
+	unread []byte
+}
+
+func newStream(source savior.Source) *stream {
+	return &stream{source: source}
+}
+
+func (s *stream) resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	offset, err := s.source.Resume(checkpoint)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	s.offset = offset
+	s.unread = nil
+	return offset, nil
+}
+
+func (s *stream) Read(buf []byte) (int, error) {
+	if len(s.unread) > 0 {
+		n := copy(buf, s.unread)
+		s.unread = s.unread[n:]
+		s.offset += int64(n)
+		return n, nil
+	}
+
+	n, err := s.source.Read(buf)
+	s.offset += int64(n)
+	return n, err
+}
+
+func (s *stream) unreadBytes(buf []byte) {
+	s.unread = append(append([]byte(nil), buf...), s.unread...)
+	s.offset -= int64(len(buf))
+}
+
+// discard reads through the next n bytes
+func (s *stream) discard(n int64) error {
+	copied, err := io.CopyN(io.Discard, s, n)
+	if err != nil {
+		if err == io.EOF {
+			return errors.Wrapf(io.ErrUnexpectedEOF, "stream ended %d bytes early", n-copied)
+		}
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// stopSaving drops checkpoints of the source until an entry source
+// wants them again, since those emitted outside of entry data are useless
+func (s *stream) stopSaving() {
+	s.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			return nil
+		},
+	})
+}
+
+// dataSource is a Source for the (raw) data of an entry, read from the
+// archive stream. Its checkpoints wrap those of the archive's source.
+type dataSource struct {
+	stream *stream
+	// start is where the data starts in the stream
+	start int64
+	// size is the size of the data, or -1 if it's not known
+	size int64
+	// descriptorLen is the length of the data descriptor (signature included)
+	// to look for, to find the end of data of unknown size. It's zero if
+	// the data delimits itself, like deflate streams do.
+	descriptorLen int
+
+	offset int64
+	// pending bytes were read from the stream, but could be the start
+	// of the data descriptor
+	pending []byte
+	chunk   []byte
+	done    bool
+
+	bytebuf []byte
+}
+
+// DataSourceCheckpoint is stored in checkpoints of streamed entries
+type DataSourceCheckpoint struct {
+	SourceCheckpoint *savior.SourceCheckpoint
+	// Pending holds bytes read past the checkpoint's offset
+	// while looking for a data descriptor
+	Pending []byte
+}
+
+var _ savior.Source = (*dataSource)(nil)
+
+func newDataSource(s *stream, h *LocalFileHeader) *dataSource {
+	ds := &dataSource{
+		stream:  s,
+		start:   h.DataOffset,
+		size:    -1,
+		bytebuf: []byte{0x00},
+	}
+
+	switch {
+	case h.sizeKnown():
+		ds.size = int64(h.CompressedSize64)
+	case h.Method == zip.Deflate && h.Flags&flagEncrypted == 0:
+		// the end of the deflate stream is the end of the data
+	default:
+		ds.descriptorLen = 16
+		if h.Zip64 {
+			ds.descriptorLen = 24
+		}
+	}
+	return ds
+}
+
+// delimited returns a source whose end is the end of the data
+func (ds *dataSource) delimited() savior.Source {
+	if ds.size < 0 && ds.descriptorLen == 0 {
+		return flatesource.New(ds)
+	}
+	return ds
+}
+
+func (ds *dataSource) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "zip-entry",
+		ResumeSupport: ds.stream.source.Features().ResumeSupport,
+	}
+}
+
+func (ds *dataSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	ds.stream.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(sourceCheckpoint *savior.SourceCheckpoint) error {
+			checkpoint := &savior.SourceCheckpoint{
+				Offset: ds.offset,
+				Data: &DataSourceCheckpoint{
+					SourceCheckpoint: sourceCheckpoint,
+					Pending:          append([]byte(nil), ds.pending...),
+				},
+			}
+			return ssc.Save(checkpoint)
+		},
+	})
+}
+
+func (ds *dataSource) WantSave() {
+	ds.stream.source.WantSave()
+}
+
+func (ds *dataSource) Progress() float64 {
+	return ds.stream.source.Progress()
+}
+
+func (ds *dataSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	ds.pending = nil
+	ds.done = false
+
+	if checkpoint == nil {
+		// we're reading a stream, we can't go back
+		if ds.stream.offset != ds.start {
+			msg := fmt.Sprintf("zipextractor: can't rewind stream to %d, it's at %d", ds.start, ds.stream.offset)
+			return 0, errors.New(msg)
+		}
+		ds.offset = 0
+		return 0, nil
+	}
+
+	ourCheckpoint, ok := checkpoint.Data.(*DataSourceCheckpoint)
+	if !ok {
+		return 0, errors.Errorf("zipextractor: invalid entry source checkpoint (%T)", checkpoint.Data)
+	}
+
+	offset, err := ds.stream.resume(ourCheckpoint.SourceCheckpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	target := ds.start + checkpoint.Offset + int64(len(ourCheckpoint.Pending))
+	if offset > target {
+		msg := fmt.Sprintf("zipextractor: stream resumed at %d, past entry checkpoint at %d", offset, target)
+		return 0, errors.New(msg)
+	}
+	if offset < target {
+		savior.Debugf("zipextractor: discarding %d bytes to align stream with entry", target-offset)
+		err := ds.stream.discard(target - offset)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	ds.offset = checkpoint.Offset
+	ds.pending = ourCheckpoint.Pending
+	return ds.offset, nil
+}
+
+func (ds *dataSource) Read(buf []byte) (int, error) {
+	if ds.done {
+		return 0, io.EOF
+	}
+	if ds.descriptorLen > 0 {
+		return ds.readUntilDescriptor(buf)
+	}
+
+	if ds.size >= 0 {
+		remaining := ds.size - ds.offset
+		if remaining == 0 {
+			ds.done = true
+			return 0, io.EOF
+		}
+		if int64(len(buf)) > remaining {
+			buf = buf[:remaining]
+		}
+	}
+
+	n, err := ds.stream.Read(buf)
+	ds.offset += int64(n)
+	if err == io.EOF {
+		if ds.offset == ds.size {
+			ds.done = true
+			return n, nil
+		}
+		// only the end of the data is a proper end
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// readUntilDescriptor reads data up to a data descriptor whose compressed size
+// matches the distance from the start of the data. Since checkpoints can
+// happen anywhere, at most a data descriptor's worth of data is kept pending.
+func (ds *dataSource) readUntilDescriptor(buf []byte) (int, error) {
+	var sig [4]byte
+	binary.LittleEndian.PutUint32(sig[:], dataDescriptorSignature)
+
+	for {
+		for i := 0; ; i++ {
+			j := bytes.Index(ds.pending[i:], sig[:])
+			if j < 0 {
+				break
+			}
+			i += j
+			if i+ds.descriptorLen > len(ds.pending) {
+				break
+			}
+			if !ds.isDescriptor(ds.pending[i:], ds.offset+int64(i)) {
+				continue
+			}
+
+			n := copy(buf, ds.pending[:i])
+			ds.offset += int64(n)
+			ds.pending = ds.pending[n:]
+			if n == i {
+				// the descriptor (and whatever follows) belongs to the stream
+				ds.stream.unreadBytes(ds.pending)
+				ds.pending = nil
+				ds.done = true
+			}
+			return n, nil
+		}
+
+		// no descriptor can start before that
+		if safe := len(ds.pending) - ds.descriptorLen + 1; safe > 0 {
+			n := copy(buf, ds.pending[:safe])
+			ds.offset += int64(n)
+			ds.pending = ds.pending[n:]
+			return n, nil
+		}
+
+		if ds.chunk == nil {
+			ds.chunk = make([]byte, 32*1024)
+		}
+		n, err := ds.stream.Read(ds.chunk)
+		ds.pending = append(ds.pending, ds.chunk[:n]...)
+		if err != nil && !(err == io.EOF && n > 0) {
+			if err == io.EOF {
+				return 0, errors.Wrap(io.ErrUnexpectedEOF, "looking for data descriptor")
+			}
+			return 0, err
+		}
+	}
+}
+
+func (ds *dataSource) isDescriptor(desc []byte, size int64) bool {
+	if ds.descriptorLen == 24 {
+		return binary.LittleEndian.Uint64(desc[8:]) == uint64(size)
+	}
+	return uint64(binary.LittleEndian.Uint32(desc[8:])) == uint64(size)
+}
+
+func (ds *dataSource) ReadByte() (byte, error) {
+	for {
+		// reads that find a data descriptor can be empty
+		n, err := ds.Read(ds.bytebuf)
+		if n > 0 {
+			return ds.bytebuf[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// finish reads through whatever is left of the data, after
+// it's been decompressed
+func (ds *dataSource) finish() error {
+	if ds.size >= 0 && ds.offset < ds.size {
+		err := ds.stream.discard(ds.size - ds.offset)
+		if err != nil {
+			return err
+		}
+		ds.offset = ds.size
+	}
+	return nil
+}
+
+func init() {
+	gob.Register(&DataSourceCheckpoint{})
+	savior.RegisterCheckpointType("zipextractor.DataSourceCheckpoint", &DataSourceCheckpoint{})
+}
@@ -0,0 +1,160 @@
+package zipextractor
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/itchio/arkive/zip"
+)
+
+const (
+	ntfsExtraID        = 0x000a
+	unixExtraID        = 0x000d
+	extTimeExtraID     = 0x5455
+	infoZipUnixExtraID = 0x5855
+
+	// NTFS timestamps are in 100ns ticks since 1601
+	ntfsTicksPerSecond = 10 * 1000 * 1000
+	ntfsEpochOffset    = 11644473600
+)
+
+// noMsDosTime is what the zip package reads a zero MS-DOS date and time as
+var noMsDosTime = msDosTime(0, 0)
+
+// entryTimes returns the modification and access times of an entry. The
+// former is the one the zip package found, unless there was none at all,
+// the latter is found in extra fields. Either may be zero.
+func entryTimes(zf *zip.File) (mtime time.Time, atime time.Time) {
+	if zf.ModifiedDate != 0 || zf.ModifiedTime != 0 || !zf.Modified.Equal(noMsDosTime) {
+		mtime = zf.Modified
+	}
+	_, atime = extraTimes(zf.Extra)
+	return mtime, atime
+}
+
+// modifiedTime returns the modification time of an entry from its MS-DOS
+// date and time and its extra fields, the same way the zip package does
+// for central directories, so local headers read the same.
+func modifiedTime(dosDate uint16, dosTime uint16, extra []byte) time.Time {
+	msdosModified := msDosTime(dosDate, dosTime)
+	modified, _ := extraTimes(extra)
+	if modified.IsZero() {
+		return msdosModified
+	}
+
+	modified = modified.UTC()
+	if dosDate != 0 || dosTime != 0 {
+		// the delta between both gives an idea of the time zone
+		modified = modified.In(timeZone(msdosModified.Sub(modified)))
+	}
+	return modified
+}
+
+// extraTimes returns the modification and access times found in extra
+// fields, the last one having them winning. Only the local header has
+// the access time of extended timestamps. Either may be zero.
+func extraTimes(extra []byte) (mtime time.Time, atime time.Time) {
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		field := extra[:size]
+		extra = extra[size:]
+
+		switch tag {
+		case ntfsExtraID:
+			if len(field) < 4 {
+				continue
+			}
+			// skip reserved field, then look for the timestamps attribute
+			field = field[4:]
+			for len(field) >= 4 {
+				attrTag := binary.LittleEndian.Uint16(field[0:])
+				attrSize := int(binary.LittleEndian.Uint16(field[2:]))
+				field = field[4:]
+				if attrSize > len(field) {
+					break
+				}
+				if attrTag == 1 && attrSize == 24 {
+					mtime = ntfsTime(binary.LittleEndian.Uint64(field[0:]))
+					atime = ntfsTime(binary.LittleEndian.Uint64(field[8:]))
+				}
+				field = field[attrSize:]
+			}
+		case unixExtraID, infoZipUnixExtraID:
+			if len(field) < 8 {
+				continue
+			}
+			atime = time.Unix(int64(binary.LittleEndian.Uint32(field[0:])), 0).UTC()
+			mtime = time.Unix(int64(binary.LittleEndian.Uint32(field[4:])), 0).UTC()
+		case extTimeExtraID:
+			// the flags say which times follow, although central
+			// directories only ever have the modification time
+			if len(field) < 1 {
+				continue
+			}
+			flags := field[0]
+			field = field[1:]
+			if flags&0x1 == 0 {
+				// the zip package ignores those
+				continue
+			}
+			if len(field) < 4 {
+				continue
+			}
+			mtime = time.Unix(int64(binary.LittleEndian.Uint32(field)), 0).UTC()
+			field = field[4:]
+			if flags&0x2 != 0 && len(field) >= 4 {
+				atime = time.Unix(int64(binary.LittleEndian.Uint32(field)), 0).UTC()
+			}
+		}
+	}
+
+	return mtime, atime
+}
+
+func ntfsTime(ticks uint64) time.Time {
+	if ticks == 0 {
+		return time.Time{}
+	}
+	secs := int64(ticks/ntfsTicksPerSecond) - ntfsEpochOffset
+	nsecs := int64(ticks%ntfsTicksPerSecond) * 100
+	return time.Unix(secs, nsecs).UTC()
+}
+
+// msDosTime converts an MS-DOS date and time, which have no time zone,
+// and are read as UTC like the zip package does
+func msDosTime(dosDate uint16, dosTime uint16) time.Time {
+	return time.Date(
+		// date bits 0-4: day of month; 5-8: month; 9-15: years since 1980
+		int(dosDate>>9+1980),
+		time.Month(dosDate>>5&0xf),
+		int(dosDate&0x1f),
+
+		// time bits 0-4: second/2; 5-10: minute; 11-15: hour
+		int(dosTime>>11),
+		int(dosTime>>5&0x3f),
+		int(dosTime&0x1f*2),
+		0, // nanoseconds
+
+		time.UTC,
+	)
+}
+
+// timeZone returns a zone for an offset, rounded to 15 minutes,
+// like the zip package does
+func timeZone(offset time.Duration) *time.Location {
+	const (
+		minOffset   = -12 * time.Hour
+		maxOffset   = +14 * time.Hour
+		offsetAlias = 15 * time.Minute
+	)
+	offset = offset.Round(offsetAlias)
+	if offset < minOffset || maxOffset < offset {
+		offset = 0
+	}
+	return time.FixedZone("", int(offset/time.Second))
+}
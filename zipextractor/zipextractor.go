@@ -1,6 +1,10 @@
 package zipextractor
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -9,7 +13,6 @@ import (
 
 	"github.com/itchio/headway/state"
 	"github.com/itchio/headway/united"
-	"github.com/itchio/savior/flatesource"
 	"github.com/itchio/savior/seeksource"
 
 	"github.com/itchio/arkive/zip"
@@ -22,19 +25,63 @@ var ErrEncrypted = zip.ErrEncrypted
 
 const defaultFlateThreshold = 1 * 1024 * 1024
 
+// symlink targets are read in memory, and can't be longer than that,
+// whatever the size their entry says they are
+const maxLinknameLength = 64 * 1024
+
 type ZipExtractor struct {
 	zr *zip.Reader
 
-	reader io.ReaderAt
+	reader     io.ReaderAt
+	readerSize int64
+	// where the archive starts in the original reader
+	archiveOffset int64
+	// set if the central directory had to be rebuilt
+	recoveryReport *RecoveryReport
 
 	saveConsumer savior.SaveConsumer
 	consumer     *state.Consumer
 
-	flateThreshold int64
-	resumeSupport  savior.ResumeSupport
+	flateThreshold      int64
+	workers             int
+	resumeSupport       savior.ResumeSupport
+	methodResumeSupport map[string]savior.ResumeSupport
+
+	filter savior.Filter
+	limits *savior.Limits
+
+	defaultPassword string
+	passwordFunc    PasswordFunc
+
+	normalizeBackslashes bool
+	// nameEncoding is "" if names are as the zip package decoded them
+	nameEncoding string
+	rawNames     [][]byte
+	zipNames     []string
 }
 
 var _ savior.Extractor = (*ZipExtractor)(nil)
+var _ savior.ContextExtractor = (*ZipExtractor)(nil)
+var _ savior.Filterable = (*ZipExtractor)(nil)
+var _ savior.Lister = (*ZipExtractor)(nil)
+var _ savior.Limitable = (*ZipExtractor)(nil)
+
+// ZipExtractorState is stored in checkpoints
+type ZipExtractorState struct {
+	// CRC32 of the entry's contents, up to Entry.WriteOffset
+	CRC32 uint32
+	// NameEncoding is the encoding entry names were decoded with,
+	// see Params.NameEncoding
+	NameEncoding string
+
+	// Completed lists entries past the checkpoint's EntryIndex that are
+	// done, when several entries are extracted at once, see SetWorkers
+	Completed []int64
+	// InFlight holds where entries that were being extracted are at,
+	// when several entries are extracted at once. Entries that are neither
+	// completed nor in flight are extracted from the start.
+	InFlight []*EntryCheckpoint
+}
 
 type Params struct {
 	// Interpret backslashes in entry names as path separators. The zip spec
@@ -43,6 +90,28 @@ type Params struct {
 	// rewritten to use forward slashes; names that are still non-local after
 	// rewriting (absolute, "..") keep the archive rejected.
 	NormalizeBackslashes bool
+
+	// Password used to decrypt encrypted entries, be they encrypted with
+	// the traditional PKWARE cipher ("ZipCrypto") or WinZip AES.
+	Password string
+	// PasswordFunc, if set, is called to get the password of each
+	// encrypted entry, instead of using Password.
+	PasswordFunc PasswordFunc
+
+	// NameEncoding is the encoding of entry names that aren't valid UTF-8,
+	// which zips made on non-english versions of Windows are full of. It can be
+	// an IANA name ("IBM437", "Shift_JIS", "GBK", "IBM866", etc.), or
+	// NameEncodingAuto to guess it from the names themselves. If empty, names
+	// are decoded by the zip package, which only knows about CP437 and Shift_JIS.
+	// The encoding used is recorded in checkpoints, and used again on resume.
+	NameEncoding string
+
+	// Recover from a missing or damaged central directory (like in truncated
+	// downloads) by scanning local file headers instead, and extracting the
+	// entries that are intact, see RecoveryReport. Recovered entries lose
+	// the attributes only found in the central directory, like their mode:
+	// they're regular files, or directories if their name ends with a slash.
+	Recover bool
 }
 
 func New(reader io.ReaderAt, readerSize int64) (*ZipExtractor, error) {
@@ -50,53 +119,160 @@ func New(reader io.ReaderAt, readerSize int64) (*ZipExtractor, error) {
 }
 
 func NewWithParams(reader io.ReaderAt, readerSize int64, params Params) (*ZipExtractor, error) {
+	// self-extracting executables and other prefixed archives: from now on,
+	// only look at the archive itself, so that all offsets line up
+	var archiveOffset int64
+	if dl, err := locateDirectory(reader, readerSize); err == nil && dl.archiveStart > 0 {
+		savior.Debugf("zip: archive starts at %d", dl.archiveStart)
+		archiveOffset = dl.archiveStart
+		reader = io.NewSectionReader(reader, archiveOffset, readerSize-archiveOffset)
+		readerSize -= archiveOffset
+	}
+
 	zr, err := zip.NewReader(reader, readerSize)
-	if err != nil {
-		// zip.NewReader returns a usable reader alongside ErrInsecurePath
-		if params.NormalizeBackslashes && err == zip.ErrInsecurePath {
-			err = normalizeBackslashNames(zr)
-		}
-		if err != nil {
-			return nil, errors.WithStack(err)
+	var recoveryReport *RecoveryReport
+	if err != nil && err != zip.ErrInsecurePath && params.Recover {
+		savior.Debugf("zip: %v, recovering entries from local headers", err)
+		var recoverErr error
+		reader, readerSize, recoveryReport, recoverErr = recoverArchive(reader, readerSize)
+		if recoverErr != nil {
+			return nil, errors.WithStack(recoverErr)
 		}
+		zr, err = zip.NewReader(reader, readerSize)
+	}
+	// zip.NewReader returns a usable reader alongside ErrInsecurePath
+	if err != nil && err != zip.ErrInsecurePath {
+		return nil, errors.WithStack(err)
 	}
 
 	ex := &ZipExtractor{
-		reader: reader,
-		zr:     zr,
+		reader:        reader,
+		readerSize:    readerSize,
+		archiveOffset: archiveOffset,
+		zr:            zr,
+
+		recoveryReport: recoveryReport,
 
 		saveConsumer:  savior.NopSaveConsumer(),
 		consumer:      savior.NopConsumer(),
 		resumeSupport: savior.ResumeSupportBlock,
+		limits:        &savior.Limits{},
+
+		defaultPassword: params.Password,
+		passwordFunc:    params.PasswordFunc,
+
+		normalizeBackslashes: params.NormalizeBackslashes,
+	}
+
+	nameEncoding, nameErr := resolveNameEncoding(params.NameEncoding, ex.getRawNames)
+	if nameErr != nil {
+		return nil, nameErr
+	}
+	if nameEncoding != "" {
+		// names get checked again once they're decoded
+		err = ex.useNameEncoding(nameEncoding)
+	} else if err == zip.ErrInsecurePath && params.NormalizeBackslashes {
+		err = normalizeBackslashNames(zr)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
+	if recoveryReport != nil {
+		for _, f := range zr.File {
+			recoveryReport.Recovered = append(recoveryReport.Recovered, filepath.ToSlash(f.Name))
+		}
+	}
+
+	ex.methodResumeSupport = make(map[string]savior.ResumeSupport)
 	for _, f := range zr.File {
-		switch f.Method {
-		case zip.Store, zip.Deflate:
-			// all good
-		case zip.LZMA:
-			// no block resume for you
-			ex.resumeSupport = savior.ResumeSupportEntry
+		name := methodName(entryMethod(f))
+		rs, ok := ex.methodResumeSupport[name]
+		if !ok {
+			rs = methodResumeSupport(f)
+			ex.methodResumeSupport[name] = rs
+		}
+		if f.Flags&flagEncrypted != 0 && f.Method != MethodAES && rs > savior.ResumeSupportEntry {
+			// ZipCrypto entries are decrypted from the start when resuming
+			rs = savior.ResumeSupportEntry
+		}
+		if rs < ex.resumeSupport {
+			ex.resumeSupport = rs
 		}
 	}
 
 	return ex, nil
 }
 
+// entrySource returns a resumable source for the contents of an entry, and the
+// source that decrypts it, if it's encrypted. The source is nil if there's no
+// resumable source for the entry's method, in which case zf.Open must be used.
+func (ze *ZipExtractor) entrySource(zf *zip.File, enc *encryption) (savior.Source, *decryptSource, error) {
+	if enc != nil {
+		method, ok := zipMethods[enc.method]
+		if !ok {
+			// encrypted entries can't go through zf.Open
+			return nil, nil, errors.Wrapf(zip.ErrAlgorithm, "encrypted entry with %s", methodName(enc.method))
+		}
+
+		ds := newDecryptSource(ze.reader, enc)
+		return method.newSource(ds, zf), ds, nil
+	}
+
+	method, ok := zipMethods[zf.Method]
+	if !ok {
+		return nil, nil, nil
+	}
+
+	dataOff, err := zf.DataOffset()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	compressedSize := int64(zf.CompressedSize64)
+
+	reader := io.NewSectionReader(ze.reader, dataOff, compressedSize)
+	rawSource := seeksource.NewWithSize(reader, compressedSize)
+	return method.newSource(rawSource, zf), nil, nil
+}
+
 func normalizeBackslashNames(zr *zip.Reader) error {
 	for _, f := range zr.File {
 		if f.Name == "" {
 			continue
 		}
-		name := strings.ReplaceAll(f.Name, `\`, "/")
-		if !filepath.IsLocal(name) {
-			return zip.ErrInsecurePath
+		name, err := normalizeBackslashName(f.Name)
+		if err != nil {
+			return err
 		}
 		f.Name = name
 	}
 	return nil
 }
 
+// normalizeBackslashName rewrites backslashes in name as forward slashes,
+// and returns zip.ErrInsecurePath if it's still not local after that
+func normalizeBackslashName(name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if !filepath.IsLocal(name) {
+		return "", zip.ErrInsecurePath
+	}
+	return name, nil
+}
+
+// ArchiveOffset returns where the archive starts in the reader it was opened
+// from. It's non-zero for archives appended to something else, like the stub
+// of a self-extracting executable, unless their offsets were adjusted for it.
+func (ze *ZipExtractor) ArchiveOffset() int64 {
+	return ze.archiveOffset
+}
+
+// RecoveryReport returns what was recovered from local file headers,
+// or nil if the central directory was fine, see Params.Recover
+func (ze *ZipExtractor) RecoveryReport() *RecoveryReport {
+	return ze.recoveryReport
+}
+
 func (ze *ZipExtractor) SetSaveConsumer(saveConsumer savior.SaveConsumer) {
 	ze.saveConsumer = saveConsumer
 }
@@ -116,11 +292,61 @@ func (ze *ZipExtractor) FlateThreshold() int64 {
 	return defaultFlateThreshold
 }
 
+// SetWorkers sets how many entries are decompressed at once. More than one
+// worker needs a savior.ConcurrentSink, other sinks get entries one at a time.
+// Entries that have the same path are still extracted one after the other,
+// in order, so the last one wins, as with a single worker.
+func (ze *ZipExtractor) SetWorkers(workers int) {
+	ze.workers = workers
+}
+
+func (ze *ZipExtractor) Workers() int {
+	if ze.workers > 0 {
+		return ze.workers
+	}
+	return 1
+}
+
+// SetFilter sets a filter for entries to extract, see savior.Filterable
+func (ze *ZipExtractor) SetFilter(filter savior.Filter) {
+	ze.filter = filter
+}
+
+// SetLimits sets the limits enforced during extraction, see savior.Limitable.
+// Entries are checked against them before anything gets written.
+func (ze *ZipExtractor) SetLimits(limits *savior.Limits) {
+	if limits == nil {
+		limits = &savior.Limits{}
+	}
+	ze.limits = limits
+}
+
+func (ze *ZipExtractor) includes(zf *zip.File) bool {
+	return ze.filter == nil || ze.filter(zipFileEntry(zf))
+}
+
 func (ze *ZipExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savior.Sink) (*savior.ExtractorResult, error) {
+	return ze.ResumeContext(context.Background(), checkpoint, sink)
+}
+
+// ResumeContext is like Resume, but stops when ctx is done, see savior.ContextExtractor.
+// Since zip entries can be extracted in any order, a final checkpoint is emitted
+// even when cancellation happens between entries.
+func (ze *ZipExtractor) ResumeContext(ctx context.Context, checkpoint *savior.ExtractorCheckpoint, sink savior.Sink) (*savior.ExtractorResult, error) {
+	if ctx.Err() != nil {
+		return nil, savior.NewCancelledError(ctx)
+	}
+
 	zr := ze.zr
 
 	isFresh := false
 
+	fingerprint, err := savior.NewFingerprint(io.NewSectionReader(ze.reader, 0, ze.readerSize), ze.readerSize)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	envelope := savior.NewCheckpointEnvelope(ze.Features(), fingerprint)
+
 	if checkpoint == nil {
 		isFresh = true
 		ze.consumer.Infof("→ Starting fresh extraction")
@@ -128,14 +354,46 @@ func (ze *ZipExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 			EntryIndex: 0,
 		}
 	} else {
+		err := savior.CheckCheckpoint(checkpoint, envelope)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
 		ze.consumer.Infof("↻ Resuming @ %.1f%%", checkpoint.Progress*100)
+
+		// names must be the same as when the checkpoint was made
+		nameEncoding := ""
+		if state, ok := checkpoint.Data.(*ZipExtractorState); ok {
+			nameEncoding = state.NameEncoding
+		}
+		if nameEncoding != ze.nameEncoding {
+			ze.consumer.Infof("↻ Decoding names as %q, like before", nameEncoding)
+			err := ze.useNameEncoding(nameEncoding)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	checkpoint.Envelope = envelope
+	if checkpoint.Data == nil {
+		checkpoint.Data = &ZipExtractorState{NameEncoding: ze.nameEncoding}
 	}
 
 	numEntries := int64(len(zr.File))
 
 	var doneBytes int64
 	var totalBytes int64
+	var numIncluded int64
 	for i, zf := range zr.File {
+		if !ze.includes(zf) {
+			continue
+		}
+
+		err := ze.limits.CheckEntry(zipFileEntry(zf))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		numIncluded++
+
 		size := int64(zf.UncompressedSize64)
 		totalBytes += size
 		if int64(i) < checkpoint.EntryIndex {
@@ -143,10 +401,18 @@ func (ze *ZipExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 		}
 	}
 
+	err = ze.limits.CheckTotals(numIncluded, totalBytes)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	if isFresh {
 		ze.consumer.Infof("⇓ Pre-allocating %s on disk", united.FormatBytes(totalBytes))
 		preallocateStart := time.Now()
 		for _, zf := range zr.File {
+			if !ze.includes(zf) {
+				continue
+			}
 			entry := zipFileEntry(zf)
 			if entry.Kind == savior.EntryKindFile {
 				err := sink.Preallocate(entry)
@@ -159,6 +425,17 @@ func (ze *ZipExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 		ze.consumer.Infof("⇒ Pre-allocated in %s, nothing can stop us now", preallocateDuration)
 	}
 
+	if !isFresh {
+		err := savior.RemakeDirs(sink, ze.extractedEntries(checkpoint))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if ze.Workers() > 1 || isParallelCheckpoint(checkpoint) {
+		return ze.resumeParallel(ctx, checkpoint, sink, totalBytes)
+	}
+
 	var stopError error
 
 	// allocate a copy buffer once
@@ -167,193 +444,358 @@ func (ze *ZipExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 	for entryIndex := checkpoint.EntryIndex; entryIndex < numEntries && stopError == nil; entryIndex++ {
 		savior.Debugf(`doing entryIndex %d`, entryIndex)
 		zf := zr.File[entryIndex]
+		if !ze.includes(zf) {
+			savior.Debugf(`skipping filtered entry %s`, zf.Name)
+			continue
+		}
 
-		err := func() error {
+		if ctx.Err() != nil {
+			// we're between entries, so that's an easy checkpoint to make
 			checkpoint.EntryIndex = entryIndex
-
-			if checkpoint.Entry == nil {
-				checkpoint.Entry = zipFileEntry(zf)
+			checkpoint.Progress = float64(doneBytes) / float64(totalBytes)
+			_, err := ze.saveConsumer.Save(checkpoint)
+			if err != nil {
+				return nil, errors.WithStack(err)
 			}
-			entry := checkpoint.Entry
-
-			ze.consumer.Debugf("→ %s", entry)
+			return nil, savior.NewCancelledError(ctx)
+		}
 
-			if zf.Flags&0x1 != 0 {
-				return zip.ErrEncrypted
-			}
+		checkpoint.EntryIndex = entryIndex
+		if checkpoint.Entry == nil {
+			checkpoint.Entry = zipFileEntry(zf)
+		}
+		entry := checkpoint.Entry
 
-			switch entry.Kind {
-			case savior.EntryKindDir:
-				err := sink.Mkdir(entry)
-				if err != nil {
-					return errors.WithStack(err)
-				}
-			case savior.EntryKindSymlink:
-				rc, err := zf.Open()
-				if err != nil {
-					return errors.WithStack(err)
-				}
+		ec := &EntryCheckpoint{
+			EntryIndex:       entryIndex,
+			Entry:            entry,
+			SourceCheckpoint: checkpoint.SourceCheckpoint,
+		}
+		state, crcKnown := checkpoint.Data.(*ZipExtractorState)
+		if crcKnown {
+			ec.CRC32 = state.CRC32
+		}
 
-				defer rc.Close()
+		computeProgress := func() float64 {
+			actualDoneBytes := doneBytes + entry.WriteOffset
+			return float64(actualDoneBytes) / float64(totalBytes)
+		}
 
-				linkname, err := io.ReadAll(rc)
-				if err != nil {
-					return errors.WithStack(err)
+		err := ze.extractEntry(ctx, copier, zf, ec, crcKnown, sink, &entryHooks{
+			getWriter: sink.GetWriter,
+			save: func(sourceCheckpoint *savior.SourceCheckpoint, crc uint32) (bool, error) {
+				checkpoint.SourceCheckpoint = sourceCheckpoint
+				checkpoint.Data = &ZipExtractorState{
+					CRC32:        crc,
+					NameEncoding: ze.nameEncoding,
 				}
+				checkpoint.Progress = computeProgress()
 
-				err = sink.Symlink(entry, string(linkname))
+				action, err := ze.saveConsumer.Save(checkpoint)
 				if err != nil {
-					return errors.WithStack(err)
+					return false, errors.WithStack(err)
 				}
-			case savior.EntryKindFile:
-				var src savior.Source
-
-				switch zf.Method {
-				case zip.Store, zip.Deflate:
-					dataOff, err := zf.DataOffset()
-					if err != nil {
-						return errors.WithStack(err)
-					}
-
-					compressedSize := int64(zf.CompressedSize64)
-
-					reader := io.NewSectionReader(ze.reader, dataOff, compressedSize)
-					rawSource := seeksource.NewWithSize(reader, compressedSize)
-
-					switch zf.Method {
-					case zip.Store:
-						src = rawSource
-					case zip.Deflate:
-						src = flatesource.New(rawSource)
-					}
-				default:
-					// will have to copy
+				if ctx.Err() != nil {
+					stopError = savior.NewCancelledError(ctx)
+				} else if action == savior.AfterSaveStop {
+					stopError = savior.ErrStop
 				}
-
-				if src == nil {
-					// save/resume not supported for this storage format
-					// (probably LZMA), doing a simple copy
-					entry.WriteOffset = 0
-
-					rc, err := zf.Open()
-					if err != nil {
-						return errors.WithStack(err)
-					}
-
-					defer rc.Close()
-
-					writer, err := sink.GetWriter(entry)
-					if err != nil {
-						return errors.WithStack(err)
-					}
-
-					_, err = io.Copy(writer, rc)
-					if err != nil {
-						return errors.WithStack(err)
-					}
-				} else {
-					offset, err := src.Resume(checkpoint.SourceCheckpoint)
-					if err != nil {
-						return errors.WithStack(err)
-					}
-
-					if offset < entry.WriteOffset {
-						delta := entry.WriteOffset - offset
-						savior.Debugf(`%s: discarding %d bytes to align source and writer`, entry.CanonicalPath, delta)
-						savior.Debugf(`%s: (source resumed at %d, writer was at %d)`, entry.CanonicalPath, offset, entry.WriteOffset)
-						err := savior.DiscardByRead(src, delta)
-						if err != nil {
-							return errors.WithStack(err)
-						}
-					}
-					savior.Debugf(`%s: zipextractor resuming from %s`, entry.CanonicalPath, united.FormatBytes(entry.WriteOffset))
-
-					writer, err := sink.GetWriter(entry)
-					if err != nil {
-						return errors.WithStack(err)
-					}
-
-					computeProgress := func() float64 {
-						actualDoneBytes := doneBytes + entry.WriteOffset
-						return float64(actualDoneBytes) / float64(totalBytes)
-					}
-
-					src.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
-						OnSave: func(sourceCheckpoint *savior.SourceCheckpoint) error {
-							savior.Debugf(`%s: saving, has source checkpoint? %v`, entry.CanonicalPath, sourceCheckpoint != nil)
-							if sourceCheckpoint != nil {
-								savior.Debugf(`%s: source checkpoint is at %d`, entry.CanonicalPath, sourceCheckpoint.Offset)
-							}
-							checkpoint.SourceCheckpoint = sourceCheckpoint
-
-							err = writer.Sync()
-							if err != nil {
-								return errors.WithStack(err)
-							}
-
-							checkpoint.Progress = computeProgress()
-
-							action, err := ze.saveConsumer.Save(checkpoint)
-							if err != nil {
-								return errors.WithStack(err)
-							}
-							if action == savior.AfterSaveStop {
-								copier.Stop()
-								stopError = savior.ErrStop
-							}
-
-							return nil
-						},
-					})
-
-					err = copier.Do(&savior.CopyParams{
-						Src:   src,
-						Dst:   writer,
-						Entry: entry,
-
-						Savable: src,
-
-						EmitProgress: func() {
-							ze.consumer.Progress(computeProgress())
-						},
-					})
-					if err != nil {
-						return errors.WithStack(err)
-					}
-				}
-			}
-			doneBytes += int64(zf.UncompressedSize64)
-
-			return nil
-		}()
+				return stopError != nil, nil
+			},
+			progress: func() {
+				ze.consumer.Progress(computeProgress())
+			},
+		})
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
+		doneBytes += int64(zf.UncompressedSize64)
 
 		checkpoint.SourceCheckpoint = nil
 		checkpoint.Entry = nil
+		checkpoint.Data = &ZipExtractorState{NameEncoding: ze.nameEncoding}
 	}
 
 	if stopError != nil {
-		return nil, savior.ErrStop
+		return nil, stopError
 	}
 
+	return ze.result(), nil
+}
+
+// extractedEntries returns the entries that were done extracting when
+// checkpoint was made
+func (ze *ZipExtractor) extractedEntries(checkpoint *savior.ExtractorCheckpoint) []*savior.Entry {
+	completed := make(map[int64]bool)
+	if state, ok := checkpoint.Data.(*ZipExtractorState); ok {
+		for _, entryIndex := range state.Completed {
+			completed[entryIndex] = true
+		}
+	}
+
+	var entries []*savior.Entry
+	for i, zf := range ze.zr.File {
+		entryIndex := int64(i)
+		if entryIndex >= checkpoint.EntryIndex && !completed[entryIndex] {
+			continue
+		}
+		if !ze.includes(zf) {
+			continue
+		}
+		entries = append(entries, zipFileEntry(zf))
+	}
+	return entries
+}
+
+func (ze *ZipExtractor) result() *savior.ExtractorResult {
 	res := &savior.ExtractorResult{}
-	for _, zf := range zr.File {
+	for _, zf := range ze.zr.File {
+		if !ze.includes(zf) {
+			continue
+		}
 		res.Entries = append(res.Entries, zipFileEntry(zf))
 	}
+	return res
+}
+
+// entryHooks are how extractEntry gets writers, saves checkpoints
+// and reports progress
+type entryHooks struct {
+	getWriter func(entry *savior.Entry) (savior.EntryWriter, error)
+	// save is called once the entry's writer is synced, with the checkpoint
+	// of its source and the CRC32 of what was written so far. Copying stops
+	// if it returns true.
+	save     func(sourceCheckpoint *savior.SourceCheckpoint, crc uint32) (bool, error)
+	progress func()
+}
+
+// extractEntry extracts an entry, from where ec says it's at. The CRC32 of
+// its contents so far is in ec, unless crcKnown is false.
+func (ze *ZipExtractor) extractEntry(ctx context.Context, copier *savior.Copier, zf *zip.File, ec *EntryCheckpoint, crcKnown bool, sink savior.Sink, hooks *entryHooks) error {
+	entry := ec.Entry
+
+	ze.consumer.Debugf("→ %s", entry)
+
+	var enc *encryption
+	if zf.Flags&flagEncrypted != 0 && entry.Kind != savior.EntryKindDir {
+		var err error
+		enc, err = ze.openEncryption(zf, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch entry.Kind {
+	case savior.EntryKindDir:
+		err := sink.Mkdir(entry)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	case savior.EntryKindSymlink:
+		var linkname []byte
+		if enc != nil {
+			src, ds, err := ze.entrySource(zf, enc)
+			if err != nil {
+				return err
+			}
+
+			_, err = src.Resume(nil)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			linkname, err = readLinkname(entry, src)
+			if err != nil {
+				return err
+			}
+
+			err = ds.verify()
+			if err != nil {
+				return errors.Wrapf(err, "%s", entry.CanonicalPath)
+			}
+		} else {
+			rc, err := zf.Open()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			defer rc.Close()
+
+			linkname, err = readLinkname(entry, rc)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := sink.Symlink(entry, string(linkname))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	case savior.EntryKindFile:
+		src, ds, err := ze.entrySource(zf, enc)
+		if err != nil {
+			return err
+		}
+
+		if src == nil {
+			// save/resume not supported for this storage format
+			// (it needs a registered decompressor), doing a simple copy
+			entry.WriteOffset = 0
+
+			rc, err := zf.Open()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			defer rc.Close()
+
+			writer, err := hooks.getWriter(entry)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			cw := &crcWriter{w: writer}
+			err = copier.Do(&savior.CopyParams{
+				Src:   rc,
+				Dst:   cw,
+				Entry: entry,
+
+				EmitProgress: hooks.progress,
+
+				Context: ctx,
+				Limits:  ze.limits,
+			})
+			if err != nil {
+				if errors.Cause(err) == zip.ErrChecksum {
+					return &ChecksumError{Path: entry.CanonicalPath, Expected: zf.CRC32, Actual: cw.crc}
+				}
+				return errors.WithStack(err)
+			}
+
+			err = cw.check(zf, entry)
+			if err != nil {
+				return err
+			}
+			return savior.FinishEntry(sink, entry)
+		}
+
+		offset, err := src.Resume(ec.SourceCheckpoint)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if offset < entry.WriteOffset {
+			delta := entry.WriteOffset - offset
+			savior.Debugf(`%s: discarding %d bytes to align source and writer`, entry.CanonicalPath, delta)
+			savior.Debugf(`%s: (source resumed at %d, writer was at %d)`, entry.CanonicalPath, offset, entry.WriteOffset)
+			err := savior.DiscardByRead(src, delta)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		savior.Debugf(`%s: zipextractor resuming from %s`, entry.CanonicalPath, united.FormatBytes(entry.WriteOffset))
+
+		writer, err := hooks.getWriter(entry)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		cw := &crcWriter{w: writer}
+		if entry.WriteOffset > 0 {
+			if crcKnown {
+				cw.crc = ec.CRC32
+			} else {
+				cw.unknown = true
+			}
+		}
+
+		stopped := false
+		src.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+			OnSave: func(sourceCheckpoint *savior.SourceCheckpoint) error {
+				savior.Debugf(`%s: saving, has source checkpoint? %v`, entry.CanonicalPath, sourceCheckpoint != nil)
+				if sourceCheckpoint != nil {
+					savior.Debugf(`%s: source checkpoint is at %d`, entry.CanonicalPath, sourceCheckpoint.Offset)
+				}
+
+				err := writer.Sync()
+				if err != nil {
+					return errors.WithStack(err)
+				}
+
+				stop, err := hooks.save(sourceCheckpoint, cw.crc)
+				if err != nil {
+					return err
+				}
+				if stop {
+					copier.Stop()
+					stopped = true
+				}
+
+				return nil
+			},
+		})
+
+		err = copier.Do(&savior.CopyParams{
+			Src:   src,
+			Dst:   cw,
+			Entry: entry,
+
+			Savable: src,
+
+			EmitProgress: hooks.progress,
+
+			Context: ctx,
+			Limits:  ze.limits,
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if stopped {
+			return nil
+		}
+
+		if ds != nil {
+			err = ds.verify()
+			if err != nil {
+				return errors.Wrapf(err, "%s", entry.CanonicalPath)
+			}
+		}
+
+		err = cw.check(zf, entry)
+		if err != nil {
+			return err
+		}
+		return savior.FinishEntry(sink, entry)
+	}
 
-	return res, nil
+	return nil
+}
+
+// readLinkname reads the target of a symlink entry, failing
+// if it's longer than maxLinknameLength
+func readLinkname(entry *savior.Entry, r io.Reader) ([]byte, error) {
+	linkname, err := io.ReadAll(io.LimitReader(r, maxLinknameLength+1))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(linkname) > maxLinknameLength {
+		msg := fmt.Sprintf("%s: symlink target is longer than %d bytes", entry.CanonicalPath, maxLinknameLength)
+		return nil, errors.New(msg)
+	}
+	return linkname, nil
 }
 
 func (ze *ZipExtractor) Features() savior.ExtractorFeatures {
 	// zip has great resume support and is random access!
-	// (we only have entry resume if lzma is enabled)
+	// (we only have entry resume for methods that need a registered decompressor,
+	// see MethodResumeSupport)
 	return savior.ExtractorFeatures{
-		Name:          "zip",
-		ResumeSupport: ze.resumeSupport,
-		Preallocate:   true,
-		RandomAccess:  true,
+		Name:                "zip",
+		ResumeSupport:       ze.resumeSupport,
+		MethodResumeSupport: ze.methodResumeSupport,
+		Preallocate:         true,
+		RandomAccess:        true,
 	}
 }
 
@@ -365,6 +807,21 @@ func (ze *ZipExtractor) Entries() []*savior.Entry {
 	return entries
 }
 
+// List calls onEntry for each entry of the central directory
+// that isn't filtered out, see savior.Lister
+func (ze *ZipExtractor) List(onEntry savior.ListEntryFunc) error {
+	for _, zf := range ze.zr.File {
+		if !ze.includes(zf) {
+			continue
+		}
+		err := onEntry(zipFileEntry(zf))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func zipFileEntry(zf *zip.File) *savior.Entry {
 	entry := &savior.Entry{
 		CanonicalPath:    filepath.ToSlash(zf.Name),
@@ -372,6 +829,7 @@ func zipFileEntry(zf *zip.File) *savior.Entry {
 		UncompressedSize: int64(zf.UncompressedSize64),
 		Mode:             zf.Mode(),
 	}
+	entry.ModTime, entry.AccessTime = entryTimes(zf)
 
 	info := zf.FileInfo()
 
@@ -384,3 +842,31 @@ func zipFileEntry(zf *zip.File) *savior.Entry {
 	}
 	return entry
 }
+
+func init() {
+	gob.Register(&ZipExtractorState{})
+	savior.RegisterCheckpointType("zipextractor.ZipExtractorState", &ZipExtractorState{})
+
+	savior.RegisterArchiveFormat(savior.ArchiveFormat{
+		Name: "zip",
+		Match: func(header []byte) bool {
+			// local file header, or end of central directory for empty archives
+			return bytes.HasPrefix(header, []byte("PK\x03\x04")) || bytes.HasPrefix(header, []byte("PK\x05\x06"))
+		},
+		Probe: func(source savior.SeekSource) bool {
+			// self-extracting executables, etc.
+			_, err := locateDirectory(savior.NewReaderAt(source), source.Size())
+			return err == nil
+		},
+		NewSeekExtractor: func(source savior.SeekSource) (savior.Extractor, error) {
+			ex, err := New(savior.NewReaderAt(source), source.Size())
+			if err != nil {
+				return nil, err
+			}
+			return ex, nil
+		},
+		NewExtractor: func(source savior.Source) (savior.Extractor, error) {
+			return NewStream(source), nil
+		},
+	})
+}
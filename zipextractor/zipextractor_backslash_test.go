@@ -9,6 +9,7 @@ import (
 
 	"github.com/itchio/arkive/zip"
 	"github.com/itchio/savior"
+	"github.com/itchio/savior/seeksource"
 	"github.com/itchio/savior/zipextractor"
 	"github.com/stretchr/testify/assert"
 )
@@ -70,3 +71,41 @@ func TestZipNormalizeBackslashesStillRejectsTraversal(t *testing.T) {
 	})
 	assert.True(t, errors.Is(err, zip.ErrInsecurePath))
 }
+
+func TestZipStreamNormalizeBackslashes(t *testing.T) {
+	zipBytes := makeBackslashZip(t, map[string]string{
+		`dir\sub\`:         "",
+		`dir\sub\file.txt`: "hello",
+	})
+
+	_, err := zipextractor.NewStream(seeksource.FromBytes(zipBytes)).Resume(nil, &savior.FolderSink{Directory: t.TempDir()})
+	assert.True(t, errors.Is(err, zip.ErrInsecurePath), "expected ErrInsecurePath, got %v", err)
+
+	dir := t.TempDir()
+	ex := zipextractor.NewStreamWithParams(seeksource.FromBytes(zipBytes), zipextractor.StreamParams{
+		NormalizeBackslashes: true,
+	})
+	res, err := ex.Resume(nil, &savior.FolderSink{Directory: dir})
+	must(t, err)
+
+	var kinds = map[string]savior.EntryKind{}
+	for _, entry := range res.Entries {
+		kinds[entry.CanonicalPath] = entry.Kind
+	}
+	assert.EqualValues(t, savior.EntryKindDir, kinds["dir/sub/"])
+	assert.EqualValues(t, savior.EntryKindFile, kinds["dir/sub/file.txt"])
+
+	contents, err := os.ReadFile(filepath.Join(dir, "dir", "sub", "file.txt"))
+	must(t, err)
+	assert.Equal(t, "hello", string(contents))
+
+	// still rejects traversal
+	zipBytes = makeBackslashZip(t, map[string]string{
+		`..\evil.txt`: "nope",
+	})
+	ex = zipextractor.NewStreamWithParams(seeksource.FromBytes(zipBytes), zipextractor.StreamParams{
+		NormalizeBackslashes: true,
+	})
+	_, err = ex.Resume(nil, &savior.FolderSink{Directory: t.TempDir()})
+	assert.True(t, errors.Is(err, zip.ErrInsecurePath), "expected ErrInsecurePath, got %v", err)
+}
@@ -0,0 +1,99 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZipEntry(t *testing.T) {
+	zipBytes, items := makeRecoveryZip(t)
+
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+
+	for _, item := range items[1:] {
+		buf := new(bytes.Buffer)
+		entry, err := ex.ExtractEntryTo(item.name, buf)
+		must(t, err)
+		assert.EqualValues(t, item.name, entry.CanonicalPath)
+		assert.True(t, bytes.Equal(item.data, buf.Bytes()), "%s should be intact", item.name)
+	}
+
+	// deflated and stored
+	for _, name := range []string{"docs/a.txt", "d.bin"} {
+		source, entry, err := ex.EntrySource(name)
+		must(t, err)
+		assert.EqualValues(t, name, entry.CanonicalPath)
+
+		for _, item := range items {
+			if item.name == name {
+				checker.RunSourceTest(t, source, item.data)
+			}
+		}
+	}
+
+	_, err = ex.ExtractEntryTo("nope.txt", new(bytes.Buffer))
+	assert.True(t, errors.Is(err, savior.ErrEntryNotFound), "expected ErrEntryNotFound, got %v", err)
+
+	_, _, err = ex.EntrySource("docs/")
+	assert.Error(t, err)
+}
+
+func TestZipEntryEncrypted(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(10)
+
+	for _, encryption := range []int{checker.ZipCrypto, checker.AES256} {
+		zipBytes := checker.MakeEncryptedZip(t, sink, "hunter2", encryption)
+
+		ex, err := zipextractor.NewWithParams(bytes.NewReader(zipBytes), int64(len(zipBytes)), zipextractor.Params{
+			Password: "hunter2",
+		})
+		must(t, err)
+
+		for name, item := range sink.Items {
+			if item.Entry.Kind != savior.EntryKindFile {
+				continue
+			}
+
+			buf := new(bytes.Buffer)
+			_, err := ex.ExtractEntryTo(name, buf)
+			must(t, err)
+			assert.True(t, bytes.Equal(item.Data, buf.Bytes()), "%s should be intact", name)
+		}
+	}
+}
+
+func TestZipEntryTampered(t *testing.T) {
+	zipBytes, _ := makeRecoveryZip(t)
+
+	// d.bin is stored, so this flips a bit of its contents
+	tampered := append([]byte(nil), zipBytes...)
+	idx := bytes.Index(tampered, []byte("d.bin")) + len("d.bin") + 1024
+	tampered[idx] ^= 0x1
+
+	ex, err := zipextractor.New(bytes.NewReader(tampered), int64(len(tampered)))
+	must(t, err)
+
+	_, err = ex.ExtractEntryTo("d.bin", new(bytes.Buffer))
+	var ce *zipextractor.ChecksumError
+	if assert.True(t, errors.As(err, &ce), "expected ChecksumError, got %v", err) {
+		assert.EqualValues(t, "d.bin", ce.Path)
+	}
+
+	// reading past the end doesn't hide it
+	source, _, err := ex.EntrySource("d.bin")
+	must(t, err)
+	_, err = source.Resume(nil)
+	must(t, err)
+	buf := new(bytes.Buffer)
+	_, err = buf.ReadFrom(source)
+	assert.True(t, errors.As(err, &ce), "expected ChecksumError, got %v", err)
+	_, err = source.Read(make([]byte, 16))
+	assert.True(t, errors.As(err, &ce), "expected ChecksumError, got %v", err)
+}
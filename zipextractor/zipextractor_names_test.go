@@ -0,0 +1,150 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// makeLegacyZip makes a zip whose names are stored in a legacy encoding,
+// without the UTF-8 flag. Names ending with a slash are directories.
+func makeLegacyZip(t *testing.T, enc encoding.Encoding, names []string, contents []byte) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, name := range names {
+		encoded, err := enc.NewEncoder().String(name)
+		must(t, err)
+
+		fh := &zip.FileHeader{
+			Name:    encoded,
+			NonUTF8: true,
+			Method:  zip.Store,
+		}
+		w, err := zw.CreateHeader(fh)
+		must(t, err)
+		if !strings.HasSuffix(name, "/") {
+			_, err = w.Write(contents)
+			must(t, err)
+		}
+	}
+	must(t, zw.Close())
+	return buf.Bytes()
+}
+
+func entryPaths(ex *zipextractor.ZipExtractor) []string {
+	var paths []string
+	for _, entry := range ex.Entries() {
+		paths = append(paths, entry.CanonicalPath)
+	}
+	return paths
+}
+
+func TestZipNameEncoding(t *testing.T) {
+	cases := []struct {
+		name     string
+		encoding encoding.Encoding
+		names    []string
+	}{
+		{"IBM437", charmap.CodePage437, []string{"Café/", "Café/menü.txt", "résumé.doc"}},
+		{"Shift_JIS", japanese.ShiftJIS, []string{"ゲーム/", "ゲーム/データ.bin", "説明書.txt"}},
+		{"EUC-KR", korean.EUCKR, []string{"게임/", "게임/데이터.bin", "설명서.txt"}},
+		{"GBK", simplifiedchinese.GBK, []string{"游戏/", "游戏/数据.bin", "说明文件.txt"}},
+		{"Big5", traditionalchinese.Big5, []string{"遊戲/", "遊戲/資料.bin", "說明文件.txt"}},
+		{"IBM866", charmap.CodePage866, []string{"Документы/", "Документы/отчёт за год.txt", "привет.txt"}},
+		{"windows-1251", charmap.Windows1251, []string{"Документы/", "Документы/отчёт за год.txt", "привет.txt"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			zipBytes := makeLegacyZip(t, c.encoding, c.names, []byte("hello"))
+
+			for _, param := range []string{zipextractor.NameEncodingAuto, c.name} {
+				ex, err := zipextractor.NewWithParams(bytes.NewReader(zipBytes), int64(len(zipBytes)), zipextractor.Params{
+					NameEncoding: param,
+				})
+				must(t, err)
+				assert.EqualValues(t, c.names, entryPaths(ex), "with name encoding %q", param)
+			}
+		})
+	}
+
+	t.Run("utf-8 without the flag", func(t *testing.T) {
+		names := []string{"Café/", "Café/menü.txt", "ゲーム.bin"}
+		zipBytes := makeLegacyZip(t, encoding.Nop, names, []byte("hello"))
+
+		ex, err := zipextractor.NewWithParams(bytes.NewReader(zipBytes), int64(len(zipBytes)), zipextractor.Params{
+			NameEncoding: zipextractor.NameEncodingAuto,
+		})
+		must(t, err)
+		assert.EqualValues(t, names, entryPaths(ex))
+	})
+
+	t.Run("unknown encoding", func(t *testing.T) {
+		zipBytes := makeLegacyZip(t, encoding.Nop, []string{"hello.txt"}, []byte("hello"))
+
+		_, err := zipextractor.NewWithParams(bytes.NewReader(zipBytes), int64(len(zipBytes)), zipextractor.Params{
+			NameEncoding: "klingon",
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestZipNameEncodingResume(t *testing.T) {
+	contents := make([]byte, 4*1024*1024)
+	for i := range contents {
+		contents[i] = byte(i * 7 / 3)
+	}
+	names := []string{"Документы/", "Документы/отчёт.bin"}
+	zipBytes := makeLegacyZip(t, charmap.CodePage866, names, contents)
+
+	ex, err := zipextractor.NewWithParams(bytes.NewReader(zipBytes), int64(len(zipBytes)), zipextractor.Params{
+		NameEncoding: zipextractor.NameEncodingAuto,
+	})
+	must(t, err)
+
+	var saved *savior.ExtractorCheckpoint
+	ex.SetSaveConsumer(checker.NewTestSaveConsumer(1024*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+		buf := new(bytes.Buffer)
+		must(t, savior.JSONCodec.Encode(buf, checkpoint))
+		saved, err = savior.JSONCodec.Decode(buf)
+		must(t, err)
+		return savior.AfterSaveStop, nil
+	}))
+
+	dir := t.TempDir()
+	sink := &savior.FolderSink{Directory: dir, Consumer: savior.NopConsumer()}
+	_, err = ex.Resume(nil, sink)
+	assert.Equal(t, savior.ErrStop, err)
+	if assert.IsType(t, &zipextractor.ZipExtractorState{}, saved.Data) {
+		assert.EqualValues(t, "IBM866", saved.Data.(*zipextractor.ZipExtractorState).NameEncoding)
+	}
+
+	// without any parameter, names would be decoded as CP437,
+	// but the checkpoint knows better
+	ex, err = zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+	assert.NotEqualValues(t, names, entryPaths(ex))
+
+	res, err := ex.Resume(saved, sink)
+	must(t, err)
+	assert.EqualValues(t, names, entryPaths(ex))
+	assert.EqualValues(t, names[1], res.Entries[1].CanonicalPath)
+
+	written, err := os.ReadFile(filepath.Join(dir, "Документы", "отчёт.bin"))
+	must(t, err)
+	assert.True(t, bytes.Equal(contents, written))
+}
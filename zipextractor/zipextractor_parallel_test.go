@@ -0,0 +1,234 @@
+package zipextractor_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/itchio/headway/united"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZipParallel(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(40)
+	zipBytes := checker.MakeZip(t, sink)
+
+	makeZipExtractor := func() savior.Extractor {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		must(t, err)
+		ex.SetWorkers(4)
+		return ex
+	}
+
+	log.Printf("Testing .zip (%s) with 4 workers, no resumes", united.FormatBytes(int64(len(zipBytes))))
+	checker.RunExtractorText(t, makeZipExtractor, sink, func() bool {
+		return false
+	})
+
+	log.Printf("Testing .zip (%s) with 4 workers, every resume", united.FormatBytes(int64(len(zipBytes))))
+	checker.RunExtractorText(t, makeZipExtractor, sink, func() bool {
+		return true
+	})
+
+	log.Printf("Testing .zip (%s) with 4 workers, every other resume", united.FormatBytes(int64(len(zipBytes))))
+	i := 0
+	checker.RunExtractorText(t, makeZipExtractor, sink, func() bool {
+		i++
+		return i%2 == 0
+	})
+}
+
+func TestZipParallelMixedResumes(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(40)
+	zipBytes := checker.MakeZip(t, sink)
+
+	// checkpoints of parallel extractions can be resumed one entry
+	// at a time, and the other way around
+	resumes := 0
+	makeZipExtractor := func() savior.Extractor {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		must(t, err)
+		if resumes%2 == 0 {
+			ex.SetWorkers(3)
+		}
+		resumes++
+		return ex
+	}
+
+	checker.RunExtractorText(t, makeZipExtractor, sink, func() bool {
+		return true
+	})
+}
+
+func TestZipParallelCancel(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(40)
+	zipBytes := checker.MakeZip(t, sink)
+
+	makeZipExtractor := func() savior.Extractor {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		must(t, err)
+		ex.SetWorkers(4)
+		return ex
+	}
+
+	checker.RunExtractorCancelTest(t, makeZipExtractor, sink)
+}
+
+func TestZipParallelFolderSink(t *testing.T) {
+	zipBytes, items := makeRecoveryZip(t)
+
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+	ex.SetWorkers(4)
+
+	dir := t.TempDir()
+	sink := &savior.FolderSink{Directory: dir, Consumer: savior.NopConsumer()}
+	_, err = ex.Resume(nil, sink)
+	must(t, err)
+	must(t, sink.Close())
+
+	for _, item := range items[1:] {
+		written, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(item.name)))
+		must(t, err)
+		assert.True(t, bytes.Equal(item.data, written), "%s should be intact", item.name)
+	}
+}
+
+func TestZipParallelCaseOnly(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	contents := map[string]string{
+		"A.txt": "upper case",
+		"a.txt": "lower case",
+	}
+	for _, name := range []string{"A.txt", "a.txt"} {
+		w, err := zw.Create(name)
+		must(t, err)
+		_, err = w.Write([]byte(contents[name]))
+		must(t, err)
+	}
+	must(t, zw.Close())
+	zipBytes := buf.Bytes()
+
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+	ex.SetWorkers(4)
+
+	// on case-sensitive filesystems, they're two different files
+	dir := t.TempDir()
+	sink := &savior.FolderSink{Directory: dir, Consumer: savior.NopConsumer()}
+	res, err := ex.Resume(nil, sink)
+	must(t, err)
+	must(t, sink.Close())
+	assert.Len(t, res.Entries, 2)
+
+	entries, err := os.ReadDir(dir)
+	must(t, err)
+	if len(entries) == 2 {
+		for name, data := range contents {
+			written, err := os.ReadFile(filepath.Join(dir, name))
+			must(t, err)
+			assert.EqualValues(t, data, string(written))
+		}
+	} else {
+		// case-insensitive: the last one wins, like in serial mode
+		written, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+		must(t, err)
+		assert.EqualValues(t, contents["a.txt"], string(written))
+	}
+}
+
+// recordingSink records what's written to each file, every time it's
+// opened, in order
+type recordingSink struct {
+	*savior.FolderSink
+
+	mu      sync.Mutex
+	written map[string][]*bytes.Buffer
+}
+
+func (rs *recordingSink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	return rs.record(entry, rs.FolderSink.GetWriter)
+}
+
+func (rs *recordingSink) GetConcurrentWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	return rs.record(entry, rs.FolderSink.GetConcurrentWriter)
+}
+
+func (rs *recordingSink) record(entry *savior.Entry, getWriter func(entry *savior.Entry) (savior.EntryWriter, error)) (savior.EntryWriter, error) {
+	w, err := getWriter(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	buf := new(bytes.Buffer)
+	rs.written[entry.CanonicalPath] = append(rs.written[entry.CanonicalPath], buf)
+	return &recordingWriter{w, buf}, nil
+}
+
+type recordingWriter struct {
+	savior.EntryWriter
+	buf *bytes.Buffer
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	rw.buf.Write(p)
+	return rw.EntryWriter.Write(p)
+}
+
+func TestZipParallelDuplicates(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	entries := []struct {
+		name, data string
+	}{
+		{"dup.txt", "first"},
+		{"other.txt", "other"},
+		{"dup.txt", "second"},
+		{"dup.txt", "third"},
+	}
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		must(t, err)
+		_, err = w.Write([]byte(e.data))
+		must(t, err)
+	}
+	must(t, zw.Close())
+	zipBytes := buf.Bytes()
+
+	// every entry is extracted, in order, whatever the number of workers
+	for _, workers := range []int{1, 4} {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		must(t, err)
+		ex.SetWorkers(workers)
+
+		dir := t.TempDir()
+		sink := &recordingSink{
+			FolderSink: &savior.FolderSink{Directory: dir, Consumer: savior.NopConsumer()},
+			written:    make(map[string][]*bytes.Buffer),
+		}
+		res, err := ex.Resume(nil, sink)
+		must(t, err)
+		must(t, sink.Close())
+		assert.Len(t, res.Entries, len(entries))
+
+		var dups []string
+		for _, b := range sink.written["dup.txt"] {
+			dups = append(dups, b.String())
+		}
+		assert.EqualValues(t, []string{"first", "second", "third"}, dups, "with %d workers", workers)
+
+		written, err := os.ReadFile(filepath.Join(dir, "dup.txt"))
+		must(t, err)
+		assert.EqualValues(t, "third", string(written), "with %d workers", workers)
+	}
+}
@@ -0,0 +1,171 @@
+package zipextractor_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+type recoveryItem struct {
+	name string
+	data []byte
+}
+
+// makeRecoveryZip makes a zip with entries that have data descriptors
+// (deflated and stored), and entries that don't
+func makeRecoveryZip(t *testing.T) ([]byte, []recoveryItem) {
+	items := []recoveryItem{
+		{"docs/", nil},
+		{"docs/a.txt", bytes.Repeat([]byte("all work and no play "), 16*1024)},
+		{"b.bin", semirandom.Bytes(200 * 1024)},
+		{"c.bin", bytes.Repeat([]byte("raw"), 30*1024)},
+		{"d.bin", semirandom.Bytes(4 * 1024 * 1024)},
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, item := range items {
+		switch item.name {
+		case "docs/":
+			_, err := zw.CreateHeader(&zip.FileHeader{Name: item.name})
+			must(t, err)
+		case "c.bin":
+			compressed, err := checker.FlateCompress(item.data)
+			must(t, err)
+			w, err := zw.CreateRaw(&zip.FileHeader{
+				Name:               item.name,
+				Method:             zip.Deflate,
+				CRC32:              crc32.ChecksumIEEE(item.data),
+				CompressedSize64:   uint64(len(compressed)),
+				UncompressedSize64: uint64(len(item.data)),
+			})
+			must(t, err)
+			_, err = w.Write(compressed)
+			must(t, err)
+		default:
+			method := zip.Deflate
+			if item.name == "b.bin" || item.name == "d.bin" {
+				method = zip.Store
+			}
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: item.name, Method: method})
+			must(t, err)
+			_, err = w.Write(item.data)
+			must(t, err)
+		}
+	}
+	must(t, zw.Close())
+	return buf.Bytes(), items
+}
+
+func TestZipRecovery(t *testing.T) {
+	zipBytes, items := makeRecoveryZip(t)
+	allNames := []string{"docs/", "docs/a.txt", "b.bin", "c.bin", "d.bin"}
+
+	open := func(input []byte) (*zipextractor.ZipExtractor, error) {
+		return zipextractor.NewWithParams(bytes.NewReader(input), int64(len(input)), zipextractor.Params{
+			Recover: true,
+		})
+	}
+
+	t.Run("intact", func(t *testing.T) {
+		ex, err := open(zipBytes)
+		must(t, err)
+		assert.Nil(t, ex.RecoveryReport())
+	})
+
+	t.Run("damaged directory end", func(t *testing.T) {
+		input := zipBytes[:len(zipBytes)-10]
+		_, err := zipextractor.New(bytes.NewReader(input), int64(len(input)))
+		assert.Error(t, err)
+
+		ex, err := open(input)
+		must(t, err)
+		report := ex.RecoveryReport()
+		if assert.NotNil(t, report) {
+			assert.EqualValues(t, allNames, report.Recovered)
+			assert.Empty(t, report.Unreadable)
+			assert.EqualValues(t, 0, report.SkippedBytes)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		input := zipBytes[:len(zipBytes)-1024*1024]
+
+		ex, err := open(input)
+		must(t, err)
+		report := ex.RecoveryReport()
+		if assert.NotNil(t, report) {
+			assert.EqualValues(t, allNames[:4], report.Recovered)
+			if assert.Len(t, report.Unreadable, 1) {
+				assert.EqualValues(t, "d.bin", report.Unreadable[0].Name)
+				assert.EqualValues(t, "truncated", report.Unreadable[0].Reason)
+			}
+		}
+
+		// stop at the first checkpoint, then resume from it
+		var saved *savior.ExtractorCheckpoint
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(64*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			if saved != nil {
+				return savior.AfterSaveContinue, nil
+			}
+			buf := new(bytes.Buffer)
+			must(t, savior.JSONCodec.Encode(buf, checkpoint))
+			saved, err = savior.JSONCodec.Decode(buf)
+			must(t, err)
+			return savior.AfterSaveStop, nil
+		}))
+
+		dir := t.TempDir()
+		sink := &savior.FolderSink{Directory: dir, Consumer: savior.NopConsumer()}
+		_, err = ex.Resume(nil, sink)
+		assert.Equal(t, savior.ErrStop, err)
+
+		ex, err = open(input)
+		must(t, err)
+		_, err = ex.Resume(saved, sink)
+		must(t, err)
+
+		for _, item := range items[1:4] {
+			written, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(item.name)))
+			must(t, err)
+			assert.True(t, bytes.Equal(item.data, written), "%s should be intact", item.name)
+		}
+		_, err = os.Stat(filepath.Join(dir, "d.bin"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("damaged local header", func(t *testing.T) {
+		input := append([]byte(nil), zipBytes[:len(zipBytes)-10]...)
+		i := -1
+		for j := 0; j+35 < len(input); j++ {
+			if bytes.HasPrefix(input[j:], []byte("PK\x03\x04")) && bytes.HasPrefix(input[j+30:], []byte("b.bin")) {
+				i = j
+				break
+			}
+		}
+		if !assert.True(t, i >= 0, "b.bin's header should be found") {
+			return
+		}
+		copy(input[i:], "XXXX")
+
+		ex, err := open(input)
+		must(t, err)
+		report := ex.RecoveryReport()
+		if assert.NotNil(t, report) {
+			assert.EqualValues(t, []string{"docs/", "docs/a.txt", "c.bin", "d.bin"}, report.Recovered)
+			assert.True(t, report.SkippedBytes > 200*1024)
+		}
+
+		_, err = ex.Resume(nil, &savior.FolderSink{Directory: t.TempDir(), Consumer: savior.NopConsumer()})
+		must(t, err)
+	})
+}
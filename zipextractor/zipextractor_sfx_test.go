@@ -0,0 +1,113 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+// makeStub makes something that looks like an executable
+func makeStub() []byte {
+	return append([]byte("MZ"), semirandom.Bytes(64*1024+17)...)
+}
+
+func TestZipPrefixed(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(10)
+	zipBytes := checker.MakeZip(t, sink)
+	stub := makeStub()
+
+	cases := []struct {
+		name   string
+		input  []byte
+		offset int64
+	}{
+		// like `cat stub.exe archive.zip`
+		{"relative offsets", append(append([]byte(nil), stub...), zipBytes...), int64(len(stub))},
+		// zips appended to each other, the last one wins
+		{"concatenated", append(append([]byte(nil), zipBytes...), zipBytes...), int64(len(zipBytes))},
+		// like `zip -A`, which adjusts offsets for the stub
+		{"absolute offsets", makeZipAfter(t, stub, sink), 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			makeZipExtractor := func() savior.Extractor {
+				ex, err := zipextractor.New(bytes.NewReader(c.input), int64(len(c.input)))
+				must(t, err)
+				assert.EqualValues(t, c.offset, ex.ArchiveOffset())
+				return ex
+			}
+
+			log.Printf("Testing prefixed .zip (%s), every resume", c.name)
+			checker.RunExtractorText(t, makeZipExtractor, sink, func() bool {
+				return true
+			})
+		})
+	}
+}
+
+// makeZipAfter writes a zip after some data, with offsets that account for it
+func makeZipAfter(t *testing.T, prefix []byte, sink *checker.Sink) []byte {
+	buf := bytes.NewBuffer(append([]byte(nil), prefix...))
+	zw := zip.NewWriter(buf)
+	zw.SetOffset(int64(len(prefix)))
+	for _, item := range sink.Items {
+		fh := &zip.FileHeader{Name: item.Entry.CanonicalPath}
+		var data []byte
+		switch item.Entry.Kind {
+		case savior.EntryKindDir:
+			fh.SetMode(os.ModeDir | 0755)
+		case savior.EntryKindFile:
+			fh.SetMode(0644)
+			data = item.Data
+		case savior.EntryKindSymlink:
+			fh.SetMode(os.ModeSymlink | 0644)
+			data = []byte(item.Entry.Linkname)
+		}
+		w, err := zw.CreateHeader(fh)
+		must(t, err)
+		_, err = w.Write(data)
+		must(t, err)
+	}
+	must(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestZipPrefixedZip64(t *testing.T) {
+	// more than 65535 entries need a zip64 directory end, whose
+	// recorded offset doesn't account for the stub
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	const numEntries = 70000
+	for i := 0; i < numEntries; i++ {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("f%05d", i), Method: zip.Store})
+		must(t, err)
+		_, err = w.Write([]byte{byte(i)})
+		must(t, err)
+	}
+	must(t, zw.Close())
+
+	stub := makeStub()
+	input := append(append([]byte(nil), stub...), buf.Bytes()...)
+
+	ex, err := zipextractor.New(bytes.NewReader(input), int64(len(input)))
+	must(t, err)
+	assert.EqualValues(t, len(stub), ex.ArchiveOffset())
+	assert.Len(t, ex.Entries(), numEntries)
+
+	ex.SetFilter(func(entry *savior.Entry) bool {
+		return entry.CanonicalPath == "f69999"
+	})
+	dir := t.TempDir()
+	_, err = ex.Resume(nil, &savior.FolderSink{Directory: dir, Consumer: savior.NopConsumer()})
+	must(t, err)
+}
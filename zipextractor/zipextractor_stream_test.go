@@ -0,0 +1,210 @@
+package zipextractor_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/headway/united"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/gzipsource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// makeStreamSink returns a sink whose directories end with a slash, since
+// that's the only way to tell them apart without the central directory
+func makeStreamSink(numEntries int) *checker.Sink {
+	sink := checker.MakeTestSinkAdvanced(numEntries)
+	res := checker.NewSink()
+	for name, item := range sink.Items {
+		if item.Entry.Kind == savior.EntryKindDir {
+			name += "/"
+			entry := *item.Entry
+			entry.CanonicalPath = name
+			item = &checker.Item{Entry: &entry, Data: item.Data}
+		}
+		res.Items[name] = item
+	}
+	return res
+}
+
+func TestZipStream(t *testing.T) {
+	sink := makeStreamSink(40)
+	zipBytes := checker.MakeZip(t, sink)
+
+	gzipBytes, err := checker.GzipCompress(zipBytes)
+	must(t, err)
+
+	sources := []struct {
+		ext        string
+		makeSource func() savior.Source
+	}{
+		{".zip", func() savior.Source { return seeksource.FromBytes(zipBytes) }},
+		{".zip.gz", func() savior.Source { return gzipsource.New(seeksource.FromBytes(gzipBytes)) }},
+	}
+
+	for _, s := range sources {
+		makeExtractor := func() savior.Extractor {
+			return zipextractor.NewStream(s.makeSource())
+		}
+
+		log.Printf("Streaming %s (%s), no resumes", s.ext, united.FormatBytes(int64(len(zipBytes))))
+		checker.RunExtractorText(t, makeExtractor, sink, func() bool {
+			return false
+		})
+
+		log.Printf("Streaming %s (%s), every resume", s.ext, united.FormatBytes(int64(len(zipBytes))))
+		checker.RunExtractorText(t, makeExtractor, sink, func() bool {
+			return true
+		})
+	}
+
+	ex, err := savior.Detect(seeksource.FromBytes(gzipBytes))
+	must(t, err)
+	assert.EqualValues(t, "zip-stream", ex.Features().Name)
+}
+
+func TestZipStreamMethods(t *testing.T) {
+	methods := []struct {
+		name     string
+		method   uint16
+		compress checker.CompressFunc
+	}{
+		// those don't delimit themselves, so data descriptors have to be found
+		{"bzip2", zipextractor.MethodBzip2, checker.Bzip2Compress},
+		{"zstd", zipextractor.MethodZstd, checker.ZstdCompress},
+	}
+
+	for _, m := range methods {
+		t.Run(m.name, func(t *testing.T) {
+			sink := makeStreamSink(20)
+			zipBytes := checker.MakeZipWithMethod(t, sink, m.method, m.compress)
+
+			makeExtractor := func() savior.Extractor {
+				return zipextractor.NewStream(seeksource.FromBytes(zipBytes))
+			}
+
+			log.Printf("Streaming %s .zip (%s), every resume", m.name, united.FormatBytes(int64(len(zipBytes))))
+			checker.RunExtractorText(t, makeExtractor, sink, func() bool {
+				return true
+			})
+		})
+	}
+}
+
+func TestZipStreamFilter(t *testing.T) {
+	sink := makeStreamSink(40)
+	zipBytes := checker.MakeZip(t, sink)
+
+	filter, err := savior.GlobFilter([]string{"file-*"}, []string{"file-1"})
+	must(t, err)
+	filteredSink := sink.Filter(filter)
+
+	makeExtractor := func() savior.Extractor {
+		ex := zipextractor.NewStream(seeksource.FromBytes(zipBytes))
+		ex.(savior.Filterable).SetFilter(filter)
+		return ex
+	}
+	checker.RunExtractorText(t, makeExtractor, filteredSink, func() bool {
+		return true
+	})
+
+	res, err := savior.List(makeExtractor())
+	must(t, err)
+	assert.EqualValues(t, len(filteredSink.Items), len(res.Entries))
+	for _, entry := range res.Entries {
+		item, ok := filteredSink.Items[entry.CanonicalPath]
+		if assert.True(t, ok, "listed unknown entry %s", entry.CanonicalPath) {
+			assert.EqualValues(t, item.Entry.Kind, entry.Kind)
+			assert.EqualValues(t, len(item.Data), entry.UncompressedSize)
+		}
+	}
+}
+
+func TestZipStreamKnownSizes(t *testing.T) {
+	// that one has entries with and without data descriptors
+	zipBytes, items := makeRecoveryZip(t)
+
+	dir := t.TempDir()
+	sink := &savior.FolderSink{Directory: dir, Consumer: savior.NopConsumer()}
+	res, err := zipextractor.NewStream(seeksource.FromBytes(zipBytes)).Resume(nil, sink)
+	must(t, err)
+
+	var names []string
+	for _, entry := range res.Entries {
+		names = append(names, entry.CanonicalPath)
+	}
+	assert.EqualValues(t, []string{"docs/", "docs/a.txt", "b.bin", "c.bin", "d.bin"}, names)
+
+	for _, item := range items[1:] {
+		written, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(item.name)))
+		must(t, err)
+		assert.True(t, bytes.Equal(item.data, written), "%s should be intact", item.name)
+	}
+}
+
+func TestZipStreamTruncated(t *testing.T) {
+	zipBytes, _ := makeRecoveryZip(t)
+	input := zipBytes[:len(zipBytes)-1024*1024]
+
+	dir := t.TempDir()
+	sink := &savior.FolderSink{Directory: dir, Consumer: savior.NopConsumer()}
+	_, err := zipextractor.NewStream(seeksource.FromBytes(input)).Resume(nil, sink)
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "expected unexpected EOF, got %v", err)
+
+	// what came before is there
+	written, err := os.ReadFile(filepath.Join(dir, "c.bin"))
+	must(t, err)
+	assert.NotEmpty(t, written)
+}
+
+func TestZipStreamLimits(t *testing.T) {
+	// sizes of entries written by the zip package are in data descriptors
+	zeroes := make([]byte, 8*1024*1024)
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, name := range []string{"zeroes", "more-zeroes"} {
+		w, err := zw.Create(name)
+		must(t, err)
+		_, err = w.Write(zeroes)
+		must(t, err)
+	}
+	must(t, zw.Close())
+	zipBytes := buf.Bytes()
+
+	extract := func(limits *savior.Limits) error {
+		ex := zipextractor.NewStream(seeksource.FromBytes(zipBytes))
+		ex.(savior.Limitable).SetLimits(limits)
+		_, err := ex.Resume(nil, &savior.NopSink{})
+		return err
+	}
+
+	expectLimit := func(err error, limit string) {
+		var le *savior.LimitError
+		if assert.True(t, errors.As(err, &le), "expected limit error, got %v", err) {
+			assert.EqualValues(t, limit, le.Limit)
+			assert.EqualValues(t, "zeroes", le.Path)
+			log.Printf("got expected error: %v", le)
+		}
+	}
+
+	assert.NoError(t, extract(nil))
+	expectLimit(extract(&savior.Limits{MaxRatio: 100}), savior.LimitRatio)
+	expectLimit(extract(&savior.Limits{MaxTotalBytes: 1024 * 1024}), savior.LimitTotalBytes)
+
+	// the second entry goes over the total, counting the first
+	err := extract(&savior.Limits{MaxTotalBytes: 12 * 1024 * 1024})
+	var le *savior.LimitError
+	if assert.True(t, errors.As(err, &le), "expected limit error, got %v", err) {
+		assert.EqualValues(t, savior.LimitTotalBytes, le.Limit)
+		assert.EqualValues(t, "more-zeroes", le.Path)
+	}
+}
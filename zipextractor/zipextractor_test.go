@@ -1,10 +1,21 @@
 package zipextractor_test
 
 import (
+	"archive/zip"
 	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/gob"
+	"errors"
+	"hash/crc32"
+	"io"
 	"log"
+	"math/rand"
+	"os"
+	"sync"
 	"testing"
 
+	arkivezip "github.com/itchio/arkive/zip"
 	"github.com/itchio/headway/united"
 	"github.com/itchio/savior"
 	"github.com/itchio/savior/checker"
@@ -48,3 +59,495 @@ func TestZip(t *testing.T) {
 		return i%2 == 0
 	})
 }
+
+func TestZipFilter(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(40)
+	zipBytes := checker.MakeZip(t, sink)
+
+	filter, err := savior.GlobFilter(nil, []string{"file-1*", "dir-*"})
+	must(t, err)
+
+	// the filtered sink errors out on entries it doesn't know about,
+	// so this also checks that filtered entries never reach the sink.
+	filteredSink := sink.Filter(filter)
+	assert.True(t, len(filteredSink.Items) < len(sink.Items))
+
+	makeZipExtractor := func() savior.Extractor {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		must(t, err)
+		ex.SetFilter(filter)
+		return ex
+	}
+
+	log.Printf("Testing filtered .zip, no resumes")
+	checker.RunExtractorText(t, makeZipExtractor, filteredSink, func() bool {
+		return false
+	})
+
+	log.Printf("Testing filtered .zip, every resume")
+	checker.RunExtractorText(t, makeZipExtractor, filteredSink, func() bool {
+		return true
+	})
+
+	res, err := makeZipExtractor().Resume(nil, filteredSink)
+	must(t, err)
+	assert.EqualValues(t, len(filteredSink.Items), len(res.Entries))
+	for _, entry := range res.Entries {
+		assert.True(t, filter(entry), "%s is in result", entry.CanonicalPath)
+	}
+}
+
+func TestZipList(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(40)
+	zipBytes := checker.MakeZip(t, sink)
+
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+
+	res, err := savior.List(ex)
+	must(t, err)
+	assert.EqualValues(t, len(sink.Items), len(res.Entries))
+	for _, entry := range res.Entries {
+		item, ok := sink.Items[entry.CanonicalPath]
+		if assert.True(t, ok, "listed unknown entry %s", entry.CanonicalPath) {
+			assert.EqualValues(t, item.Entry.Kind, entry.Kind)
+			assert.EqualValues(t, len(item.Data), entry.UncompressedSize)
+		}
+	}
+
+	stopErr := errors.New("seen enough")
+	numSeen := 0
+	err = ex.List(func(entry *savior.Entry) error {
+		numSeen++
+		return stopErr
+	})
+	assert.Equal(t, stopErr, err)
+	assert.EqualValues(t, 1, numSeen)
+}
+
+func TestZipCancel(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(40)
+	zipBytes := checker.MakeZip(t, sink)
+
+	makeZipExtractor := func() savior.Extractor {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		must(t, err)
+		return ex
+	}
+
+	checker.RunExtractorCancelTest(t, makeZipExtractor, sink)
+}
+
+func TestZipLimits(t *testing.T) {
+	zeroes := make([]byte, 8*1024*1024)
+
+	compressed := new(bytes.Buffer)
+	fw, err := flate.NewWriter(compressed, flate.BestCompression)
+	must(t, err)
+	_, err = fw.Write(zeroes)
+	must(t, err)
+	must(t, fw.Close())
+
+	// makeZip stores 8MiB of zeroes, but declares `declaredSize`
+	makeZip := func(declaredSize uint64) []byte {
+		buf := new(bytes.Buffer)
+		zw := zip.NewWriter(buf)
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               "zeroes",
+			Method:             zip.Deflate,
+			CRC32:              crc32.ChecksumIEEE(zeroes),
+			CompressedSize64:   uint64(compressed.Len()),
+			UncompressedSize64: declaredSize,
+		})
+		must(t, err)
+		_, err = w.Write(compressed.Bytes())
+		must(t, err)
+		must(t, zw.Close())
+		return buf.Bytes()
+	}
+
+	extract := func(zipBytes []byte, limits *savior.Limits) error {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		must(t, err)
+		ex.SetLimits(limits)
+		_, err = ex.Resume(nil, &savior.NopSink{})
+		return err
+	}
+
+	expectLimit := func(err error, limit string) {
+		var le *savior.LimitError
+		if assert.True(t, errors.As(err, &le), "expected limit error, got %v", err) {
+			assert.EqualValues(t, limit, le.Limit)
+			log.Printf("got expected error: %v", le)
+		}
+	}
+
+	honest := makeZip(uint64(len(zeroes)))
+	assert.NoError(t, extract(honest, nil))
+	expectLimit(extract(honest, &savior.Limits{MaxRatio: 100}), savior.LimitRatio)
+	expectLimit(extract(honest, &savior.Limits{MaxTotalBytes: 1024 * 1024}), savior.LimitTotalBytes)
+	expectLimit(extract(honest, &savior.Limits{MaxEntries: -1, MaxPathLength: 3}), savior.LimitPathLength)
+
+	// an entry that inflates past its declared size is stopped in the copy loop
+	liar := makeZip(1024 * 1024)
+	expectLimit(extract(liar, nil), savior.LimitDeclaredSize)
+	expectLimit(extract(liar, savior.NoLimits()), savior.LimitDeclaredSize)
+}
+
+func TestZipLimitsFallback(t *testing.T) {
+	// entries of methods that only the zip package can decompress are
+	// copied without checkpoints, but with limits and cancellation
+	registerFlateMethod.Do(func() {
+		arkivezip.RegisterDecompressor(97, func(r io.Reader, f *arkivezip.File) io.ReadCloser {
+			return flate.NewReader(r)
+		})
+	})
+
+	zeroes := make([]byte, 8*1024*1024)
+	compressed := new(bytes.Buffer)
+	fw, err := flate.NewWriter(compressed, flate.BestCompression)
+	must(t, err)
+	_, err = fw.Write(zeroes)
+	must(t, err)
+	must(t, fw.Close())
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "zeroes",
+		Method:             97,
+		CRC32:              crc32.ChecksumIEEE(zeroes),
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: uint64(len(zeroes)),
+	})
+	must(t, err)
+	_, err = w.Write(compressed.Bytes())
+	must(t, err)
+	must(t, zw.Close())
+	zipBytes := buf.Bytes()
+
+	makeZipExtractor := func() *zipextractor.ZipExtractor {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		must(t, err)
+		assert.EqualValues(t, savior.ResumeSupportEntry, ex.Features().ResumeSupport)
+		return ex
+	}
+
+	ex := makeZipExtractor()
+	_, err = ex.Resume(nil, &savior.NopSink{})
+	assert.NoError(t, err)
+
+	ex = makeZipExtractor()
+	ex.SetLimits(&savior.Limits{MaxRatio: 100})
+	_, err = ex.Resume(nil, &savior.NopSink{})
+	var le *savior.LimitError
+	if assert.True(t, errors.As(err, &le), "expected limit error, got %v", err) {
+		assert.EqualValues(t, savior.LimitRatio, le.Limit)
+	}
+
+	// cancel as soon as something's written
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sink := &cancelSink{cancel: cancel}
+	_, err = makeZipExtractor().ResumeContext(ctx, nil, sink)
+	assert.True(t, errors.Is(err, context.Canceled), "expected cancellation, got %v", err)
+	assert.Less(t, sink.written, int64(len(zeroes)))
+}
+
+// decompressors can only be registered once per process
+var registerFlateMethod sync.Once
+
+// cancelSink calls cancel when anything is written to it
+type cancelSink struct {
+	savior.NopSink
+	cancel  context.CancelFunc
+	written int64
+}
+
+func (cs *cancelSink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	return cs, nil
+}
+
+func (cs *cancelSink) Write(buf []byte) (int, error) {
+	cs.written += int64(len(buf))
+	cs.cancel()
+	return len(buf), nil
+}
+
+func (cs *cancelSink) Sync() error {
+	return nil
+}
+
+func TestZipLongSymlink(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	fh := &zip.FileHeader{Name: "link", Method: zip.Deflate}
+	fh.SetMode(os.ModeSymlink | 0755)
+	w, err := zw.CreateHeader(fh)
+	must(t, err)
+	_, err = w.Write(bytes.Repeat([]byte("../"), 64*1024))
+	must(t, err)
+	must(t, zw.Close())
+
+	ex, err := zipextractor.New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	must(t, err)
+	_, err = ex.Resume(nil, &savior.NopSink{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "symlink target is longer than")
+	}
+}
+
+func TestZipChecksum(t *testing.T) {
+	data := make([]byte, 4*1024*1024)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+
+	makeZip := func(crc uint32) []byte {
+		buf := new(bytes.Buffer)
+		zw := zip.NewWriter(buf)
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               "data.bin",
+			Method:             zip.Store,
+			CRC32:              crc,
+			CompressedSize64:   uint64(len(data)),
+			UncompressedSize64: uint64(len(data)),
+		})
+		must(t, err)
+		_, err = w.Write(data)
+		must(t, err)
+		must(t, zw.Close())
+		return buf.Bytes()
+	}
+
+	// extract stops at the first checkpoint, then resumes from it
+	// after a trip through JSON
+	extract := func(zipBytes []byte) error {
+		sink := &savior.FolderSink{
+			Directory: t.TempDir(),
+			Consumer:  savior.NopConsumer(),
+		}
+
+		var saved *savior.ExtractorCheckpoint
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		must(t, err)
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(1024*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			if saved != nil {
+				return savior.AfterSaveContinue, nil
+			}
+			buf := new(bytes.Buffer)
+			must(t, savior.JSONCodec.Encode(buf, checkpoint))
+			saved, err = savior.JSONCodec.Decode(buf)
+			must(t, err)
+			return savior.AfterSaveStop, nil
+		}))
+
+		_, err = ex.Resume(nil, sink)
+		if err != savior.ErrStop {
+			return err
+		}
+
+		if assert.NotNil(t, saved.Entry) && assert.IsType(t, &zipextractor.ZipExtractorState{}, saved.Data) {
+			assert.True(t, saved.Entry.WriteOffset > 0)
+		}
+
+		ex, err = zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		must(t, err)
+		_, err = ex.Resume(saved, sink)
+		return err
+	}
+
+	assert.NoError(t, extract(makeZip(crc32.ChecksumIEEE(data))))
+
+	err := extract(makeZip(0xdeadbeef))
+	var ce *zipextractor.ChecksumError
+	if assert.True(t, errors.As(err, &ce), "expected checksum error, got %v", err) {
+		assert.EqualValues(t, "data.bin", ce.Path)
+		assert.EqualValues(t, 0xdeadbeef, ce.Expected)
+		assert.EqualValues(t, crc32.ChecksumIEEE(data), ce.Actual)
+		assert.True(t, errors.Is(err, zipextractor.ErrChecksum))
+		assert.Contains(t, err.Error(), "data.bin")
+	}
+}
+
+func TestZipMethods(t *testing.T) {
+	methods := []struct {
+		name     string
+		method   uint16
+		compress checker.CompressFunc
+	}{
+		{"deflate64", zipextractor.MethodDeflate64, checker.Deflate64Compress},
+		{"lzma", arkivezip.LZMA, checker.LzmaZipCompress},
+		{"bzip2", zipextractor.MethodBzip2, checker.Bzip2Compress},
+		{"zstd", zipextractor.MethodZstd, checker.ZstdCompress},
+		{"xz", zipextractor.MethodXz, checker.XzCompress},
+	}
+
+	for _, m := range methods {
+		t.Run(m.name, func(t *testing.T) {
+			sink := checker.MakeTestSinkAdvanced(20)
+			zipBytes := checker.MakeZipWithMethod(t, sink, m.method, m.compress)
+
+			makeZipExtractor := func() savior.Extractor {
+				ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+				must(t, err)
+				features := ex.Features()
+				assert.EqualValues(t, savior.ResumeSupportBlock, features.ResumeSupport)
+				assert.EqualValues(t, savior.ResumeSupportBlock, features.MethodResumeSupport[m.name])
+				return ex
+			}
+
+			log.Printf("Testing %s .zip (%s), every resume", m.name, united.FormatBytes(int64(len(zipBytes))))
+			checker.RunExtractorText(t, makeZipExtractor, sink, func() bool {
+				return true
+			})
+		})
+	}
+
+	methodFeatures := func(method uint16) savior.ExtractorFeatures {
+		buf := new(bytes.Buffer)
+		zw := zip.NewWriter(buf)
+		w, err := zw.CreateRaw(&zip.FileHeader{Name: "mystery", Method: method})
+		must(t, err)
+		_, err = w.Write([]byte("?"))
+		must(t, err)
+		must(t, zw.Close())
+
+		ex, err := zipextractor.New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		must(t, err)
+		return ex.Features()
+	}
+
+	// methods the zip package has decompressors for, but we don't have
+	// sources for, can only resume between entries
+	registerMysteryMethod.Do(func() {
+		arkivezip.RegisterDecompressor(98, func(r io.Reader, f *arkivezip.File) io.ReadCloser {
+			return io.NopCloser(r)
+		})
+	})
+	features := methodFeatures(98)
+	assert.EqualValues(t, savior.ResumeSupportEntry, features.ResumeSupport)
+	assert.EqualValues(t, savior.ResumeSupportEntry, features.MethodResumeSupport["method 98"])
+	assert.Contains(t, features.String(), "[method 98=entry]")
+
+	// others can't be extracted at all
+	features = methodFeatures(99)
+	assert.EqualValues(t, savior.ResumeSupportNone, features.ResumeSupport)
+	assert.EqualValues(t, savior.ResumeSupportNone, features.MethodResumeSupport["method 99"])
+}
+
+// decompressors can only be registered once per process
+var registerMysteryMethod sync.Once
+
+func TestZipEncrypted(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(10)
+
+	for _, encryption := range []int{checker.ZipCrypto, checker.AES128, checker.AES256} {
+		zipBytes := checker.MakeEncryptedZip(t, sink, "hunter2", encryption)
+
+		makeZipExtractor := func() savior.Extractor {
+			ex, err := zipextractor.NewWithParams(bytes.NewReader(zipBytes), int64(len(zipBytes)), zipextractor.Params{
+				Password: "hunter2",
+			})
+			must(t, err)
+			return ex
+		}
+
+		log.Printf("Testing encrypted .zip (method %d), every resume", encryption)
+		checker.RunExtractorText(t, makeZipExtractor, sink, func() bool {
+			return true
+		})
+
+		extract := func(params zipextractor.Params) error {
+			ex, err := zipextractor.NewWithParams(bytes.NewReader(zipBytes), int64(len(zipBytes)), params)
+			must(t, err)
+			_, err = ex.Resume(nil, &savior.NopSink{})
+			return err
+		}
+
+		err := extract(zipextractor.Params{})
+		assert.True(t, errors.Is(err, arkivezip.ErrEncrypted), "expected ErrEncrypted, got %v", err)
+
+		err = extract(zipextractor.Params{Password: "hunter3"})
+		assert.True(t, errors.Is(err, zipextractor.ErrWrongPassword), "expected ErrWrongPassword, got %v", err)
+
+		var asked []string
+		err = extract(zipextractor.Params{
+			PasswordFunc: func(entry *savior.Entry) (string, error) {
+				asked = append(asked, entry.CanonicalPath)
+				return "hunter2", nil
+			},
+		})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, asked)
+	}
+}
+
+func TestZipCryptoCheckpoints(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(10)
+	zipBytes := checker.MakeEncryptedZip(t, sink, "hunter2", checker.ZipCrypto)
+
+	makeZipExtractor := func() savior.Extractor {
+		ex, err := zipextractor.NewWithParams(bytes.NewReader(zipBytes), int64(len(zipBytes)), zipextractor.Params{
+			Password: "hunter2",
+		})
+		must(t, err)
+		return ex
+	}
+	assert.EqualValues(t, savior.ResumeSupportEntry, makeZipExtractor().Features().ResumeSupport)
+
+	// the cipher state would give away the password, so entries are
+	// decrypted from the start instead
+	ex := makeZipExtractor()
+	checkpoints := 0
+	ex.SetSaveConsumer(checker.NewTestSaveConsumer(64*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+		checkpoints++
+		buf := new(bytes.Buffer)
+		must(t, gob.NewEncoder(buf).Encode(checkpoint))
+		assert.NotContains(t, buf.String(), "DecryptSourceCheckpoint")
+		return savior.AfterSaveContinue, nil
+	}))
+	_, err := ex.Resume(nil, &savior.NopSink{})
+	must(t, err)
+	log.Printf("ZipCrypto extraction made %d checkpoints", checkpoints)
+
+	checker.RunExtractorText(t, makeZipExtractor, sink, func() bool {
+		return true
+	})
+}
+
+func TestZipEncryptedTampered(t *testing.T) {
+	// incompressible, so that deflate stores it as-is
+	data := make([]byte, 8*1024)
+	rand.New(rand.NewSource(0x5e5a3e)).Read(data)
+
+	sink := &checker.Sink{
+		Items: map[string]*checker.Item{
+			"secret.txt": {
+				Entry: &savior.Entry{
+					CanonicalPath: "secret.txt",
+					Kind:          savior.EntryKindFile,
+				},
+				Data: data,
+			},
+		},
+	}
+	zipBytes := checker.MakeEncryptedZip(t, sink, "hunter2", checker.AES128)
+
+	// flip a bit in the middle of the payload (after the 11-byte extra field, the
+	// salt and password verifier): AE-2 has no CRC32, so only the authentication
+	// code catches it
+	tampered := append([]byte(nil), zipBytes...)
+	idx := bytes.Index(tampered, []byte("secret.txt")) + len("secret.txt") + 11 + 10 + 1024
+	tampered[idx] ^= 0x1
+
+	ex, err := zipextractor.NewWithParams(bytes.NewReader(tampered), int64(len(tampered)), zipextractor.Params{
+		Password: "hunter2",
+	})
+	must(t, err)
+	_, err = ex.Resume(nil, &savior.NopSink{})
+	assert.True(t, errors.Is(err, zipextractor.ErrAuthentication), "expected ErrAuthentication, got %v", err)
+	if err != nil {
+		assert.Contains(t, err.Error(), "secret.txt")
+	}
+}
@@ -0,0 +1,236 @@
+package zipextractor_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	timesModified = time.Date(2015, time.October, 21, 16, 29, 0, 0, time.UTC)
+	timesAccessed = time.Date(2016, time.January, 2, 3, 4, 5, 0, time.UTC)
+)
+
+// ntfsTimesExtra returns an NTFS extra field with the given modification
+// and access times
+func ntfsTimesExtra(mtime time.Time, atime time.Time) []byte {
+	ticks := func(t time.Time) uint64 {
+		return uint64(t.Unix()+11644473600)*10000000 + uint64(t.Nanosecond()/100)
+	}
+
+	extra := make([]byte, 4+4+4+24)
+	binary.LittleEndian.PutUint16(extra[0:], 0x000a)
+	binary.LittleEndian.PutUint16(extra[2:], 4+4+24)
+	binary.LittleEndian.PutUint16(extra[8:], 1)
+	binary.LittleEndian.PutUint16(extra[10:], 24)
+	binary.LittleEndian.PutUint64(extra[12:], ticks(mtime))
+	binary.LittleEndian.PutUint64(extra[20:], ticks(atime))
+	binary.LittleEndian.PutUint64(extra[28:], ticks(mtime))
+	return extra
+}
+
+// extTimesExtra returns an extended timestamp extra field with the given
+// modification and access times
+func extTimesExtra(mtime time.Time, atime time.Time) []byte {
+	extra := make([]byte, 4+1+4+4)
+	binary.LittleEndian.PutUint16(extra[0:], 0x5455)
+	binary.LittleEndian.PutUint16(extra[2:], 1+4+4)
+	extra[4] = 0x1 | 0x2
+	binary.LittleEndian.PutUint32(extra[5:], uint32(mtime.Unix()))
+	binary.LittleEndian.PutUint32(extra[9:], uint32(atime.Unix()))
+	return extra
+}
+
+// makeTimesZip makes a zip whose entries have times in various places
+func makeTimesZip(t *testing.T) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	headers := []*zip.FileHeader{
+		// the zip package writes an extended timestamp with just the
+		// modification time
+		{Name: "dir/", Modified: timesModified},
+		{Name: "dir/big.bin", Method: zip.Store, Modified: timesModified},
+		// created after resuming in the middle of dir/big.bin
+		{Name: "dir/after.txt", Method: zip.Deflate, Modified: timesModified},
+		{Name: "ntfs.txt", Method: zip.Deflate, Extra: ntfsTimesExtra(timesModified, timesAccessed)},
+		{Name: "ext.txt", Method: zip.Deflate, Extra: extTimesExtra(timesModified, timesAccessed)},
+		{Name: "none.txt", Method: zip.Deflate},
+	}
+	for _, fh := range headers {
+		w, err := zw.CreateHeader(fh)
+		must(t, err)
+		switch fh.Name {
+		case "dir/":
+		case "dir/big.bin":
+			_, err = w.Write(semirandom.Bytes(4 * 1024 * 1024))
+		default:
+			_, err = w.Write([]byte("some text"))
+		}
+		must(t, err)
+	}
+	must(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestZipTimes(t *testing.T) {
+	zipBytes := makeTimesZip(t)
+
+	assertTimes := func(entries []*savior.Entry) {
+		times := make(map[string]*savior.Entry)
+		for _, entry := range entries {
+			times[entry.CanonicalPath] = entry
+		}
+
+		for _, name := range []string{"dir/big.bin", "ntfs.txt", "ext.txt"} {
+			if assert.Contains(t, times, name) {
+				assert.True(t, timesModified.Equal(times[name].ModTime), "%s has mtime %s", name, times[name].ModTime)
+			}
+		}
+		for _, name := range []string{"ntfs.txt", "ext.txt"} {
+			if assert.Contains(t, times, name) {
+				assert.True(t, timesAccessed.Equal(times[name].AccessTime), "%s has atime %s", name, times[name].AccessTime)
+			}
+		}
+		if assert.Contains(t, times, "none.txt") {
+			assert.True(t, times["none.txt"].ModTime.IsZero())
+			assert.True(t, times["none.txt"].AccessTime.IsZero())
+		}
+	}
+
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+	res, err := savior.List(ex)
+	must(t, err)
+	assertTimes(res.Entries)
+
+	// local headers have them too
+	streamRes, err := savior.List(zipextractor.NewStream(seeksource.FromBytes(zipBytes)))
+	must(t, err)
+	assertTimes(streamRes.Entries)
+
+	// and they're read the same, time zone included
+	if assert.Equal(t, len(res.Entries), len(streamRes.Entries)) {
+		for i, entry := range res.Entries {
+			streamEntry := streamRes.Entries[i]
+			assert.Equal(t, entry.ModTime.String(), streamEntry.ModTime.String(), "%s", entry.CanonicalPath)
+		}
+	}
+}
+
+func TestZipTimesFolderSink(t *testing.T) {
+	zipBytes := makeTimesZip(t)
+
+	makeExtractors := map[string]func() savior.Extractor{
+		"random-access": func() savior.Extractor {
+			ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+			must(t, err)
+			return ex
+		},
+		"stream": func() savior.Extractor {
+			return zipextractor.NewStream(seeksource.FromBytes(zipBytes))
+		},
+	}
+
+	for name, makeExtractor := range makeExtractors {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			sink := &savior.FolderSink{Directory: dir, Consumer: savior.NopConsumer()}
+
+			// stop in the middle of dir/big.bin, after dir/ was made. the
+			// checkpoint is encoded right away, like a real consumer would.
+			var saved *bytes.Buffer
+			ex := makeExtractor()
+			ex.SetSaveConsumer(checker.NewTestSaveConsumer(1024*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+				saved = new(bytes.Buffer)
+				return savior.AfterSaveStop, gob.NewEncoder(saved).Encode(checkpoint)
+			}))
+			_, err := ex.Resume(nil, sink)
+			assert.Equal(t, savior.ErrStop, err)
+			must(t, sink.Close())
+			if !assert.NotNil(t, saved) {
+				return
+			}
+			assertPartial(t, dir)
+
+			// the entry in the checkpoint keeps its times
+			checkpoint := &savior.ExtractorCheckpoint{}
+			must(t, gob.NewDecoder(saved).Decode(checkpoint))
+
+			// and so does the directory made before stopping
+			sink = &savior.FolderSink{Directory: dir, Consumer: savior.NopConsumer()}
+			_, err = makeExtractor().Resume(checkpoint, sink)
+			must(t, err)
+			must(t, sink.Close())
+
+			for _, name := range []string{"dir", "dir/big.bin", "ntfs.txt", "ext.txt"} {
+				stats, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+				must(t, err)
+				assert.True(t, timesModified.Equal(stats.ModTime()), "%s has mtime %s", name, stats.ModTime())
+			}
+
+			stats, err := os.Stat(filepath.Join(dir, "none.txt"))
+			must(t, err)
+			assert.WithinDuration(t, time.Now(), stats.ModTime(), time.Hour)
+		})
+	}
+}
+
+func TestZipTimesCancel(t *testing.T) {
+	zipBytes := makeTimesZip(t)
+
+	makeExtractors := map[string]func() savior.ContextExtractor{
+		"random-access": func() savior.ContextExtractor {
+			ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+			must(t, err)
+			return ex
+		},
+		"stream": func() savior.ContextExtractor {
+			return zipextractor.NewStream(seeksource.FromBytes(zipBytes)).(savior.ContextExtractor)
+		},
+	}
+
+	for name, makeExtractor := range makeExtractors {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			sink := &savior.FolderSink{Directory: dir, Consumer: savior.NopConsumer()}
+
+			// cancel in the middle of dir/big.bin
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ex := makeExtractor()
+			ex.SetSaveConsumer(checker.NewTestSaveConsumer(1024*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+				cancel()
+				return savior.AfterSaveContinue, nil
+			}))
+			_, err := ex.ResumeContext(ctx, nil, sink)
+			assert.True(t, errors.Is(err, context.Canceled), "got error %v", err)
+			must(t, sink.Close())
+
+			assertPartial(t, dir)
+		})
+	}
+}
+
+// assertPartial checks that dir/big.bin was only partly extracted to dir,
+// and doesn't have the time from the archive
+func assertPartial(t *testing.T, dir string) {
+	stats, err := os.Stat(filepath.Join(dir, "dir", "big.bin"))
+	must(t, err)
+	assert.Less(t, stats.Size(), int64(4*1024*1024))
+	assert.False(t, timesModified.Equal(stats.ModTime()), "partial dir/big.bin has mtime %s", stats.ModTime())
+}
@@ -0,0 +1,199 @@
+package zstdsource
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/internal/zstd"
+	"github.com/pkg/errors"
+)
+
+type zstdSource struct {
+	// input
+	source savior.Source
+
+	// internal
+	sr      zstd.SaverReader
+	offset  int64
+	bytebuf []byte
+
+	ssc              savior.SourceSaveConsumer
+	sourceCheckpoint *savior.SourceCheckpoint
+}
+
+type ZstdSourceCheckpoint struct {
+	Offset           int64
+	SourceCheckpoint *savior.SourceCheckpoint
+	ZstdCheckpoint   *zstd.Checkpoint
+}
+
+var _ savior.Source = (*zstdSource)(nil)
+
+func New(source savior.Source) *zstdSource {
+	return &zstdSource{
+		source:  source,
+		bytebuf: []byte{0x00},
+	}
+}
+
+func (zs *zstdSource) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "zstd",
+		ResumeSupport: savior.ResumeSupportBlock,
+	}
+}
+
+func (zs *zstdSource) Fingerprint() (*savior.Fingerprint, error) {
+	return savior.SourceFingerprint(zs.source)
+}
+
+func (zs *zstdSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	zs.ssc = ssc
+	zs.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			zs.sourceCheckpoint = checkpoint
+			zs.sr.WantSave()
+			return nil
+		},
+	})
+}
+
+func (zs *zstdSource) WantSave() {
+	zs.source.WantSave()
+}
+
+func (zs *zstdSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	if checkpoint != nil {
+		if ourCheckpoint, ok := checkpoint.Data.(*ZstdSourceCheckpoint); ok {
+			sourceOffset, err := zs.source.Resume(ourCheckpoint.SourceCheckpoint)
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+
+			gc := ourCheckpoint.ZstdCheckpoint
+			if sourceOffset < gc.Roffset {
+				delta := gc.Roffset - sourceOffset
+				savior.Debugf(`zstdsource: discarding %d bytes to align source with decompressor`, delta)
+				err = savior.DiscardByRead(zs.source, delta)
+				if err != nil {
+					return 0, errors.WithStack(err)
+				}
+				sourceOffset += delta
+			}
+
+			if sourceOffset == gc.Roffset {
+				zs.sr, err = gc.Resume(zs.source)
+				if err != nil {
+					savior.Debugf(`zstdsource: could not use zstd checkpoint at R=%d`, gc.Roffset)
+					// well, let's start over
+					_, err = zs.source.Resume(nil)
+					if err != nil {
+						return 0, errors.WithStack(err)
+					}
+				} else {
+					zs.offset = ourCheckpoint.Offset
+					return zs.offset, nil
+				}
+			} else {
+				savior.Debugf(`zstdsource: expected source to resume at %d but got %d`, gc.Roffset, sourceOffset)
+			}
+		}
+	}
+
+	// start from beginning
+	sourceOffset, err := zs.source.Resume(nil)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if sourceOffset != 0 {
+		msg := fmt.Sprintf("zstdsource: expected source to resume at start but got %d", sourceOffset)
+		return 0, errors.New(msg)
+	}
+
+	zs.sr = zstd.NewSaverReader(zs.source)
+
+	zs.offset = 0
+	return 0, nil
+}
+
+func (zs *zstdSource) Read(buf []byte) (int, error) {
+	if zs.sr == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	n, err := zs.sr.Read(buf)
+	zs.offset += int64(n)
+
+	if err == zstd.ReadyToSaveError {
+		err = nil
+
+		if zs.sourceCheckpoint == nil {
+			savior.Debugf("zstdsource: can't save, sourceCheckpoint is nil!")
+		} else if zs.ssc == nil {
+			savior.Debugf("zstdsource: can't save, ssc is nil!")
+		} else {
+			zstdCheckpoint, saveErr := zs.sr.Save()
+			if saveErr != nil {
+				return n, saveErr
+			}
+
+			savior.Debugf("zstdsource: saving, zstd rOffset = %d, sourceCheckpoint.Offset = %d", zstdCheckpoint.Roffset, zs.sourceCheckpoint.Offset)
+
+			checkpoint := &savior.SourceCheckpoint{
+				Offset: zs.offset,
+				Data: &ZstdSourceCheckpoint{
+					Offset:           zs.offset,
+					ZstdCheckpoint:   zstdCheckpoint,
+					SourceCheckpoint: zs.sourceCheckpoint,
+				},
+			}
+			zs.sourceCheckpoint = nil
+
+			err = zs.ssc.Save(checkpoint)
+			savior.Debugf("zstdsource: saved checkpoint at byte %d", zs.offset)
+		}
+	}
+
+	return n, err
+}
+
+func (zs *zstdSource) ReadByte() (byte, error) {
+	if zs.sr == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	n, err := zs.Read(zs.bytebuf)
+	if n == 0 {
+		/* this happens when Read needs to save, but it swallows the error */
+		/* we're not meant to surface them, but there's no way to handle a */
+		/* short read from ReadByte, so we just read again */
+		n, err = zs.Read(zs.bytebuf)
+	}
+
+	return zs.bytebuf[0], err
+}
+
+func (zs *zstdSource) Progress() float64 {
+	// We can't tell how large the uncompressed stream is until we finish
+	// decompressing it. The underlying's source progress is a good enough
+	// approximation.
+	return zs.source.Progress()
+}
+
+func init() {
+	gob.Register(&ZstdSourceCheckpoint{})
+	savior.RegisterCheckpointType("zstdsource.ZstdSourceCheckpoint", &ZstdSourceCheckpoint{})
+
+	savior.RegisterCompressionFormat(savior.CompressionFormat{
+		Name: "zstd",
+		Match: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte{0x28, 0xB5, 0x2F, 0xFD})
+		},
+		NewSource: func(source savior.Source) savior.Source {
+			return New(source)
+		},
+	})
+}
@@ -0,0 +1,60 @@
+package zstdsource_test
+
+import (
+	"log"
+	"math/rand"
+	"testing"
+
+	"github.com/itchio/headway/united"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zstdsource"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Uninitialized(t *testing.T) {
+	{
+		ss := seeksource.FromBytes(nil)
+		_, err := ss.Resume(nil)
+		assert.NoError(t, err)
+
+		zs := zstdsource.New(ss)
+		_, err = zs.Read([]byte{})
+		assert.Error(t, err)
+		assert.True(t, errors.Cause(err) == savior.ErrUninitializedSource)
+
+		_, err = zs.ReadByte()
+		assert.Error(t, err)
+		assert.True(t, errors.Cause(err) == savior.ErrUninitializedSource)
+	}
+}
+
+func Test_Checkpoints(t *testing.T) {
+	reference := semirandom.Bytes(4 * 1024 * 1024 /* 4 MiB of random data */)
+	compressed, err := checker.ZstdCompress(reference)
+	assert.NoError(t, err)
+
+	log.Printf("uncompressed size: %s", united.FormatBytes(int64(len(reference))))
+	log.Printf("  compressed size: %s", united.FormatBytes(int64(len(compressed))))
+
+	source := seeksource.FromBytes(compressed)
+	zs := zstdsource.New(source)
+
+	checker.RunSourceTest(t, zs, reference)
+}
+
+func Test_Incompressible(t *testing.T) {
+	// raw blocks never set any FSE table, checkpoints must still encode
+	reference := make([]byte, 2*1024*1024)
+	rand.New(rand.NewSource(0xf00d)).Read(reference)
+	compressed, err := checker.ZstdCompress(reference)
+	assert.NoError(t, err)
+
+	source := seeksource.FromBytes(compressed)
+	zs := zstdsource.New(source)
+
+	checker.RunSourceTest(t, zs, reference)
+}